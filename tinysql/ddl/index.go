@@ -764,7 +764,8 @@ func (w *addIndexWorker) fetchRowColVals(txn kv.Transaction, taskRange reorgInde
 				return false, nil
 			}
 
-			idxRecord, err1 := w.getIndexRecord(handle, recordKey, rawRow)
+			// The handle of the row key is sharded if the table has HandleShards, the entry has the real one.
+			idxRecord, err1 := w.getIndexRecord(tables.UnshardHandle(handle, w.table.Meta().HandleShards), recordKey, rawRow)
 			if err1 != nil {
 				return false, errors.Trace(err1)
 			}
@@ -835,7 +836,7 @@ func (w *addIndexWorker) checkUniqueKey(txn kv.Transaction, idxRecords []*indexR
 	for i, key := range w.batchCheckKeys {
 		if val, found := vals[string(key)]; found {
 			if w.distinctCheckFlags[i] {
-				handle, err1 := tables.DecodeHandle(val)
+				handle, err1 := tablecodec.DecodeIndexValueAsHandle(val)
 				if err1 != nil {
 					return errors.Trace(err1)
 				}
@@ -1016,9 +1017,10 @@ func makeupDecodeColMap(t table.Table, indexInfo *model.IndexInfo) (map[int64]de
 // splitTableRanges uses PD region's key ranges to split the backfilling table key range space,
 // to speed up adding index in table with disperse handle.
 // The `t` should be a non-partitioned table or a partition.
+// startHandle and endHandle are the handles of the row keys, see rowKeyAt.
 func splitTableRanges(t table.PhysicalTable, store kv.Storage, startHandle, endHandle int64) ([]kv.KeyRange, error) {
-	startRecordKey := t.RecordKey(startHandle)
-	endRecordKey := t.RecordKey(endHandle).Next()
+	startRecordKey := rowKeyAt(t, startHandle)
+	endRecordKey := rowKeyAt(t, endHandle).Next()
 
 	logutil.BgLogger().Info("[ddl] split table range from PD", zap.Int64("physicalTableID", t.GetPhysicalID()), zap.Int64("startHandle", startHandle), zap.Int64("endHandle", endHandle))
 	kvRange := kv.KeyRange{StartKey: startRecordKey, EndKey: endRecordKey}
@@ -1136,7 +1138,7 @@ func (w *worker) sendRangeTaskToWorkers(t table.Table, workers []*addIndexWorker
 			return nil, errors.Trace(err)
 		}
 
-		endKey := t.RecordKey(endHandle)
+		endKey := rowKeyAt(t, endHandle)
 		endIncluded := false
 		if endKey.Cmp(keyRange.EndKey) < 0 {
 			endIncluded = true
@@ -1293,8 +1295,17 @@ func allocateIndexID(tblInfo *model.TableInfo) int64 {
 }
 
 // recordIterFunc is used for low-level record iteration.
+// h is the handle of the row key, which is sharded if the table has HandleShards, see tables.UnshardHandle.
 type recordIterFunc func(h int64, rowKey kv.Key, rawRecord []byte) (more bool, err error)
 
+// rowKeyAt returns the row key of t with handle h as is. Unlike t.RecordKey, it doesn't shard h, so the
+// handles of the row keys are contiguous in the key space, which the reorganization ranges rely on.
+// The handles of the row keys are the handles unless the table has HandleShards.
+func rowKeyAt(t table.Table, h int64) kv.Key {
+	return tablecodec.EncodeRecordKey(t.RecordPrefix(), h)
+}
+
+// iterateSnapshotRows iterates the rows whose row key handles are between startHandle and endHandle.
 func iterateSnapshotRows(store kv.Storage, priority int, t table.Table, version uint64, startHandle int64, endHandle int64, endIncluded bool, fn recordIterFunc) error {
 	ver := kv.Version{Ver: version}
 
@@ -1302,19 +1313,19 @@ func iterateSnapshotRows(store kv.Storage, priority int, t table.Table, version
 	if err != nil {
 		return errors.Trace(err)
 	}
-	firstKey := t.RecordKey(startHandle)
+	firstKey := rowKeyAt(t, startHandle)
 
 	// Calculate the exclusive upper bound
 	var upperBound kv.Key
 	if endIncluded {
 		if endHandle == math.MaxInt64 {
-			upperBound = t.RecordKey(endHandle).PrefixNext()
+			upperBound = rowKeyAt(t, endHandle).PrefixNext()
 		} else {
 			// PrefixNext is time costing. Try to avoid it if possible.
-			upperBound = t.RecordKey(endHandle + 1)
+			upperBound = rowKeyAt(t, endHandle+1)
 		}
 	} else {
-		upperBound = t.RecordKey(endHandle)
+		upperBound = rowKeyAt(t, endHandle)
 	}
 
 	it, err := snap.Iter(firstKey, upperBound)
@@ -1333,7 +1344,7 @@ func iterateSnapshotRows(store kv.Storage, priority int, t table.Table, version
 		if err != nil {
 			return errors.Trace(err)
 		}
-		rk := t.RecordKey(handle)
+		rk := rowKeyAt(t, handle)
 
 		more, err := fn(handle, rk, it.Value())
 		if !more || err != nil {
@@ -15,6 +15,8 @@ package ddl
 
 import (
 	"context"
+	"fmt"
+	"math"
 
 	. "github.com/pingcap/check"
 	"github.com/pingcap/errors"
@@ -24,7 +26,11 @@ import (
 	"github.com/pingcap/tidb/parser/mysql"
 	"github.com/pingcap/tidb/sessionctx"
 	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/table/tables"
+	"github.com/pingcap/tidb/tablecodec"
 	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/mock"
+	"github.com/pingcap/tidb/util/rowcodec"
 )
 
 var _ = Suite(&testIndexChangeSuite{})
@@ -430,3 +436,79 @@ func (s *testIndexChangeSuite) checkDropDeleteOnly(d *ddl, ctx sessionctx.Contex
 	}
 	return txn.Commit(context.Background())
 }
+
+var _ = Suite(&testShardedBackfillSuite{})
+
+type testShardedBackfillSuite struct{}
+
+// snapshotStore is a storage whose snapshots read the rows written to it.
+type snapshotStore struct {
+	kv.Storage
+	rows kv.MemBuffer
+}
+
+func (s *snapshotStore) GetSnapshot(ver kv.Version) (kv.Snapshot, error) {
+	return s.rows, nil
+}
+
+// startTSTxn is a transaction which only has a start ts.
+type startTSTxn struct {
+	kv.Transaction
+}
+
+func (t *startTSTxn) StartTS() uint64 { return 1 }
+
+func (s *testShardedBackfillSuite) TestBackfillShardedHandles(c *C) {
+	// create table t (c1 int primary key, c2 int, index(c2)) with the row keys over 16 handle shards.
+	tblInfo := &model.TableInfo{ID: 100, Name: model.NewCIStr("t"), PKIsHandle: true, HandleShards: 16}
+	for i := 0; i < 2; i++ {
+		tblInfo.Columns = append(tblInfo.Columns, &model.ColumnInfo{
+			ID:        allocateColumnID(tblInfo),
+			Name:      model.NewCIStr(fmt.Sprintf("c%d", i+1)),
+			Offset:    i,
+			State:     model.StatePublic,
+			FieldType: *types.NewFieldType(mysql.TypeLong),
+		})
+	}
+	tblInfo.Columns[0].Flag = mysql.PriKeyFlag | mysql.NotNullFlag
+	idxInfo := &model.IndexInfo{
+		ID:      1,
+		Name:    model.NewCIStr("c2"),
+		Columns: []*model.IndexColumn{{Name: model.NewCIStr("c2"), Offset: 1, Length: types.UnspecifiedLength}},
+		State:   model.StatePublic,
+	}
+	tblInfo.Indices = []*model.IndexInfo{idxInfo}
+	tbl := tables.MockTableFromMeta(tblInfo).(table.PhysicalTable)
+
+	store := &snapshotStore{rows: kv.NewMemDbBuffer(4096)}
+	ctx := mock.NewContext()
+	ctx.Store = store
+	const rows = 50
+	for h := int64(1); h <= rows; h++ {
+		row, err := tablecodec.EncodeRow(ctx.GetSessionVars().StmtCtx, types.MakeDatums(h*10), []int64{tblInfo.Columns[1].ID}, nil, nil, &rowcodec.Encoder{})
+		c.Assert(err, IsNil)
+		c.Assert(store.rows.Set(tbl.RecordKey(h), row), IsNil)
+	}
+
+	// The row keys are split into ranges of their sharded handles, the entries get the real handles.
+	decodeColMap, err := makeupDecodeColMap(tbl, idxInfo)
+	c.Assert(err, IsNil)
+	w := newAddIndexWorker(ctx, nil, 0, tbl, idxInfo, decodeColMap)
+	w.batchCnt = rows
+	bounds := []int64{math.MinInt64, math.MinInt64 / 2, 0, math.MaxInt64 / 2, math.MaxInt64}
+	seen := make(map[int64]bool)
+	for i := 1; i < len(bounds); i++ {
+		r := kv.KeyRange{StartKey: rowKeyAt(tbl, bounds[i-1]), EndKey: rowKeyAt(tbl, bounds[i])}
+		startHandle, endHandle, err := decodeHandleRange(r)
+		c.Assert(err, IsNil)
+		records, _, _, err := w.fetchRowColVals(&startTSTxn{}, reorgIndexTask{tbl.GetPhysicalID(), startHandle, endHandle, i == len(bounds)-1})
+		c.Assert(err, IsNil)
+		for _, record := range records {
+			c.Assert(record.vals[0].GetInt64(), Equals, record.handle*10)
+			c.Assert(record.key, BytesEquals, []byte(tbl.RecordKey(record.handle)))
+			c.Assert(seen[record.handle], IsFalse)
+			seen[record.handle] = true
+		}
+	}
+	c.Assert(seen, HasLen, rows)
+}
@@ -252,7 +252,8 @@ func (d *ddlCtx) buildDescTableScan(ctx context.Context, startTS uint64, tbl tab
 	}
 	ranges := ranger.FullIntRange(false)
 	var builder distsql.RequestBuilder
-	builder.SetTableRanges(tbl.GetPhysicalID(), ranges).
+	// The full range is over the row keys of the table, sharded or not.
+	builder.SetTableRanges(tbl.GetPhysicalID(), ranges, 0).
 		SetDAGRequest(dagPB).
 		SetStartTS(startTS).
 		SetKeepOrder(true).
@@ -307,6 +308,7 @@ func (d *ddlCtx) GetTableMaxRowID(startTS uint64, tbl table.PhysicalTable) (maxR
 }
 
 // getTableRange gets the start and end handle of a table (or partition).
+// They are the handles of the row keys, which are sharded if the table has HandleShards.
 func getTableRange(d *ddlCtx, tbl table.PhysicalTable, snapshotVer uint64, priority int) (startHandle, endHandle int64, err error) {
 	startHandle = math.MinInt64
 	endHandle = math.MaxInt64
@@ -319,6 +321,10 @@ func getTableRange(d *ddlCtx, tbl table.PhysicalTable, snapshotVer uint64, prior
 	if err != nil {
 		return 0, 0, errors.Trace(err)
 	}
+	if tbl.Meta().HandleShards > 1 {
+		// The max handle isn't the last row key, the range ends at the end of the table instead.
+		return startHandle, math.MaxInt64, nil
+	}
 	var emptyTable bool
 	// Get the end handle of this partition.
 	endHandle, emptyTable, err = d.GetTableMaxRowID(snapshotVer, tbl)
@@ -15,10 +15,13 @@ package distsql
 
 import (
 	"math"
+	"sort"
 
+	"github.com/cznic/sortutil"
 	"github.com/pingcap/tidb/kv"
 	"github.com/pingcap/tidb/sessionctx/stmtctx"
 	"github.com/pingcap/tidb/sessionctx/variable"
+	"github.com/pingcap/tidb/table/tables"
 	"github.com/pingcap/tidb/tablecodec"
 	"github.com/pingcap/tidb/util/codec"
 	"github.com/pingcap/tidb/util/ranger"
@@ -38,10 +41,10 @@ func (builder *RequestBuilder) Build() (*kv.Request, error) {
 }
 
 // SetTableRanges sets "KeyRanges" for "kv.Request" by converting "tableRanges"
-// to "KeyRanges" firstly.
-func (builder *RequestBuilder) SetTableRanges(tid int64, tableRanges []*ranger.Range) *RequestBuilder {
+// to "KeyRanges" firstly. shards is the HandleShards of the table.
+func (builder *RequestBuilder) SetTableRanges(tid int64, tableRanges []*ranger.Range, shards int) *RequestBuilder {
 	if builder.err == nil {
-		builder.Request.KeyRanges = TableRangesToKVRanges(tid, tableRanges)
+		builder.Request.KeyRanges = TableRangesToKVRanges(tid, tableRanges, shards)
 	}
 	return builder
 }
@@ -56,9 +59,9 @@ func (builder *RequestBuilder) SetIndexRanges(sc *stmtctx.StatementContext, tid,
 }
 
 // SetTableHandles sets "KeyRanges" for "kv.Request" by converting table handles
-// "handles" to "KeyRanges" firstly.
-func (builder *RequestBuilder) SetTableHandles(tid int64, handles []int64) *RequestBuilder {
-	builder.Request.KeyRanges = TableHandlesToKVRanges(tid, handles)
+// "handles" to "KeyRanges" firstly. shards is the HandleShards of the table.
+func (builder *RequestBuilder) SetTableHandles(tid int64, handles []int64, shards int) *RequestBuilder {
+	builder.Request.KeyRanges = TableHandlesToKVRanges(tid, handles, shards)
 	return builder
 }
 
@@ -133,7 +136,12 @@ func (builder *RequestBuilder) SetConcurrency(concurrency int) *RequestBuilder {
 }
 
 // TableRangesToKVRanges converts table ranges to "KeyRange".
-func TableRangesToKVRanges(tid int64, ranges []*ranger.Range) []kv.KeyRange {
+// The row keys of a table with shards, its HandleShards, are sharded by tables.ShardHandle, so the ranges are
+// converted to the ranges of the row keys they're sharded into, which may also have the rows of other handles.
+func TableRangesToKVRanges(tid int64, ranges []*ranger.Range, shards int) []kv.KeyRange {
+	if shards > 1 {
+		return shardedTableRangesToKVRanges(tid, ranges, shards)
+	}
 	krs := make([]kv.KeyRange, 0, len(ranges))
 	for _, ran := range ranges {
 		low, high := encodeHandleKey(ran)
@@ -144,6 +152,33 @@ func TableRangesToKVRanges(tid int64, ranges []*ranger.Range) []kv.KeyRange {
 	return krs
 }
 
+func shardedTableRangesToKVRanges(tid int64, ranges []*ranger.Range, shards int) []kv.KeyRange {
+	handleRanges := make([][2]int64, 0, len(ranges))
+	for _, ran := range ranges {
+		low, high := ran.LowVal[0].GetInt64(), ran.HighVal[0].GetInt64()
+		if ran.LowExclude {
+			if low == math.MaxInt64 {
+				continue
+			}
+			low++
+		}
+		if ran.HighExclude {
+			if high == math.MinInt64 {
+				continue
+			}
+			high--
+		}
+		handleRanges = append(handleRanges, [2]int64{low, high})
+	}
+	handleRanges = tables.ShardHandleRanges(handleRanges, shards)
+	krs := make([]kv.KeyRange, 0, len(handleRanges))
+	for _, r := range handleRanges {
+		high := []byte(kv.Key(codec.EncodeInt(nil, r[1])).PrefixNext())
+		krs = append(krs, kv.KeyRange{StartKey: tablecodec.EncodeRowKeyWithHandle(tid, r[0]), EndKey: tablecodec.EncodeRowKey(tid, high)})
+	}
+	return krs
+}
+
 func encodeHandleKey(ran *ranger.Range) ([]byte, []byte) {
 	low := codec.EncodeInt(nil, ran.LowVal[0].GetInt64())
 	high := codec.EncodeInt(nil, ran.HighVal[0].GetInt64())
@@ -158,7 +193,16 @@ func encodeHandleKey(ran *ranger.Range) ([]byte, []byte) {
 
 // TableHandlesToKVRanges converts sorted handle to kv ranges.
 // For continuous handles, we should merge them to a single key range.
-func TableHandlesToKVRanges(tid int64, handles []int64) []kv.KeyRange {
+// The handles of a table with shards, its HandleShards, are sharded by tables.ShardHandle first.
+func TableHandlesToKVRanges(tid int64, handles []int64, shards int) []kv.KeyRange {
+	if shards > 1 {
+		sharded := make([]int64, len(handles))
+		for i, h := range handles {
+			sharded[i] = tables.ShardHandle(h, shards)
+		}
+		sort.Sort(sortutil.Int64Slice(sharded))
+		handles = sharded
+	}
 	krs := make([]kv.KeyRange, 0, len(handles))
 	i := 0
 	for i < len(handles) {
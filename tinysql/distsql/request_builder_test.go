@@ -113,7 +113,7 @@ func (s *testSuite) TestTableHandlesToKVRanges(c *C) {
 
 	// Build key ranges.
 	expect := s.getExpectedRanges(1, hrs)
-	actual := TableHandlesToKVRanges(1, handles)
+	actual := TableHandlesToKVRanges(1, handles, 0)
 
 	// Compare key ranges and expected key ranges.
 	c.Assert(len(actual), Equals, len(expect))
@@ -152,7 +152,7 @@ func (s *testSuite) TestTableRangesToKVRanges(c *C) {
 		},
 	}
 
-	actual := TableRangesToKVRanges(13, ranges)
+	actual := TableRangesToKVRanges(13, ranges, 0)
 	expect := []kv.KeyRange{
 		{
 			StartKey: kv.Key{0x74, 0x80, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0xd, 0x5f, 0x72, 0x80, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1},
@@ -268,7 +268,7 @@ func (s *testSuite) TestRequestBuilder1(c *C) {
 		},
 	}
 
-	actual, err := (&RequestBuilder{}).SetTableRanges(12, ranges).
+	actual, err := (&RequestBuilder{}).SetTableRanges(12, ranges, 0).
 		SetDAGRequest(&tipb.DAGRequest{}).
 		SetDesc(false).
 		SetKeepOrder(false).
@@ -388,7 +388,7 @@ func (s *testSuite) TestRequestBuilder2(c *C) {
 func (s *testSuite) TestRequestBuilder3(c *C) {
 	handles := []int64{0, 2, 3, 4, 5, 10, 11, 100}
 
-	actual, err := (&RequestBuilder{}).SetTableHandles(15, handles).
+	actual, err := (&RequestBuilder{}).SetTableHandles(15, handles, 0).
 		SetDAGRequest(&tipb.DAGRequest{}).
 		SetDesc(false).
 		SetKeepOrder(false).
@@ -764,6 +764,9 @@ func (b *executorBuilder) constructDAGReq(plans []plannercore.PhysicalPlan) (dag
 	dagReq = &tipb.DAGRequest{}
 	sc := b.ctx.GetSessionVars().StmtCtx
 	dagReq.Flags = sc.PushDownFlags()
+	if ts, ok := plans[0].(*plannercore.PhysicalTableScan); ok && ts.Table.HandleShards > 1 {
+		dagReq.Flags |= uint64(mathutil.Min(ts.Table.HandleShards, 1<<16-1)) << model.FlagHandleShardsShift
+	}
 	dagReq.Executors, err = constructDistExec(b.ctx, plans)
 	return dagReq, err
 }
@@ -945,7 +948,7 @@ func (builder *dataReaderBuilder) buildTableReaderFromHandles(ctx context.Contex
 
 	sort.Sort(sortutil.Int64Slice(handles))
 	var b distsql.RequestBuilder
-	kvReq, err := b.SetTableHandles(getPhysicalTableID(e.table), handles).
+	kvReq, err := b.SetTableHandles(getPhysicalTableID(e.table), handles, e.table.Meta().HandleShards).
 		SetDAGRequest(e.dagPB).
 		SetStartTS(startTS).
 		SetDesc(e.desc).
@@ -92,6 +92,7 @@ func (e *ReplaceExec) replaceRow(ctx context.Context, r toBeCheckedRow) error {
 		if err != nil {
 			return err
 		}
+		handle = tables.UnshardHandle(handle, e.Table.Meta().HandleShards)
 
 		if _, err := txn.Get(ctx, r.handleKey.newKV.key); err == nil {
 			rowUnchanged, err := e.removeRow(ctx, txn, handle, r)
@@ -147,7 +148,7 @@ func (e *ReplaceExec) removeIndexRow(ctx context.Context, txn kv.Transaction, r
 			return false, false, err
 		}
 
-		handle, err := tables.DecodeHandle(val)
+		handle, err := tablecodec.DecodeIndexValueAsHandle(val)
 		if err != nil {
 			return false, true, err
 		}
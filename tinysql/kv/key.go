@@ -15,7 +15,9 @@ package kv
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/hex"
+	"strconv"
 )
 
 // Key represents high-level Key type.
@@ -119,3 +121,108 @@ func (r *KeyRange) IsPoint() bool {
 	return r.StartKey[diffOneIdx]+1 == r.EndKey[diffOneIdx] &&
 		bytes.Equal(r.StartKey[:diffOneIdx], r.EndKey[:diffOneIdx])
 }
+
+// Handle is the ID of a row, it's either an IntHandle or a CommonHandle of a table clustered
+// by a non-integer or composite primary key.
+type Handle interface {
+	// IsInt returns whether the handle is an IntHandle.
+	IsInt() bool
+	// IntValue returns the int value of an IntHandle, it panics for a CommonHandle.
+	IntValue() int64
+	// Encoded returns the encoded handle, the 8-byte big-endian value of an IntHandle, or the
+	// memcomparable encoded primary key values of a CommonHandle.
+	Encoded() []byte
+	// Equal returns whether the handles are the same.
+	Equal(h Handle) bool
+	// Compare compares the handles of the same kind by their order in the table.
+	Compare(h Handle) int
+	String() string
+}
+
+// IntHandle is the handle of a row identified by an int64.
+type IntHandle int64
+
+// IsInt implements the Handle interface.
+func (ih IntHandle) IsInt() bool {
+	return true
+}
+
+// IntValue implements the Handle interface.
+func (ih IntHandle) IntValue() int64 {
+	return int64(ih)
+}
+
+// Encoded implements the Handle interface.
+func (ih IntHandle) Encoded() []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(ih))
+	return buf[:]
+}
+
+// Equal implements the Handle interface.
+func (ih IntHandle) Equal(h Handle) bool {
+	return h.IsInt() && int64(ih) == h.IntValue()
+}
+
+// Compare implements the Handle interface, it panics if h isn't an IntHandle.
+func (ih IntHandle) Compare(h Handle) int {
+	if !h.IsInt() {
+		panic("IntHandle compares to CommonHandle")
+	}
+	switch other := h.IntValue(); {
+	case int64(ih) < other:
+		return -1
+	case int64(ih) > other:
+		return 1
+	}
+	return 0
+}
+
+// String implements the Handle interface.
+func (ih IntHandle) String() string {
+	return strconv.FormatInt(int64(ih), 10)
+}
+
+// CommonHandle is the handle of a row of a table clustered by a non-integer or composite primary key,
+// it holds the memcomparable encoded primary key values, so the handles sort as the keys.
+type CommonHandle struct {
+	encoded []byte
+}
+
+// NewCommonHandle returns a CommonHandle of the encoded primary key values.
+func NewCommonHandle(encoded []byte) *CommonHandle {
+	return &CommonHandle{encoded: encoded}
+}
+
+// IsInt implements the Handle interface.
+func (ch *CommonHandle) IsInt() bool {
+	return false
+}
+
+// IntValue implements the Handle interface.
+func (ch *CommonHandle) IntValue() int64 {
+	panic("CommonHandle has no int value")
+}
+
+// Encoded implements the Handle interface.
+func (ch *CommonHandle) Encoded() []byte {
+	return ch.encoded
+}
+
+// Equal implements the Handle interface.
+func (ch *CommonHandle) Equal(h Handle) bool {
+	return !h.IsInt() && bytes.Equal(ch.encoded, h.Encoded())
+}
+
+// Compare implements the Handle interface, it panics if h is an IntHandle.
+func (ch *CommonHandle) Compare(h Handle) int {
+	if h.IsInt() {
+		panic("CommonHandle compares to IntHandle")
+	}
+	return bytes.Compare(ch.encoded, h.Encoded())
+}
+
+// String implements the Handle interface.
+func (ch *CommonHandle) String() string {
+	return hex.EncodeToString(ch.encoded)
+}
@@ -65,6 +65,14 @@ const (
 // Append UnCommitIndexKVFlag to the value indicate the index key/value is no need to commit.
 const UnCommitIndexKVFlag byte = '1'
 
+// IndexValueVersion leads the index value written in the versioned layout, which is followed by a flags byte,
+// the fields of the flags and the value of the unversioned layout, see tablecodec.EncodeVersionedIndexValue.
+const IndexValueVersion byte = 0xfe
+
+// IndexValueUntouchedFlag is the flags byte of an untouched index value in the versioned layout, which is
+// IndexValueVersion, IndexValueUntouchedFlag and the untouched value of the unversioned layout.
+const IndexValueUntouchedFlag byte = 0x80
+
 // MaxTxnTimeUse is the max time a Txn may use (in ms) from its begin to commit.
 // We use it to abort the transaction to guarantee GC worker will not influence it.
 const MaxTxnTimeUse = 24 * 60 * 60 * 1000
@@ -36,6 +36,9 @@ type UnionStore interface {
 	GetOption(opt Option) interface{}
 	// GetMemBuffer return the MemBuffer binding to this UnionStore.
 	GetMemBuffer() MemBuffer
+	AssertionMutator
+	// CheckAssertions checks the assertions attached by SetWithAssertion against the snapshot, before the commit.
+	CheckAssertions(ctx context.Context) error
 }
 
 // AssertionType is the type of a assertion.
@@ -48,6 +51,13 @@ const (
 	NotExist
 )
 
+// AssertionMutator is implemented by the Mutator of an engine which checks an assertion about the prior state
+// of a key at commit time, e.g. NotExist for the new entry of a unique index instead of reading the key first.
+type AssertionMutator interface {
+	// SetWithAssertion sets the value for key k as v like Set, the commit fails if k doesn't satisfy assertion.
+	SetWithAssertion(k Key, v []byte, assertion AssertionType) error
+}
+
 // Option is used for customizing kv store's behaviors during a transaction.
 type Option int
 
@@ -87,6 +97,7 @@ func (e *existErrInfo) Err() error {
 type unionStore struct {
 	*BufferStore
 	keyExistErrs map[string]*existErrInfo // for the lazy check
+	assertions   map[string]AssertionType // for CheckAssertions
 	opts         options
 }
 
@@ -95,6 +106,7 @@ func NewUnionStore(snapshot Snapshot) UnionStore {
 	return &unionStore{
 		BufferStore:  NewBufferStore(snapshot, DefaultTxnMembufCap),
 		keyExistErrs: make(map[string]*existErrInfo),
+		assertions:   make(map[string]AssertionType),
 		opts:         make(map[Option]interface{}),
 	}
 }
@@ -246,6 +258,36 @@ func (us *unionStore) SetCap(cap int) {
 
 func (us *unionStore) Reset() {
 	us.BufferStore.Reset()
+	us.assertions = make(map[string]AssertionType)
+}
+
+// SetWithAssertion implements the AssertionMutator SetWithAssertion interface.
+func (us *unionStore) SetWithAssertion(k Key, v []byte, assertion AssertionType) error {
+	if err := us.Set(k, v); err != nil {
+		return err
+	}
+	if assertion != None {
+		us.assertions[string(k)] = assertion
+	}
+	return nil
+}
+
+// CheckAssertions implements the UnionStore CheckAssertions interface. The snapshot is the state the
+// transaction starts from, a key written since then by another transaction conflicts with its write.
+func (us *unionStore) CheckAssertions(ctx context.Context) error {
+	for k, assertion := range us.assertions {
+		_, err := us.BufferStore.r.Get(ctx, Key(k))
+		if err != nil && !IsErrNotFound(err) {
+			return err
+		}
+		switch {
+		case assertion == NotExist && err == nil:
+			return ErrKeyExists.FastGenByArgs(Key(k).String(), "assertion")
+		case assertion == Exist && err != nil:
+			return ErrNotExist
+		}
+	}
+	return nil
 }
 
 type options map[Option]interface{}
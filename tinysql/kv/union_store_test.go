@@ -124,6 +124,36 @@ func (s *testUnionStoreSuite) TestIterReverse(c *C) {
 	checkIterator(c, iter, [][]byte{[]byte("2"), []byte("0")}, [][]byte{[]byte("2"), []byte("0")})
 }
 
+func (s *testUnionStoreSuite) TestAssertions(c *C) {
+	defer testleak.AfterTest(c)()
+	err := s.store.Set([]byte("1"), []byte("1"))
+	c.Assert(err, IsNil)
+	err = s.us.SetWithAssertion([]byte("1"), []byte("2"), Exist)
+	c.Assert(err, IsNil)
+	err = s.us.SetWithAssertion([]byte("2"), []byte("2"), NotExist)
+	c.Assert(err, IsNil)
+	v, err := s.us.Get(context.TODO(), []byte("2"))
+	c.Assert(err, IsNil)
+	c.Assert(v, BytesEquals, []byte("2"))
+	c.Assert(s.us.CheckAssertions(context.TODO()), IsNil)
+
+	// The assertions are checked against the snapshot, not the buffered writes, and a later write without
+	// one keeps them.
+	err = s.us.SetWithAssertion([]byte("1"), []byte("3"), NotExist)
+	c.Assert(err, IsNil)
+	c.Assert(ErrKeyExists.Equal(s.us.CheckAssertions(context.TODO())), IsTrue)
+	err = s.us.SetWithAssertion([]byte("1"), []byte("3"), None)
+	c.Assert(err, IsNil)
+	c.Assert(ErrKeyExists.Equal(s.us.CheckAssertions(context.TODO())), IsTrue)
+	err = s.us.SetWithAssertion([]byte("3"), []byte("3"), Exist)
+	c.Assert(err, IsNil)
+	s.us.Reset()
+	c.Assert(s.us.CheckAssertions(context.TODO()), IsNil)
+	err = s.us.SetWithAssertion([]byte("3"), []byte("3"), Exist)
+	c.Assert(err, IsNil)
+	c.Assert(IsErrNotFound(s.us.CheckAssertions(context.TODO())), IsTrue)
+}
+
 func checkIterator(c *C, iter Iterator, keys [][]byte, values [][]byte) {
 	defer iter.Close()
 	c.Assert(len(keys), Equals, len(values))
@@ -45,3 +45,7 @@ const (
 	// FlagInLoadDataStmt indicates if this is a LOAD DATA statement.
 	FlagInLoadDataStmt = 1 << 10
 )
+
+// FlagHandleShardsShift is the shift of the HandleShards of the scanned table in the flags, which the table
+// scan unshards the handles of the row keys with.
+const FlagHandleShardsShift = 48
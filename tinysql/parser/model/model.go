@@ -90,6 +90,10 @@ type ColumnInfo struct {
 	Comment            string      `json:"comment"`
 	// A hidden column is used internally(expression index) and are not accessible by users.
 	Hidden bool `json:"hidden"`
+	// GeneratedExprString is the expression of a generated column, e.g. the hidden column of an expression index.
+	GeneratedExprString string `json:"generated_expr_string"`
+	// GeneratedStored is set if the value of the generated column is stored in the row.
+	GeneratedStored bool `json:"generated_stored"`
 	// Version means the version of the column info.
 	// Version = 0: For OriginDefaultValue and DefaultValue of timestamp column will stores the default time in system time zone.
 	//              That is a bug if multiple TiDB servers in different system time zone.
@@ -195,6 +199,9 @@ type TableInfo struct {
 	// UpdateTS is used to record the timestamp of updating the table's schema information.
 	// These changing schema operations don't include 'truncate table' and 'rename table'.
 	UpdateTS uint64 `json:"update_timestamp"`
+	// IsCommonHandle is set for a table clustered by a non-integer or composite primary key,
+	// whose rows are identified by the encoded primary key values, see kv.CommonHandle.
+	IsCommonHandle bool `json:"is_common_handle"`
 	// OldSchemaID :
 	// Because auto increment ID has schemaID as prefix,
 	// We need to save original schemaID to keep autoID unchanged
@@ -209,6 +216,9 @@ type TableInfo struct {
 	MaxShardRowIDBits uint64 `json:"max_shard_row_id_bits"`
 	// AutoRandomBits is used to set the bit number to shard automatically when PKIsHandle.
 	AutoRandomBits uint64 `json:"auto_shard_bits"`
+	// HandleShards is the number of hash shards the row keys are spread over, see tables.ShardHandle.
+	// The handles themselves, e.g. in the index entries, aren't changed.
+	HandleShards int `json:"handle_shards"`
 	// PreSplitRegions specify the pre-split region when create table.
 	// The pre-split region num is 2^(PreSplitRegions-1).
 	// And the PreSplitRegions should less than or equal to ShardRowIDBits.
@@ -514,6 +524,13 @@ type IndexInfo struct {
 	State   SchemaState    `json:"state"`
 	Comment string         `json:"comment"`    // Comment
 	Tp      IndexType      `json:"index_type"` // Index type: Btree, Hash or Rtree
+	// Invisible is set for an index ignored by the optimizer, it's still maintained.
+	Invisible bool `json:"is_invisible"`
+	// NullsNotDistinct is set for a unique index whose NULL values collide like the other values,
+	// as UNIQUE NULLS NOT DISTINCT, instead of being permitted many times as MySQL does.
+	NullsNotDistinct bool `json:"nulls_not_distinct"`
+	// CollationVersion is the version of the collation weights the index is built with, 0 if it isn't recorded.
+	CollationVersion uint32 `json:"collation_version"`
 }
 
 // Clone clones IndexInfo.
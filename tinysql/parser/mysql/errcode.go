@@ -1020,6 +1020,11 @@ const (
 	ErrSnapshotTooOld                      = 8055
 	ErrInvalidTableID                      = 8056
 	ErrInvalidType                         = 8057
+	ErrIndexHandleMismatch                 = 8058
+	ErrIndexFormatMismatch                 = 8059
+	ErrIndexCollationVersion               = 8060
+	ErrIndexKeyTooLong                     = 8061
+	ErrIndexValueKindMismatch              = 8062
 
 	// Error codes used by TiDB ddl package
 	ErrUnsupportedDDLOperation  = 8200
@@ -1013,6 +1013,11 @@ var MySQLErrName = map[uint16]string{
 	ErrUnknownFieldType:           "unknown field type",
 	ErrInvalidSequence:            "invalid sequence",
 	ErrInvalidType:                "invalid type",
+	ErrIndexHandleMismatch:        "Index entry of %s points to handle %v, expected handle %v",
+	ErrIndexFormatMismatch:        "Index entry of %s is written in an unknown format %#x",
+	ErrIndexCollationVersion:      "Index %s is built with collation version %d, but used with version %d",
+	ErrIndexKeyTooLong:            "Index key of %s is %d bytes long, longer than the max %d bytes",
+	ErrIndexValueKindMismatch:     "Index %s column %s of type %s gets a value of kind %s",
 	ErrCantGetValidID:             "cannot get valid auto-increment id in retry",
 	ErrCantSetToNull:              "cannot set variable to null",
 	ErrSnapshotTooOld:             "snapshot is older than GC safe point %s",
@@ -260,7 +260,8 @@ func compareCandidates(lhs, rhs *candidatePath) int {
 func (ds *DataSource) getTableCandidate(path *util.AccessPath, prop *property.PhysicalProperty) *candidatePath {
 	candidate := &candidatePath{path: path}
 	pkCol := ds.getPKIsHandleCol()
-	if len(prop.Items) == 1 && pkCol != nil {
+	// The rows of a table with HandleShards are in the order of their sharded row keys instead of their handles.
+	if len(prop.Items) == 1 && pkCol != nil && ds.tableInfo.HandleShards < 2 {
 		candidate.isMatchProp = prop.Items[0].Col.Equal(nil, pkCol)
 	}
 	candidate.columnSet = expression.ExtractColumnSet(path.AccessConds)
@@ -689,6 +690,10 @@ func (ds *DataSource) getOriginalPhysicalTableScan(prop *property.PhysicalProper
 		AccessCondition: path.AccessConds,
 		filterCondition: path.TableFilters,
 	}.Init(ds.ctx)
+	if ds.tableInfo.HandleShards > 1 {
+		// The row key ranges of a sharded table may also have the rows of the handles out of the ranges.
+		ts.filterCondition = append(append([]expression.Expression(nil), path.AccessConds...), path.TableFilters...)
+	}
 	ts.SetSchema(ds.schema.Clone())
 	rowCount := path.CountAfterAccess
 	// Only use expectedCnt when it's smaller than the count we calculated.
@@ -194,12 +194,13 @@ func (h *rpcHandler) buildTableScan(ctx *dagContext, executor *tipb.Executor) (*
 	}
 	rd := rowcodec.NewByteDecoder(colInfos, -1, defVal, nil)
 	e := &tableScanExec{
-		TableScan: executor.TblScan,
-		kvRanges:  ranges,
-		colIDs:    ctx.evalCtx.colIDs,
-		startTS:   startTS,
-		mvccStore: h.mvccStore,
-		rd:        rd,
+		TableScan:    executor.TblScan,
+		kvRanges:     ranges,
+		colIDs:       ctx.evalCtx.colIDs,
+		startTS:      startTS,
+		mvccStore:    h.mvccStore,
+		handleShards: int(ctx.dagReq.Flags >> model.FlagHandleShardsShift),
+		rd:           rd,
 	}
 	if ctx.dagReq.CollectRangeCounts != nil && *ctx.dagReq.CollectRangeCounts {
 		e.counts = make([]int64, len(ranges))
@@ -0,0 +1,134 @@
+// Copyright 2019 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mocktikv
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"github.com/pingcap-incubator/tinykv/proto/pkg/coprocessor"
+	"github.com/pingcap-incubator/tinykv/proto/pkg/kvrpcpb"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/distsql"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tidb/parser/mysql"
+	"github.com/pingcap/tidb/sessionctx/stmtctx"
+	"github.com/pingcap/tidb/table/tables"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/codec"
+	"github.com/pingcap/tidb/util/ranger"
+	"github.com/pingcap/tidb/util/rowcodec"
+	"github.com/pingcap/tipb/go-tipb"
+)
+
+var _ = Suite(&testCopHandlerSuite{})
+
+type testCopHandlerSuite struct{}
+
+// TestShardedTableRangeScan scans the ranges of the primary key of a table with HandleShards, like
+// SELECT a, b FROM t WHERE a >= lo AND a < hi does with the access conditions kept as the filter.
+func (s *testCopHandlerSuite) TestShardedTableRangeScan(c *C) {
+	const tableID, shards = int64(1), 16
+	store, err := NewMVCCLevelDB("")
+	c.Assert(err, IsNil)
+	sc := &stmtctx.StatementContext{}
+	handles := []int64{math.MinInt64, -5, 0, 1 << 59, math.MaxInt64}
+	for h := int64(1); h <= 200; h++ {
+		handles = append(handles, h)
+	}
+	var mutations []*kvrpcpb.Mutation
+	for _, h := range handles {
+		value, err := tablecodec.EncodeRow(sc, types.MakeDatums(h%1000), []int64{2}, nil, nil, &rowcodec.Encoder{})
+		c.Assert(err, IsNil)
+		key := tablecodec.EncodeRowKeyWithHandle(tableID, tables.ShardHandle(h, shards))
+		mutations = append(mutations, &kvrpcpb.Mutation{Op: kvrpcpb.Op_Put, Key: key, Value: value})
+	}
+	for _, err := range store.Prewrite(&kvrpcpb.PrewriteRequest{Mutations: mutations, PrimaryLock: mutations[0].Key, StartVersion: 1}) {
+		c.Assert(err, IsNil)
+	}
+	keys := make([][]byte, 0, len(mutations))
+	for _, m := range mutations {
+		keys = append(keys, m.Key)
+	}
+	c.Assert(store.Commit(keys, 1, 2), IsNil)
+
+	intTp := &tipb.FieldType{Tp: int32(mysql.TypeLonglong)}
+	column := &tipb.Expr{Tp: tipb.ExprType_ColumnRef, Val: codec.EncodeInt(nil, 0), FieldType: intTp}
+	compare := func(sig tipb.ScalarFuncSig, v int64) *tipb.Expr {
+		constant := &tipb.Expr{Tp: tipb.ExprType_Int64, Val: codec.EncodeInt(nil, v), FieldType: intTp}
+		return &tipb.Expr{Tp: tipb.ExprType_ScalarFunc, Sig: sig, Children: []*tipb.Expr{column, constant}, FieldType: intTp}
+	}
+	h := &rpcHandler{mvccStore: store}
+	for _, t := range []struct {
+		lo, hi int64
+	}{
+		{10, 13},
+		{10, 40},
+		{-10, 150},
+		{150, math.MaxInt64},
+		{math.MinInt64, math.MaxInt64},
+	} {
+		ran := &ranger.Range{LowVal: types.MakeDatums(t.lo), HighVal: types.MakeDatums(t.hi), HighExclude: true}
+		var ranges []*coprocessor.KeyRange
+		for _, r := range distsql.TableRangesToKVRanges(tableID, []*ranger.Range{ran}, shards) {
+			ranges = append(ranges, &coprocessor.KeyRange{Start: r.StartKey, End: r.EndKey})
+		}
+		dag := &tipb.DAGRequest{
+			Executors: []*tipb.Executor{{
+				Tp: tipb.ExecType_TypeTableScan,
+				TblScan: &tipb.TableScan{TableId: tableID, Columns: []*tipb.ColumnInfo{
+					{ColumnId: 1, Tp: int32(mysql.TypeLonglong), Flag: int32(mysql.PriKeyFlag), PkHandle: true},
+					{ColumnId: 2, Tp: int32(mysql.TypeLonglong)},
+				}},
+			}, {
+				Tp: tipb.ExecType_TypeSelection,
+				Selection: &tipb.Selection{Conditions: []*tipb.Expr{
+					compare(tipb.ScalarFuncSig_GEInt, t.lo), compare(tipb.ScalarFuncSig_LTInt, t.hi),
+				}},
+			}},
+			Flags:         shards << model.FlagHandleShardsShift,
+			OutputOffsets: []uint32{0, 1},
+		}
+		data, err := dag.Marshal()
+		c.Assert(err, IsNil)
+		_, e, _, err := h.buildDAGExecutor(&coprocessor.Request{Tp: kv.ReqTypeDAG, Data: data, Ranges: ranges, StartTs: 3})
+		c.Assert(err, IsNil)
+		var got []int64
+		for {
+			row, err := e.Next(context.TODO())
+			c.Assert(err, IsNil)
+			if row == nil {
+				break
+			}
+			_, a, err := codec.DecodeOne(row[0])
+			c.Assert(err, IsNil)
+			_, b, err := codec.DecodeOne(row[1])
+			c.Assert(err, IsNil)
+			c.Assert(b.GetInt64(), Equals, a.GetInt64()%1000)
+			got = append(got, a.GetInt64())
+		}
+		sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+		var expected []int64
+		for _, h := range handles {
+			if h >= t.lo && h < t.hi {
+				expected = append(expected, h)
+			}
+		}
+		sort.Slice(expected, func(i, j int) bool { return expected[i] < expected[j] })
+		c.Assert(got, DeepEquals, expected, Commentf("range [%d, %d)", t.lo, t.hi))
+	}
+}
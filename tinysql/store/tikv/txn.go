@@ -116,6 +116,14 @@ func (txn *tikvTxn) Set(k kv.Key, v []byte) error {
 	return txn.us.Set(k, v)
 }
 
+// SetWithAssertion implements the kv.AssertionMutator interface, the assertions are checked by Commit.
+func (txn *tikvTxn) SetWithAssertion(k kv.Key, v []byte, assertion kv.AssertionType) error {
+	txn.setCnt++
+
+	txn.dirty = true
+	return txn.us.SetWithAssertion(k, v, assertion)
+}
+
 func (txn *tikvTxn) String() string {
 	return fmt.Sprintf("%d", txn.StartTS())
 }
@@ -178,6 +186,10 @@ func (txn *tikvTxn) Commit(ctx context.Context) error {
 		connID = val.(uint64)
 	}
 
+	if err := txn.us.CheckAssertions(ctx); err != nil {
+		return errors.Trace(err)
+	}
+
 	var err error
 	committer := txn.committer
 	if committer == nil {
@@ -15,9 +15,11 @@ package table
 
 import (
 	"context"
+	"strings"
 
 	"github.com/pingcap/tidb/kv"
 	"github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tidb/parser/terror"
 	"github.com/pingcap/tidb/sessionctx"
 	"github.com/pingcap/tidb/sessionctx/stmtctx"
 	"github.com/pingcap/tidb/types"
@@ -34,7 +36,29 @@ type CreateIdxOpt struct {
 	SkipHandleCheck bool // If true, skip the handle constraint check.
 	SkipCheck       bool // If true, skip all the unique indices constraint check.
 	Ctx             context.Context
-	Untouched       bool // If true, the index key/value is no need to commit.
+	Untouched       bool       // If true, the index key/value is no need to commit.
+	Sequence        *int64     // If not nil, the insertion sequence of the entry for an index which keeps one.
+	PlacementHint   string     // The placement hint stored in the entry for an index which stores them.
+	OpStats         *KVOpStats // If not nil, the KV operations the call issues are added to it.
+	// The values of the included columns stored in a non-distinct entry of an index with included columns.
+	IncludedValues []types.Datum
+	// The full row of the entry, which a partial index evaluates its predicate on.
+	Row []types.Datum
+	// The assertion attached to the write of a distinct entry, see WithAssertion.
+	Assertion kv.AssertionType
+}
+
+// KVOpStats counts the KV operations an index operation issues, e.g. to verify a write path optimization.
+type KVOpStats struct {
+	Gets    int
+	Iters   int
+	Sets    int
+	Deletes int
+}
+
+// Total returns the number of all the operations.
+func (s *KVOpStats) Total() int {
+	return s.Gets + s.Iters + s.Sets + s.Deletes
 }
 
 // CreateIdxOptFunc is defined for the Create() method of Index interface.
@@ -65,6 +89,210 @@ func WithCtx(ctx context.Context) CreateIdxOptFunc {
 	}
 }
 
+// WithSequence returns a CreateIdxOptFunc.
+// This option is used to supply the insertion sequence of the entry instead of generating one.
+func WithSequence(seq int64) CreateIdxOptFunc {
+	return func(opt *CreateIdxOpt) {
+		opt.Sequence = &seq
+	}
+}
+
+// WithPlacementHint returns a CreateIdxOptFunc.
+// This option is used to store the placement hint of the entry, e.g. the region or the replica which should
+// own the reads of the row, for an index which stores them.
+func WithPlacementHint(hint string) CreateIdxOptFunc {
+	return func(opt *CreateIdxOpt) {
+		opt.PlacementHint = hint
+	}
+}
+
+// WithOpStats returns a CreateIdxOptFunc.
+// This option is used to count the KV operations the Create issues in stats.
+func WithOpStats(stats *KVOpStats) CreateIdxOptFunc {
+	return func(opt *CreateIdxOpt) {
+		opt.OpStats = stats
+	}
+}
+
+// WithIncludedValues returns a CreateIdxOptFunc.
+// This option is used to store the values of the included columns of the row in the entry, for an index
+// with included columns, so a covering read doesn't look up the row.
+func WithIncludedValues(vals []types.Datum) CreateIdxOptFunc {
+	return func(opt *CreateIdxOpt) {
+		opt.IncludedValues = vals
+	}
+}
+
+// WithRow returns a CreateIdxOptFunc.
+// This option is used to pass the full row of the entry, for a partial index which only indexes the rows
+// qualified by its predicate.
+func WithRow(row []types.Datum) CreateIdxOptFunc {
+	return func(opt *CreateIdxOpt) {
+		opt.Row = row
+	}
+}
+
+// WithAssertion returns a CreateIdxOptFunc.
+// This option is used to attach assertion to the write of a distinct entry of a unique index, if the kv.Mutator
+// is a kv.AssertionMutator. With kv.NotExist, the uniqueness isn't checked by reading the key but by the engine
+// at commit time, so a duplicate fails the commit instead of the Create. Only kv.NotExist is supported.
+func WithAssertion(assertion kv.AssertionType) CreateIdxOptFunc {
+	return func(opt *CreateIdxOpt) {
+		opt.Assertion = assertion
+	}
+}
+
+// DeleteIdxOpt contains the options will be used when deleting an index entry.
+type DeleteIdxOpt struct {
+	// If true, read the entry before deleting it and fail if it doesn't point to the handle to delete.
+	VerifyHandle bool
+	// If not nil, the KV operations the Delete issues are added to it.
+	OpStats *KVOpStats
+	// The full row of the entry, which a partial index evaluates its predicate on.
+	Row []types.Datum
+	// The session, which a partial index evaluates its predicate with, and a deferred index keeps its pending checks in.
+	SessionCtx sessionctx.Context
+}
+
+// DeleteIdxOptFunc is defined for the Delete() method of Index interface.
+type DeleteIdxOptFunc func(*DeleteIdxOpt)
+
+// VerifyHandle is a defined value of DeleteIdxOptFunc.
+// The kv.Mutator passed to Delete must also be a kv.Retriever to verify the handle.
+var VerifyHandle DeleteIdxOptFunc = func(opt *DeleteIdxOpt) {
+	opt.VerifyHandle = true
+}
+
+// WithDeleteOpStats returns a DeleteIdxOptFunc.
+// This option is used to count the KV operations the Delete issues in stats.
+func WithDeleteOpStats(stats *KVOpStats) DeleteIdxOptFunc {
+	return func(opt *DeleteIdxOpt) {
+		opt.OpStats = stats
+	}
+}
+
+// WithDeleteRow returns a DeleteIdxOptFunc.
+// This option is used to pass the full row of the entry, for a partial index which only has an entry
+// for the rows qualified by its predicate.
+func WithDeleteRow(sctx sessionctx.Context, row []types.Datum) DeleteIdxOptFunc {
+	return func(opt *DeleteIdxOpt) {
+		opt.SessionCtx = sctx
+		opt.Row = row
+	}
+}
+
+// WithDeleteSessionCtx returns a DeleteIdxOptFunc.
+// This option is used to pass the session, for an index which records its pending deferred unique checks in it.
+func WithDeleteSessionCtx(sctx sessionctx.Context) DeleteIdxOptFunc {
+	return func(opt *DeleteIdxOpt) {
+		opt.SessionCtx = sctx
+	}
+}
+
+// DropIdxOpt contains the options will be used when dropping an index.
+type DropIdxOpt struct {
+	// If positive, at most BatchSize entries are deleted by one Drop.
+	BatchSize int
+	// If not nil, the drop starts at StartKey, the continuation key returned by the last Drop.
+	StartKey kv.Key
+	// If not nil, the drop stops with the error of Ctx once Ctx is done.
+	Ctx context.Context
+}
+
+// DropIdxOptFunc is defined for the Drop() method of Index interface.
+type DropIdxOptFunc func(*DropIdxOpt)
+
+// WithDropBatchSize returns a DropIdxOptFunc.
+// This option is used to bound the entries deleted by one Drop, e.g. to keep a transaction under the size limit.
+func WithDropBatchSize(size int) DropIdxOptFunc {
+	return func(opt *DropIdxOpt) {
+		opt.BatchSize = size
+	}
+}
+
+// WithDropStartKey returns a DropIdxOptFunc.
+// This option is used to resume a drop from the continuation key returned by the last Drop.
+func WithDropStartKey(key kv.Key) DropIdxOptFunc {
+	return func(opt *DropIdxOpt) {
+		opt.StartKey = key
+	}
+}
+
+// WithDropCtx returns a DropIdxOptFunc.
+// This option is used to cancel a long drop, e.g. by a statement timeout. The cancelled drop returns
+// the continuation key besides the error, so it can be resumed by WithDropStartKey.
+func WithDropCtx(ctx context.Context) DropIdxOptFunc {
+	return func(opt *DropIdxOpt) {
+		opt.Ctx = ctx
+	}
+}
+
+// DupKeyError is the kv.ErrKeyExists returned by Index.Create for a unique conflict, with the details of the
+// conflict. Its message is MySQL's, e.g. "Duplicate entry 'x-y' for key 'idx'", so INSERT can return it as is,
+// and it's still kv.ErrKeyExists for errors.Is, kv.ErrKeyExists.Equal and terror.ErrorEqual.
+type DupKeyError struct {
+	IndexName string
+	// Handle is the int handle of the existing entry, 0 for a kv.CommonHandle.
+	Handle int64
+	// KVHandle is the handle of the existing entry, an IntHandle or a CommonHandle.
+	KVHandle kv.Handle
+	// Entry is the conflicting values joined by '-', with NULL for a NULL value.
+	Entry string
+	err   error
+}
+
+// NewDupKeyError returns the DupKeyError of indexedValues of the index indexName conflicting with the entry of handle.
+func NewDupKeyError(indexName string, handle int64, indexedValues []types.Datum) error {
+	return NewDupKeyErrorWithHandle(indexName, kv.IntHandle(handle), indexedValues)
+}
+
+// NewDupKeyErrorWithHandle is NewDupKeyError for a kv.Handle.
+func NewDupKeyErrorWithHandle(indexName string, handle kv.Handle, indexedValues []types.Datum) error {
+	strVals := make([]string, 0, len(indexedValues))
+	for _, v := range indexedValues {
+		str := "NULL"
+		if !v.IsNull() {
+			var err error
+			if str, err = v.ToString(); err != nil {
+				str = v.String()
+			}
+		}
+		strVals = append(strVals, str)
+	}
+	entry := strings.Join(strVals, "-")
+	e := &DupKeyError{
+		IndexName: indexName,
+		KVHandle:  handle,
+		Entry:     entry,
+		err:       kv.ErrKeyExists.FastGenByArgs(entry, indexName),
+	}
+	if handle.IsInt() {
+		e.Handle = handle.IntValue()
+	}
+	return e
+}
+
+// Error implements the error interface.
+func (e *DupKeyError) Error() string {
+	return e.err.Error()
+}
+
+// Cause returns the kv.ErrKeyExists with the message, for errors.Cause.
+func (e *DupKeyError) Cause() error {
+	return e.err
+}
+
+// Unwrap returns the kv.ErrKeyExists with the message.
+func (e *DupKeyError) Unwrap() error {
+	return e.err
+}
+
+// Is reports whether target is kv.ErrKeyExists, for errors.Is.
+func (e *DupKeyError) Is(target error) bool {
+	t, ok := target.(*terror.Error)
+	return ok && t.Equal(e.err)
+}
+
 // Index is the interface for index data on KV store.
 type Index interface {
 	// Meta returns IndexInfo.
@@ -72,9 +300,10 @@ type Index interface {
 	// Create supports insert into statement.
 	Create(ctx sessionctx.Context, rm kv.RetrieverMutator, indexedValues []types.Datum, h int64, opts ...CreateIdxOptFunc) (int64, error)
 	// Delete supports delete from statement.
-	Delete(sc *stmtctx.StatementContext, m kv.Mutator, indexedValues []types.Datum, h int64) error
+	Delete(sc *stmtctx.StatementContext, m kv.Mutator, indexedValues []types.Datum, h int64, opts ...DeleteIdxOptFunc) error
 	// Drop supports drop table, drop index statements.
-	Drop(rm kv.RetrieverMutator) error
+	// If the batch size is hit, done is false and the drop is resumed from next by another Drop.
+	Drop(rm kv.RetrieverMutator, opts ...DropIdxOptFunc) (next kv.Key, done bool, err error)
 	// Exist supports check index exists or not.
 	Exist(sc *stmtctx.StatementContext, rm kv.RetrieverMutator, indexedValues []types.Datum, h int64) (bool, int64, error)
 	// GenIndexKey generates an index key.
@@ -69,6 +69,18 @@ var (
 	ErrNoDefaultValue = terror.ClassTable.New(mysql.ErrNoDefaultForField, mysql.MySQLErrName[mysql.ErrNoDefaultForField])
 	// ErrIndexOutBound returns for index column offset out of bound.
 	ErrIndexOutBound = terror.ClassTable.New(mysql.ErrIndexOutBound, mysql.MySQLErrName[mysql.ErrIndexOutBound])
+	// ErrKeyColumnDoesNotExist returns for index column which doesn't match the table column at its offset.
+	ErrKeyColumnDoesNotExist = terror.ClassTable.New(mysql.ErrKeyColumnDoesNotExits, mysql.MySQLErrName[mysql.ErrKeyColumnDoesNotExits])
+	// ErrIndexHandleMismatch returns for index entry which doesn't point to the expected handle.
+	ErrIndexHandleMismatch = terror.ClassTable.New(mysql.ErrIndexHandleMismatch, mysql.MySQLErrName[mysql.ErrIndexHandleMismatch])
+	// ErrIndexFormatMismatch returns for index entry written in a format the index can't read.
+	ErrIndexFormatMismatch = terror.ClassTable.New(mysql.ErrIndexFormatMismatch, mysql.MySQLErrName[mysql.ErrIndexFormatMismatch])
+	// ErrIndexCollationVersion returns for index used with another collation version than it's built with.
+	ErrIndexCollationVersion = terror.ClassTable.New(mysql.ErrIndexCollationVersion, mysql.MySQLErrName[mysql.ErrIndexCollationVersion])
+	// ErrIndexKeyTooLong returns for index key longer than the max key length of the index.
+	ErrIndexKeyTooLong = terror.ClassTable.New(mysql.ErrIndexKeyTooLong, mysql.MySQLErrName[mysql.ErrIndexKeyTooLong])
+	// ErrIndexValueKindMismatch returns for index value whose kind doesn't fit the type of its column.
+	ErrIndexValueKindMismatch = terror.ClassTable.New(mysql.ErrIndexValueKindMismatch, mysql.MySQLErrName[mysql.ErrIndexValueKindMismatch])
 	// ErrUnsupportedOp returns for unsupported operation.
 	ErrUnsupportedOp = terror.ClassTable.New(mysql.ErrUnsupportedOp, mysql.MySQLErrName[mysql.ErrUnsupportedOp])
 	// ErrRowNotFound returns for row not found.
@@ -245,6 +257,12 @@ func init() {
 		mysql.ErrNoPartitionForGivenValue:    mysql.ErrNoPartitionForGivenValue,
 		mysql.ErrLockOrActiveTransaction:     mysql.ErrLockOrActiveTransaction,
 		mysql.ErrIndexOutBound:               mysql.ErrIndexOutBound,
+		mysql.ErrKeyColumnDoesNotExits:       mysql.ErrKeyColumnDoesNotExits,
+		mysql.ErrIndexHandleMismatch:         mysql.ErrIndexHandleMismatch,
+		mysql.ErrIndexFormatMismatch:         mysql.ErrIndexFormatMismatch,
+		mysql.ErrIndexCollationVersion:       mysql.ErrIndexCollationVersion,
+		mysql.ErrIndexKeyTooLong:             mysql.ErrIndexKeyTooLong,
+		mysql.ErrIndexValueKindMismatch:      mysql.ErrIndexValueKindMismatch,
 		mysql.ErrColumnStateNonPublic:        mysql.ErrColumnStateNonPublic,
 		mysql.ErrFieldGetDefaultFailed:       mysql.ErrFieldGetDefaultFailed,
 		mysql.ErrUnsupportedOp:               mysql.ErrUnsupportedOp,
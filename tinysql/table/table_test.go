@@ -45,4 +45,9 @@ func (t *testTableSuite) TestErrorCode(c *C) {
 	c.Assert(int(ErrInvalidRecordKey.ToSQLError().Code), Equals, mysql.ErrInvalidRecordKey)
 	c.Assert(int(ErrTruncatedWrongValueForField.ToSQLError().Code), Equals, mysql.ErrTruncatedWrongValueForField)
 	c.Assert(int(ErrLockOrActiveTransaction.ToSQLError().Code), Equals, mysql.ErrLockOrActiveTransaction)
+	c.Assert(int(ErrIndexHandleMismatch.ToSQLError().Code), Equals, mysql.ErrIndexHandleMismatch)
+	c.Assert(int(ErrIndexFormatMismatch.ToSQLError().Code), Equals, mysql.ErrIndexFormatMismatch)
+	c.Assert(int(ErrIndexCollationVersion.ToSQLError().Code), Equals, mysql.ErrIndexCollationVersion)
+	c.Assert(int(ErrIndexKeyTooLong.ToSQLError().Code), Equals, mysql.ErrIndexKeyTooLong)
+	c.Assert(int(ErrIndexValueKindMismatch.ToSQLError().Code), Equals, mysql.ErrIndexValueKindMismatch)
 }
@@ -18,21 +18,34 @@ import (
 	"context"
 	"encoding/binary"
 	"io"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
 	"unicode/utf8"
 
 	"github.com/pingcap/errors"
 	"github.com/pingcap/tidb/kv"
 	"github.com/pingcap/tidb/parser/charset"
 	"github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tidb/parser/mysql"
+	"github.com/pingcap/tidb/parser/terror"
 	"github.com/pingcap/tidb/sessionctx"
 	"github.com/pingcap/tidb/sessionctx/stmtctx"
 	"github.com/pingcap/tidb/table"
 	"github.com/pingcap/tidb/tablecodec"
 	"github.com/pingcap/tidb/types"
 	"github.com/pingcap/tidb/util/codec"
+	"github.com/pingcap/tidb/util/logutil"
+	"go.uber.org/zap"
 )
 
 // EncodeHandle encodes handle in data.
+// An index entry stores its handle in one of two encodings: a distinct entry (unique index without NULL, see uniqueValues)
+// stores EncodeHandle(h), the raw 8-byte big-endian handle, as its value, while any other entry appends
+// the handle to the key as a codec encoded int datum, which is a flag byte followed by the comparable
+// 8-byte encoding, so the entries with the same values are ordered by handle. Both decode to the same int64.
 func EncodeHandle(h int64) []byte {
 	var data [8]byte
 	binary.BigEndian.PutUint64(data[:], uint64(h))
@@ -44,13 +57,49 @@ func DecodeHandle(data []byte) (int64, error) {
 	return int64(binary.BigEndian.Uint64(data)), nil
 }
 
+// EncodeHandleCompact encodes handle in data with the comparable varint encoding of codec, which takes 1 byte
+// for a small handle and at most 9 bytes, and keeps the order of the handles, see WithCompactHandles.
+func EncodeHandleCompact(h int64) []byte {
+	return codec.EncodeComparableVarint(nil, h)
+}
+
+// DecodeHandleCompact decodes handle in data encoded by EncodeHandleCompact.
+func DecodeHandleCompact(data []byte) (int64, error) {
+	remain, h, err := codec.DecodeComparableVarint(data)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	if len(remain) > 0 {
+		return 0, errors.Errorf("invalid compact handle %x", data)
+	}
+	return h, nil
+}
+
 // indexIter is for KV store index iterator.
 type indexIter struct {
 	it     kv.Iterator
 	idx    *index
 	prefix kv.Key
+
+	// ctx is checked every ctxCheckInterval entries if it's set.
+	ctx   context.Context
+	count int
+
+	// handle is the handle of the entry last returned by Next, see Handle.
+	handle       int64
+	commonHandle kv.Handle
+
+	// upper is the exclusive upper bound of the keys if it's set, see SeekRange.
+	upper kv.Key
+
+	// withTombstones is set to return the tombstoned entries instead of skipping them, see SeekWithTombstones.
+	withTombstones bool
 }
 
+// ctxCheckInterval is the number of entries an indexIter returns between two checks of its context,
+// checking it for every entry costs too much for a fast scan.
+const ctxCheckInterval = 64
+
 // Close does the clean up works when KV store index iterator is closed.
 func (c *indexIter) Close() {
 	if c.it != nil {
@@ -61,35 +110,152 @@ func (c *indexIter) Close() {
 
 // Next returns current key and moves iterator to the next step.
 func (c *indexIter) Next() (val []types.Datum, h int64, err error) {
+	val, h, _, err = c.NextWithMeta()
+	return
+}
+
+// NextWithWriteTime is Next also returning the write time of the entry, see WithWriteTime.
+// The time is zero if the entry isn't stamped.
+func (c *indexIter) NextWithWriteTime() (val []types.Datum, h int64, ts time.Time, err error) {
+	val, h, meta, err := c.NextWithMeta()
+	return val, h, meta.WriteTime, err
+}
+
+// NextWithMeta is Next also returning the metadata stored in the value of the entry.
+func (c *indexIter) NextWithMeta() (val []types.Datum, h int64, meta EntryMeta, err error) {
+	if err = c.checkNext(); err != nil {
+		return nil, 0, meta, err
+	}
+	c.count++
+	if c.idx.isCommonHandle() {
+		// The handle isn't an int, Next returns 0 and it's only returned by Handle.
+		val, c.commonHandle, meta, err = c.idx.decodeEntryHandle(c.it.Key(), c.it.Value())
+	} else {
+		val, h, meta, err = c.idx.decodeEntryWithMeta(c.it.Key(), c.it.Value())
+		c.handle = h
+	}
+	if err != nil {
+		return nil, 0, meta, err
+	}
+	// update new iter to next
+	err = c.it.Next()
+	if err != nil {
+		return nil, 0, meta, err
+	}
+	return
+}
+
+// checkNext checks the iterator is at an entry to return, otherwise it returns io.EOF or the error of the context.
+func (c *indexIter) checkNext() error {
+	if c.it == nil {
+		// The iterator is closed, e.g. released by a cancelled scan.
+		if c.ctx != nil && c.ctx.Err() != nil {
+			return errors.Trace(c.ctx.Err())
+		}
+		return errors.Trace(io.EOF)
+	}
+	if err := c.skipTombstones(); err != nil {
+		return err
+	}
 	if !c.it.Valid() {
-		return nil, 0, errors.Trace(io.EOF)
+		return errors.Trace(io.EOF)
 	}
 	if !c.it.Key().HasPrefix(c.prefix) {
-		return nil, 0, errors.Trace(io.EOF)
+		return errors.Trace(io.EOF)
 	}
-	// get indexedValues
-	buf := c.it.Key()[len(c.prefix):]
-	vv, err := codec.Decode(buf, len(c.idx.idxInfo.Columns))
+	if c.upper != nil && c.it.Key().Cmp(c.upper) >= 0 {
+		return errors.Trace(io.EOF)
+	}
+	if c.ctx != nil && c.count%ctxCheckInterval == 0 {
+		if err := c.ctx.Err(); err != nil {
+			// Release the KV iterator at once, the caller may not close a cancelled scan promptly.
+			c.Close()
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// Handle returns the handle of the entry last returned by Next, which is a kv.CommonHandle
+// for a table with common handles, and a kv.IntHandle otherwise.
+func (c *indexIter) Handle() kv.Handle {
+	if c.idx.isCommonHandle() {
+		return c.commonHandle
+	}
+	return kv.IntHandle(c.handle)
+}
+
+// NamedDatum is an index value labeled with its column name.
+type NamedDatum struct {
+	Name  string
+	Datum types.Datum
+}
+
+// NamedIndexIterator is implemented by the iterators returned by Seek and SeekFirst.
+type NamedIndexIterator interface {
+	table.IndexIterator
+	// NextNamed is Next returning the values labeled with the index column names, in column order.
+	NextNamed() ([]NamedDatum, int64, error)
+}
+
+// NextNamed implements NamedIndexIterator NextNamed interface.
+func (c *indexIter) NextNamed() ([]NamedDatum, int64, error) {
+	vals, h, err := c.Next()
 	if err != nil {
 		return nil, 0, err
 	}
-	if len(vv) > len(c.idx.idxInfo.Columns) {
-		h = vv[len(vv)-1].GetInt64()
-		val = vv[0 : len(vv)-1]
-	} else {
-		// If the index is unique and the value isn't nil, the handle is in value.
-		h, err = DecodeHandle(c.it.Value())
+	named := make([]NamedDatum, len(vals))
+	for i, v := range vals {
+		named[i] = NamedDatum{Name: c.idx.idxInfo.Columns[i].Name.O, Datum: v}
+	}
+	return named, h, nil
+}
+
+// IndexRow is a decoded index entry.
+type IndexRow struct {
+	Values []types.Datum
+	Handle int64
+}
+
+// distinctIter is a loose index scan iterator which returns the distinct tuples of the leading columns.
+type distinctIter struct {
+	r       kv.Retriever
+	idx     *index
+	numCols int
+	// seekKey is where the next distinct tuple is searched from.
+	seekKey kv.Key
+}
+
+// Close implements table.IndexIterator Close interface.
+func (c *distinctIter) Close() {}
+
+// Next returns the next distinct tuple, the handle is always 0.
+// Instead of walking the duplicates, every call seeks past all the entries sharing the previous tuple.
+func (c *distinctIter) Next() (val []types.Datum, h int64, err error) {
+	prefix := c.idx.scanPrefix
+	it, err := c.r.Iter(c.seekKey, prefix.PrefixNext())
+	if err != nil {
+		return nil, 0, err
+	}
+	defer it.Close()
+	if !it.Valid() || !it.Key().HasPrefix(prefix) {
+		return nil, 0, errors.Trace(io.EOF)
+	}
+	key := it.Key()
+	remain := key[len(prefix):]
+	for i := 0; i < c.numCols; i++ {
+		remain, err = c.idx.cutIndexValue(remain, i)
 		if err != nil {
 			return nil, 0, err
 		}
-		val = vv
 	}
-	// update new iter to next
-	err = c.it.Next()
+	tuple := key[len(prefix) : len(key)-len(remain)]
+	val, err = c.idx.decodeIndexValues(tuple)
 	if err != nil {
 		return nil, 0, err
 	}
-	return
+	c.seekKey = kv.Key(key[:len(key)-len(remain)]).PrefixNext()
+	return val, 0, nil
 }
 
 // index is the data structure for index data in the KV store.
@@ -97,244 +263,2347 @@ type index struct {
 	idxInfo *model.IndexInfo
 	tblInfo *model.TableInfo
 	prefix  kv.Key
+	// scanPrefix bounds the scans, it's the prefix followed by the tenant of an index scoped to a tenant.
+	scanPrefix kv.Key
+
+	// slowLogThreshold is the duration above which an operation is reported to slowLog, 0 means disabled.
+	slowLogThreshold time.Duration
+	slowLog          SlowLogFunc
+
+	// hashFunc is set for an index whose hashedCols are stored as hashes instead of their values.
+	hashFunc   HashFunc
+	hashedCols []bool
+	// sortKeys are the SortKeyFuncs of the index columns, nil for the columns encoded as is.
+	sortKeys []SortKeyFunc
+
+	// seqGen is set for an index which keeps the entries with the same values in insertion order.
+	seqGen func() int64
+
+	// nullsLast is set for an index whose NULL leading values sort after all the other values.
+	nullsLast bool
+
+	// prefixConflictDiag is set to report the unique conflicts caused by the prefix lengths,
+	// fetchIndexedValues returns the full indexed values of the row of a handle for the report.
+	prefixConflictDiag bool
+	fetchIndexedValues func(h int64) ([]types.Datum, error)
+
+	// exprCols are the ExprFuncs of the index columns, nil for the plain columns.
+	exprCols []ExprFunc
+	// exprErr is the error compiling the generated expressions of the index columns, see WithGeneratedColumns.
+	exprErr error
+	// optErr is the error of a combination of the options the index can't encode, see validateOptions.
+	optErr error
+
+	// formatMagic is set for an index which writes handleFormatMagic after the handle of a distinct entry.
+	formatMagic bool
+
+	// writeTimeNow is set for an index which stamps the write time of the entries in their values.
+	writeTimeNow func() time.Time
+
+	// capacity is set for an index which warns when its entry count crosses some thresholds.
+	capacity *capacityGuard
+
+	// placementHints is set for an index which stores the placement hints of the entries in their values.
+	placementHints bool
+
+	// valueCache caches the encoded original values of an index which stores them in the values.
+	valueCache *valueCache
+
+	// collationVersion is the version of the weights given by sortKeys, see WithCollationVersion.
+	collationVersion uint32
+
+	// collatedCols are set for the index columns whose sort keys are given by their collations, see WithCollations.
+	collatedCols []bool
+
+	// compactHandles is set for an index which encodes the handles by EncodeHandleCompact, see WithCompactHandles.
+	compactHandles bool
+
+	// metrics receives the counters of the index operations, see WithMetricsHook.
+	metrics MetricsHook
+
+	// multiValued is set for an index whose column multiValuedCol holds a JSON array, see WithMultiValued.
+	multiValued    bool
+	multiValuedCol int
+
+	// includeCols are the offsets of the table columns stored in the non-distinct entries, see WithIncludeColumns.
+	includeCols []int
+
+	// maxKeyLen is the max length of the keys if it's positive, see WithMaxKeyLen.
+	maxKeyLen     int
+	lenientKeyLen bool
+
+	// tombstoneNow is set for an index which keeps the deleted entries as tombstones, see WithTombstones.
+	tombstoneNow func() time.Time
+
+	// strictValues is set to check the kinds of the values fetched by FetchValues, see WithStrictValues.
+	strictValues bool
+
+	// global is set for an index shared by all the partitions, partitionID is the one of the index, see WithGlobal.
+	global      bool
+	partitionID int64
+
+	// predicate qualifies the rows which have an entry in a partial index, see WithPredicate.
+	predicate IndexPredicate
+
+	// deferUnique is set to defer the unique checks to CheckDeferredConstraints, see WithDeferredUnique.
+	deferUnique bool
+
+	// legacyValues is set for an index which writes its values without the versioned envelope, see WithLegacyValues.
+	legacyValues bool
+}
+
+// capacityGuard counts the entries created by an index and reports each threshold crossed by the count once.
+type capacityGuard struct {
+	count      int64
+	thresholds []int64
+	// next is the index of the next threshold to report.
+	next int32
+	fn   func(indexName string, threshold, count int64)
+}
+
+// add counts a created entry and reports the thresholds it crosses.
+func (g *capacityGuard) add(indexName string) {
+	count := atomic.AddInt64(&g.count, 1)
+	for {
+		next := atomic.LoadInt32(&g.next)
+		if int(next) >= len(g.thresholds) || count < g.thresholds[next] {
+			return
+		}
+		if atomic.CompareAndSwapInt32(&g.next, next, next+1) {
+			g.fn(indexName, g.thresholds[next], count)
+		}
+	}
+}
+
+// maxPlacementHintLen is the maximum length of a placement hint.
+const maxPlacementHintLen = 255
+
+// handleFormatMagic follows the big-endian handle in the value of a distinct entry of an index with
+// format magic. A build encoding the handle differently must use another magic byte.
+const handleFormatMagic byte = 0xbe
+
+// compactHandleVersion follows the compact handle in the value of a distinct entry of an index with compact
+// handles. A 1-byte handle may be the untouched flag, so the value never has the shape of an untouched value.
+const compactHandleVersion byte = 0x03
+
+// nullsLastFlag replaces the NULL flag of the leading value of a NullsLast index,
+// it's the flag codec uses for MaxValue, which sorts after the flags of all the other values.
+const nullsLastFlag byte = 250
+
+// Index operation types reported to SlowLogFunc.
+const (
+	IndexOpCreate = "create"
+	IndexOpDelete = "delete"
+	IndexOpSeek   = "seek"
+)
+
+// SlowLogFunc is called when an index operation takes longer than the slow log threshold.
+type SlowLogFunc func(idxName string, op string, cost time.Duration)
+
+// IndexOption is defined for the NewIndex function to set the optional behaviors of an index.
+type IndexOption func(*index)
+
+// WithSlowLog returns an IndexOption which reports the Create, Delete and Seek calls
+// that take longer than threshold to fn. If fn is nil, a warning is logged instead.
+func WithSlowLog(threshold time.Duration, fn SlowLogFunc) IndexOption {
+	return func(c *index) {
+		c.slowLogThreshold = threshold
+		c.slowLog = fn
+	}
+}
+
+// HashFunc computes the hash stored in the index key in place of a column value.
+type HashFunc func(d types.Datum) uint64
+
+// WithHashedColumns returns an IndexOption which stores fn's hash of the index columns at colOffsets
+// (offsets in the index columns) instead of the values themselves, to keep the keys of wide columns small.
+// The entries of a hashed index always keep the handle in the key and the original values in the value,
+// so entries whose values share a hash don't collide and can be told apart by HashLookup.
+func WithHashedColumns(fn HashFunc, colOffsets ...int) IndexOption {
+	return func(c *index) {
+		c.hashFunc = fn
+		c.hashedCols = make([]bool, len(c.idxInfo.Columns))
+		for _, offset := range colOffsets {
+			c.hashedCols[offset] = true
+		}
+	}
+}
+
+// WithInsertionSequence returns an IndexOption which appends a sequence number after the index columns
+// and before the handle, so the entries with the same values are scanned in insertion order even if
+// the handles aren't monotonic. Create takes the sequence from table.WithSequence if it's supplied,
+// otherwise from gen. If gen is nil, a counter starting from the current time in nanoseconds is used.
+// It can't be combined with WithHashedColumns or the sort keys, NewIndex rejects them.
+func WithInsertionSequence(gen func() int64) IndexOption {
+	return func(c *index) {
+		if gen == nil {
+			seq := time.Now().UnixNano()
+			gen = func() int64 { return atomic.AddInt64(&seq, 1) }
+		}
+		c.seqGen = gen
+	}
+}
+
+// WithTenantPrefix returns an IndexOption which scopes the index to the tenant whose ID is the value of
+// the leading index column. All the scans are bounded by the keys of the tenant, so they never return
+// the entries of another tenant, and the operations on the values of another tenant fail.
+func WithTenantPrefix(tenantID int64) IndexOption {
+	return func(c *index) {
+		// Encoding an int never fails.
+		c.scanPrefix, _ = codec.EncodeKey(nil, append(kv.Key{}, c.prefix...), types.NewIntDatum(tenantID))
+	}
+}
+
+// WithNullsLast returns an IndexOption which sorts the entries whose leading value is NULL after all
+// the other entries, so the index serves ORDER BY col NULLS LAST in a forward scan.
+func WithNullsLast() IndexOption {
+	return func(c *index) {
+		c.nullsLast = true
+	}
+}
+
+// SortKeyFunc returns the sort key stored in the index key in place of a column value,
+// the entries are ordered by the bytes of the sort keys.
+type SortKeyFunc func(d types.Datum) []byte
+
+// WithSortKey returns an IndexOption which orders the index column at colOffset (offset in the index
+// columns) by fn's sort key, e.g. for a natural sort order which no collation provides. Like a hashed
+// index, the entries always keep the handle in the key and the original values in the value, so
+// different values sharing a sort key don't collide, and the iterators return the original values.
+func WithSortKey(colOffset int, fn SortKeyFunc) IndexOption {
+	return func(c *index) {
+		if c.sortKeys == nil {
+			c.sortKeys = make([]SortKeyFunc, len(c.idxInfo.Columns))
+		}
+		c.sortKeys[colOffset] = fn
+		if c.collatedCols != nil {
+			c.collatedCols[colOffset] = false
+		}
+	}
+}
+
+// WithCollations returns an IndexOption which orders the string columns with a case insensitive collation,
+// e.g. utf8mb4_general_ci, by their sort keys under the collation instead of their bytes, so 'abc' and 'ABC'
+// sort together and collide on a unique index, and a seek to 'ABC' finds 'abc'. The index stores the original
+// values in the entry values as WithSortKey does, so the iterators return them. It doesn't change a column
+// whose sort key is given by WithSortKey before it, and it changes the keys of the existing entries, so it
+// can only be used for a new index.
+func WithCollations() IndexOption {
+	return func(c *index) {
+		for i, ic := range c.idxInfo.Columns {
+			if !isCaseInsensitiveCollation(c.tblInfo.Columns[ic.Offset].Collate) {
+				continue
+			}
+			if c.sortKeys == nil {
+				c.sortKeys = make([]SortKeyFunc, len(c.idxInfo.Columns))
+			}
+			if c.sortKeys[i] != nil {
+				continue
+			}
+			if c.collatedCols == nil {
+				c.collatedCols = make([]bool, len(c.idxInfo.Columns))
+			}
+			c.sortKeys[i] = caseInsensitiveSortKey
+			c.collatedCols[i] = true
+		}
+	}
+}
+
+// isCaseInsensitiveCollation returns whether the collation compares the strings case insensitively.
+func isCaseInsensitiveCollation(collate string) bool {
+	return strings.HasSuffix(strings.ToLower(collate), "_ci")
+}
+
+// caseInsensitiveSortKey is the sort key of a string under a case insensitive collation, which is
+// the upper case of the string without the trailing spaces, as the PAD SPACE collations compare them.
+func caseInsensitiveSortKey(d types.Datum) []byte {
+	s := strings.TrimRight(string(d.GetBytes()), " ")
+	return []byte(strings.Map(unicode.ToUpper, s))
+}
+
+// equalityKey returns the encoded values compared by the unique check of an index which stores the original
+// values, encoded is the encoded original values. Two values are duplicates if their equality keys are the same,
+// which are the original values with the columns ordered by a collation replaced by their sort keys.
+func (c *index) equalityKey(sc *stmtctx.StatementContext, encoded []byte) ([]byte, error) {
+	if c.collatedCols == nil {
+		return encoded, nil
+	}
+	vals, err := codec.Decode(encoded, len(c.idxInfo.Columns))
+	if err != nil {
+		return nil, err
+	}
+	for i := range vals {
+		if c.collatedCols[i] && !vals[i].IsNull() {
+			vals[i] = types.NewBytesDatum(c.sortKeys[i](vals[i]))
+		}
+	}
+	return codec.EncodeKey(sc, nil, vals...)
+}
+
+// WithCollationVersion returns an IndexOption which tells the version of the collation weights given by
+// the SortKeyFuncs of WithSortKey, e.g. of utf8mb4_0900_ai_ci. The weights may change across the versions,
+// so an index whose IndexInfo.CollationVersion is recorded can only be used with the same version, or the
+// keys would be silently misordered: the seeks and the writes fail with ErrIndexCollationVersion.
+// The version is recorded by BuildFromRows if it isn't yet, an index without a recorded version isn't checked.
+func WithCollationVersion(version uint32) IndexOption {
+	return func(c *index) {
+		c.collationVersion = version
+	}
+}
+
+// checkCollationVersion checks the index is used with the collation version it's built with.
+func (c *index) checkCollationVersion() error {
+	if c.sortKeys == nil || c.idxInfo.CollationVersion == 0 || c.idxInfo.CollationVersion == c.collationVersion {
+		return nil
+	}
+	return table.ErrIndexCollationVersion.GenWithStackByArgs(c.idxInfo.Name, c.idxInfo.CollationVersion, c.collationVersion)
+}
+
+// WithPrefixConflictDiagnostics returns an IndexOption which makes Create tell the conflicts on a unique
+// index caused by its prefix lengths, i.e. different values sharing the indexed prefix, from the exact
+// duplicates. The ErrKeyExists of such a conflict says so with both full values. fetch returns the full
+// indexed values of the row of a handle, if it's nil, the existing value is reported as the shared prefix.
+func WithPrefixConflictDiagnostics(fetch func(h int64) ([]types.Datum, error)) IndexOption {
+	return func(c *index) {
+		c.prefixConflictDiag = true
+		c.fetchIndexedValues = fetch
+	}
+}
+
+// ExprFunc evaluates the value of an expression index column from a table row.
+type ExprFunc func(row []types.Datum) (types.Datum, error)
+
+// WithExpressionColumn returns an IndexOption which makes the index column at colOffset (offset in the
+// index columns) an expression column, whose value FetchValues evaluates by fn from the row instead of
+// reading the column at its offset. The offset of the index column should refer to the column the
+// expression is over, whose type decides the prefix length handling.
+func WithExpressionColumn(colOffset int, fn ExprFunc) IndexOption {
+	return func(c *index) {
+		if c.exprCols == nil {
+			c.exprCols = make([]ExprFunc, len(c.idxInfo.Columns))
+		}
+		c.exprCols[colOffset] = fn
+	}
+}
+
+// GeneratedExprCompiler compiles the expression of the generated column col, i.e. col.GeneratedExprString,
+// to an ExprFunc evaluating it in the evaluation context of sctx. It's implemented by the layer which builds
+// the expressions, the table package can't.
+type GeneratedExprCompiler func(sctx sessionctx.Context, col *model.ColumnInfo) (ExprFunc, error)
+
+// WithGeneratedColumns returns an IndexOption which makes every index column over a generated column of the
+// table, e.g. the hidden column of an expression index, an expression column as WithExpressionColumn does, with
+// the expression compiled by compile. So FetchValues evaluates it from the row, whether the row has the
+// generated column or not, and Create, Delete and Exist all see the same evaluated values. A NULL argument of
+// the expression usually makes the value NULL, e.g. LOWER(NULL), which isn't distinct in a unique index.
+// If an expression can't be compiled, FetchValues returns the error.
+func WithGeneratedColumns(sctx sessionctx.Context, compile GeneratedExprCompiler) IndexOption {
+	return func(c *index) {
+		for i, ic := range c.idxInfo.Columns {
+			if ic.Offset < 0 || ic.Offset >= len(c.tblInfo.Columns) {
+				continue
+			}
+			col := c.tblInfo.Columns[ic.Offset]
+			if col.GeneratedExprString == "" {
+				continue
+			}
+			fn, err := compile(sctx, col)
+			if err != nil {
+				c.exprErr = errors.Annotatef(err, "index %s column %s", c.idxInfo.Name, col.Name)
+				return
+			}
+			WithExpressionColumn(i, fn)(c)
+		}
+	}
+}
+
+// WithFormatMagic returns an IndexOption which appends a magic byte of the handle format to the value of
+// a distinct entry, so reading an entry written in another format fails with ErrIndexFormatMismatch
+// instead of returning a wrong handle. So does reading a value without the magic byte, e.g. written
+// before the option is set, so it should be set since the index is created.
+// The untouched entries, which are never committed, don't have the magic byte.
+func WithFormatMagic() IndexOption {
+	return func(c *index) {
+		c.formatMagic = true
+	}
+}
+
+// WithCompactHandles returns an IndexOption which encodes the handles by EncodeHandleCompact instead of
+// EncodeHandle, both in the value of a distinct entry, followed by compactHandleVersion, and in the key of
+// any other entry, where the encoding keeps the entries with the same values ordered by handle.
+// It must be set since the index is created, as the entries in the other format can't be read.
+// The untouched entries, which are never committed, still use EncodeHandle. An index which keeps the
+// insertion order or stores the original values in the values, and a table with common handles, aren't supported.
+func WithCompactHandles() IndexOption {
+	return func(c *index) {
+		c.compactHandles = true
+	}
+}
+
+// WithWriteTime returns an IndexOption which stamps every written entry with the wall-clock time
+// returned by now, or time.Now if it's nil. The stamp is appended to the value with a version byte,
+// so it's only understood by an index with this option, which should be set since the index is created.
+// The untouched entries, which are never committed, aren't stamped.
+// NextWithWriteTime and WriteTime return the stamps.
+func WithWriteTime(now func() time.Time) IndexOption {
+	return func(c *index) {
+		if now == nil {
+			now = time.Now
+		}
+		c.writeTimeNow = now
+	}
+}
+
+// WithPlacementHints returns an IndexOption which stores the placement hint given to Create by
+// table.WithPlacementHint in the value of the entry, so a query router can prefer the replica owning
+// the reads of the row without another lookup. NextWithMeta and PlacementHint return the hints.
+// The hint is appended to the value with a version byte, a value written without the option has an empty hint.
+// The untouched entries, which are never committed, have no hints.
+func WithPlacementHints() IndexOption {
+	return func(c *index) {
+		c.placementHints = true
+	}
+}
+
+// WithValueCache returns an IndexOption which caches up to capacity encoded tuples of the original values
+// stored in the entry values by an index with hashed columns or sort keys, so Create encodes a repeated
+// tuple once, e.g. for a bulk load of low-cardinality values. The least recently used tuples are evicted.
+func WithValueCache(capacity int) IndexOption {
+	return func(c *index) {
+		if capacity > 0 {
+			c.valueCache = newValueCache(capacity)
+		}
+	}
+}
+
+// WithCapacityThresholds returns an IndexOption which calls fn once when the number of entries crosses each
+// of thresholds. Create counts the entries in memory starting from count, which is usually the number of
+// entries when the index is opened, e.g. from a periodic count. The deleted entries aren't subtracted, so
+// it's a cheap guardrail rather than an exact size.
+func WithCapacityThresholds(count int64, thresholds []int64, fn func(indexName string, threshold, count int64)) IndexOption {
+	return func(c *index) {
+		sorted := append([]int64(nil), thresholds...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		g := &capacityGuard{count: count, thresholds: sorted, fn: fn}
+		// The thresholds already crossed at startup aren't reported.
+		for int(g.next) < len(sorted) && sorted[g.next] <= count {
+			g.next++
+		}
+		c.capacity = g
+	}
 }
 
 // NewIndex builds a new Index object.
-func NewIndex(physicalID int64, tblInfo *model.TableInfo, indexInfo *model.IndexInfo) table.Index {
+func NewIndex(physicalID int64, tblInfo *model.TableInfo, indexInfo *model.IndexInfo, opts ...IndexOption) table.Index {
 	index := &index{
 		idxInfo: indexInfo,
 		tblInfo: tblInfo,
 		// The prefix can't encode from tblInfo.ID, because table partition may change the id to partition id.
 		prefix: tablecodec.EncodeTableIndexPrefix(physicalID, indexInfo.ID),
 	}
+	index.scanPrefix = index.prefix
+	for _, opt := range opts {
+		opt(index)
+	}
+	index.optErr = index.validateOptions()
 	return index
 }
 
-// Meta returns index info.
-func (c *index) Meta() *model.IndexInfo {
-	return c.idxInfo
+// NewIndexWithCheck builds a new Index object like NewIndex, but rejects an index whose
+// column offsets don't point at the table columns they name, or whose options can't be combined.
+func NewIndexWithCheck(physicalID int64, tblInfo *model.TableInfo, indexInfo *model.IndexInfo, opts ...IndexOption) (table.Index, error) {
+	if err := checkIndexColumns(tblInfo, indexInfo); err != nil {
+		return nil, err
+	}
+	idx := NewIndex(physicalID, tblInfo, indexInfo, opts...)
+	if err := idx.(*index).optErr; err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// validateOptions returns an error if the index is built with options it can't combine, i.e. whose keys or
+// values it couldn't decode back. NewIndex keeps the error, which fails the writes and the seeks of the index.
+func (c *index) validateOptions() error {
+	seq, original := c.seqGen != nil, c.storesOriginal()
+	for _, r := range []struct {
+		invalid bool
+		a, b    string
+	}{
+		// The unique check of a hashed entry scans the key without the handle, which a sequence doesn't end.
+		{seq && original, "the insertion order", "hashed or sort-keyed columns"},
+		// The handle suffix of a compact handle is only cut from a key ending with the handle.
+		{c.compactHandles && seq, "compact handles", "the insertion order"},
+		{c.compactHandles && original, "compact handles", "hashed or sort-keyed columns"},
+		{c.compactHandles && c.formatMagic, "compact handles", "format magic"},
+		// The metadata is only stored in the versioned envelope.
+		{c.legacyValues && c.global, "legacy values", "a global index"},
+		{c.legacyValues && c.placementHints, "legacy values", "placement hints"},
+		{c.legacyValues && c.writeTimeNow != nil, "legacy values", "write times"},
+		{c.legacyValues && c.tombstoneNow != nil, "legacy values", "tombstones"},
+		// A tombstone keeps the value of the entry, which has no room for the original values or the sequence.
+		{c.tombstoneNow != nil && seq, "tombstones", "the insertion order"},
+		{c.tombstoneNow != nil && original, "tombstones", "hashed or sort-keyed columns"},
+		{len(c.includeCols) > 0 && seq, "included columns", "the insertion order"},
+		{len(c.includeCols) > 0 && original, "included columns", "hashed or sort-keyed columns"},
+		{c.deferUnique && seq, "deferred unique checks", "the insertion order"},
+	} {
+		if r.invalid {
+			return errors.Errorf("index %s doesn't support %s with %s", c.idxInfo.Name, r.a, r.b)
+		}
+	}
+	return nil
+}
+
+// checkUsable checks the options of the index can be combined and it's used with the collation version
+// it's built with.
+func (c *index) checkUsable() error {
+	if c.optErr != nil {
+		return c.optErr
+	}
+	return c.checkCollationVersion()
+}
+
+// checkTenant checks that key belongs to the tenant of an index scoped to a tenant.
+func (c *index) checkTenant(key kv.Key) error {
+	if !key.HasPrefix(c.scanPrefix) {
+		return errors.Errorf("index %s is scoped to another tenant", c.idxInfo.Name)
+	}
+	return nil
+}
+
+// checkIndexColumns checks that every index column's offset refers to the table column with the same name,
+// so a stale offset can't make TruncateIndexValuesIfNeeded read the charset of another column.
+func checkIndexColumns(tblInfo *model.TableInfo, idxInfo *model.IndexInfo) error {
+	for _, ic := range idxInfo.Columns {
+		if ic.Offset < 0 || ic.Offset >= len(tblInfo.Columns) {
+			return table.ErrKeyColumnDoesNotExist.GenWithStackByArgs(ic.Name.O)
+		}
+		if tblInfo.Columns[ic.Offset].Name.L != ic.Name.L {
+			return table.ErrKeyColumnDoesNotExist.GenWithStackByArgs(ic.Name.O)
+		}
+	}
+	return nil
+}
+
+// FindDuplicateIndexes returns the pairs of the IDs of the indices of tblInfo with identical definitions,
+// i.e. the same columns in the same order with the same prefix lengths, and the same uniqueness.
+// The index which comes first in tblInfo.Indices is the first of a pair.
+func FindDuplicateIndexes(tblInfo *model.TableInfo) [][2]int64 {
+	var pairs [][2]int64
+	for i, a := range tblInfo.Indices {
+		for _, b := range tblInfo.Indices[i+1:] {
+			if sameIndexDefinition(a, b) {
+				pairs = append(pairs, [2]int64{a.ID, b.ID})
+			}
+		}
+	}
+	return pairs
+}
+
+func sameIndexDefinition(a, b *model.IndexInfo) bool {
+	if a.Unique != b.Unique || len(a.Columns) != len(b.Columns) {
+		return false
+	}
+	for i := range a.Columns {
+		if a.Columns[i].Name.L != b.Columns[i].Name.L || a.Columns[i].Length != b.Columns[i].Length {
+			return false
+		}
+	}
+	return true
+}
+
+// Meta returns index info.
+func (c *index) Meta() *model.IndexInfo {
+	return c.idxInfo
+}
+
+// UpdateMeta replaces the index info with newIdxInfo in place, the iterators of the index are kept.
+// Only the fields which don't affect the keys, e.g. the visibility or the comment, may differ, a change
+// of the ID, the columns, their order and prefix lengths or the uniqueness requires a new index.
+func (c *index) UpdateMeta(newIdxInfo *model.IndexInfo) error {
+	old := c.idxInfo
+	if newIdxInfo.ID != old.ID || !sameIndexDefinition(old, newIdxInfo) {
+		return errors.Errorf("index %s can't be updated in place, its key format is changed", old.Name)
+	}
+	for i, ic := range newIdxInfo.Columns {
+		if ic.Offset != old.Columns[i].Offset {
+			return errors.Errorf("index %s can't be updated in place, its key format is changed", old.Name)
+		}
+	}
+	c.idxInfo = newIdxInfo
+	return nil
+}
+
+// logSlowOp reports op to the slow log if it has run longer than the threshold.
+// It's expected to be deferred only when the threshold is set.
+func (c *index) logSlowOp(op string, start time.Time) {
+	cost := time.Since(start)
+	if cost < c.slowLogThreshold {
+		return
+	}
+	if c.slowLog != nil {
+		c.slowLog(c.idxInfo.Name.O, op, cost)
+		return
+	}
+	logutil.BgLogger().Warn("slow index operation", zap.String("index", c.idxInfo.Name.O), zap.String("op", op), zap.Duration("cost", cost))
+}
+
+func (c *index) getIndexKeyBuf(buf []byte, defaultCap int) []byte {
+	if buf != nil {
+		return buf[:0]
+	}
+
+	return make([]byte, 0, defaultCap)
+}
+
+// decodeEntry decodes the indexed values and the handle from an index key/value pair.
+// The key must start with the index prefix.
+func (c *index) decodeEntry(key, value []byte) ([]types.Datum, int64, error) {
+	vv, h, _, err := c.decodeEntryWithMeta(key, value)
+	return vv, h, err
+}
+
+// decodeEntryWithMeta is decodeEntry also returning the metadata stored in the value of the entry.
+func (c *index) decodeEntryWithMeta(key, value []byte) ([]types.Datum, int64, EntryMeta, error) {
+	value, meta, err := c.splitValue(value)
+	if err != nil {
+		return nil, 0, meta, err
+	}
+	if c.compactHandles {
+		return c.decodeCompactEntry(key, value, meta)
+	}
+	// get indexedValues
+	buf := key[len(c.prefix):]
+	vv, err := c.decodeIndexValues(buf)
+	if err != nil {
+		return nil, 0, meta, err
+	}
+	if err = c.checkDecodedCount(vv, len(vv) > len(c.idxInfo.Columns)); err != nil {
+		return nil, 0, meta, err
+	}
+	if !c.keyIsDistinct(vv[:len(c.idxInfo.Columns)]) {
+		// The handle is a datum in the key, see EncodeHandle for the two handle encodings.
+		h := vv[len(vv)-1].GetInt64()
+		if c.storesOriginal() {
+			// The key only has the hashes or the sort keys, the original values are in the value.
+			vv, err = codec.Decode(value[1:], len(c.idxInfo.Columns))
+			return vv, h, meta, err
+		}
+		// The sequence of an index which keeps the insertion order is between the values and the handle.
+		return vv[:len(c.idxInfo.Columns)], h, meta, nil
+	}
+	// If the index is unique and the value isn't nil, the handle is in value.
+	h, err := c.decodeHandleValue(value)
+	if err != nil {
+		return nil, 0, meta, err
+	}
+	return vv, h, meta, nil
+}
+
+// decodeCompactEntry is decodeEntryWithMeta for an index with compact handles, whose key ends with the
+// compact handle instead of a datum, so only the index values are decoded as datums.
+func (c *index) decodeCompactEntry(key, value []byte, meta EntryMeta) ([]types.Datum, int64, EntryMeta, error) {
+	b := key[len(c.prefix):]
+	remain := b
+	for i := range c.idxInfo.Columns {
+		var err error
+		if remain, err = c.cutIndexValue(remain, i); err != nil {
+			return nil, 0, meta, err
+		}
+	}
+	vv, err := c.decodeIndexValues(b[:len(b)-len(remain)])
+	if err != nil {
+		return nil, 0, meta, err
+	}
+	if err = c.checkDecodedCount(vv, len(remain) > 0); err != nil {
+		return nil, 0, meta, err
+	}
+	var h int64
+	if !c.keyIsDistinct(vv) {
+		h, err = DecodeHandleCompact(remain)
+	} else {
+		h, err = c.decodeHandleValue(value)
+	}
+	if err != nil {
+		return nil, 0, meta, err
+	}
+	return vv, h, meta, nil
+}
+
+// EntryMeta is the metadata an index may store in the value of an entry besides the handle.
+type EntryMeta struct {
+	// WriteTime is the write time of the entry, see WithWriteTime. It's zero if the entry isn't stamped.
+	WriteTime time.Time
+	// PlacementHint is the placement hint of the entry, see WithPlacementHints. It's empty if the entry has none.
+	PlacementHint string
+	// Deleted is set for a tombstoned entry, deleted at DeleteTime, see WithTombstones.
+	Deleted    bool
+	DeleteTime time.Time
+	// PartitionID is the physical ID of the partition of the row of an entry of a global index, see WithGlobal.
+	PartitionID int64
+}
+
+// encodeIndexValues appends the memcomparable encoding of indexedValues to key,
+// the leading NULL of a NullsLast index is encoded as nullsLastFlag.
+func (c *index) encodeIndexValues(sc *stmtctx.StatementContext, key []byte, indexedValues []types.Datum) ([]byte, error) {
+	if c.nullsLast && len(indexedValues) > 0 && indexedValues[0].IsNull() {
+		key = append(key, nullsLastFlag)
+		indexedValues = indexedValues[1:]
+	}
+	return codec.EncodeKey(sc, key, indexedValues...)
+}
+
+// decodeIndexValues decodes the values encoded by encodeIndexValues, followed by the datums encoded after them.
+func (c *index) decodeIndexValues(b []byte) ([]types.Datum, error) {
+	if c.nullsLast && len(b) > 0 && b[0] == nullsLastFlag {
+		if len(b) == 1 {
+			return []types.Datum{{}}, nil
+		}
+		vv, err := codec.Decode(b[1:], len(c.idxInfo.Columns))
+		if err != nil {
+			return nil, err
+		}
+		return append([]types.Datum{{}}, vv...), nil
+	}
+	return codec.Decode(b, len(c.idxInfo.Columns))
+}
+
+// cutIndexValue cuts the i-th index value encoded by encodeIndexValues from b, and returns the remains.
+func (c *index) cutIndexValue(b []byte, i int) ([]byte, error) {
+	if i == 0 && c.nullsLast && len(b) > 0 && b[0] == nullsLastFlag {
+		return b[1:], nil
+	}
+	_, remain, err := codec.CutOne(b)
+	return remain, err
+}
+
+// TruncateIndexValuesIfNeeded truncates the index values created using only the leading part of column values.
+// Values of BINARY(N) columns are right-padded with 0x00 to N bytes first, as MySQL stores them.
+// indexedValues is never modified, a copy is returned if any value is changed. Truncating the returned
+// values again doesn't change them, so a key generated twice from the same values is always the same.
+func TruncateIndexValuesIfNeeded(tblInfo *model.TableInfo, idxInfo *model.IndexInfo, indexedValues []types.Datum) []types.Datum {
+	return TruncateIndexValuesInto(tblInfo, idxInfo, indexedValues, nil)
+}
+
+// TruncateIndexValuesInto is TruncateIndexValuesIfNeeded copying the values into scratch instead of a new slice
+// if any value is changed, scratch is only grown if it's too short. So the returned values are either
+// indexedValues itself, if no value is changed, or scratch's backing array, if it's long enough. Neither is
+// modified by the caller's next call, but the one after may overwrite scratch, and scratch must not overlap
+// indexedValues. A caller building the values once and generating many keys can reuse the returned slice as the
+// scratch of the next call instead of copying the values defensively.
+func TruncateIndexValuesInto(tblInfo *model.TableInfo, idxInfo *model.IndexInfo, indexedValues, scratch []types.Datum) []types.Datum {
+	truncated, _ := truncateIndexValues(tblInfo, idxInfo, indexedValues, scratch)
+	return truncated
+}
+
+// truncateIndexValues is TruncateIndexValuesInto also returning whether the values are copied into scratch.
+func truncateIndexValues(tblInfo *model.TableInfo, idxInfo *model.IndexInfo, indexedValues, scratch []types.Datum) (truncated []types.Datum, copied bool) {
+	for i := 0; i < len(indexedValues); i++ {
+		v, changed := truncateIndexValue(tblInfo, idxInfo.Columns[i], indexedValues[i])
+		if changed {
+			if !copied {
+				indexedValues = append(scratch[:0], indexedValues...)
+				copied = true
+			}
+			indexedValues[i] = v
+		}
+	}
+
+	return indexedValues, copied
+}
+
+// truncateIndexValue returns the value v of the index column ic truncated as truncateIndexValues does,
+// and whether it's changed. Only the padding of a BINARY(N) value allocates.
+func truncateIndexValue(tblInfo *model.TableInfo, ic *model.IndexColumn, v types.Datum) (types.Datum, bool) {
+	if v.Kind() != types.KindString && v.Kind() != types.KindBytes {
+		return v, false
+	}
+	col := tblInfo.Columns[ic.Offset]
+	colCharset := col.Charset
+	colValue := v.GetBytes()
+	changed := false
+	if col.Tp == mysql.TypeString && types.IsBinaryStr(&col.FieldType) && len(colValue) < col.Flen {
+		padded := make([]byte, col.Flen)
+		copy(padded, colValue)
+		colValue = padded
+		setSameKind(&v, colValue)
+		changed = true
+	}
+	isUTF8Charset := colCharset == charset.CharsetUTF8 || colCharset == charset.CharsetUTF8MB4
+	if isUTF8Charset {
+		if ic.Length != types.UnspecifiedLength && utf8.RuneCount(colValue) > ic.Length {
+			// truncate value and limit its length, the bytes are sliced at the rune boundary
+			// instead of being re-encoded from runes, so a 4-byte character or an invalid byte
+			// is kept as is and every truncation of the value gives the same bytes.
+			setSameKind(&v, colValue[:runePrefixLen(colValue, ic.Length)])
+			changed = true
+		}
+	} else if ic.Length != types.UnspecifiedLength && len(colValue) > ic.Length {
+		// truncate value and limit its length
+		setSameKind(&v, colValue[:ic.Length])
+		changed = true
+	}
+	return v, changed
+}
+
+// setSameKind sets the string or bytes datum v to b without changing its kind. b is referenced instead
+// of copied, it's either a new buffer or a part of v's own bytes, which are never modified.
+func setSameKind(v *types.Datum, b []byte) {
+	if v.Kind() == types.KindBytes {
+		v.SetBytes(b)
+	} else {
+		v.SetBytesAsString(b)
+	}
+}
+
+// runePrefixLen returns the byte length of the first n runes of b, counted as utf8.RuneCount does.
+func runePrefixLen(b []byte, n int) int {
+	offset := 0
+	for i := 0; i < n && offset < len(b); i++ {
+		_, size := utf8.DecodeRune(b[offset:])
+		offset += size
+	}
+	return offset
+}
+
+// RangePrefixLen returns how many leading index columns the range [lower, upper] constrains by equality,
+// i.e. the number of leading columns whose lower and upper bounds are equal before the first column
+// constrained by a range. It's the length of the access condition prefix shown in EXPLAIN.
+func RangePrefixLen(lower, upper []types.Datum) int {
+	sc := &stmtctx.StatementContext{}
+	n := 0
+	for n < len(lower) && n < len(upper) {
+		cmp, err := lower[n].CompareDatum(sc, &upper[n])
+		if err != nil || cmp != 0 {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// GenIndexKey generates storage key for index values. Returned distinct indicates whether the
+// indexed values should be distinct in storage (i.e. whether handle is encoded in the key).
+// For an index which keeps the insertion order, a newly generated sequence is put before the handle.
+// A bytes value is encoded in the memcomparable format, which escapes the bytes in groups but keeps their
+// order, so a column holding another index key, e.g. for a denormalized table, sorts like the nested key.
+func (c *index) GenIndexKey(sc *stmtctx.StatementContext, indexedValues []types.Datum, h int64, buf []byte) (key []byte, distinct bool, err error) {
+	return c.genIndexKey(sc, indexedValues, h, buf, nil, nil)
+}
+
+// GenIndexKeyWithHandleOffset is GenIndexKey also returning the offset in the key where the handle suffix
+// starts, key[:handleOffset] is the prefix and the values, so comparing it ignores the handle.
+// For an index which keeps the insertion order, the suffix starts at the sequence. The offset is -1 for a
+// distinct key, which has no handle suffix.
+func (c *index) GenIndexKeyWithHandleOffset(sc *stmtctx.StatementContext, indexedValues []types.Datum, h int64, buf []byte) (key []byte, distinct bool, handleOffset int, err error) {
+	key, distinct, err = c.genIndexKey(sc, indexedValues, h, buf, nil, nil)
+	if err != nil {
+		return nil, false, 0, err
+	}
+	if distinct {
+		return key, true, -1, nil
+	}
+	if c.compactHandles {
+		return key, false, len(key) - len(EncodeHandleCompact(h)), nil
+	}
+	// The handle and the sequence are both encoded int datums, which are always 9 bytes.
+	handleOffset = len(key) - 9
+	if c.seqGen != nil {
+		handleOffset -= 9
+	}
+	return key, false, handleOffset, nil
+}
+
+// GenIndexKeys generates the keys of rows, the indexed values of several rows, with their handles, as
+// GenIndexKey does for each of them, e.g. for a multi-row INSERT. All the keys are encoded into buf, which is
+// grown to their total size given by EstimateIndexKeySize, so it's allocated once for all the rows instead of
+// once per row. The keys share buf's backing array but never overlap, and their capacities are capped, so
+// appending to a key copies it.
+func (c *index) GenIndexKeys(sc *stmtctx.StatementContext, rows [][]types.Datum, handles []int64, buf []byte) (keys [][]byte, distincts []bool, err error) {
+	if len(rows) != len(handles) {
+		return nil, nil, errors.Errorf("%d rows with %d handles", len(rows), len(handles))
+	}
+	size := 0
+	for _, vals := range rows {
+		// A value failing the estimate fails the encoding below with the error.
+		n, err1 := c.EstimateIndexKeySize(sc, vals, c.uniqueValues(vals))
+		if err1 != nil {
+			break
+		}
+		size += n
+	}
+	if cap(buf) < size {
+		buf = make([]byte, 0, size)
+	}
+	buf = buf[:0]
+	ends := make([]int, len(rows))
+	distincts = make([]bool, len(rows))
+	var scratch []byte
+	var valsScratch []types.Datum
+	for i, vals := range rows {
+		scratch, distincts[i], err = c.genIndexKey(sc, vals, handles[i], scratch, nil, &valsScratch)
+		if err != nil {
+			return nil, nil, err
+		}
+		buf = append(buf, scratch...)
+		ends[i] = len(buf)
+	}
+	// buf may be reallocated while it grows, so the keys are only sliced after all are encoded.
+	keys = make([][]byte, len(rows))
+	start := 0
+	for i, end := range ends {
+		keys[i] = buf[start:end:end]
+		start = end
+	}
+	return keys, distincts, nil
+}
+
+// GenIndexKeyWithScratch is GenIndexKey truncating the values into scratch as TruncateIndexValuesInto does,
+// and returning it, so the values passed aren't copied for every key. indexedValues is never modified.
+func (c *index) GenIndexKeyWithScratch(sc *stmtctx.StatementContext, indexedValues []types.Datum, h int64, buf []byte, scratch []types.Datum) (key []byte, distinct bool, newScratch []types.Datum, err error) {
+	key, distinct, err = c.genIndexKey(sc, indexedValues, h, buf, nil, &scratch)
+	return key, distinct, scratch, err
+}
+
+// genIndexKey is GenIndexKey with the sequence to put in the key of an index which keeps the
+// insertion order, if seq is nil, a new sequence is generated. The values are truncated into
+// *scratch if it's set, see GenIndexKeyWithScratch.
+func (c *index) genIndexKey(sc *stmtctx.StatementContext, indexedValues []types.Datum, h int64, buf []byte, seq *int64, scratch *[]types.Datum) (key []byte, distinct bool, err error) {
+	if err = c.checkUsable(); err != nil {
+		return nil, false, err
+	}
+	distinct = c.keyIsDistinct(indexedValues)
+
+	origValues := indexedValues
+	// For string columns, indexes can be created using only the leading part of column values,
+	// using col_name(length) syntax to specify an index prefix length.
+	if scratch != nil {
+		var copied bool
+		if indexedValues, copied = truncateIndexValues(c.tblInfo, c.idxInfo, indexedValues, *scratch); copied {
+			*scratch = indexedValues
+		}
+	} else {
+		indexedValues = TruncateIndexValuesIfNeeded(c.tblInfo, c.idxInfo, indexedValues)
+	}
+	if c.storesOriginal() {
+		indexedValues = c.hashIndexValues(indexedValues)
+	}
+	key = c.getIndexKeyBuf(buf, len(c.prefix)+len(indexedValues)*9+18)
+	key = append(key, []byte(c.prefix)...)
+	key, err = c.encodeIndexValues(sc, key, indexedValues)
+	if err != nil {
+		err = c.wrapEncodeErr(origValues, err)
+	}
+	if c.seqGen != nil && err == nil {
+		if seq == nil {
+			next := c.seqGen()
+			seq = &next
+		}
+		key, err = codec.EncodeKey(sc, key, types.NewIntDatum(*seq))
+	}
+	if !distinct && err == nil {
+		if c.compactHandles {
+			key = codec.EncodeComparableVarint(key, h)
+		} else {
+			key, err = codec.EncodeKey(sc, key, types.NewDatum(h))
+		}
+	}
+	if err == nil {
+		err = c.checkTenant(key)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if c.maxKeyLen > 0 && len(key) > c.maxKeyLen {
+		return c.genShrunkKey(sc, indexedValues, h, buf, seq, len(key))
+	}
+	return
+}
+
+// GenIndexKeyPaddedMin generates the smallest key of the entries whose leading index columns are
+// partialValues, the remaining columns are padded with the minimum value, so a seek to the key
+// starts at the first of the entries.
+func (c *index) GenIndexKeyPaddedMin(sc *stmtctx.StatementContext, partialValues []types.Datum) (kv.Key, error) {
+	return c.genPaddedKey(sc, partialValues, false)
+}
+
+// GenIndexKeyPaddedMax generates a key above all the keys of the entries whose leading index columns
+// are partialValues, the remaining columns and the handle are padded with the maximum value, so it's
+// an upper bound of the entries.
+func (c *index) GenIndexKeyPaddedMax(sc *stmtctx.StatementContext, partialValues []types.Datum) (kv.Key, error) {
+	return c.genPaddedKey(sc, partialValues, true)
+}
+
+// genPaddedKey encodes partialValues as the leading index columns and pads the remaining columns
+// with the minimum value, or the maximum value if upper is true.
+func (c *index) genPaddedKey(sc *stmtctx.StatementContext, partialValues []types.Datum, upper bool) (kv.Key, error) {
+	if len(partialValues) > len(c.idxInfo.Columns) {
+		return nil, errors.Errorf("index %s has %d columns, but %d values are given", c.idxInfo.Name, len(c.idxInfo.Columns), len(partialValues))
+	}
+	key, err := c.genLeadingKey(sc, partialValues)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(partialValues); i < len(c.idxInfo.Columns); i++ {
+		switch {
+		case upper:
+			key, err = codec.EncodeKey(sc, key, types.MaxValueDatum())
+		case i == 0 && c.nullsLast:
+			// NULL sorts last, the smallest value is the smallest non-NULL one.
+			key, err = codec.EncodeKey(sc, key, types.MinNotNullDatum())
+		default:
+			key, err = codec.EncodeKey(sc, key, types.Datum{})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if upper {
+		// The sequence or the handle may follow the values.
+		key, err = codec.EncodeKey(sc, key, types.MaxValueDatum())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return key, c.checkTenant(key)
+}
+
+// genLeadingKey generates the common prefix of the keys of the entries whose leading index columns are
+// partialValues, the values are truncated, and hashed for an index which stores the original values.
+func (c *index) genLeadingKey(sc *stmtctx.StatementContext, partialValues []types.Datum) (kv.Key, error) {
+	vals := TruncateIndexValuesIfNeeded(c.tblInfo, c.idxInfo, partialValues)
+	if c.storesOriginal() {
+		vals = c.hashIndexValues(vals)
+	}
+	return c.encodeIndexValues(sc, append([]byte{}, c.prefix...), vals)
+}
+
+// EstimateEqualMatches returns the number of entries whose leading index columns equal indexedValues,
+// for the selectivity of an equality predicate. It only scans the key range of the values, so it's cheap
+// for a selective value, and 0 or 1 for all the columns of a unique index without NULL.
+// The values are compared as the index stores them, e.g. truncated to the prefix lengths or hashed.
+func (c *index) EstimateEqualMatches(sc *stmtctx.StatementContext, r kv.Retriever, indexedValues []types.Datum) (int64, error) {
+	if len(indexedValues) > len(c.idxInfo.Columns) {
+		return 0, errors.Errorf("index %s has %d columns, but %d values are given", c.idxInfo.Name, len(c.idxInfo.Columns), len(indexedValues))
+	}
+	keyPrefix, err := c.genLeadingKey(sc, indexedValues)
+	if err != nil {
+		return 0, err
+	}
+	if err = c.checkTenant(keyPrefix); err != nil {
+		return 0, err
+	}
+	it, err := r.Iter(keyPrefix, keyPrefix.PrefixNext())
+	if err != nil {
+		return 0, err
+	}
+	defer it.Close()
+	var count int64
+	for it.Valid() && it.Key().HasPrefix(keyPrefix) {
+		count++
+		if err = it.Next(); err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+// PlanSplits returns numRegions-1 ordered split keys inside the key range of the index, which divide it
+// into numRegions ranges for pre-splitting before a bulk load. The boundaries are placed at the quantiles of
+// sampleValues, the full or leading index values of some expected rows, so the ranges hold roughly equal
+// data. Without enough distinct samples, the boundaries are interpolated uniformly over the encoded key space.
+func (c *index) PlanSplits(numRegions int, sampleValues [][]types.Datum) ([][]byte, error) {
+	if numRegions <= 0 {
+		return nil, errors.Errorf("invalid number of regions %d for index %s", numRegions, c.idxInfo.Name)
+	}
+	sc := &stmtctx.StatementContext{}
+	samples := make([][]byte, 0, len(sampleValues))
+	for _, vals := range sampleValues {
+		if len(vals) > len(c.idxInfo.Columns) {
+			return nil, errors.Errorf("index %s has %d columns, but %d values are given", c.idxInfo.Name, len(c.idxInfo.Columns), len(vals))
+		}
+		key, err := c.genLeadingKey(sc, vals)
+		if err != nil {
+			return nil, err
+		}
+		// A key equal to the start of the range would split off an empty range.
+		if err = c.checkTenant(key); err == nil && len(key) > len(c.scanPrefix) {
+			samples = append(samples, key)
+		}
+	}
+	sort.Slice(samples, func(i, j int) bool { return bytes.Compare(samples[i], samples[j]) < 0 })
+	distinct := samples[:0]
+	for i, key := range samples {
+		if i == 0 || !bytes.Equal(key, samples[i-1]) {
+			distinct = append(distinct, key)
+		}
+	}
+
+	splits := make([][]byte, 0, numRegions-1)
+	if len(distinct) >= numRegions {
+		// Every boundary is a distinct sample, so the quantiles are strictly increasing.
+		for i := 1; i < numRegions; i++ {
+			splits = append(splits, distinct[i*len(distinct)/numRegions])
+		}
+		return splits, nil
+	}
+	for i := 1; i < numRegions; i++ {
+		var suffix [4]byte
+		binary.BigEndian.PutUint32(suffix[:], uint32(uint64(i)<<32/uint64(numRegions)))
+		splits = append(splits, append(append([]byte{}, c.scanPrefix...), suffix[:]...))
+	}
+	return splits, nil
+}
+
+// AnyMatch returns whether any entry's leading index columns equal prefixValues, e.g. for an EXISTS subquery.
+// It seeks once to the key prefix of the values and checks the first key without decoding it.
+func (c *index) AnyMatch(sc *stmtctx.StatementContext, r kv.Retriever, prefixValues []types.Datum) (bool, error) {
+	if len(prefixValues) > len(c.idxInfo.Columns) {
+		return false, errors.Errorf("index %s has %d columns, but %d values are given", c.idxInfo.Name, len(c.idxInfo.Columns), len(prefixValues))
+	}
+	keyPrefix, err := c.genLeadingKey(sc, prefixValues)
+	if err != nil {
+		return false, err
+	}
+	if err = c.checkTenant(keyPrefix); err != nil {
+		return false, err
+	}
+	it, err := r.Iter(keyPrefix, keyPrefix.PrefixNext())
+	if err != nil {
+		return false, err
+	}
+	defer it.Close()
+	return it.Valid() && it.Key().HasPrefix(keyPrefix), nil
+}
+
+// maxErrValueLen is the maximum length of a value rendered in an error message.
+const maxErrValueLen = 64
+
+// renderDatum renders d with its kind for an error message, a long value is truncated.
+func renderDatum(d types.Datum) string {
+	str := d.String()
+	if len(str) <= maxErrValueLen {
+		return str
+	}
+	end := maxErrValueLen
+	for end > 0 && !utf8.RuneStart(str[end]) {
+		end--
+	}
+	return str[:end] + "..."
+}
+
+// wrapEncodeErr adds the first value of indexedValues the key codec can't encode and its column to err.
+func (c *index) wrapEncodeErr(indexedValues []types.Datum, err error) error {
+	for i, v := range indexedValues {
+		if !encodableKind(v.Kind()) {
+			return errors.Errorf("cannot encode value '%s' for index column '%s' of index %s: %v",
+				renderDatum(v), c.idxInfo.Columns[i].Name, c.idxInfo.Name, err)
+		}
+	}
+	return err
+}
+
+// storesOriginal returns whether the index stores the hashes or the sort keys of some columns in the key
+// and the original values in the value.
+func (c *index) storesOriginal() bool {
+	return c.hashFunc != nil || c.sortKeys != nil
+}
+
+// hashIndexValues returns a copy of indexedValues with the hashed columns replaced by their hashes
+// and the columns with sort keys replaced by their sort keys.
+// NULL is kept as is, so the NULL entries still sort first.
+func (c *index) hashIndexValues(indexedValues []types.Datum) []types.Datum {
+	hashed := make([]types.Datum, len(indexedValues))
+	for i := range indexedValues {
+		hashed[i] = c.hashIndexValue(i, indexedValues[i])
+	}
+	return hashed
+}
+
+// hashIndexValue returns the hash or the sort key of the value v of the i-th index column, see hashIndexValues.
+func (c *index) hashIndexValue(i int, v types.Datum) types.Datum {
+	if v.IsNull() {
+		return v
+	} else if i < len(c.hashedCols) && c.hashedCols[i] {
+		return types.NewUintDatum(c.hashFunc(v))
+	} else if i < len(c.sortKeys) && c.sortKeys[i] != nil {
+		return types.NewBytesDatum(c.sortKeys[i](v))
+	}
+	return v
+}
+
+// HashLookup returns the handles of the entries of a hashed index whose original values equal indexedValues.
+// It seeks to the entries sharing the hash of indexedValues and filters out the hash collisions by the
+// original values stored in the entry values. It serves an index with sort keys in the same way.
+func (c *index) HashLookup(sc *stmtctx.StatementContext, r kv.Retriever, indexedValues []types.Datum) ([]int64, error) {
+	if !c.storesOriginal() {
+		return nil, errors.Errorf("index %s is not a hashed index", c.idxInfo.Name)
+	}
+	indexedValues = TruncateIndexValuesIfNeeded(c.tblInfo, c.idxInfo, indexedValues)
+	origin, err := codec.EncodeKey(sc, nil, indexedValues...)
+	if err != nil {
+		return nil, err
+	}
+	keyPrefix := append([]byte{}, c.prefix...)
+	keyPrefix, err = c.encodeIndexValues(sc, keyPrefix, c.hashIndexValues(indexedValues))
+	if err != nil {
+		return nil, err
+	}
+	return c.lookupHashed(sc, r, keyPrefix, origin)
+}
+
+// lookupHashed returns the handles of the entries under keyPrefix whose stored original values equal origin,
+// the encoded original values, as the unique check compares them, see equalityKey.
+func (c *index) lookupHashed(sc *stmtctx.StatementContext, r kv.Retriever, keyPrefix kv.Key, origin []byte) ([]int64, error) {
+	origin, err := c.equalityKey(sc, origin)
+	if err != nil {
+		return nil, err
+	}
+	it, err := r.Iter(keyPrefix, keyPrefix.PrefixNext())
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	var handles []int64
+	for it.Valid() && it.Key().HasPrefix(keyPrefix) {
+		value, _, err := c.splitValue(it.Value())
+		if err != nil {
+			return nil, err
+		}
+		stored, err := c.equalityKey(sc, value[1:])
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Equal(stored, origin) {
+			_, d, err := codec.DecodeOne(it.Key()[len(keyPrefix):])
+			if err != nil {
+				return nil, err
+			}
+			handles = append(handles, d.GetInt64())
+		}
+		if err = it.Next(); err != nil {
+			return nil, err
+		}
+	}
+	return handles, nil
+}
+
+// Create creates a new entry in the kvIndex data.
+// If the index is unique and there is an existing entry with the same key,
+// Create will return the existing entry's handle as the first return value, ErrKeyExists as the second return value.
+// For a multi-valued index, an entry is created for each element of the multi-valued column, see WithMultiValued.
+func (c *index) Create(sctx sessionctx.Context, rm kv.RetrieverMutator, indexedValues []types.Datum, h int64, opts ...table.CreateIdxOptFunc) (int64, error) {
+	if c.predicate != nil {
+		var opt table.CreateIdxOpt
+		for _, fn := range opts {
+			fn(&opt)
+		}
+		if ok, err := c.qualifies(sctx, opt.Row); !ok || err != nil {
+			return 0, err
+		}
+	}
+	if c.multiValued {
+		return c.createMultiValued(sctx, rm, indexedValues, h, opts)
+	}
+	return c.create(sctx, rm, indexedValues, h, opts...)
+}
+
+// create creates the single entry of indexedValues.
+func (c *index) create(sctx sessionctx.Context, rm kv.RetrieverMutator, indexedValues []types.Datum, h int64, opts ...table.CreateIdxOptFunc) (handle int64, err error) {
+	if c.slowLogThreshold > 0 {
+		defer c.logSlowOp(IndexOpCreate, time.Now())
+	}
+	var opt table.CreateIdxOpt
+	for _, fn := range opts {
+		fn(&opt)
+	}
+	if opt.Assertion != kv.None && opt.Assertion != kv.NotExist {
+		return 0, errors.Errorf("index %s doesn't support assertion %d on create", c.idxInfo.Name, opt.Assertion)
+	}
+	// The counting wrappers hide the assertion support of rm.
+	asserter, _ := rm.(kv.AssertionMutator)
+	if opt.OpStats != nil {
+		rm = &opCountingRM{opCountingMutator{rm, opt.OpStats}, rm}
+	}
+	if c.metrics != nil {
+		m, counts := countMetrics(rm)
+		rm = m.(kv.RetrieverMutator)
+		defer func() { c.reportCreate(counts, opt.Untouched, err) }()
+	}
+	if c.capacity != nil && !opt.Untouched {
+		defer func() {
+			if err == nil {
+				c.capacity.add(c.idxInfo.Name.O)
+			}
+		}()
+	}
+	hint := opt.PlacementHint
+	if hint != "" && !c.placementHints {
+		return 0, errors.Errorf("index %s doesn't store placement hints", c.idxInfo.Name)
+	}
+	if len(hint) > maxPlacementHintLen {
+		return 0, errors.Errorf("placement hint of index %s is longer than %d bytes", c.idxInfo.Name, maxPlacementHintLen)
+	}
+	if err = c.checkIncluded(opt.IncludedValues); err != nil {
+		return 0, err
+	}
+	vars := sctx.GetSessionVars()
+	writeBufs := vars.GetWriteStmtBufs()
+	skipCheck := vars.StmtCtx.BatchCheck
+	// truncated is set to the values truncated to the prefix lengths if any value is truncated.
+	var truncated []types.Datum
+	key, distinct, err := c.genIndexKey(vars.StmtCtx, indexedValues, h, writeBufs.IndexKeyBuf, opt.Sequence, &truncated)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx := opt.Ctx
+	if opt.Untouched {
+		txn, err1 := sctx.Txn(true)
+		if err1 != nil {
+			return 0, err1
+		}
+		// If the index kv was untouched(unchanged), and the key/value already exists in mem-buffer,
+		// should not overwrite the key with un-commit flag.
+		// So if the key exists, just do nothing and return.
+		if opt.OpStats != nil {
+			opt.OpStats.Gets++
+		}
+		_, err = c.get(ctx, txn.GetMemBuffer(), key)
+		if err == nil {
+			return 0, nil
+		}
+	}
+
+	// save the key buffer to reuse.
+	writeBufs.IndexKeyBuf = key
+	if c.storesOriginal() {
+		if truncated != nil {
+			indexedValues = truncated
+		}
+		return c.createHashed(vars.StmtCtx, rm, key, indexedValues, h, opt.PlacementHint, skipCheck || opt.Untouched, opt.Untouched)
+	}
+	if c.seqGen != nil {
+		return c.createWithSequence(rm, key, indexedValues, h, opt.PlacementHint, skipCheck || opt.Untouched, opt.Untouched)
+	}
+	if !distinct {
+		var value []byte
+		if opt.Untouched {
+			value = c.untouchedValue([]byte{kv.UnCommitIndexKVFlag})
+		} else {
+			if value, err = c.nonDistinctValue(vars.StmtCtx, opt.IncludedValues); err != nil {
+				return 0, err
+			}
+			value = c.stampValue(value, hint)
+		}
+		err = rm.Set(key, value)
+		return 0, err
+	}
+
+	if skipCheck || opt.Untouched {
+		var value []byte
+		// If index is untouched and fetch here means the key is exists in TiKV, but not in txn mem-buffer,
+		// then should also write the untouched index key/value to mem-buffer to make sure the data
+		// is consistent with the index in txn mem-buffer.
+		if opt.Untouched {
+			value = c.untouchedValue(append(EncodeHandle(h), kv.UnCommitIndexKVFlag))
+		} else {
+			value = c.stampValue(c.encodeHandleValue(h), hint)
+		}
+		err = rm.Set(key, value)
+		return 0, err
+	}
+
+	if c.deferUnique {
+		return c.createDeferred(sctx, rm, key, indexedValues, h, hint)
+	}
+
+	if opt.Assertion == kv.NotExist && asserter != nil && c.tombstoneNow == nil {
+		// The engine checks the key doesn't exist at commit time instead of reading it now,
+		// which can't be done for an index whose deleted entries are kept as tombstones.
+		if opt.OpStats != nil {
+			opt.OpStats.Sets++
+		}
+		return 0, asserter.SetWithAssertion(key, c.stampValue(c.encodeHandleValue(h), hint), kv.NotExist)
+	}
+
+	ctx = context.TODO()
+
+	var value []byte
+	value, err = c.get(ctx, rm, key)
+	if kv.IsErrNotFound(err) {
+		v := c.stampValue(c.encodeHandleValue(h), hint)
+		err = rm.Set(key, v)
+		return 0, err
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	handle, err = c.decodeHandleValue(value)
+	if err != nil {
+		return 0, err
+	}
+	if c.prefixConflictDiag {
+		return handle, c.prefixConflictErr(indexedValues, handle)
+	}
+	return handle, table.NewDupKeyError(c.idxInfo.Name.O, handle, indexedValues)
+}
+
+// prefixConflictErr returns the ErrKeyExists of indexedValues conflicting with the entry of handle.
+// If the values aren't the same as the existing ones but only share the indexed prefix with them,
+// the error tells it with both full values.
+func (c *index) prefixConflictErr(indexedValues []types.Datum, handle int64) error {
+	truncated := TruncateIndexValuesIfNeeded(c.tblInfo, c.idxInfo, indexedValues)
+	existing := truncated
+	if c.fetchIndexedValues != nil {
+		var err error
+		if existing, err = c.fetchIndexedValues(handle); err != nil {
+			return err
+		}
+	}
+	sc := &stmtctx.StatementContext{}
+	for i := range indexedValues {
+		cmp, err := indexedValues[i].CompareDatum(sc, &existing[i])
+		if err != nil {
+			return err
+		}
+		if cmp != 0 {
+			return kv.ErrKeyExists.GenWithStack("Duplicate entry '%s' for key '%s', caused by the prefix length: the value only shares the prefix '%s' with the existing value '%s'",
+				types.DatumsToStrNoErr(indexedValues), c.idxInfo.Name, types.DatumsToStrNoErr(truncated), types.DatumsToStrNoErr(existing))
+		}
+	}
+	return table.NewDupKeyError(c.idxInfo.Name.O, handle, indexedValues)
+}
+
+// encodeHandleValue encodes the value of a distinct entry pointing to handle h.
+func (c *index) encodeHandleValue(h int64) []byte {
+	if c.compactHandles {
+		return append(EncodeHandleCompact(h), compactHandleVersion)
+	}
+	value := EncodeHandle(h)
+	if c.formatMagic {
+		value = append(value, handleFormatMagic)
+	}
+	return value
+}
+
+// decodeHandleValue decodes the handle in the value of a distinct entry.
+// For an index with format magic or compact handles, a value ending with a byte other than the magic byte
+// or compactHandleVersion is rejected, except the flag of an untouched entry. So is a bare 8-byte handle
+// without the magic byte of an index with format magic.
+func (c *index) decodeHandleValue(value []byte) (int64, error) {
+	value, _, err := c.splitValue(value)
+	if err != nil {
+		return 0, err
+	}
+	if c.compactHandles && len(value) > 0 {
+		flag := value[len(value)-1]
+		if flag == compactHandleVersion {
+			return DecodeHandleCompact(value[:len(value)-1])
+		}
+		if len(value) != 9 || flag != kv.UnCommitIndexKVFlag {
+			return 0, table.ErrIndexFormatMismatch.GenWithStackByArgs(c.idxInfo.Name, flag)
+		}
+	}
+	if c.formatMagic && len(value) > 0 {
+		flag := value[len(value)-1]
+		if len(value) != 9 || (flag != handleFormatMagic && flag != kv.UnCommitIndexKVFlag) {
+			return 0, table.ErrIndexFormatMismatch.GenWithStackByArgs(c.idxInfo.Name, flag)
+		}
+	}
+	if len(value) < 8 {
+		return 0, errors.Errorf("index %s has an invalid handle value %x", c.idxInfo.Name, value)
+	}
+	return DecodeHandle(value)
+}
+
+// createHashed writes the entry of a hashed index or an index with sort keys,
+// the value is the flag byte followed by the original values.
+// indexedValues are the truncated values GenIndexKey has hashed for key.
+// For a unique index, an existing entry with the same original values is a duplicate.
+func (c *index) createHashed(sc *stmtctx.StatementContext, rm kv.RetrieverMutator, key kv.Key, indexedValues []types.Datum, h int64, hint string, skipCheck, untouched bool) (int64, error) {
+	value := []byte{'0'}
+	if untouched {
+		value[0] = kv.UnCommitIndexKVFlag
+	}
+	var err error
+	if c.valueCache != nil {
+		var encoded []byte
+		if encoded, err = c.valueCache.encode(sc, indexedValues); err == nil {
+			value = append(value, encoded...)
+		}
+	} else {
+		value, err = codec.EncodeKey(sc, value, indexedValues...)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !skipCheck && c.uniqueValues(indexedValues) {
+		// The key ends with the encoded handle, which is always 9 bytes.
+		handles, err := c.lookupHashed(sc, rm, key[:len(key)-9], value[1:])
+		if err != nil {
+			return 0, err
+		}
+		for _, handle := range handles {
+			if handle != h {
+				return handle, table.NewDupKeyError(c.idxInfo.Name.O, handle, indexedValues)
+			}
+		}
+	}
+	if untouched {
+		value = c.untouchedValue(value)
+	} else {
+		value = c.stampValue(value, hint)
+	}
+	return 0, rm.Set(key, value)
+}
+
+// uniqueValues returns whether the entries with indexedValues must be unique.
+// See https://dev.mysql.com/doc/refman/5.7/en/create-index.html
+// A UNIQUE index creates a constraint such that all values in the index must be distinct.
+// An error occurs if you try to add a new row with a key value that matches an existing row.
+// For all engines, a UNIQUE index permits multiple NULL values for columns that can contain NULL,
+// unless the index is UNIQUE NULLS NOT DISTINCT.
+func (c *index) uniqueValues(indexedValues []types.Datum) bool {
+	return c.idxInfo.Unique && (c.idxInfo.NullsNotDistinct || !hasNullDatum(indexedValues))
+}
+
+// keyIsDistinct reports whether the entry of the indexed values is distinct, i.e. its key has no handle and
+// its value stores the handle. It's decided by the index and the values alone, a NULL in a unique index makes
+// the entry non-distinct, so the decoding never guesses it from the number of datums in the key.
+func (c *index) keyIsDistinct(indexedValues []types.Datum) bool {
+	// Different values may share a hash or a sort key, so the handle is always needed to tell them apart,
+	// and the entries with the same values of an index keeping the insertion order must all be kept.
+	if c.storesOriginal() || c.seqGen != nil {
+		return false
+	}
+	return c.uniqueValues(indexedValues)
+}
+
+// checkDecodedCount checks the key of an entry, whose decoded datums are vv, has a handle iff the entry
+// isn't distinct, so a corrupted key or one written with other index options fails instead of being
+// decoded with a wrong handle.
+func (c *index) checkDecodedCount(vv []types.Datum, keyHasHandle bool) error {
+	n := len(c.idxInfo.Columns)
+	if len(vv) < n {
+		return errors.Errorf("index %s has %d columns but the key has %d values", c.idxInfo.Name, n, len(vv))
+	}
+	if distinct := c.keyIsDistinct(vv[:n]); keyHasHandle == distinct {
+		return errors.Errorf("index %s entry is distinct=%v but its key has handle=%v", c.idxInfo.Name, distinct, keyHasHandle)
+	}
+	return nil
+}
+
+func hasNullDatum(vals []types.Datum) bool {
+	for _, v := range vals {
+		if v.IsNull() {
+			return true
+		}
+	}
+	return false
+}
+
+// createWithSequence writes the entry of an index which keeps the insertion order.
+// The key ends with the sequence and the handle, so the entries with the same values are found by
+// scanning the keys without them. An existing entry with the same handle is kept as is, so creating
+// an entry twice doesn't duplicate it. For a unique index, an entry with another handle is a duplicate.
+func (c *index) createWithSequence(rm kv.RetrieverMutator, key kv.Key, indexedValues []types.Datum, h int64, hint string, skipCheck, untouched bool) (int64, error) {
+	if !skipCheck {
+		// The sequence and the handle are always 9 bytes each.
+		_, handles, err := c.seqEntries(rm, key[:len(key)-18])
+		if err != nil {
+			return 0, err
+		}
+		unique := c.uniqueValues(indexedValues)
+		for _, handle := range handles {
+			if handle == h {
+				return 0, nil
+			}
+			if unique {
+				return handle, table.NewDupKeyError(c.idxInfo.Name.O, handle, indexedValues)
+			}
+		}
+	}
+	if untouched {
+		return 0, rm.Set(key, c.untouchedValue([]byte{kv.UnCommitIndexKVFlag}))
+	}
+	return 0, rm.Set(key, c.stampValue([]byte{'0'}, hint))
+}
+
+// genValuesKey generates the common prefix of the keys of the entries with indexedValues,
+// which is the key without the sequence and the handle for an index which keeps the insertion order.
+func (c *index) genValuesKey(sc *stmtctx.StatementContext, indexedValues []types.Datum) (kv.Key, error) {
+	indexedValues = TruncateIndexValuesIfNeeded(c.tblInfo, c.idxInfo, indexedValues)
+	key, err := c.encodeIndexValues(sc, append([]byte{}, c.prefix...), indexedValues)
+	if err != nil {
+		return nil, err
+	}
+	return key, c.checkTenant(key)
+}
+
+// seqEntries returns the keys and the handles of the entries under keyPrefix of an index which keeps
+// the insertion order, in insertion order.
+func (c *index) seqEntries(r kv.Retriever, keyPrefix kv.Key) ([]kv.Key, []int64, error) {
+	it, err := r.Iter(keyPrefix, keyPrefix.PrefixNext())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer it.Close()
+
+	var keys []kv.Key
+	var handles []int64
+	for it.Valid() && it.Key().HasPrefix(keyPrefix) {
+		vv, err := codec.Decode(it.Key()[len(keyPrefix):], 2)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, append(kv.Key{}, it.Key()...))
+		handles = append(handles, vv[len(vv)-1].GetInt64())
+		if err = it.Next(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return keys, handles, nil
+}
+
+// findSeqEntry returns the key of the entry with indexedValues and handle h of an index which keeps
+// the insertion order, with the handles of all the entries with indexedValues. The key is nil if
+// there's no such entry.
+func (c *index) findSeqEntry(sc *stmtctx.StatementContext, r kv.Retriever, indexedValues []types.Datum, h int64) (kv.Key, []int64, error) {
+	keyPrefix, err := c.genValuesKey(sc, indexedValues)
+	if err != nil {
+		return nil, nil, err
+	}
+	keys, handles, err := c.seqEntries(r, keyPrefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, handle := range handles {
+		if handle == h {
+			return keys[i], handles, nil
+		}
+	}
+	return nil, handles, nil
+}
+
+// Delete removes the entry for handle h and indexdValues from KV index.
+// With the VerifyHandle option, a unique entry which points to another handle is kept and
+// ErrIndexHandleMismatch is returned, so a concurrently rewritten entry isn't removed by mistake.
+// For a multi-valued index, the entry of each element of the multi-valued column is removed.
+func (c *index) Delete(sc *stmtctx.StatementContext, m kv.Mutator, indexedValues []types.Datum, h int64, opts ...table.DeleteIdxOptFunc) error {
+	if c.predicate != nil {
+		var opt table.DeleteIdxOpt
+		for _, fn := range opts {
+			fn(&opt)
+		}
+		if ok, err := c.qualifies(opt.SessionCtx, opt.Row); !ok || err != nil {
+			return err
+		}
+	}
+	if c.multiValued {
+		return c.deleteMultiValued(sc, m, indexedValues, h, opts)
+	}
+	return c.deleteEntry(sc, m, indexedValues, h, opts...)
+}
+
+// deleteEntry removes the single entry of indexedValues.
+func (c *index) deleteEntry(sc *stmtctx.StatementContext, m kv.Mutator, indexedValues []types.Datum, h int64, opts ...table.DeleteIdxOptFunc) error {
+	if c.slowLogThreshold > 0 {
+		defer c.logSlowOp(IndexOpDelete, time.Now())
+	}
+	var opt table.DeleteIdxOpt
+	for _, fn := range opts {
+		fn(&opt)
+	}
+	if opt.OpStats != nil {
+		m = countOps(m, opt.OpStats)
+	}
+	if c.metrics != nil {
+		var counts *metricsMutator
+		m, counts = countMetrics(m)
+		defer func() { c.addCounter(CounterEntriesDeleted, counts.deleted) }()
+	}
+	if c.seqGen != nil {
+		return c.deleteWithSequence(sc, m, indexedValues, h)
+	}
+	key, distinct, err := c.GenIndexKey(sc, indexedValues, h, nil)
+	if err != nil {
+		return err
+	}
+	// The handle of a non-distinct entry is in the key, so only the distinct entry needs to be verified.
+	if opt.VerifyHandle && distinct {
+		r, ok := m.(kv.Retriever)
+		if !ok {
+			return errors.New("index handle verification requires a kv.Retriever")
+		}
+		value, err := c.get(context.TODO(), r, key)
+		if kv.IsErrNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		handle, err := c.decodeHandleValue(value)
+		if err != nil {
+			return err
+		}
+		if handle != h {
+			return table.ErrIndexHandleMismatch.GenWithStackByArgs(c.idxInfo.Name, handle, h)
+		}
+	}
+	if c.deferUnique && distinct {
+		if deferred, err := c.deleteDeferred(opt.SessionCtx, m, key, h); deferred || err != nil {
+			return err
+		}
+	}
+	return c.deleteKey(m, key, c.liveValue(sc, distinct, h))
+}
+
+// liveValue returns the payload of the value of the entry of handle h for its tombstone, without the included
+// values or the metadata of the entry.
+func (c *index) liveValue(sc *stmtctx.StatementContext, distinct bool, h int64) []byte {
+	if c.tombstoneNow == nil {
+		return nil
+	}
+	if distinct {
+		return c.encodeHandleValue(h)
+	}
+	// A nil included values never fails the encoding.
+	value, _ := c.nonDistinctValue(sc, nil)
+	return value
+}
+
+// DeleteExact removes the entry with indexedValues only if it points to handle h, and returns whether it's
+// removed. Unlike Delete with VerifyHandle, a missing entry or a unique entry pointing to another handle,
+// e.g. rewritten by a concurrent write or looked up by stale values, isn't an error but deleted is false.
+func (c *index) DeleteExact(sc *stmtctx.StatementContext, rm kv.RetrieverMutator, indexedValues []types.Datum, h int64) (deleted bool, err error) {
+	if c.slowLogThreshold > 0 {
+		defer c.logSlowOp(IndexOpDelete, time.Now())
+	}
+	var key kv.Key
+	if c.seqGen != nil {
+		key, _, err = c.findSeqEntry(sc, rm, indexedValues, h)
+		if err != nil || key == nil {
+			return false, err
+		}
+		return true, rm.Delete(key)
+	}
+	key, distinct, err := c.GenIndexKey(sc, indexedValues, h, nil)
+	if err != nil {
+		return false, err
+	}
+	value, err := c.get(context.TODO(), rm, key)
+	if kv.IsErrNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	// The handle of a non-distinct entry is in the key, so only the distinct entry needs to be verified.
+	if distinct {
+		handle, err := c.decodeHandleValue(value)
+		if err != nil {
+			return false, err
+		}
+		if handle != h {
+			return false, nil
+		}
+	}
+	return true, c.deleteKey(rm, key, value)
+}
+
+// deleteWithSequence removes the entry of an index which keeps the insertion order.
+// The sequence of the entry is unknown, so m must also be a kv.Retriever to find the entry.
+func (c *index) deleteWithSequence(sc *stmtctx.StatementContext, m kv.Mutator, indexedValues []types.Datum, h int64) error {
+	r, ok := m.(kv.Retriever)
+	if !ok {
+		return errors.New("deleting from an index with insertion sequence requires a kv.Retriever")
+	}
+	key, _, err := c.findSeqEntry(sc, r, indexedValues, h)
+	if err != nil || key == nil {
+		return err
+	}
+	return m.Delete(key)
+}
+
+// Drop removes the KV index from store.
+// For an index scoped to a tenant, only the entries of the tenant are removed.
+// With WithDropBatchSize, at most the batch size entries are removed, and if there're more, done is false
+// and next is the key to resume from: the caller may commit rm and call Drop again with WithDropStartKey(next).
+// With WithDropCtx, a drop cancelled by the context returns its error and next too.
+func (c *index) Drop(rm kv.RetrieverMutator, opts ...table.DropIdxOptFunc) (next kv.Key, done bool, err error) {
+	var opt table.DropIdxOpt
+	for _, fn := range opts {
+		fn(&opt)
+	}
+	start := c.scanPrefix
+	if opt.StartKey != nil {
+		if !opt.StartKey.HasPrefix(c.scanPrefix) {
+			return nil, false, errors.Errorf("start key %x is out of index %s", []byte(opt.StartKey), c.idxInfo.Name)
+		}
+		start = opt.StartKey
+	}
+	it, err := rm.Iter(start, c.scanPrefix.PrefixNext())
+	if err != nil {
+		return nil, false, err
+	}
+	defer it.Close()
+	if c.metrics != nil {
+		m, counts := countMetrics(rm)
+		rm = m.(kv.RetrieverMutator)
+		defer func() { c.addCounter(CounterEntriesDeleted, counts.deleted) }()
+	}
+
+	// remove all indices
+	deleted := 0
+	for it.Valid() {
+		if !it.Key().HasPrefix(c.scanPrefix) {
+			break
+		}
+		if opt.BatchSize > 0 && deleted >= opt.BatchSize {
+			return append(kv.Key(nil), it.Key()...), false, nil
+		}
+		if opt.Ctx != nil && deleted%ctxCheckInterval == 0 {
+			if err := opt.Ctx.Err(); err != nil {
+				return append(kv.Key(nil), it.Key()...), false, errors.Trace(err)
+			}
+		}
+		err := rm.Delete(it.Key())
+		if err != nil {
+			return nil, false, err
+		}
+		deleted++
+		err = it.Next()
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	return nil, true, nil
+}
+
+// rangeCompactor is implemented by the backend which can compact a key range on demand,
+// e.g. to drop the tombstones an LSM tree keeps for the deleted keys.
+type rangeCompactor interface {
+	CompactRange(ctx context.Context, start, end kv.Key) error
+}
+
+// Compact asks the backend to compact the key range of the index, which reclaims the space and
+// speeds up the scans after many entries are deleted, without compacting the whole table.
+// It's a no-op if rm doesn't support compaction.
+func (c *index) Compact(ctx context.Context, rm kv.RetrieverMutator) error {
+	compactor, ok := rm.(rangeCompactor)
+	if !ok {
+		return nil
+	}
+	return compactor.CompactRange(ctx, c.scanPrefix, c.scanPrefix.PrefixNext())
+}
+
+// SwapPrefixes exchanges the entries of the index with the entries of the same index of the table
+// or partition otherPhysicalID, as EXCHANGE PARTITION does, by rewriting both ranges under the other prefix.
+// It's only atomic if rm is: written to a transaction, the swap is committed or rolled back as a whole,
+// but an error in the middle leaves rm partially rewritten. Both ranges are held in memory during the swap.
+func (c *index) SwapPrefixes(rm kv.RetrieverMutator, otherPhysicalID int64) error {
+	otherPrefix := tablecodec.EncodeTableIndexPrefix(otherPhysicalID, c.idxInfo.ID)
+	if otherPrefix.Cmp(c.prefix) == 0 {
+		return nil
+	}
+	mine, err := readPrefix(rm, c.prefix)
+	if err != nil {
+		return err
+	}
+	others, err := readPrefix(rm, otherPrefix)
+	if err != nil {
+		return err
+	}
+	for _, pair := range mine {
+		if err = rm.Delete(pair[0]); err != nil {
+			return err
+		}
+	}
+	for _, pair := range others {
+		if err = rm.Delete(pair[0]); err != nil {
+			return err
+		}
+	}
+	// Index prefixes are all of the same length, so the suffixes start at the same offset.
+	for _, pair := range mine {
+		key := append(append(kv.Key{}, otherPrefix...), pair[0][len(c.prefix):]...)
+		if err = rm.Set(key, pair[1]); err != nil {
+			return err
+		}
+	}
+	for _, pair := range others {
+		key := append(append(kv.Key{}, c.prefix...), pair[0][len(otherPrefix):]...)
+		if err = rm.Set(key, pair[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readPrefix returns copies of all the key/value pairs under prefix.
+func readPrefix(r kv.Retriever, prefix kv.Key) ([][2][]byte, error) {
+	it, err := r.Iter(prefix, prefix.PrefixNext())
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	var pairs [][2][]byte
+	for it.Valid() && it.Key().HasPrefix(prefix) {
+		pairs = append(pairs, [2][]byte{append([]byte(nil), it.Key()...), append([]byte(nil), it.Value()...)})
+		if err = it.Next(); err != nil {
+			return nil, err
+		}
+	}
+	return pairs, nil
+}
+
+// Seek searches KV index for the entry with indexedValues.
+// The values are truncated to the prefix lengths of the index like the stored ones, so a value
+// longer than the prefix length seeks to the entries sharing its prefix.
+func (c *index) Seek(sc *stmtctx.StatementContext, r kv.Retriever, indexedValues []types.Datum) (iter table.IndexIterator, hit bool, err error) {
+	if c.slowLogThreshold > 0 {
+		defer c.logSlowOp(IndexOpSeek, time.Now())
+	}
+	if c.metrics != nil {
+		c.addCounter(CounterSeeks, 1)
+	}
+	if c.seqGen != nil {
+		return c.seekWithSequence(sc, r, indexedValues)
+	}
+	key, _, err := c.GenIndexKey(sc, indexedValues, 0, nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	upperBound := c.scanPrefix.PrefixNext()
+	it, err := r.Iter(key, upperBound)
+	if err != nil {
+		return nil, false, err
+	}
+	// check if hit
+	hit = false
+	if it.Valid() && it.Key().Cmp(key) == 0 {
+		hit = true
+	}
+	return &indexIter{it: it, idx: c, prefix: c.scanPrefix}, hit, nil
+}
+
+// SeekWithContext is Seek, but the returned iterator stops with the error of ctx once ctx is done,
+// so a scan is bounded by the deadline of the statement.
+func (c *index) SeekWithContext(ctx context.Context, sc *stmtctx.StatementContext, r kv.Retriever, indexedValues []types.Datum) (iter table.IndexIterator, hit bool, err error) {
+	iter, hit, err = c.Seek(sc, r, indexedValues)
+	if err != nil {
+		return nil, false, err
+	}
+	iter.(*indexIter).ctx = ctx
+	return iter, hit, nil
+}
+
+// seekWithSequence seeks to the first inserted entry with indexedValues of an index which keeps
+// the insertion order, it's a hit if there's any entry with indexedValues.
+func (c *index) seekWithSequence(sc *stmtctx.StatementContext, r kv.Retriever, indexedValues []types.Datum) (table.IndexIterator, bool, error) {
+	key, err := c.genValuesKey(sc, indexedValues)
+	if err != nil {
+		return nil, false, err
+	}
+	it, err := r.Iter(key, c.scanPrefix.PrefixNext())
+	if err != nil {
+		return nil, false, err
+	}
+	hit := it.Valid() && it.Key().HasPrefix(key)
+	return &indexIter{it: it, idx: c, prefix: c.scanPrefix}, hit, nil
+}
+
+// SeekFirst returns an iterator which points to the first entry of the KV index.
+func (c *index) SeekFirst(r kv.Retriever) (iter table.IndexIterator, err error) {
+	if err = c.checkUsable(); err != nil {
+		return nil, err
+	}
+	upperBound := c.scanPrefix.PrefixNext()
+	it, err := r.Iter(c.scanPrefix, upperBound)
+	if err != nil {
+		return nil, err
+	}
+	return &indexIter{it: it, idx: c, prefix: c.scanPrefix}, nil
+}
+
+// SeekFirstWithContext is SeekFirst, but the returned iterator stops with the error of ctx once ctx is done.
+func (c *index) SeekFirstWithContext(ctx context.Context, r kv.Retriever) (iter table.IndexIterator, err error) {
+	iter, err = c.SeekFirst(r)
+	if err != nil {
+		return nil, err
+	}
+	iter.(*indexIter).ctx = ctx
+	return iter, nil
+}
+
+// IterReverse returns an iterator walking the index backward, from the largest entry whose leading index
+// columns are upper to the first entry of the index, e.g. for ORDER BY DESC LIMIT. A nil upper starts
+// at the last entry. The values are compared as the index stores them, as EstimateEqualMatches does.
+func (c *index) IterReverse(sc *stmtctx.StatementContext, r kv.Retriever, upper []types.Datum) (table.IndexIterator, error) {
+	if err := c.checkUsable(); err != nil {
+		return nil, err
+	}
+	end := c.scanPrefix.PrefixNext()
+	if len(upper) > 0 {
+		key, err := c.genLeadingKey(sc, upper)
+		if err != nil {
+			return nil, err
+		}
+		end = key.PrefixNext()
+	}
+	it, err := r.IterReverse(end)
+	if err != nil {
+		return nil, err
+	}
+	return &indexIter{it: it, idx: c, prefix: c.scanPrefix}, nil
+}
+
+// coveringIter projects the entries of an index scan to table columns.
+type coveringIter struct {
+	*indexIter
+	// sources are the offsets in the index columns of the projected columns, -1 for the handle.
+	sources []int
+	// unsignedHandle is set if the handle is an unsigned integer primary key.
+	unsignedHandle bool
 }
 
-func (c *index) getIndexKeyBuf(buf []byte, defaultCap int) []byte {
-	if buf != nil {
-		return buf[:0]
+// Next returns the projected row of the next entry with its handle.
+func (c *coveringIter) Next() (row []types.Datum, h int64, err error) {
+	vals, h, err := c.indexIter.Next()
+	if err != nil {
+		return nil, 0, err
 	}
-
-	return make([]byte, 0, defaultCap)
+	row = make([]types.Datum, len(c.sources))
+	for i, src := range c.sources {
+		switch {
+		case src >= 0:
+			row[i] = vals[src]
+		case c.unsignedHandle:
+			row[i] = types.NewUintDatum(uint64(h))
+		default:
+			row[i] = types.NewIntDatum(h)
+		}
+	}
+	return row, h, nil
 }
 
-// TruncateIndexValuesIfNeeded truncates the index values created using only the leading part of column values.
-func TruncateIndexValuesIfNeeded(tblInfo *model.TableInfo, idxInfo *model.IndexInfo, indexedValues []types.Datum) []types.Datum {
-	for i := 0; i < len(indexedValues); i++ {
-		v := &indexedValues[i]
-		if v.Kind() == types.KindString || v.Kind() == types.KindBytes {
-			ic := idxInfo.Columns[i]
-			colCharset := tblInfo.Columns[ic.Offset].Charset
-			colValue := v.GetBytes()
-			isUTF8Charset := colCharset == charset.CharsetUTF8 || colCharset == charset.CharsetUTF8MB4
-			origKind := v.Kind()
-			if isUTF8Charset {
-				if ic.Length != types.UnspecifiedLength && utf8.RuneCount(colValue) > ic.Length {
-					rs := bytes.Runes(colValue)
-					truncateStr := string(rs[:ic.Length])
-					// truncate value and limit its length
-					v.SetString(truncateStr)
-					if origKind == types.KindBytes {
-						v.SetBytes(v.GetBytes())
-					}
-				}
-			} else if ic.Length != types.UnspecifiedLength && len(colValue) > ic.Length {
-				// truncate value and limit its length
-				v.SetBytes(colValue[:ic.Length])
-				if origKind == types.KindString {
-					v.SetString(v.GetString())
-				}
+// CoveringScan scans the whole index and returns the values of the table columns at the offsets in
+// projection for every entry, without looking up the rows. The columns must all be covered by the index,
+// i.e. be index columns without prefix lengths, or the integer primary key stored as the handle.
+func (c *index) CoveringScan(sc *stmtctx.StatementContext, r kv.Retriever, projection []int) (table.IndexIterator, error) {
+	var pkOffset = -1
+	if c.tblInfo.PKIsHandle {
+		if pk := c.tblInfo.GetPkColInfo(); pk != nil {
+			pkOffset = pk.Offset
+		}
+	}
+	sources := make([]int, len(projection))
+	for i, offset := range projection {
+		sources[i] = -2
+		for j, ic := range c.idxInfo.Columns {
+			if ic.Offset == offset && ic.Length == types.UnspecifiedLength {
+				sources[i] = j
+				break
 			}
 		}
+		if sources[i] == -2 && offset == pkOffset {
+			sources[i] = -1
+		}
+		if sources[i] == -2 {
+			return nil, errors.Errorf("column at offset %d isn't covered by index %s", offset, c.idxInfo.Name)
+		}
+	}
+	it, err := c.SeekFirst(r)
+	if err != nil {
+		return nil, err
+	}
+	iter := &coveringIter{indexIter: it.(*indexIter), sources: sources}
+	if pkOffset >= 0 {
+		iter.unsignedHandle = mysql.HasUnsignedFlag(c.tblInfo.Columns[pkOffset].Flag)
 	}
+	return iter, nil
+}
 
-	return indexedValues
+// handleRangeIter is an index iterator which skips the entries whose handles are out of [lo, hi).
+type handleRangeIter struct {
+	*indexIter
+	lo, hi int64
 }
 
-// GenIndexKey generates storage key for index values. Returned distinct indicates whether the
-// indexed values should be distinct in storage (i.e. whether handle is encoded in the key).
-func (c *index) GenIndexKey(sc *stmtctx.StatementContext, indexedValues []types.Datum, h int64, buf []byte) (key []byte, distinct bool, err error) {
-	if c.idxInfo.Unique {
-		// See https://dev.mysql.com/doc/refman/5.7/en/create-index.html
-		// A UNIQUE index creates a constraint such that all values in the index must be distinct.
-		// An error occurs if you try to add a new row with a key value that matches an existing row.
-		// For all engines, a UNIQUE index permits multiple NULL values for columns that can contain NULL.
-		distinct = true
-		for _, cv := range indexedValues {
-			if cv.IsNull() {
-				distinct = false
-				break
-			}
+// Next returns the next entry whose handle is in range.
+func (c *handleRangeIter) Next() (val []types.Datum, h int64, err error) {
+	for {
+		val, h, err = c.indexIter.Next()
+		if err != nil || (h >= c.lo && h < c.hi) {
+			return val, h, err
 		}
 	}
+}
 
-	// For string columns, indexes can be created using only the leading part of column values,
-	// using col_name(length) syntax to specify an index prefix length.
-	indexedValues = TruncateIndexValuesIfNeeded(c.tblInfo, c.idxInfo, indexedValues)
-	key = c.getIndexKeyBuf(buf, len(c.prefix)+len(indexedValues)*9+9)
-	key = append(key, []byte(c.prefix)...)
-	key, err = codec.EncodeKey(sc, key, indexedValues...)
-	if !distinct && err == nil {
-		key, err = codec.EncodeKey(sc, key, types.NewDatum(h))
-	}
+// SeekHandleRange scans the whole index and returns only the entries whose handles are in [loHandle, hiHandle).
+// The handle isn't the leading part of the key, so it's not a key range, the other entries are read and skipped.
+func (c *index) SeekHandleRange(sc *stmtctx.StatementContext, r kv.Retriever, loHandle, hiHandle int64) (table.IndexIterator, error) {
+	it, err := c.SeekFirst(r)
 	if err != nil {
-		return nil, false, err
+		return nil, err
 	}
-	return
+	return &handleRangeIter{indexIter: it.(*indexIter), lo: loHandle, hi: hiHandle}, nil
 }
 
-// Create creates a new entry in the kvIndex data.
-// If the index is unique and there is an existing entry with the same key,
-// Create will return the existing entry's handle as the first return value, ErrKeyExists as the second return value.
-func (c *index) Create(sctx sessionctx.Context, rm kv.RetrieverMutator, indexedValues []types.Datum, h int64, opts ...table.CreateIdxOptFunc) (int64, error) {
-	var opt table.CreateIdxOpt
-	for _, fn := range opts {
-		fn(&opt)
-	}
-	vars := sctx.GetSessionVars()
-	writeBufs := vars.GetWriteStmtBufs()
-	skipCheck := vars.StmtCtx.BatchCheck
-	key, distinct, err := c.GenIndexKey(vars.StmtCtx, indexedValues, h, writeBufs.IndexKeyBuf)
-	if err != nil {
-		return 0, err
+// rowsIter is an index iterator over decoded rows.
+type rowsIter struct {
+	rows []IndexRow
+}
+
+// Close implements table.IndexIterator Close interface.
+func (c *rowsIter) Close() {}
+
+// Next implements table.IndexIterator Next interface.
+func (c *rowsIter) Next() (val []types.Datum, h int64, err error) {
+	if len(c.rows) == 0 {
+		return nil, 0, errors.Trace(io.EOF)
 	}
+	row := c.rows[0]
+	c.rows = c.rows[1:]
+	return row.Values, row.Handle, nil
+}
 
-	ctx := opt.Ctx
-	if opt.Untouched {
-		txn, err1 := sctx.Txn(true)
-		if err1 != nil {
-			return 0, err1
+// RawOrderScan scans the whole index in the raw byte order of the encoded values, then the handles,
+// ignoring the sort keys or the hashes. It's the order the entries would be laid out in without them,
+// while SeekFirst returns the logical order, e.g. the collation order given by WithSortKey.
+// An index with neither stores the values in its keys, so both orders are the same and the entries
+// are streamed, otherwise the whole index is read and sorted in memory.
+func (c *index) RawOrderScan(sc *stmtctx.StatementContext, r kv.Retriever) (table.IndexIterator, error) {
+	it, err := c.SeekFirst(r)
+	if err != nil || !c.storesOriginal() {
+		return it, err
+	}
+	defer it.Close()
+	var rows []IndexRow
+	var keys [][]byte
+	for {
+		vals, h, err := it.Next()
+		if terror.ErrorEqual(err, io.EOF) {
+			break
 		}
-		// If the index kv was untouched(unchanged), and the key/value already exists in mem-buffer,
-		// should not overwrite the key with un-commit flag.
-		// So if the key exists, just do nothing and return.
-		_, err = txn.GetMemBuffer().Get(ctx, key)
-		if err == nil {
-			return 0, nil
+		if err != nil {
+			return nil, err
 		}
-	}
-
-	// save the key buffer to reuse.
-	writeBufs.IndexKeyBuf = key
-	if !distinct {
-		// non-unique index doesn't need store value, write a '0' to reduce space
-		value := []byte{'0'}
-		if opt.Untouched {
-			value[0] = kv.UnCommitIndexKVFlag
+		key, err := codec.EncodeKey(sc, nil, vals...)
+		if err != nil {
+			return nil, err
 		}
-		err = rm.Set(key, value)
-		return 0, err
+		rows = append(rows, IndexRow{Values: vals, Handle: h})
+		keys = append(keys, codec.EncodeInt(key, h))
 	}
+	sort.Sort(&rawOrderRows{rows: rows, keys: keys})
+	return &rowsIter{rows: rows}, nil
+}
 
-	if skipCheck || opt.Untouched {
-		value := EncodeHandle(h)
-		// If index is untouched and fetch here means the key is exists in TiKV, but not in txn mem-buffer,
-		// then should also write the untouched index key/value to mem-buffer to make sure the data
-		// is consistent with the index in txn mem-buffer.
-		if opt.Untouched {
-			value = append(value, kv.UnCommitIndexKVFlag)
-		}
-		err = rm.Set(key, value)
-		return 0, err
-	}
+// rawOrderRows sorts the rows by their encoded values and handles in keys.
+type rawOrderRows struct {
+	rows []IndexRow
+	keys [][]byte
+}
 
-	ctx = context.TODO()
+func (s *rawOrderRows) Len() int           { return len(s.rows) }
+func (s *rawOrderRows) Less(i, j int) bool { return bytes.Compare(s.keys[i], s.keys[j]) < 0 }
+func (s *rawOrderRows) Swap(i, j int) {
+	s.rows[i], s.rows[j] = s.rows[j], s.rows[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
 
-	var value []byte
-	value, err = rm.Get(ctx, key)
-	if kv.IsErrNotFound(err) {
-		v := EncodeHandle(h)
-		err = rm.Set(key, v)
-		return 0, err
-	}
-	if err != nil {
-		return 0, err
+// DistinctValues returns an iterator over the distinct tuples of the first numCols index columns,
+// it serves SELECT DISTINCT on the leading index columns by a loose index scan, which seeks past
+// the duplicates instead of reading them. The iterator returns no handles.
+func (c *index) DistinctValues(sc *stmtctx.StatementContext, r kv.Retriever, numCols int) (table.IndexIterator, error) {
+	if numCols <= 0 || numCols > len(c.idxInfo.Columns) {
+		return nil, errors.Errorf("invalid number of distinct columns %d for index %s", numCols, c.idxInfo.Name)
 	}
+	return &distinctIter{r: r, idx: c, numCols: numCols, seekKey: c.scanPrefix}, nil
+}
 
-	handle, err := DecodeHandle(value)
+// IterRaw returns an iterator over the undecoded key/value pairs of the index.
+// The iterator must be closed after use.
+func (c *index) IterRaw(r kv.Retriever) (kv.Iterator, error) {
+	return r.Iter(c.scanPrefix, c.scanPrefix.PrefixNext())
+}
+
+// FirstKey returns the smallest key present in the index, or false if the index is empty.
+func (c *index) FirstKey(r kv.Retriever) ([]byte, bool, error) {
+	it, err := c.IterRaw(r)
 	if err != nil {
-		return 0, err
+		return nil, false, err
 	}
-	return handle, kv.ErrKeyExists
+	defer it.Close()
+	if !it.Valid() || !it.Key().HasPrefix(c.scanPrefix) {
+		return nil, false, nil
+	}
+	return append([]byte(nil), it.Key()...), true, nil
 }
 
-// Delete removes the entry for handle h and indexdValues from KV index.
-func (c *index) Delete(sc *stmtctx.StatementContext, m kv.Mutator, indexedValues []types.Datum, h int64) error {
-	key, _, err := c.GenIndexKey(sc, indexedValues, h, nil)
+// LastKey returns the largest key present in the index, or false if the index is empty.
+// It iterates backward from the end of the index, so it reads only one entry as FirstKey does.
+func (c *index) LastKey(r kv.Retriever) ([]byte, bool, error) {
+	it, err := r.IterReverse(c.scanPrefix.PrefixNext())
 	if err != nil {
-		return err
+		return nil, false, err
 	}
-	err = m.Delete(key)
-	return err
+	defer it.Close()
+	if !it.Valid() || !it.Key().HasPrefix(c.scanPrefix) {
+		return nil, false, nil
+	}
+	return append([]byte(nil), it.Key()...), true, nil
 }
 
-// Drop removes the KV index from store.
-func (c *index) Drop(rm kv.RetrieverMutator) error {
-	it, err := rm.Iter(c.prefix, c.prefix.PrefixNext())
+// AggKind is an aggregate AggScan computes.
+type AggKind int
+
+// The aggregates AggScan computes.
+const (
+	AggCount AggKind = iota
+	AggMin
+	AggMax
+)
+
+// AggScan computes the aggregate of the index column at col (offset in the index columns) without
+// returning the rows. MIN and MAX read the entries from either end of the index until a non-NULL value,
+// so they only support the leading column; COUNT scans the index and counts the non-NULL values.
+// MIN and MAX return NULL for an index without non-NULL values.
+func (c *index) AggScan(sc *stmtctx.StatementContext, r kv.Retriever, agg AggKind, col int) (types.Datum, error) {
+	if col < 0 || col >= len(c.idxInfo.Columns) {
+		return types.Datum{}, errors.Errorf("invalid column offset %d for index %s", col, c.idxInfo.Name)
+	}
+	var it kv.Iterator
+	var err error
+	switch agg {
+	case AggCount:
+		it, err = c.IterRaw(r)
+	case AggMin, AggMax:
+		if col != 0 {
+			return types.Datum{}, errors.Errorf("MIN and MAX are only supported on the leading column of index %s", c.idxInfo.Name)
+		}
+		if c.storesOriginal() {
+			return types.Datum{}, errors.Errorf("index %s isn't ordered by its values", c.idxInfo.Name)
+		}
+		if agg == AggMin {
+			it, err = c.IterRaw(r)
+		} else {
+			it, err = r.IterReverse(c.scanPrefix.PrefixNext())
+		}
+	default:
+		return types.Datum{}, errors.Errorf("unknown aggregate %d", agg)
+	}
 	if err != nil {
-		return err
+		return types.Datum{}, err
 	}
 	defer it.Close()
 
-	// remove all indices
-	for it.Valid() {
-		if !it.Key().HasPrefix(c.prefix) {
-			break
-		}
-		err := rm.Delete(it.Key())
+	var count int64
+	for it.Valid() && it.Key().HasPrefix(c.scanPrefix) {
+		vals, _, err := c.decodeEntry(it.Key(), it.Value())
 		if err != nil {
-			return err
+			return types.Datum{}, err
 		}
-		err = it.Next()
-		if err != nil {
-			return err
+		if !vals[col].IsNull() {
+			if agg != AggCount {
+				return vals[col], nil
+			}
+			count++
+		}
+		if err = it.Next(); err != nil {
+			return types.Datum{}, err
 		}
 	}
-	return nil
+	if agg == AggCount {
+		return types.NewIntDatum(count), nil
+	}
+	return types.Datum{}, nil
 }
 
-// Seek searches KV index for the entry with indexedValues.
-func (c *index) Seek(sc *stmtctx.StatementContext, r kv.Retriever, indexedValues []types.Datum) (iter table.IndexIterator, hit bool, err error) {
-	key, _, err := c.GenIndexKey(sc, indexedValues, 0, nil)
+// KeyLengthStats scans the keys of the index and returns the minimum, maximum and mean encoded key
+// length with a histogram of key length to the number of keys. The values are never decoded.
+func (c *index) KeyLengthStats(r kv.Retriever) (min, max, mean int, histogram map[int]int, err error) {
+	it, err := c.IterRaw(r)
 	if err != nil {
-		return nil, false, err
+		return 0, 0, 0, nil, err
 	}
+	defer it.Close()
 
-	upperBound := c.prefix.PrefixNext()
-	it, err := r.Iter(key, upperBound)
-	if err != nil {
-		return nil, false, err
+	histogram = make(map[int]int)
+	total, count := 0, 0
+	for it.Valid() && it.Key().HasPrefix(c.scanPrefix) {
+		l := len(it.Key())
+		if count == 0 || l < min {
+			min = l
+		}
+		if l > max {
+			max = l
+		}
+		histogram[l]++
+		total += l
+		count++
+		if err = it.Next(); err != nil {
+			return 0, 0, 0, nil, err
+		}
 	}
-	// check if hit
-	hit = false
-	if it.Valid() && it.Key().Cmp(key) == 0 {
-		hit = true
+	if count > 0 {
+		mean = total / count
 	}
-	return &indexIter{it: it, idx: c, prefix: c.prefix}, hit, nil
+	return min, max, mean, histogram, nil
 }
 
-// SeekFirst returns an iterator which points to the first entry of the KV index.
-func (c *index) SeekFirst(r kv.Retriever) (iter table.IndexIterator, err error) {
-	upperBound := c.prefix.PrefixNext()
-	it, err := r.Iter(c.prefix, upperBound)
+// Count returns the number of entries of the index, e.g. for the statistics. Only the keys are scanned,
+// the values are never decoded. The scan stops with the error of ctx once ctx is done.
+func (c *index) Count(ctx context.Context, r kv.Retriever) (int64, error) {
+	it, err := c.IterRaw(r)
 	if err != nil {
-		return nil, err
+		return 0, err
+	}
+	defer it.Close()
+	var count int64
+	for it.Valid() && it.Key().HasPrefix(c.scanPrefix) {
+		if count%ctxCheckInterval == 0 {
+			if err = ctx.Err(); err != nil {
+				return 0, errors.Trace(err)
+			}
+		}
+		count++
+		if err = it.Next(); err != nil {
+			return 0, err
+		}
 	}
-	return &indexIter{it: it, idx: c, prefix: c.prefix}, nil
+	return count, nil
 }
 
 func (c *index) Exist(sc *stmtctx.StatementContext, rm kv.RetrieverMutator, indexedValues []types.Datum, h int64) (bool, int64, error) {
+	return c.exist(sc, rm, indexedValues, h)
+}
+
+// exist is Exist, which only reads from r.
+func (c *index) exist(sc *stmtctx.StatementContext, r kv.Retriever, indexedValues []types.Datum, h int64) (bool, int64, error) {
+	if c.multiValued {
+		return c.existMultiValued(sc, r, indexedValues, h)
+	}
+	return c.existEntry(sc, r, indexedValues, h)
+}
+
+// existEntry is exist for the single entry of indexedValues.
+func (c *index) existEntry(sc *stmtctx.StatementContext, r kv.Retriever, indexedValues []types.Datum, h int64) (bool, int64, error) {
+	if c.seqGen != nil {
+		return c.existWithSequence(sc, r, indexedValues, h)
+	}
 	key, distinct, err := c.GenIndexKey(sc, indexedValues, h, nil)
 	if err != nil {
 		return false, 0, err
 	}
 
-	value, err := rm.Get(context.TODO(), key)
+	value, err := c.get(context.TODO(), r, key)
 	if kv.IsErrNotFound(err) {
 		return false, 0, nil
 	}
@@ -344,7 +2613,7 @@ func (c *index) Exist(sc *stmtctx.StatementContext, rm kv.RetrieverMutator, inde
 
 	// For distinct index, the value of key is handle.
 	if distinct {
-		handle, err := DecodeHandle(value)
+		handle, err := c.decodeHandleValue(value)
 		if err != nil {
 			return false, 0, err
 		}
@@ -359,16 +2628,229 @@ func (c *index) Exist(sc *stmtctx.StatementContext, rm kv.RetrieverMutator, inde
 	return true, h, nil
 }
 
+// WriteTime returns the write time of the entry with indexedValues and handle h, see WithWriteTime.
+// It returns false if there's no such entry, and a zero time if the entry isn't stamped.
+func (c *index) WriteTime(sc *stmtctx.StatementContext, r kv.Retriever, indexedValues []types.Datum, h int64) (time.Time, bool, error) {
+	meta, ok, err := c.entryMeta(sc, r, indexedValues, h)
+	return meta.WriteTime, ok, err
+}
+
+// PlacementHint returns the placement hint of the entry with indexedValues and handle h, see WithPlacementHints.
+// It returns false if there's no such entry, and an empty hint if the entry has none.
+func (c *index) PlacementHint(sc *stmtctx.StatementContext, r kv.Retriever, indexedValues []types.Datum, h int64) (string, bool, error) {
+	meta, ok, err := c.entryMeta(sc, r, indexedValues, h)
+	return meta.PlacementHint, ok, err
+}
+
+// entryMeta returns the metadata of the entry with indexedValues and handle h, and false if there's no such entry.
+func (c *index) entryMeta(sc *stmtctx.StatementContext, r kv.Retriever, indexedValues []types.Datum, h int64) (EntryMeta, bool, error) {
+	var key kv.Key
+	distinct := false
+	var err error
+	if c.seqGen != nil {
+		key, _, err = c.findSeqEntry(sc, r, indexedValues, h)
+	} else {
+		key, distinct, err = c.GenIndexKey(sc, indexedValues, h, nil)
+	}
+	if err != nil || key == nil {
+		return EntryMeta{}, false, err
+	}
+	value, err := c.get(context.TODO(), r, key)
+	if kv.IsErrNotFound(err) {
+		return EntryMeta{}, false, nil
+	}
+	if err != nil {
+		return EntryMeta{}, false, err
+	}
+	if distinct {
+		handle, err := c.decodeHandleValue(value)
+		if err != nil {
+			return EntryMeta{}, false, err
+		}
+		if handle != h {
+			return EntryMeta{}, false, nil
+		}
+	}
+	_, meta, err := c.splitValue(value)
+	return meta, err == nil, err
+}
+
+// RepairFromTable makes sure every table row has its index entry, creating the missing ones.
+// rows returns the rows of the table with their handles one by one, and false when there're no more rows.
+// It returns the number of rows whose entries are created, the missing entries of the elements of a row of a
+// multi-valued index are all created. A unique entry which points to another row can't be repaired,
+// so ErrKeyExists is returned for it.
+func (c *index) RepairFromTable(sctx sessionctx.Context, rm kv.RetrieverMutator, rows func() ([]types.Datum, int64, bool, error)) (created int, err error) {
+	var vals []types.Datum
+	for {
+		row, h, ok, err := rows()
+		if err != nil {
+			return created, err
+		}
+		if !ok {
+			return created, nil
+		}
+		// A partial index has no entry for a row out of its predicate.
+		if qualified, err := c.qualifies(sctx, row); err != nil || !qualified {
+			if err != nil {
+				return created, err
+			}
+			continue
+		}
+		vals, err = c.FetchValues(row, vals)
+		if err != nil {
+			return created, err
+		}
+		opts, err := c.rowCreateOpts(row)
+		if err != nil {
+			return created, err
+		}
+		repaired, err := c.createMissing(sctx, rm, vals, h, opts)
+		if err != nil {
+			return created, err
+		}
+		if repaired {
+			created++
+		}
+	}
+}
+
+// createMissing creates the entries of indexedValues with handle h which don't exist, and returns whether any
+// is created. The entry of each element of a multi-valued index is checked on its own, so a row whose
+// entries are partly missing is repaired. A partial index must have checked its predicate.
+func (c *index) createMissing(sctx sessionctx.Context, rm kv.RetrieverMutator, indexedValues []types.Datum, h int64, opts []table.CreateIdxOptFunc) (bool, error) {
+	sets, err := c.ExpandMultiValued(indexedValues)
+	if err != nil {
+		return false, err
+	}
+	created := false
+	for _, vals := range sets {
+		exist, _, err := c.existEntry(sctx.GetSessionVars().StmtCtx, rm, vals, h)
+		if err != nil {
+			return created, err
+		}
+		if exist {
+			continue
+		}
+		if _, err = c.create(sctx, rm, vals, h, opts...); err != nil {
+			return created, err
+		}
+		created = true
+	}
+	return created, nil
+}
+
+// RepairEntry rewrites the entry of the row with handle h at the key derived from the row, e.g. to fix an entry
+// CheckIndexConsistency reports without rebuilding the index. Any entry at the key, e.g. one with a corrupt value,
+// is deleted and the correct one is created, so repairing an entry twice is the same as once. The stale entries
+// of the row at other keys aren't found. A unique entry which points to another row isn't overwritten, its
+// ErrKeyExists is returned. The entry of an index keeping the insertion order can't be derived from the row.
+func (c *index) RepairEntry(sctx sessionctx.Context, rm kv.RetrieverMutator, row []types.Datum, h int64) error {
+	if c.seqGen != nil {
+		return errors.Errorf("the entry of index %s keeping the insertion order can't be derived from the row", c.idxInfo.Name)
+	}
+	sets, err := c.FetchMultiValues(row)
+	if err != nil {
+		return err
+	}
+	// The keys of all the elements of a multi-valued index are checked before any is rewritten.
+	keys := make([]kv.Key, len(sets))
+	for i, vals := range sets {
+		key, distinct, err := c.GenIndexKey(sctx.GetSessionVars().StmtCtx, vals, h, nil)
+		if err != nil {
+			return err
+		}
+		if distinct {
+			value, err := c.get(context.TODO(), rm, key)
+			if err != nil && !kv.IsErrNotFound(err) {
+				return err
+			}
+			if err == nil {
+				if other, err := c.decodeHandleValue(value); err == nil && other != h {
+					return table.NewDupKeyError(c.idxInfo.Name.O, other, vals)
+				}
+			}
+		}
+		keys[i] = key
+	}
+	qualified, err := c.qualifies(sctx, row)
+	if err != nil {
+		return err
+	}
+	opts, err := c.rowCreateOpts(row)
+	if err != nil {
+		return err
+	}
+	for i, vals := range sets {
+		if err = rm.Delete(keys[i]); err != nil {
+			return err
+		}
+		// A partial index has no entry for a row out of its predicate.
+		if !qualified {
+			continue
+		}
+		if _, err = c.create(sctx, rm, vals, h, opts...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rowCreateOpts returns the options to create the entry of row with, the row itself for the predicate of a
+// partial index and the values of the included columns, see WithIncludeColumns.
+func (c *index) rowCreateOpts(row []types.Datum) ([]table.CreateIdxOptFunc, error) {
+	opts := []table.CreateIdxOptFunc{table.WithRow(row)}
+	if len(c.includeCols) > 0 {
+		included, err := c.FetchIncludedValues(row)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, table.WithIncludedValues(included))
+	}
+	return opts, nil
+}
+
+// existWithSequence is Exist for an index which keeps the insertion order.
+func (c *index) existWithSequence(sc *stmtctx.StatementContext, r kv.Retriever, indexedValues []types.Datum, h int64) (bool, int64, error) {
+	key, handles, err := c.findSeqEntry(sc, r, indexedValues, h)
+	if err != nil {
+		return false, 0, err
+	}
+	if key != nil {
+		return true, h, nil
+	}
+	if len(handles) > 0 && c.uniqueValues(indexedValues) {
+		return true, handles[0], kv.ErrKeyExists
+	}
+	return false, 0, nil
+}
+
 func (c *index) FetchValues(r []types.Datum, vals []types.Datum) ([]types.Datum, error) {
+	if c.exprErr != nil {
+		return nil, c.exprErr
+	}
 	needLength := len(c.idxInfo.Columns)
 	if vals == nil || cap(vals) < needLength {
 		vals = make([]types.Datum, needLength)
 	}
 	vals = vals[:needLength]
 	for i, ic := range c.idxInfo.Columns {
+		if i < len(c.exprCols) && c.exprCols[i] != nil {
+			v, err := c.exprCols[i](r)
+			if err != nil {
+				return nil, err
+			}
+			vals[i] = v
+			continue
+		}
 		if ic.Offset < 0 || ic.Offset >= len(r) {
 			return nil, table.ErrIndexOutBound.GenWithStackByArgs(ic.Name, ic.Offset, r)
 		}
+		if c.strictValues {
+			if err := c.checkValueKind(ic, r[ic.Offset]); err != nil {
+				return nil, err
+			}
+		}
 		vals[i] = r[ic.Offset]
 	}
 	return vals, nil
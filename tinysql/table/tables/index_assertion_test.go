@@ -0,0 +1,82 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/types"
+)
+
+// assertingStore is a store recording the assertions attached to its writes, its commit checks them
+// against committed, which stands for the keys written by the other transactions.
+type assertingStore struct {
+	*kv.BufferStore
+	committed  *kv.BufferStore
+	assertions map[string]kv.AssertionType
+}
+
+func (s *assertingStore) SetWithAssertion(k kv.Key, v []byte, assertion kv.AssertionType) error {
+	s.assertions[string(k)] = assertion
+	return s.Set(k, v)
+}
+
+func (s *assertingStore) commit() error {
+	for k, assertion := range s.assertions {
+		_, err := s.committed.Get(context.TODO(), kv.Key(k))
+		if assertion == kv.NotExist && err == nil {
+			return kv.ErrKeyExists.GenWithStackByArgs(k, "assertion")
+		}
+	}
+	return s.SaveTo(s.committed)
+}
+
+func (s *testIndexInternalSuite) TestCreateAssertion(c *C) {
+	idx := s.newIndex([]string{"a"}, true)
+	committed := newTestStore()
+	// Another transaction has committed an entry for 2.
+	_, err := idx.Create(s.sctx, committed, types.MakeDatums(2), 20)
+	c.Assert(err, IsNil)
+
+	txn := &assertingStore{BufferStore: newTestStore(), committed: committed, assertions: make(map[string]kv.AssertionType)}
+	stats := &table.KVOpStats{}
+	for i := int64(1); i <= 2; i++ {
+		_, err = idx.Create(s.sctx, txn, types.MakeDatums(i), i, table.WithAssertion(kv.NotExist), table.WithOpStats(stats))
+		c.Assert(err, IsNil)
+	}
+	// A NULL entry isn't distinct, it's written without an assertion.
+	_, err = idx.Create(s.sctx, txn, types.MakeDatums(nil), 3, table.WithAssertion(kv.NotExist))
+	c.Assert(err, IsNil)
+	c.Assert(txn.assertions, HasLen, 2)
+	for _, assertion := range txn.assertions {
+		c.Assert(assertion, Equals, kv.NotExist)
+	}
+	// The keys aren't read, the duplicate of 2 is only found at commit time.
+	c.Assert(stats.Gets, Equals, 0)
+	c.Assert(stats.Sets, Equals, 2)
+	c.Assert(kv.ErrKeyExists.Equal(txn.commit()), IsTrue)
+
+	// Without the assertion, the duplicate fails the Create.
+	_, err = idx.Create(s.sctx, &assertingStore{BufferStore: kv.NewBufferStore(committed, 4096), assertions: make(map[string]kv.AssertionType)}, types.MakeDatums(2), 2)
+	c.Assert(kv.ErrKeyExists.Equal(err), IsTrue, Commentf("err %v", err))
+	// A store without assertions falls back to the read.
+	_, err = idx.Create(s.sctx, kv.NewBufferStore(committed, 4096), types.MakeDatums(2), 2, table.WithAssertion(kv.NotExist))
+	c.Assert(kv.ErrKeyExists.Equal(err), IsTrue, Commentf("err %v", err))
+
+	_, err = idx.Create(s.sctx, txn, types.MakeDatums(5), 5, table.WithAssertion(kv.Exist))
+	c.Assert(err, ErrorMatches, ".*doesn't support assertion.*")
+}
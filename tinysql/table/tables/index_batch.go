@@ -0,0 +1,477 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"context"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tidb/sessionctx"
+	"github.com/pingcap/tidb/sessionctx/stmtctx"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/codec"
+)
+
+// IndexEntry is an index entry to write or delete in a batch.
+type IndexEntry struct {
+	Values []types.Datum
+	Handle int64
+	// Included are the values of the included columns CreateBatch stores like table.WithIncludedValues,
+	// see WithIncludeColumns.
+	Included []types.Datum
+}
+
+// UnencodablePolicy decides what a batch operation does with an entry which has a value of a kind
+// the key codec can't encode.
+type UnencodablePolicy int
+
+const (
+	// UnencodableError fails the batch, it's the default.
+	UnencodableError UnencodablePolicy = iota
+	// UnencodableSkip skips the entry and appends a warning to the statement.
+	UnencodableSkip
+	// UnencodableAsNull encodes the value as NULL and appends a warning to the statement.
+	UnencodableAsNull
+)
+
+// BatchOpt contains the options of CreateBatch and DeleteBatch.
+type BatchOpt struct {
+	Unencodable UnencodablePolicy
+}
+
+// BatchOptFunc is defined for the CreateBatch and DeleteBatch methods.
+type BatchOptFunc func(*BatchOpt)
+
+// WithUnencodablePolicy returns a BatchOptFunc which handles the unencodable values by policy.
+func WithUnencodablePolicy(policy UnencodablePolicy) BatchOptFunc {
+	return func(opt *BatchOpt) {
+		opt.Unencodable = policy
+	}
+}
+
+// encodableKind returns whether the key codec can encode a datum of kind k.
+func encodableKind(k byte) bool {
+	switch k {
+	case types.KindNull, types.KindInt64, types.KindUint64, types.KindFloat32, types.KindFloat64,
+		types.KindString, types.KindBytes, types.KindMinNotNull, types.KindMaxValue:
+		return true
+	}
+	return false
+}
+
+// applyUnencodablePolicy returns the values to use for e by policy, and false if e should be skipped.
+// The values are only copied if one of them is replaced.
+func (c *index) applyUnencodablePolicy(sc *stmtctx.StatementContext, e IndexEntry, policy UnencodablePolicy) ([]types.Datum, bool) {
+	vals := e.Values
+	copied := false
+	for i := range e.Values {
+		if encodableKind(e.Values[i].Kind()) {
+			continue
+		}
+		switch policy {
+		case UnencodableSkip:
+			sc.AppendWarning(errors.Errorf("index %s skips the entry of handle %d with the unencodable value '%s'", c.idxInfo.Name, e.Handle, renderDatum(e.Values[i])))
+			return nil, false
+		case UnencodableAsNull:
+			sc.AppendWarning(errors.Errorf("index %s encodes the unencodable value '%s' of handle %d as NULL", c.idxInfo.Name, renderDatum(e.Values[i]), e.Handle))
+			if !copied {
+				vals = append([]types.Datum(nil), e.Values...)
+				copied = true
+			}
+			vals[i] = types.Datum{}
+		}
+	}
+	return vals, true
+}
+
+// CreateBatch creates the entries in KV index like calling Create for every entry, e.g. for the backfill of
+// ADD INDEX. The batch is deduplicated before anything is written: the entries with the same indexed values,
+// after the prefix truncation, and handle are written once, and the entries of a unique index with the same
+// values but different handles fail the batch with ErrKeyExists as an in-batch conflict. The keys are all
+// generated by GenIndexKeys into a single buffer, and the existing entries of a unique index are looked up by
+// a single BatchGet if rm supports it, so a conflict fails the batch before any entry is written. The Included
+// of the entries are stored in the non-distinct entries, see WithIncludeColumns.
+// An index which needs its own write path, e.g. a hashed one, creates the entries one by one and stops at
+// the first error.
+func (c *index) CreateBatch(sctx sessionctx.Context, rm kv.RetrieverMutator, entries []IndexEntry, opts ...BatchOptFunc) error {
+	var opt BatchOpt
+	for _, fn := range opts {
+		fn(&opt)
+	}
+	if c.predicate != nil {
+		return errors.Errorf("partial index %s can't create a batch without the rows", c.idxInfo.Name)
+	}
+	sc := sctx.GetSessionVars().StmtCtx
+	if c.seqGen != nil || c.storesOriginal() || c.multiValued || c.deferUnique || c.metrics != nil || c.capacity != nil || c.slowLogThreshold > 0 {
+		deduped, err := c.dedupBatch(sc, entries, opt.Unencodable)
+		if err != nil {
+			return err
+		}
+		for _, e := range deduped {
+			if _, err := c.Create(sctx, rm, e.Values, e.Handle, table.WithIncludedValues(e.Included)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	rows := make([][]types.Datum, 0, len(entries))
+	handles := make([]int64, 0, len(entries))
+	includeds := make([][]types.Datum, 0, len(entries))
+	for _, e := range entries {
+		if err := c.checkIncluded(e.Included); err != nil {
+			return err
+		}
+		if vals, ok := c.applyUnencodablePolicy(sc, e, opt.Unencodable); ok {
+			rows = append(rows, vals)
+			handles = append(handles, e.Handle)
+			includeds = append(includeds, e.Included)
+		}
+	}
+	keys, distincts, err := c.GenIndexKeys(sc, rows, handles, nil)
+	if err != nil {
+		return err
+	}
+	// The entries are deduplicated by their keys, a non-distinct key has the handle, so only the same
+	// distinct key with another handle is an in-batch conflict.
+	keyHandles := make(map[string]int64, len(keys))
+	n := 0
+	for i, key := range keys {
+		if h, ok := keyHandles[string(key)]; ok {
+			if h != handles[i] {
+				return batchConflictErr(rows[i], c.idxInfo.Name, h, handles[i])
+			}
+			continue
+		}
+		keyHandles[string(key)] = handles[i]
+		keys[n], distincts[n], rows[n], handles[n], includeds[n] = key, distincts[i], rows[i], handles[i], includeds[i]
+		n++
+	}
+	keys, distincts, rows, handles, includeds = keys[:n], distincts[:n], rows[:n], handles[:n], includeds[:n]
+	if !sc.BatchCheck {
+		if err = c.checkBatchConflicts(rm, keys, distincts, rows); err != nil {
+			return err
+		}
+	}
+	for i, key := range keys {
+		var value []byte
+		if distincts[i] {
+			value = c.encodeHandleValue(handles[i])
+		} else if value, err = c.nonDistinctValue(sc, includeds[i]); err != nil {
+			return err
+		}
+		if err = rm.Set(key, c.stampValue(value, "")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkBatchConflicts returns the error Create returns for the first distinct key of the batch which already exists in rm.
+func (c *index) checkBatchConflicts(rm kv.Retriever, keys [][]byte, distincts []bool, rows [][]types.Datum) error {
+	var distinctKeys []kv.Key
+	for i, key := range keys {
+		if distincts[i] {
+			distinctKeys = append(distinctKeys, key)
+		}
+	}
+	if len(distinctKeys) == 0 {
+		return nil
+	}
+	values, err := batchGet(context.TODO(), rm, distinctKeys)
+	if err != nil {
+		return err
+	}
+	values = c.dropTombstones(values)
+	for i, key := range keys {
+		value, ok := values[string(key)]
+		if !ok || !distincts[i] {
+			continue
+		}
+		handle, err := c.decodeHandleValue(value)
+		if err != nil {
+			return err
+		}
+		if c.prefixConflictDiag {
+			return c.prefixConflictErr(rows[i], handle)
+		}
+		return table.NewDupKeyError(c.idxInfo.Name.O, handle, rows[i])
+	}
+	return nil
+}
+
+// batchConflictErr returns the ErrKeyExists of two entries of a batch with the same values vals but different handles.
+func batchConflictErr(vals []types.Datum, idxName model.CIStr, h1, h2 int64) error {
+	return kv.ErrKeyExists.GenWithStack("Duplicate entry '%s' for key '%s' within the batch, of handles %d and %d",
+		types.DatumsToStrNoErr(vals), idxName, h1, h2)
+}
+
+// dedupBatch applies the unencodable policy to entries and removes the duplicated entries, in order.
+// It returns ErrKeyExists if two entries of a unique index have the same values but different handles.
+func (c *index) dedupBatch(sc *stmtctx.StatementContext, entries []IndexEntry, policy UnencodablePolicy) ([]IndexEntry, error) {
+	deduped := make([]IndexEntry, 0, len(entries))
+	// handles maps the encoded values to the handles of the entries with them.
+	handles := make(map[string][]int64, len(entries))
+	for _, e := range entries {
+		vals, ok := c.applyUnencodablePolicy(sc, e, policy)
+		if !ok {
+			continue
+		}
+		valuesKey, err := codec.EncodeKey(sc, nil, TruncateIndexValuesIfNeeded(c.tblInfo, c.idxInfo, vals)...)
+		if err != nil {
+			return nil, c.wrapEncodeErr(vals, err)
+		}
+		dup := false
+		for _, h := range handles[string(valuesKey)] {
+			if h == e.Handle {
+				dup = true
+				break
+			}
+			if c.uniqueValues(vals) {
+				return nil, batchConflictErr(vals, c.idxInfo.Name, h, e.Handle)
+			}
+		}
+		if dup {
+			continue
+		}
+		handles[string(valuesKey)] = append(handles[string(valuesKey)], e.Handle)
+		deduped = append(deduped, IndexEntry{Values: vals, Handle: e.Handle, Included: e.Included})
+	}
+	return deduped, nil
+}
+
+// ExistResult is the result of Exist for a row of BatchExist.
+type ExistResult struct {
+	// Exists is set if there's an entry with the values of the row.
+	Exists bool
+	// Handle is the handle of the entry if it exists.
+	Handle int64
+	// Conflict is set if the entry of a unique index points to another handle, Exist returns ErrKeyExists for it.
+	Conflict bool
+}
+
+// BatchExist checks the entries of rows, the indexed values of several rows, with their handles exist as Exist
+// does for each of them, e.g. to report every duplicate of an INSERT IGNORE. All the keys are read in one
+// BatchGet if rm supports it instead of one Get per row.
+func (c *index) BatchExist(sc *stmtctx.StatementContext, rm kv.RetrieverMutator, rows [][]types.Datum, handles []int64) ([]ExistResult, error) {
+	if len(rows) != len(handles) {
+		return nil, errors.Errorf("%d rows with %d handles", len(rows), len(handles))
+	}
+	results := make([]ExistResult, len(rows))
+	if c.seqGen != nil || c.multiValued {
+		// The sequences of the entries are unknown, so their keys can't be generated, and a row of a
+		// multi-valued index has the entries of its elements.
+		for i := range rows {
+			exist, h, err := c.exist(sc, rm, rows[i], handles[i])
+			if err != nil && !kv.ErrKeyExists.Equal(err) {
+				return nil, err
+			}
+			results[i] = ExistResult{Exists: exist, Handle: h, Conflict: err != nil}
+		}
+		return results, nil
+	}
+	keys, distincts, err := c.GenIndexKeys(sc, rows, handles, nil)
+	if err != nil {
+		return nil, err
+	}
+	kvKeys := make([]kv.Key, len(keys))
+	for i, key := range keys {
+		kvKeys[i] = key
+	}
+	values, err := batchGet(context.TODO(), rm, kvKeys)
+	if err != nil {
+		return nil, err
+	}
+	values = c.dropTombstones(values)
+	for i, key := range keys {
+		value, ok := values[string(key)]
+		if !ok {
+			continue
+		}
+		results[i] = ExistResult{Exists: true, Handle: handles[i]}
+		// For distinct index, the value of key is handle.
+		if distincts[i] {
+			h, err := c.decodeHandleValue(value)
+			if err != nil {
+				return nil, err
+			}
+			results[i].Handle = h
+			results[i].Conflict = h != handles[i]
+		}
+	}
+	return results, nil
+}
+
+// DeleteBatch removes the entries from KV index like calling Delete for every entry.
+// All the keys are generated before any entry is deleted, so an entry whose key can't be generated
+// deletes nothing. A failure of m in the middle leaves the entries before it deleted, the caller
+// should roll back the transaction in this case.
+func (c *index) DeleteBatch(sc *stmtctx.StatementContext, m kv.Mutator, entries []IndexEntry, opts ...BatchOptFunc) error {
+	var opt BatchOpt
+	for _, fn := range opts {
+		fn(&opt)
+	}
+	if c.seqGen != nil {
+		// The keys of an index which keeps the insertion order can only be found by scanning.
+		for _, e := range entries {
+			vals, ok := c.applyUnencodablePolicy(sc, e, opt.Unencodable)
+			if !ok {
+				continue
+			}
+			if err := c.Delete(sc, m, vals, e.Handle); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var buf []byte
+	bounds := make([]int, 0, len(entries)+1)
+	bounds = append(bounds, 0)
+	for _, e := range entries {
+		vals, ok := c.applyUnencodablePolicy(sc, e, opt.Unencodable)
+		if !ok {
+			continue
+		}
+		key, _, err := c.GenIndexKey(sc, vals, e.Handle, nil)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, key...)
+		bounds = append(bounds, len(buf))
+	}
+	for i := 1; i < len(bounds); i++ {
+		if err := m.Delete(buf[bounds[i-1]:bounds[i]]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TxnRunner runs f in a new transaction and commits it. f may be retried, so it must be idempotent.
+type TxnRunner func(f func(rm kv.RetrieverMutator) error) error
+
+// NewTxnRunner returns a TxnRunner which runs f by kv.RunInNewTxn on store.
+func NewTxnRunner(store kv.Storage) TxnRunner {
+	return func(f func(rm kv.RetrieverMutator) error) error {
+		return kv.RunInNewTxn(store, true, func(txn kv.Transaction) error {
+			return f(txn)
+		})
+	}
+}
+
+// DropInBatches is Drop committing every batchSize deleted entries in their own transaction run by run,
+// so a huge index never needs a transaction over the size limit. batchSize <= 0 means one transaction.
+// It isn't atomic: an error leaves the entries deleted by the committed chunks deleted, and calling it
+// again resumes the drop.
+func (c *index) DropInBatches(run TxnRunner, batchSize int) error {
+	var start kv.Key
+	for {
+		var next kv.Key
+		var done bool
+		err := run(func(rm kv.RetrieverMutator) (err error) {
+			next, done, err = c.Drop(rm, table.WithDropBatchSize(batchSize), table.WithDropStartKey(start))
+			return err
+		})
+		if err != nil || done {
+			return err
+		}
+		start = next
+	}
+}
+
+// BuildFromRows creates the entries of rows, which returns the table rows with their handles one by one
+// and false when there're no more rows, as a backfill does. Every batchSize rows are written in their
+// own transaction run by run, batchSize <= 0 means one transaction. It returns the number of created entries.
+// It isn't atomic: an error leaves the entries of the committed chunks created.
+func (c *index) BuildFromRows(sctx sessionctx.Context, run TxnRunner, rows func() ([]types.Datum, int64, bool, error), batchSize int) (int, error) {
+	if c.sortKeys != nil && c.idxInfo.CollationVersion == 0 {
+		// The caller saves the IndexInfo with the version the index is built with.
+		c.idxInfo.CollationVersion = c.collationVersion
+	}
+	return c.writeRowsInBatches(sctx, run, rows, batchSize, false)
+}
+
+// RepairFromTableInBatches is RepairFromTable committing every batchSize rows in their own transaction
+// run by run, batchSize <= 0 means one transaction. It isn't atomic: an error leaves the entries of the
+// committed chunks repaired, and calling it again goes on repairing.
+func (c *index) RepairFromTableInBatches(sctx sessionctx.Context, run TxnRunner, rows func() ([]types.Datum, int64, bool, error), batchSize int) (int, error) {
+	return c.writeRowsInBatches(sctx, run, rows, batchSize, true)
+}
+
+// writeRowsInBatches creates the entries of rows in chunks of batchSize rows, if repair is true,
+// the existing entries are skipped.
+func (c *index) writeRowsInBatches(sctx sessionctx.Context, run TxnRunner, rows func() ([]types.Datum, int64, bool, error), batchSize int, repair bool) (total int, err error) {
+	for {
+		// The chunk is read before the transaction, so a retried transaction writes the same rows.
+		var chunk []IndexEntry
+		var chunkOpts [][]table.CreateIdxOptFunc
+		more := true
+		for more && (batchSize <= 0 || len(chunk) < batchSize) {
+			row, h, ok, err := rows()
+			if err != nil {
+				return total, err
+			}
+			if more = ok; ok {
+				// A partial index has no entry for a row out of its predicate.
+				qualified, err := c.qualifies(sctx, row)
+				if err != nil {
+					return total, err
+				}
+				if !qualified {
+					continue
+				}
+				vals, err := c.FetchValues(row, nil)
+				if err != nil {
+					return total, err
+				}
+				opts, err := c.rowCreateOpts(row)
+				if err != nil {
+					return total, err
+				}
+				chunk = append(chunk, IndexEntry{Values: vals, Handle: h})
+				chunkOpts = append(chunkOpts, opts)
+			}
+		}
+		var created int
+		err = run(func(rm kv.RetrieverMutator) error {
+			created = 0
+			for i, e := range chunk {
+				if repair {
+					repaired, err := c.createMissing(sctx, rm, e.Values, e.Handle, chunkOpts[i])
+					if err != nil {
+						return err
+					}
+					if repaired {
+						created++
+					}
+					continue
+				}
+				if _, err := c.Create(sctx, rm, e.Values, e.Handle, chunkOpts[i]...); err != nil {
+					return err
+				}
+				created++
+			}
+			return nil
+		})
+		if err != nil {
+			return total, err
+		}
+		total += created
+		if !more {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,317 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/parser/terror"
+	"github.com/pingcap/tidb/sessionctx/stmtctx"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/mock"
+)
+
+func (s *testIndexInternalSuite) TestDeleteBatch(c *C) {
+	for _, unique := range []bool{false, true} {
+		tblInfo := newTestTableInfo([]string{"a", "b"}, []int{0, 1}, unique)
+		idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+		sctx := mock.NewContext()
+		sc := &stmtctx.StatementContext{TimeZone: time.Local}
+		batch, single := newTestStore(), newTestStore()
+		var entries []IndexEntry
+		for i := 0; i < 10; i++ {
+			e := IndexEntry{Values: types.MakeDatums(i%4, i), Handle: int64(i)}
+			if i == 9 {
+				e.Values[0] = types.Datum{}
+			}
+			for _, store := range []*kv.BufferStore{batch, single} {
+				_, err := idx.Create(sctx, store, e.Values, e.Handle)
+				c.Assert(err, IsNil)
+			}
+			entries = append(entries, e)
+		}
+
+		toDelete := []IndexEntry{entries[1], entries[4], entries[9], entries[7]}
+		c.Assert(idx.DeleteBatch(sc, batch, toDelete), IsNil)
+		for _, e := range toDelete {
+			c.Assert(idx.Delete(sc, single, e.Values, e.Handle), IsNil)
+		}
+		c.Assert(dumpKVs(c, batch, idx.prefix), DeepEquals, dumpKVs(c, single, idx.prefix))
+		c.Assert(dumpKVs(c, batch, idx.prefix), HasLen, 6)
+		for _, e := range toDelete {
+			exist, _, err := idx.Exist(sc, batch, e.Values, e.Handle)
+			c.Assert(err, IsNil)
+			c.Assert(exist, IsFalse)
+		}
+	}
+}
+
+func (s *testIndexInternalSuite) TestUnencodablePolicy(c *C) {
+	idx := s.newIndex([]string{"a", "b"}, false)
+	sc := s.sctx.GetSessionVars().StmtCtx
+	var bad types.Datum
+	bad.SetBinaryLiteral(types.BinaryLiteral{0x01})
+	entries := []IndexEntry{
+		{Values: types.MakeDatums(1, "a"), Handle: 1},
+		{Values: []types.Datum{types.NewIntDatum(2), bad}, Handle: 2},
+		{Values: types.MakeDatums(3, "c"), Handle: 3},
+	}
+
+	// The default policy fails the batch.
+	buf := newTestStore()
+	c.Assert(idx.CreateBatch(s.sctx, buf, entries), NotNil)
+
+	buf = newTestStore()
+	sc.SetWarnings(nil)
+	c.Assert(idx.CreateBatch(s.sctx, buf, entries, WithUnencodablePolicy(UnencodableSkip)), IsNil)
+	c.Assert(dumpKVs(c, buf, idx.prefix), HasLen, 2)
+	warns := sc.GetWarnings()
+	c.Assert(warns, HasLen, 1)
+	c.Assert(warns[0].Err.Error(), Matches, ".*handle 2.*")
+	c.Assert(entries[1].Values[1].Kind(), Equals, types.KindBinaryLiteral)
+
+	sc.SetWarnings(nil)
+	c.Assert(idx.DeleteBatch(sc, buf, entries, WithUnencodablePolicy(UnencodableSkip)), IsNil)
+	c.Assert(dumpKVs(c, buf, idx.prefix), HasLen, 0)
+	c.Assert(sc.WarningCount(), Equals, uint16(1))
+
+	sc.SetWarnings(nil)
+	c.Assert(idx.CreateBatch(s.sctx, buf, entries, WithUnencodablePolicy(UnencodableAsNull)), IsNil)
+	c.Assert(sc.WarningCount(), Equals, uint16(1))
+	exist, _, err := idx.Exist(sc, buf, types.MakeDatums(2, nil), 2)
+	c.Assert(err, IsNil)
+	c.Assert(exist, IsTrue)
+	c.Assert(dumpKVs(c, buf, idx.prefix), HasLen, 3)
+}
+
+// chunkRunner returns a TxnRunner which buffers every transaction before saving it to base,
+// and records the number of mutations of the transactions.
+func chunkRunner(base *kv.BufferStore, sizes *[]int) TxnRunner {
+	return func(f func(rm kv.RetrieverMutator) error) error {
+		txn := kv.NewBufferStore(base, 4096)
+		if err := f(txn); err != nil {
+			return err
+		}
+		*sizes = append(*sizes, txn.Len())
+		return txn.SaveTo(base)
+	}
+}
+
+func (s *testIndexInternalSuite) TestBatchCommit(c *C) {
+	tblInfo := newTestTableInfo([]string{"a", "b"}, []int{1}, true)
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+	var rows [][]types.Datum
+	for i := 0; i < 100; i++ {
+		rows = append(rows, types.MakeDatums(i, fmt.Sprintf("v%03d", i)))
+	}
+
+	var sizes []int
+	created, err := idx.BuildFromRows(s.sctx, chunkRunner(s.store, &sizes), sliceRows(rows), 8)
+	c.Assert(err, IsNil)
+	c.Assert(created, Equals, 100)
+	c.Assert(sizes, HasLen, 13)
+	for _, size := range sizes {
+		c.Assert(size <= 8, IsTrue)
+	}
+	c.Assert(dumpKVs(c, s.store, idx.prefix), HasLen, 100)
+
+	for i := 10; i < 40; i++ {
+		c.Assert(idx.Delete(s.sc, s.store, rows[i][1:], int64(i)), IsNil)
+	}
+	sizes = sizes[:0]
+	created, err = idx.RepairFromTableInBatches(s.sctx, chunkRunner(s.store, &sizes), sliceRows(rows), 7)
+	c.Assert(err, IsNil)
+	c.Assert(created, Equals, 30)
+	for _, size := range sizes {
+		c.Assert(size <= 7, IsTrue)
+	}
+	c.Assert(dumpKVs(c, s.store, idx.prefix), HasLen, 100)
+
+	sizes = sizes[:0]
+	c.Assert(idx.DropInBatches(chunkRunner(s.store, &sizes), 9), IsNil)
+	c.Assert(dumpKVs(c, s.store, idx.prefix), HasLen, 0)
+	c.Assert(len(sizes) >= 12, IsTrue)
+	for _, size := range sizes {
+		c.Assert(size <= 9, IsTrue)
+	}
+}
+
+func (s *testIndexInternalSuite) TestCreateBatchDedup(c *C) {
+	for _, unique := range []bool{true, false} {
+		tblInfo := newTestTableInfo([]string{"a"}, []int{0}, unique)
+		idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+		rm := &recordMutator{RetrieverMutator: newTestStore()}
+		entries := []IndexEntry{
+			{Values: types.MakeDatums(1), Handle: 1},
+			{Values: types.MakeDatums(2), Handle: 2},
+			{Values: types.MakeDatums(1), Handle: 1},
+			{Values: types.MakeDatums(nil), Handle: 3},
+			{Values: types.MakeDatums(nil), Handle: 3},
+			{Values: types.MakeDatums(nil), Handle: 4},
+			{Values: types.MakeDatums(2), Handle: 2},
+		}
+		c.Assert(idx.CreateBatch(s.sctx, rm, entries), IsNil)
+		c.Assert(rm.setKeys, HasLen, 4)
+		c.Assert(dumpKVs(c, rm, idx.prefix), HasLen, 4)
+
+		// The same value with different handles is an in-batch conflict of a unique index.
+		rm = &recordMutator{RetrieverMutator: newTestStore()}
+		entries = []IndexEntry{
+			{Values: types.MakeDatums(1), Handle: 1},
+			{Values: types.MakeDatums(5), Handle: 5},
+			{Values: types.MakeDatums(1), Handle: 6},
+		}
+		err := idx.CreateBatch(s.sctx, rm, entries)
+		if !unique {
+			c.Assert(err, IsNil)
+			c.Assert(rm.setKeys, HasLen, 3)
+			continue
+		}
+		c.Assert(terror.ErrorEqual(err, kv.ErrKeyExists), IsTrue, Commentf("err %v", err))
+		c.Assert(err, ErrorMatches, ".*within the batch, of handles 1 and 6")
+		// Nothing is written for the conflicting batch.
+		c.Assert(rm.setKeys, HasLen, 0)
+	}
+}
+
+func (s *testIndexInternalSuite) TestCreateBatchConflicts(c *C) {
+	idx := s.newIndex([]string{"a"}, true, WithFormatMagic())
+	entries := []IndexEntry{
+		{Values: types.MakeDatums(1), Handle: 1},
+		{Values: types.MakeDatums(5), Handle: 2},
+		{Values: types.MakeDatums(nil), Handle: 3},
+		{Values: types.MakeDatums(nil), Handle: 4},
+	}
+	// The batch leaves the same KV state as the Creates.
+	expected := newTestStore()
+	for _, e := range entries {
+		_, err := idx.Create(s.sctx, expected, e.Values, e.Handle)
+		c.Assert(err, IsNil)
+	}
+	store := &batchGetStore{BufferStore: newTestStore()}
+	c.Assert(idx.CreateBatch(s.sctx, store, entries), IsNil)
+	c.Assert(dumpKVs(c, store, idx.prefix), DeepEquals, dumpKVs(c, expected, idx.prefix))
+	c.Assert(store.batchGets, Equals, 1)
+	c.Assert(store.gets, Equals, 0)
+
+	// A pre-existing entry fails the batch before anything is written.
+	store.batchGets = 0
+	entries = []IndexEntry{
+		{Values: types.MakeDatums(2), Handle: 12},
+		{Values: types.MakeDatums(5), Handle: 15},
+	}
+	err := idx.CreateBatch(s.sctx, store, entries)
+	c.Assert(terror.ErrorEqual(err, kv.ErrKeyExists), IsTrue, Commentf("err %v", err))
+	dupErr, ok := err.(*table.DupKeyError)
+	c.Assert(ok, IsTrue)
+	c.Assert(dupErr.Handle, Equals, int64(2))
+	c.Assert(dupErr.Entry, Equals, "5")
+	c.Assert(store.batchGets, Equals, 1)
+	c.Assert(dumpKVs(c, store, idx.prefix), DeepEquals, dumpKVs(c, expected, idx.prefix))
+
+	// The values truncated to fit the max key length conflict within the batch.
+	idx = s.newIndex([]string{"a"}, true, WithMaxKeyLen(40, true))
+	long := strings.Repeat("x", 60)
+	entries = []IndexEntry{
+		{Values: types.MakeDatums(long + "a"), Handle: 1},
+		{Values: types.MakeDatums(long + "b"), Handle: 2},
+	}
+	store = &batchGetStore{BufferStore: newTestStore()}
+	err = idx.CreateBatch(s.sctx, store, entries)
+	c.Assert(terror.ErrorEqual(err, kv.ErrKeyExists), IsTrue, Commentf("err %v", err))
+	c.Assert(err, ErrorMatches, ".*within the batch, of handles 1 and 2")
+	c.Assert(dumpKVs(c, store, idx.prefix), HasLen, 0)
+}
+
+// benchBackfillEntries returns a unique index and the entries of n rows for the backfill benchmarks.
+func benchBackfillEntries(n int) (*index, []IndexEntry) {
+	tblInfo := newTestTableInfo([]string{"a", "b"}, []int{0, 1}, true)
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+	entries := make([]IndexEntry, n)
+	for i := range entries {
+		entries[i] = IndexEntry{Values: types.MakeDatums(i, fmt.Sprintf("value%d", i)), Handle: int64(i)}
+	}
+	return idx, entries
+}
+
+func BenchmarkBackfillCreateLoop(b *testing.B) {
+	sctx := mock.NewContext()
+	idx, entries := benchBackfillEntries(256)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		store := &batchGetStore{BufferStore: newTestStore()}
+		for _, e := range entries {
+			if _, err := idx.Create(sctx, store, e.Values, e.Handle); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkBackfillCreateBatch(b *testing.B) {
+	sctx := mock.NewContext()
+	idx, entries := benchBackfillEntries(256)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		store := &batchGetStore{BufferStore: newTestStore()}
+		if err := idx.CreateBatch(sctx, store, entries); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func (s *testIndexInternalSuite) TestBatchExist(c *C) {
+	rows := [][]types.Datum{types.MakeDatums(1), types.MakeDatums(2), types.MakeDatums(3), types.MakeDatums(nil)}
+	handles := []int64{1, 20, 3, 4}
+	for _, unique := range []bool{true, false} {
+		tblInfo := newTestTableInfo([]string{"a"}, []int{0}, unique)
+		idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+		store := &batchGetStore{BufferStore: newTestStore()}
+		for _, h := range []int64{1, 2, 4} {
+			vals := types.MakeDatums(h)
+			if h == 4 {
+				vals = types.MakeDatums(nil)
+			}
+			_, err := idx.Create(s.sctx, store.BufferStore, vals, h)
+			c.Assert(err, IsNil)
+		}
+
+		results, err := idx.BatchExist(s.sc, store, rows, handles)
+		c.Assert(err, IsNil)
+		c.Assert(store.batchGets, Equals, 1)
+		c.Assert(store.gets, Equals, 0)
+		// The entry of 2 points to handle 2 instead of 20, which only conflicts on a unique index.
+		expected := []ExistResult{{Exists: true, Handle: 1}, {}, {}, {Exists: true, Handle: 4}}
+		if unique {
+			expected[1] = ExistResult{Exists: true, Handle: 2, Conflict: true}
+		}
+		c.Assert(results, DeepEquals, expected)
+		for i := range rows {
+			exist, h, err := idx.Exist(s.sc, store.BufferStore, rows[i], handles[i])
+			c.Assert(exist, Equals, expected[i].Exists)
+			c.Assert(err != nil, Equals, expected[i].Conflict)
+			if exist {
+				c.Assert(h, Equals, expected[i].Handle)
+			}
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/mock"
+)
+
+func (s *testIndexInternalSuite) TestCompactHandles(c *C) {
+	handles := []int64{math.MinInt64, -1 << 40, -300, -1, 0, 1, 49, 127, 1 << 20, 1 << 40, math.MaxInt64}
+	var prev []byte
+	for i, h := range handles {
+		data := EncodeHandleCompact(h)
+		c.Assert(len(data) <= 9, IsTrue)
+		decoded, err := DecodeHandleCompact(data)
+		c.Assert(err, IsNil)
+		c.Assert(decoded, Equals, h)
+		if i > 0 {
+			c.Assert(bytes.Compare(prev, data), Less, 0, Commentf("%d", h))
+		}
+		prev = data
+	}
+	c.Assert(EncodeHandleCompact(1), HasLen, 1)
+	_, err := DecodeHandleCompact(append(EncodeHandleCompact(1), 0))
+	c.Assert(err, NotNil)
+
+	for _, unique := range []bool{true, false} {
+		tblInfo := newTestTableInfo([]string{"a"}, []int{0}, unique)
+		idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], WithCompactHandles()).(*index)
+		buf := newTestStore()
+		// The handles are created in reverse order, and the NULL entries are ordered by handle.
+		for i := len(handles) - 1; i >= 0; i-- {
+			_, err = idx.Create(s.sctx, buf, types.MakeDatums(i), handles[i])
+			c.Assert(err, IsNil)
+			_, err = idx.Create(s.sctx, buf, []types.Datum{{}}, handles[i])
+			c.Assert(err, IsNil)
+		}
+		it, err := idx.SeekFirst(buf)
+		c.Assert(err, IsNil)
+		for _, h := range handles {
+			vals, handle, err := it.Next()
+			c.Assert(err, IsNil)
+			c.Assert(vals[0].IsNull(), IsTrue)
+			c.Assert(handle, Equals, h)
+		}
+		for i, h := range handles {
+			vals, handle, err := it.Next()
+			c.Assert(err, IsNil)
+			c.Assert(vals[0].GetInt64(), Equals, int64(i))
+			c.Assert(handle, Equals, h)
+		}
+		it.Close()
+
+		// A 1-byte handle encoding the untouched flag isn't read as an untouched entry.
+		ok, handle, err := idx.Exist(s.sc, buf, types.MakeDatums(6), handles[6])
+		c.Assert(err, IsNil)
+		c.Assert(ok, IsTrue)
+		c.Assert(handle, Equals, handles[6])
+		if unique {
+			handle, err = idx.Create(s.sctx, buf, types.MakeDatums(6), 7)
+			c.Assert(kv.ErrKeyExists.Equal(err), IsTrue)
+			c.Assert(handle, Equals, handles[6])
+		}
+		c.Assert(idx.Delete(s.sc, buf, types.MakeDatums(6), handles[6]), IsNil)
+		ok, _, err = idx.Exist(s.sc, buf, types.MakeDatums(6), handles[6])
+		c.Assert(err, IsNil)
+		c.Assert(ok, IsFalse)
+	}
+
+	// An entry in the other format is rejected.
+	tblInfo := newTestTableInfo([]string{"a"}, []int{0}, true)
+	buf := newTestStore()
+	_, err = NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).Create(s.sctx, buf, types.MakeDatums(1), 1)
+	c.Assert(err, IsNil)
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], WithCompactHandles()).(*index)
+	_, _, err = idx.Exist(s.sc, buf, types.MakeDatums(1), 1)
+	c.Assert(table.ErrIndexFormatMismatch.Equal(err), IsTrue)
+
+	idx = NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], WithCompactHandles(), WithInsertionSequence(func() int64 { return 1 })).(*index)
+	_, _, err = idx.GenIndexKey(s.sc, types.MakeDatums(1), 1, nil)
+	c.Assert(err, NotNil)
+}
+
+// benchmarkHandleSpace reports the bytes of the keys and the values of the entries of 256 rows with
+// small handles, with the options of the index.
+func benchmarkHandleSpace(b *testing.B, unique bool, opts ...IndexOption) {
+	tblInfo := newTestTableInfo([]string{"a"}, []int{0}, unique)
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], opts...).(*index)
+	sctx := mock.NewContext()
+	b.ReportAllocs()
+	b.ResetTimer()
+	var size int
+	for i := 0; i < b.N; i++ {
+		buf := kv.NewMemDbBuffer(4096)
+		for h := int64(0); h < 256; h++ {
+			if _, err := idx.Create(sctx, buf, types.MakeDatums(h), h); err != nil {
+				b.Fatal(err)
+			}
+		}
+		size = buf.Size()
+	}
+	b.ReportMetric(float64(size)/256, "bytes/entry")
+}
+
+func BenchmarkHandleSpaceUnique(b *testing.B) { benchmarkHandleSpace(b, true) }
+
+func BenchmarkHandleSpaceUniqueCompact(b *testing.B) {
+	benchmarkHandleSpace(b, true, WithCompactHandles())
+}
+
+func BenchmarkHandleSpace(b *testing.B)        { benchmarkHandleSpace(b, false) }
+func BenchmarkHandleSpaceCompact(b *testing.B) { benchmarkHandleSpace(b, false, WithCompactHandles()) }
@@ -0,0 +1,129 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"context"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/types"
+)
+
+func (s *testIndexInternalSuite) TestScanWithContext(c *C) {
+	idx := s.newIndex([]string{"a"}, false)
+	for i := 0; i < 200; i++ {
+		_, err := idx.Create(s.sctx, s.store, types.MakeDatums(i), int64(i))
+		c.Assert(err, IsNil)
+	}
+
+	expired, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	it, err := idx.SeekFirstWithContext(expired, s.store)
+	c.Assert(err, IsNil)
+	_, _, err = it.Next()
+	c.Assert(errors.Cause(err), Equals, context.DeadlineExceeded)
+	it.Close()
+
+	// The context is checked periodically, a scan cancelled midway stops within the interval.
+	ctx, cancelScan := context.WithCancel(context.Background())
+	defer cancelScan()
+	it, _, err = idx.SeekWithContext(ctx, s.sc, s.store, types.MakeDatums(10))
+	c.Assert(err, IsNil)
+	defer it.Close()
+	cnt := 0
+	for {
+		_, _, err = it.Next()
+		if err != nil {
+			break
+		}
+		cnt++
+		if cnt == 5 {
+			cancelScan()
+		}
+	}
+	c.Assert(errors.Cause(err), Equals, context.Canceled)
+	c.Assert(cnt, Equals, ctxCheckInterval)
+}
+
+// openIterStore is a store counting its iterators which aren't closed yet.
+type openIterStore struct {
+	*kv.BufferStore
+	open int
+}
+
+type closeCountingIter struct {
+	kv.Iterator
+	s *openIterStore
+}
+
+func (it *closeCountingIter) Close() {
+	it.s.open--
+	it.Iterator.Close()
+}
+
+func (s *openIterStore) Iter(k kv.Key, upperBound kv.Key) (kv.Iterator, error) {
+	it, err := s.BufferStore.Iter(k, upperBound)
+	if err != nil {
+		return nil, err
+	}
+	s.open++
+	return &closeCountingIter{Iterator: it, s: s}, nil
+}
+
+func (s *testIndexInternalSuite) TestCancelScanAndDrop(c *C) {
+	idx := s.newIndex([]string{"a"}, false)
+	store := &openIterStore{BufferStore: s.store}
+	for i := 0; i < 200; i++ {
+		_, err := idx.Create(s.sctx, store, types.MakeDatums(i), int64(i))
+		c.Assert(err, IsNil)
+	}
+
+	// A cancelled scan releases its KV iterator before it's closed.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	it, err := idx.SeekFirstWithContext(ctx, store)
+	c.Assert(err, IsNil)
+	c.Assert(store.open, Equals, 1)
+	cnt := 0
+	for ; err == nil; cnt++ {
+		if cnt == 10 {
+			cancel()
+		}
+		_, _, err = it.Next()
+	}
+	c.Assert(errors.Cause(err), Equals, context.Canceled)
+	c.Assert(cnt <= ctxCheckInterval+1, IsTrue, Commentf("cnt %d", cnt))
+	c.Assert(store.open, Equals, 0)
+	_, _, err = it.Next()
+	c.Assert(errors.Cause(err), Equals, context.Canceled)
+	it.Close()
+	c.Assert(store.open, Equals, 0)
+
+	// A cancelled drop stops at once and can be resumed from next.
+	next, done, err := idx.Drop(store, table.WithDropCtx(ctx))
+	c.Assert(errors.Cause(err), Equals, context.Canceled)
+	c.Assert(done, IsFalse)
+	c.Assert(store.open, Equals, 0)
+	c.Assert(dumpKVs(c, store, idx.prefix), HasLen, 200)
+	next, done, err = idx.Drop(store, table.WithDropStartKey(next), table.WithDropCtx(context.Background()))
+	c.Assert(err, IsNil)
+	c.Assert(done, IsTrue)
+	c.Assert(next, IsNil)
+	c.Assert(store.open, Equals, 0)
+	c.Assert(dumpKVs(c, store, idx.prefix), HasLen, 0)
+}
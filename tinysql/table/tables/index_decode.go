@@ -0,0 +1,192 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tidb/types"
+)
+
+// decodeTask is an undecoded index entry sent from the reader to the decoders.
+type decodeTask struct {
+	key   []byte
+	value []byte
+
+	row IndexRow
+	err error
+	// done is closed when the task is decoded, it's only used to keep order.
+	done chan struct{}
+}
+
+// DecodeParallel scans the whole index and calls fn for every decoded row.
+// A reader goroutine pulls the raw entries via IterRaw and fans them out to concurrency
+// decoder goroutines. If keepOrder is true, fn sees the rows in index order and the number of
+// rows decoded ahead of fn is bounded; otherwise the rows are passed to fn as soon as they're decoded.
+// fn is always called from the calling goroutine, an error returned by fn stops the scan.
+func (c *index) DecodeParallel(ctx context.Context, r kv.Retriever, concurrency int, keepOrder bool, fn func(row IndexRow) error) error {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	taskCh := make(chan *decodeTask, concurrency)
+	resultCh := make(chan *decodeTask, concurrency)
+	var readErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if keepOrder {
+			defer close(resultCh)
+		}
+		defer close(taskCh)
+		readErr = c.readDecodeTasks(ctx, r, taskCh, resultCh, keepOrder)
+	}()
+
+	var workerWg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		workerWg.Add(1)
+		go func() {
+			defer workerWg.Done()
+			c.runDecodeWorker(ctx, taskCh, resultCh, keepOrder)
+		}()
+	}
+	if !keepOrder {
+		go func() {
+			workerWg.Wait()
+			close(resultCh)
+		}()
+	}
+
+	var err error
+	for task := range resultCh {
+		if keepOrder {
+			select {
+			case <-task.done:
+			case <-ctx.Done():
+			}
+		}
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err == nil {
+			err = task.err
+		}
+		if err == nil {
+			err = fn(task.row)
+		}
+		if err != nil {
+			// Stop the reader and the decoders, then drain resultCh so they can exit.
+			cancel()
+		}
+	}
+	wg.Wait()
+	workerWg.Wait()
+	if err == nil {
+		err = readErr
+	}
+	return errors.Trace(err)
+}
+
+// readDecodeTasks sends the raw index entries to taskCh. If keepOrder is true, the tasks are also
+// sent to resultCh in index order, where the consumer waits for them to be decoded.
+func (c *index) readDecodeTasks(ctx context.Context, r kv.Retriever, taskCh, resultCh chan<- *decodeTask, keepOrder bool) error {
+	it, err := c.IterRaw(r)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	for it.Valid() && it.Key().HasPrefix(c.scanPrefix) {
+		task := &decodeTask{
+			key:   append([]byte(nil), it.Key()...),
+			value: append([]byte(nil), it.Value()...),
+		}
+		if keepOrder {
+			task.done = make(chan struct{})
+			select {
+			case resultCh <- task:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		select {
+		case taskCh <- task:
+		case <-ctx.Done():
+			return nil
+		}
+		if err = it.Next(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runDecodeWorker decodes the tasks from taskCh until it's closed or ctx is done.
+func (c *index) runDecodeWorker(ctx context.Context, taskCh <-chan *decodeTask, resultCh chan<- *decodeTask, keepOrder bool) {
+	for task := range taskCh {
+		task.row.Values, task.row.Handle, task.err = c.decodeEntry(task.key, task.value)
+		if keepOrder {
+			close(task.done)
+			continue
+		}
+		select {
+		case resultCh <- task:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// DecodeIndexKeyValue decodes the indexed values and the handle from a raw KV pair of the index idxInfo
+// of the table tblInfo, the inverse of GenIndexKey, e.g. for the tools scanning the raw KV pairs.
+// Both the distinct entries, which store the handle in the value, and the others, which store it in the key,
+// are decoded. The values of a prefix column are the truncated ones the key stores. opts are the IndexOptions
+// the index is built with, e.g. WithCollations, which make it store the original values in the values.
+func DecodeIndexKeyValue(tblInfo *model.TableInfo, idxInfo *model.IndexInfo, key, value []byte, opts ...IndexOption) (indexedValues []types.Datum, h int64, err error) {
+	c := NewIndex(tblInfo.ID, tblInfo, idxInfo, opts...).(*index)
+	if !kv.Key(key).HasPrefix(c.prefix) {
+		return nil, 0, errors.Errorf("key %x isn't a key of index %s of table %d, whose prefix is %x", key, idxInfo.Name, tblInfo.ID, []byte(c.prefix))
+	}
+	return c.decodeEntry(key, value)
+}
+
+// DecodeKeyToMap decodes the raw KV pair of an entry like DecodeIndexKeyValue, but returns the indexed values
+// keyed by the index column names, e.g. for a debugging tool printing an entry. The handle is returned apart,
+// whether it's stored in the key or in the value.
+func (c *index) DecodeKeyToMap(key, value []byte) (map[string]types.Datum, int64, error) {
+	if !kv.Key(key).HasPrefix(c.prefix) {
+		return nil, 0, errors.Errorf("key %x isn't a key of index %s, whose prefix is %x", key, c.idxInfo.Name, []byte(c.prefix))
+	}
+	if c.isCommonHandle() {
+		return nil, 0, errors.Errorf("index %s of a table with common handles has no int handle", c.idxInfo.Name)
+	}
+	vals, h, err := c.decodeEntry(key, value)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(vals) != len(c.idxInfo.Columns) {
+		return nil, 0, errors.Errorf("index %s has %d columns but the entry has %d values", c.idxInfo.Name, len(c.idxInfo.Columns), len(vals))
+	}
+	m := make(map[string]types.Datum, len(vals))
+	for i, v := range vals {
+		m[c.idxInfo.Columns[i].Name.O] = v
+	}
+	return m, h, nil
+}
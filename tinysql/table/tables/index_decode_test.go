@@ -0,0 +1,140 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"context"
+	"io"
+	"sort"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/parser/terror"
+	"github.com/pingcap/tidb/types"
+)
+
+func (s *testIndexInternalSuite) TestDecodeParallel(c *C) {
+	idx := s.newIndex([]string{"a", "b"}, false)
+	for i := 0; i < 100; i++ {
+		_, err := idx.Create(s.sctx, s.store, types.MakeDatums(i%7, "v"), int64(i))
+		c.Assert(err, IsNil)
+	}
+
+	var expected []IndexRow
+	it, err := idx.SeekFirst(s.store)
+	c.Assert(err, IsNil)
+	for {
+		vals, h, err := it.Next()
+		if terror.ErrorEqual(err, io.EOF) {
+			break
+		}
+		c.Assert(err, IsNil)
+		expected = append(expected, IndexRow{Values: vals, Handle: h})
+	}
+	it.Close()
+	c.Assert(expected, HasLen, 100)
+
+	var ordered []IndexRow
+	err = idx.DecodeParallel(context.Background(), s.store, 4, true, func(row IndexRow) error {
+		ordered = append(ordered, row)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(ordered, DeepEquals, expected)
+
+	var unordered []IndexRow
+	err = idx.DecodeParallel(context.Background(), s.store, 4, false, func(row IndexRow) error {
+		unordered = append(unordered, row)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	sort.Slice(unordered, func(i, j int) bool {
+		return unordered[i].Values[0].GetInt64() < unordered[j].Values[0].GetInt64() ||
+			(unordered[i].Values[0].GetInt64() == unordered[j].Values[0].GetInt64() && unordered[i].Handle < unordered[j].Handle)
+	})
+	c.Assert(unordered, DeepEquals, expected)
+
+	// An error returned by fn stops the scan.
+	cnt := 0
+	stopErr := errors.New("stop")
+	err = idx.DecodeParallel(context.Background(), s.store, 4, false, func(row IndexRow) error {
+		cnt++
+		return stopErr
+	})
+	c.Assert(errors.Cause(err), Equals, stopErr)
+	c.Assert(cnt, Equals, 1)
+}
+
+func (s *testIndexInternalSuite) TestDecodeIndexKeyValue(c *C) {
+	for _, t := range []struct {
+		unique    bool
+		prefixLen int
+		vals      []interface{}
+		expected  string
+	}{
+		{true, types.UnspecifiedLength, []interface{}{1, "abcdef"}, "1,abcdef"},
+		{true, types.UnspecifiedLength, []interface{}{1, nil}, "1,NULL"},
+		{false, types.UnspecifiedLength, []interface{}{1, "abcdef"}, "1,abcdef"},
+		{true, 3, []interface{}{1, "abcdef"}, "1,abc"},
+		{false, 3, []interface{}{1, "abcdef"}, "1,abc"},
+	} {
+		tblInfo := newTestTableInfo([]string{"a", "b"}, []int{0, 1}, t.unique)
+		tblInfo.Indices[0].Columns[1].Length = t.prefixLen
+		idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+		key, distinct, err := idx.GenIndexKey(s.sc, types.MakeDatums(t.vals...), 7, nil)
+		c.Assert(err, IsNil)
+		value := []byte{'0'}
+		if distinct {
+			value = EncodeHandle(7)
+		}
+		vals, h, err := DecodeIndexKeyValue(tblInfo, tblInfo.Indices[0], key, value)
+		c.Assert(err, IsNil)
+		c.Assert(datumsString(c, vals), Equals, t.expected)
+		c.Assert(h, Equals, int64(7))
+
+		other := newTestTableInfo([]string{"a", "b"}, []int{0, 1}, t.unique)
+		other.ID = 2
+		_, _, err = DecodeIndexKeyValue(other, other.Indices[0], key, value)
+		c.Assert(err, ErrorMatches, ".*isn't a key of index test of table 2.*")
+	}
+}
+
+func (s *testIndexInternalSuite) TestDecodeKeyToMap(c *C) {
+	for _, unique := range []bool{true, false} {
+		idx := s.newIndex([]string{"a", "b", "c"}, unique)
+		for _, vals := range [][]interface{}{{1, "x", 2.5}, {1, nil, 2.5}} {
+			_, err := idx.Create(s.sctx, s.store, types.MakeDatums(vals...), 42)
+			c.Assert(err, IsNil)
+			key, _, err := idx.GenIndexKey(s.sc, types.MakeDatums(vals...), 42, nil)
+			c.Assert(err, IsNil)
+			value, err := s.store.Get(context.TODO(), key)
+			c.Assert(err, IsNil)
+			m, h, err := idx.DecodeKeyToMap(key, value)
+			c.Assert(err, IsNil)
+			c.Assert(h, Equals, int64(42))
+			c.Assert(m, HasLen, 3)
+			expected := types.MakeDatums(vals...)
+			for i, name := range []string{"a", "b", "c"} {
+				d, ok := m[name]
+				c.Assert(ok, IsTrue)
+				cmp, err := d.CompareDatum(s.sc, &expected[i])
+				c.Assert(err, IsNil)
+				c.Assert(cmp, Equals, 0, Commentf("column %s", name))
+			}
+		}
+	}
+	idx := s.newIndex([]string{"a"}, true)
+	_, _, err := idx.DecodeKeyToMap([]byte("x"), nil)
+	c.Assert(err, ErrorMatches, ".*isn't a key of index.*")
+}
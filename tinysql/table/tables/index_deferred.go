@@ -0,0 +1,184 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/sessionctx"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/types"
+)
+
+// WithDeferredUnique returns an IndexOption which defers the unique checks of the index to the commit, like a
+// SQL constraint DEFERRABLE INITIALLY DEFERRED: Create doesn't fail when the key of a distinct entry already
+// exists, but points it to the new handle and records the handles claiming the key in the session, and
+// CheckDeferredConstraints fails if a key is still claimed by more than one handle. So a statement swapping
+// the values of two rows succeeds. Delete needs the session, passed by table.WithDeleteSessionCtx, as the
+// delete of a row whose key is claimed by another handle only drops the claim. The untouched entries and the
+// writes which skip the checks aren't recorded. An index which keeps the insertion order isn't supported.
+func WithDeferredUnique() IndexOption {
+	return func(c *index) {
+		c.deferUnique = true
+	}
+}
+
+type deferredCtxKeyType int
+
+func (k deferredCtxKeyType) String() string {
+	return "deferred_unique_checks"
+}
+
+// deferredCtxKey is the session value key of the pending deferred unique checks.
+const deferredCtxKey deferredCtxKeyType = 0
+
+// deferredCheck is a pending unique check of a key of a deferred index, which is claimed by handles.
+type deferredCheck struct {
+	idx     *index
+	key     kv.Key
+	vals    []types.Datum
+	handles []int64
+}
+
+// pendingChecks returns the pending deferred checks of the session by key, creating them if create is set.
+func pendingChecks(sctx sessionctx.Context, create bool) map[string]*deferredCheck {
+	if checks, ok := sctx.Value(deferredCtxKey).(map[string]*deferredCheck); ok {
+		return checks
+	}
+	if !create {
+		return nil
+	}
+	checks := make(map[string]*deferredCheck)
+	sctx.SetValue(deferredCtxKey, checks)
+	return checks
+}
+
+// createDeferred is the write of the distinct entry of key of a deferred index, see WithDeferredUnique.
+func (c *index) createDeferred(sctx sessionctx.Context, rm kv.RetrieverMutator, key kv.Key, indexedValues []types.Datum, h int64, hint string) (int64, error) {
+	value, err := c.get(context.TODO(), rm, key)
+	if err != nil && !kv.IsErrNotFound(err) {
+		return 0, err
+	}
+	checks := pendingChecks(sctx, err == nil)
+	check := checks[string(key)]
+	if err == nil {
+		handle, err := c.decodeHandleValue(value)
+		if err != nil {
+			return 0, err
+		}
+		if handle == h {
+			return handle, table.NewDupKeyError(c.idxInfo.Name.O, handle, indexedValues)
+		}
+		if check == nil {
+			// The pending check is keyed by the key, so the writes of the same key share it.
+			check = &deferredCheck{idx: c, key: append(kv.Key(nil), key...), handles: []int64{handle}}
+			checks[string(key)] = check
+		}
+	}
+	if check != nil {
+		check.vals = append(check.vals[:0], indexedValues...)
+		check.handles = append(check.handles, h)
+	}
+	return 0, rm.Set(key, c.stampValue(c.encodeHandleValue(h), hint))
+}
+
+// deleteDeferred deletes the distinct entry of key with handle h of a deferred index. If the key has a pending
+// check, the claim of h is dropped, and the entry is kept or pointed to the last remaining claim.
+// It returns false if the key has no pending check, then the entry is deleted as usual.
+func (c *index) deleteDeferred(sctx sessionctx.Context, m kv.Mutator, key kv.Key, h int64) (bool, error) {
+	if sctx == nil {
+		return false, errors.Errorf("index %s with deferred unique checks needs the session to delete an entry", c.idxInfo.Name)
+	}
+	check := pendingChecks(sctx, false)[string(key)]
+	if check == nil {
+		return false, nil
+	}
+	for i, handle := range check.handles {
+		if handle == h {
+			check.handles = append(check.handles[:i], check.handles[i+1:]...)
+			break
+		}
+	}
+	r, ok := m.(kv.Retriever)
+	if !ok {
+		return true, errors.New("index with deferred unique checks requires a kv.Retriever to delete an entry")
+	}
+	value, err := c.get(context.TODO(), r, key)
+	if kv.IsErrNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return true, err
+	}
+	handle, err := c.decodeHandleValue(value)
+	if err != nil || handle != h {
+		return true, err
+	}
+	if len(check.handles) == 0 {
+		return true, m.Delete(key)
+	}
+	return true, m.Set(key, c.stampValue(c.encodeHandleValue(check.handles[len(check.handles)-1]), ""))
+}
+
+// CheckDeferredConstraints validates the pending unique checks of the deferred indexes written by the session,
+// see WithDeferredUnique, against the final state of its transaction, e.g. before the commit. It returns the
+// ErrKeyExists of the first key, in key order, claimed by more than one handle. The pending checks are
+// cleared either way, so a failed transaction should be rolled back. The session calls it before the commit.
+func CheckDeferredConstraints(sctx sessionctx.Context) error {
+	checks := pendingChecks(sctx, false)
+	if len(checks) == 0 {
+		return nil
+	}
+	sctx.ClearValue(deferredCtxKey)
+	keys := make([]string, 0, len(checks))
+	for key := range checks {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	txn, err := sctx.Txn(true)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		check := checks[key]
+		if len(check.handles) > 1 {
+			return table.NewDupKeyError(check.idx.idxInfo.Name.O, check.handles[0], check.vals)
+		}
+		value, err := check.idx.get(context.TODO(), txn, check.key)
+		if kv.IsErrNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		handle, err := check.idx.decodeHandleValue(value)
+		if err != nil {
+			return err
+		}
+		// The entry is rewritten by a write which isn't recorded, e.g. one skipping the checks.
+		if len(check.handles) == 0 || handle != check.handles[0] {
+			return table.NewDupKeyError(check.idx.idxInfo.Name.O, handle, check.vals)
+		}
+	}
+	return nil
+}
+
+// DiscardDeferredConstraints drops the pending unique checks of the deferred indexes written by the session,
+// e.g. when its transaction is rolled back, so they aren't validated with the next transaction.
+func DiscardDeferredConstraints(sctx sessionctx.Context) {
+	sctx.ClearValue(deferredCtxKey)
+}
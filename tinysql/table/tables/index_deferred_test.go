@@ -0,0 +1,98 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/parser/terror"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/types"
+)
+
+func (s *testIndexInternalSuite) TestDeferredUnique(c *C) {
+	s.sctx.Store = &txnStore{txn: &bufferTxn{buf: s.store}}
+	c.Assert(s.sctx.NewTxn(context.Background()), IsNil)
+	tblInfo := newTestTableInfo([]string{"u"}, []int{0}, true)
+	newIndex := func(opts ...IndexOption) *index {
+		s.store.Reset()
+		idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], opts...).(*index)
+		for h := int64(1); h <= 3; h++ {
+			_, err := idx.Create(s.sctx, s.store, types.MakeDatums(h), h)
+			c.Assert(err, IsNil)
+		}
+		return idx
+	}
+	// update sets u of the row h from old to new, as UPDATE does.
+	update := func(idx *index, h, old, new int64) error {
+		err := idx.Delete(s.sc, s.store, types.MakeDatums(old), h, table.WithDeleteSessionCtx(s.sctx))
+		c.Assert(err, IsNil)
+		_, err = idx.Create(s.sctx, s.store, types.MakeDatums(new), h)
+		return err
+	}
+	handleOf := func(idx *index, u int64) int64 {
+		exist, h, err := idx.Exist(s.sc, s.store, types.MakeDatums(u), 0)
+		c.Assert(exist, IsTrue)
+		c.Assert(terror.ErrorEqual(err, kv.ErrKeyExists), IsTrue)
+		return h
+	}
+
+	// UPDATE t SET u = CASE u WHEN 1 THEN 2 WHEN 2 THEN 1 END fails midway when the checks are immediate.
+	idx := newIndex()
+	err := update(idx, 1, 1, 2)
+	c.Assert(terror.ErrorEqual(err, kv.ErrKeyExists), IsTrue, Commentf("err %v", err))
+
+	// It succeeds when they're deferred.
+	idx = newIndex(WithDeferredUnique())
+	c.Assert(update(idx, 1, 1, 2), IsNil)
+	c.Assert(update(idx, 2, 2, 1), IsNil)
+	c.Assert(CheckDeferredConstraints(s.sctx), IsNil)
+	c.Assert(handleOf(idx, 1), Equals, int64(2))
+	c.Assert(handleOf(idx, 2), Equals, int64(1))
+	c.Assert(handleOf(idx, 3), Equals, int64(3))
+	c.Assert(dumpKVs(c, s.store, idx.prefix), HasLen, 3)
+
+	// The writes of the same key coalesce into one check, which fails if the key is still claimed twice.
+	idx = newIndex(WithDeferredUnique())
+	c.Assert(update(idx, 1, 1, 3), IsNil)
+	c.Assert(update(idx, 2, 2, 3), IsNil)
+	c.Assert(pendingChecks(s.sctx, false), HasLen, 1)
+	err = CheckDeferredConstraints(s.sctx)
+	c.Assert(terror.ErrorEqual(err, kv.ErrKeyExists), IsTrue, Commentf("err %v", err))
+	c.Assert(pendingChecks(s.sctx, false), HasLen, 0)
+
+	// A claim dropped before the check points the entry back to the remaining handle.
+	idx = newIndex(WithDeferredUnique())
+	c.Assert(update(idx, 1, 1, 3), IsNil)
+	c.Assert(update(idx, 1, 3, 1), IsNil)
+	c.Assert(CheckDeferredConstraints(s.sctx), IsNil)
+	c.Assert(handleOf(idx, 1), Equals, int64(1))
+	c.Assert(handleOf(idx, 3), Equals, int64(3))
+
+	// CreateBatch defers the checks too.
+	idx = newIndex(WithDeferredUnique())
+	c.Assert(idx.CreateBatch(s.sctx, s.store, []IndexEntry{{Values: types.MakeDatums(1), Handle: 4}}), IsNil)
+	c.Assert(pendingChecks(s.sctx, false), HasLen, 1)
+	err = CheckDeferredConstraints(s.sctx)
+	c.Assert(terror.ErrorEqual(err, kv.ErrKeyExists), IsTrue, Commentf("err %v", err))
+
+	// The checks of a rolled back transaction are discarded.
+	idx = newIndex(WithDeferredUnique())
+	c.Assert(update(idx, 1, 1, 3), IsNil)
+	DiscardDeferredConstraints(s.sctx)
+	c.Assert(pendingChecks(s.sctx, false), HasLen, 0)
+	c.Assert(CheckDeferredConstraints(s.sctx), IsNil)
+}
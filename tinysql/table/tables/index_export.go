@@ -0,0 +1,156 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/kv"
+)
+
+// exportMagic starts a stream written by ExportIndex, the last byte is the version of the format.
+var exportMagic = []byte{'T', 'I', 'D', 'X', 1}
+
+// ExportIndex writes the raw entries of the index to w, so ImportIndex can replay them into another store
+// without rebuilding them from the rows. The stream is exportMagic followed by a record for each entry:
+// the uvarint lengths of the key and the value, the key, the value, and the big-endian CRC-32 (Castagnoli)
+// of the key and the value. It ends with a record of a zero-length key, so a truncated stream is detected.
+func (c *index) ExportIndex(r kv.Retriever, w io.Writer) error {
+	it, err := r.Iter(c.scanPrefix, c.scanPrefix.PrefixNext())
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	bw := bufio.NewWriter(w)
+	if _, err = bw.Write(exportMagic); err != nil {
+		return errors.Trace(err)
+	}
+	for it.Valid() && it.Key().HasPrefix(c.scanPrefix) {
+		if err = writeExportRecord(bw, it.Key(), it.Value()); err != nil {
+			return err
+		}
+		if err = it.Next(); err != nil {
+			return err
+		}
+	}
+	if err = writeExportRecord(bw, nil, nil); err != nil {
+		return err
+	}
+	return errors.Trace(bw.Flush())
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+func writeExportRecord(w *bufio.Writer, key, value []byte) error {
+	var buf [2*binary.MaxVarintLen64 + 4]byte
+	n := binary.PutUvarint(buf[:], uint64(len(key)))
+	n += binary.PutUvarint(buf[n:], uint64(len(value)))
+	if _, err := w.Write(buf[:n]); err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := w.Write(key); err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := w.Write(value); err != nil {
+		return errors.Trace(err)
+	}
+	sum := crc32.Update(crc32.Checksum(key, castagnoli), castagnoli, value)
+	binary.BigEndian.PutUint32(buf[:4], sum)
+	_, err := w.Write(buf[:4])
+	return errors.Trace(err)
+}
+
+// ImportIndex replays the entries written by ExportIndex into rm. Every key must belong to the index,
+// so the stream must be exported by an index with the same prefix. A corrupt or truncated stream fails
+// after the records before it are written, so rm should be a transaction which is rolled back on an error.
+func (c *index) ImportIndex(rm kv.RetrieverMutator, rd io.Reader) error {
+	br := bufio.NewReader(rd)
+	magic := make([]byte, len(exportMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return errors.Annotate(noEOF(err), "read export header")
+	}
+	if string(magic) != string(exportMagic) {
+		return errors.Errorf("index %s can't import a stream with header %x", c.idxInfo.Name, magic)
+	}
+	for n := 0; ; n++ {
+		key, value, err := readExportRecord(br)
+		if err != nil {
+			return errors.Annotatef(err, "index %s import record %d", c.idxInfo.Name, n)
+		}
+		if len(key) == 0 {
+			return nil
+		}
+		if !kv.Key(key).HasPrefix(c.scanPrefix) {
+			return errors.Errorf("index %s can't import the key %x of another index", c.idxInfo.Name, key)
+		}
+		if err = rm.Set(key, value); err != nil {
+			return err
+		}
+	}
+}
+
+func readExportRecord(br *bufio.Reader) (key, value []byte, err error) {
+	keyLen, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, nil, noEOF(err)
+	}
+	valueLen, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, nil, noEOF(err)
+	}
+	// A corrupt length must not allocate an arbitrary buffer, so the record is read as it goes.
+	var b []byte
+	if b, err = readFull(br, keyLen+valueLen+4); err != nil {
+		return nil, nil, err
+	}
+	key, value = b[:keyLen], b[keyLen:keyLen+valueLen]
+	sum := crc32.Update(crc32.Checksum(key, castagnoli), castagnoli, value)
+	if binary.BigEndian.Uint32(b[keyLen+valueLen:]) != sum {
+		return nil, nil, errors.New("checksum mismatch")
+	}
+	if keyLen == 0 && valueLen > 0 {
+		return nil, nil, errors.New("record without key")
+	}
+	return key, value, nil
+}
+
+// readFull reads n bytes from br, growing the buffer as the bytes arrive.
+func readFull(br *bufio.Reader, n uint64) ([]byte, error) {
+	const chunk = 64 << 10
+	var b []byte
+	for uint64(len(b)) < n {
+		m := n - uint64(len(b))
+		if m > chunk {
+			m = chunk
+		}
+		start := len(b)
+		b = append(b, make([]byte, m)...)
+		if _, err := io.ReadFull(br, b[start:]); err != nil {
+			return nil, noEOF(err)
+		}
+	}
+	return b, nil
+}
+
+// noEOF returns io.ErrUnexpectedEOF for io.EOF, as the stream must end with its end record.
+func noEOF(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return errors.Trace(err)
+}
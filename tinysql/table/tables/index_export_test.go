@@ -0,0 +1,65 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"bytes"
+	"io"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/types"
+)
+
+func (s *testIndexInternalSuite) TestExportImportIndex(c *C) {
+	idx := s.newIndex([]string{"a", "b"}, true)
+	for h := int64(1); h <= 20; h++ {
+		var b interface{} = h % 3
+		if h%5 == 0 {
+			// The NULL values are non-distinct entries.
+			b = nil
+		}
+		_, err := idx.Create(s.sctx, s.store, types.MakeDatums(h, b), h)
+		c.Assert(err, IsNil)
+	}
+	expected := dumpKVs(c, s.store, idx.prefix)
+	c.Assert(expected, HasLen, 20)
+
+	var buf bytes.Buffer
+	c.Assert(idx.ExportIndex(s.store, &buf), IsNil)
+	stream := buf.Bytes()
+
+	s.store = newTestStore()
+	c.Assert(idx.ImportIndex(s.store, bytes.NewReader(stream)), IsNil)
+	c.Assert(dumpKVs(c, s.store, idx.prefix), DeepEquals, expected)
+
+	// A flipped byte fails the checksum of its record.
+	corrupt := append([]byte(nil), stream...)
+	corrupt[len(exportMagic)+5] ^= 0xff
+	err := idx.ImportIndex(newTestStore(), bytes.NewReader(corrupt))
+	c.Assert(err, ErrorMatches, ".*checksum mismatch.*")
+
+	// A stream cut before its end record is truncated.
+	for _, n := range []int{2, len(stream) / 2, len(stream) - 1} {
+		err = idx.ImportIndex(newTestStore(), bytes.NewReader(stream[:n]))
+		c.Assert(errors.Cause(err), Equals, io.ErrUnexpectedEOF, Commentf("cut at %d", n))
+	}
+
+	// The entries of another index can't be imported.
+	other := s.newIndex([]string{"a"}, false)
+	other.prefix = append(other.prefix[:len(other.prefix):len(other.prefix)], 'x')
+	other.scanPrefix = other.prefix
+	err = other.ImportIndex(newTestStore(), bytes.NewReader(stream))
+	c.Assert(err, ErrorMatches, ".*of another index.*")
+}
@@ -0,0 +1,44 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/types"
+)
+
+// WithGlobal returns an IndexOption which makes a partition index global: the keys are prefixed by the
+// table ID instead of the physical ID given to NewIndex, so the indexes of all the partitions share the
+// entries and a unique index rejects a duplicate in any partition. The value of each entry also stores the
+// physical ID of the partition of the row, returned by NextWithPartition and in EntryMeta. The handles must
+// be unique across the partitions, as the non-distinct entries of the same values are told apart by the
+// handle only, and Drop removes the entries of all the partitions.
+func WithGlobal() IndexOption {
+	return func(c *index) {
+		c.partitionID = tablecodec.DecodeTableID(c.prefix)
+		prefix := tablecodec.EncodeTableIndexPrefix(c.tblInfo.ID, c.idxInfo.ID)
+		// Keep the tenant of an index scoped to a tenant.
+		c.scanPrefix = append(append(kv.Key{}, prefix...), c.scanPrefix[len(c.prefix):]...)
+		c.prefix = prefix
+		c.global = true
+	}
+}
+
+// NextWithPartition is Next also returning the physical ID of the partition of the row, see WithGlobal.
+// It's zero if the index isn't global.
+func (c *indexIter) NextWithPartition() (val []types.Datum, h int64, partitionID int64, err error) {
+	val, h, meta, err := c.NextWithMeta()
+	return val, h, meta.PartitionID, err
+}
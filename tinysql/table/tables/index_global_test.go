@@ -0,0 +1,74 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"io"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/parser/terror"
+	"github.com/pingcap/tidb/types"
+)
+
+func (s *testIndexInternalSuite) TestGlobalIndex(c *C) {
+	tblInfo := newTestTableInfo([]string{"a"}, []int{0}, true)
+	const p0, p1 = 10, 11
+
+	// A local index is only unique in its partition.
+	_, err := NewIndex(p0, tblInfo, tblInfo.Indices[0]).Create(s.sctx, s.store, types.MakeDatums(1), 1)
+	c.Assert(err, IsNil)
+	_, err = NewIndex(p1, tblInfo, tblInfo.Indices[0]).Create(s.sctx, s.store, types.MakeDatums(1), 2)
+	c.Assert(err, IsNil)
+
+	s.store = newTestStore()
+	idx0 := NewIndex(p0, tblInfo, tblInfo.Indices[0], WithGlobal()).(*index)
+	idx1 := NewIndex(p1, tblInfo, tblInfo.Indices[0], WithGlobal()).(*index)
+	c.Assert([]byte(idx0.prefix), BytesEquals, []byte(idx1.prefix))
+	_, err = idx0.Create(s.sctx, s.store, types.MakeDatums(1), 1)
+	c.Assert(err, IsNil)
+	h, err := idx1.Create(s.sctx, s.store, types.MakeDatums(1), 2)
+	c.Assert(terror.ErrorEqual(err, kv.ErrKeyExists), IsTrue, Commentf("err %v", err))
+	c.Assert(h, Equals, int64(1))
+	_, err = idx1.Create(s.sctx, s.store, types.MakeDatums(2), 2)
+	c.Assert(err, IsNil)
+	// The NULL values aren't distinct.
+	_, err = idx1.Create(s.sctx, s.store, types.MakeDatums(nil), 3)
+	c.Assert(err, IsNil)
+
+	it, err := idx0.SeekFirst(s.store)
+	c.Assert(err, IsNil)
+	defer it.Close()
+	var partitions, handles []int64
+	for {
+		_, h, pid, err := it.(*indexIter).NextWithPartition()
+		if terror.ErrorEqual(err, io.EOF) {
+			break
+		}
+		c.Assert(err, IsNil)
+		handles = append(handles, h)
+		partitions = append(partitions, pid)
+	}
+	c.Assert(handles, DeepEquals, []int64{3, 1, 2})
+	c.Assert(partitions, DeepEquals, []int64{p1, p0, p1})
+
+	exist, h, err := idx0.Exist(s.sc, s.store, types.MakeDatums(2), 2)
+	c.Assert(err, IsNil)
+	c.Assert(exist, IsTrue)
+	c.Assert(h, Equals, int64(2))
+	c.Assert(idx1.Delete(s.sc, s.store, types.MakeDatums(2), 2), IsNil)
+	exist, _, err = idx0.Exist(s.sc, s.store, types.MakeDatums(2), 2)
+	c.Assert(err, IsNil)
+	c.Assert(exist, IsFalse)
+}
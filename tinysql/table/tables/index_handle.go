@@ -0,0 +1,265 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"context"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/sessionctx"
+	"github.com/pingcap/tidb/sessionctx/stmtctx"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/types"
+)
+
+// commonHandleFlag is the first byte of the value of a distinct entry pointing to a kv.CommonHandle,
+// which tells it from the 8-byte int handle and the untouched flag.
+const commonHandleFlag byte = 0x7f
+
+// EncodeKVHandle encodes h for the value of a distinct entry. An IntHandle is encoded by EncodeHandle,
+// a CommonHandle is commonHandleFlag followed by its full encoded primary key values.
+func EncodeKVHandle(h kv.Handle) []byte {
+	if h.IsInt() {
+		return EncodeHandle(h.IntValue())
+	}
+	return append([]byte{commonHandleFlag}, h.Encoded()...)
+}
+
+// DecodeKVHandle decodes the handle encoded by EncodeKVHandle, common tells whether it's a CommonHandle.
+func DecodeKVHandle(data []byte, common bool) (kv.Handle, error) {
+	if !common {
+		h, err := DecodeHandle(data)
+		return kv.IntHandle(h), err
+	}
+	if len(data) < 2 || data[0] != commonHandleFlag {
+		return nil, errors.Errorf("invalid common handle value %x", data)
+	}
+	return kv.NewCommonHandle(append([]byte(nil), data[1:]...)), nil
+}
+
+// isCommonHandle returns whether the rows of the table are identified by kv.CommonHandle.
+func (c *index) isCommonHandle() bool {
+	return c.tblInfo.IsCommonHandle
+}
+
+// checkCommonHandle checks the index can store the kv.CommonHandle of the table.
+func (c *index) checkCommonHandle() error {
+	if c.optErr != nil {
+		return c.optErr
+	}
+	if c.seqGen != nil || c.storesOriginal() || c.compactHandles {
+		return errors.Errorf("index %s doesn't support common handles", c.idxInfo.Name)
+	}
+	return nil
+}
+
+// GenIndexKeyWithHandle is GenIndexKey for a kv.Handle. The key of a non-distinct entry of a table
+// with common handles ends with the encoded primary key values, so the entries with the same values
+// are ordered by the primary key.
+func (c *index) GenIndexKeyWithHandle(sc *stmtctx.StatementContext, indexedValues []types.Datum, h kv.Handle, buf []byte) (key []byte, distinct bool, err error) {
+	if h.IsInt() {
+		return c.GenIndexKey(sc, indexedValues, h.IntValue(), buf)
+	}
+	if err = c.checkCommonHandle(); err != nil {
+		return nil, false, err
+	}
+	distinct = c.uniqueValues(indexedValues)
+	origValues := indexedValues
+	indexedValues = TruncateIndexValuesIfNeeded(c.tblInfo, c.idxInfo, indexedValues)
+	key = c.getIndexKeyBuf(buf, len(c.prefix)+len(indexedValues)*9+len(h.Encoded()))
+	key = append(key, c.prefix...)
+	key, err = c.encodeIndexValues(sc, key, indexedValues)
+	if err != nil {
+		return nil, false, c.wrapEncodeErr(origValues, err)
+	}
+	if !distinct {
+		key = append(key, h.Encoded()...)
+	}
+	if err = c.checkTenant(key); err != nil {
+		return nil, false, err
+	}
+	return key, distinct, nil
+}
+
+// decodeEntryHandle is decodeEntryWithMeta for a kv.Handle. The handle is an IntHandle unless the table
+// has common handles.
+func (c *index) decodeEntryHandle(key, value []byte) ([]types.Datum, kv.Handle, EntryMeta, error) {
+	if !c.isCommonHandle() {
+		vv, h, meta, err := c.decodeEntryWithMeta(key, value)
+		return vv, kv.IntHandle(h), meta, err
+	}
+	value, meta, err := c.splitValue(value)
+	if err != nil {
+		return nil, nil, meta, err
+	}
+	b := key[len(c.prefix):]
+	remain := b
+	for i := range c.idxInfo.Columns {
+		if remain, err = c.cutIndexValue(remain, i); err != nil {
+			return nil, nil, meta, err
+		}
+	}
+	vv, err := c.decodeIndexValues(b[:len(b)-len(remain)])
+	if err != nil {
+		return nil, nil, meta, err
+	}
+	if len(remain) > 0 {
+		// The handle of a non-distinct entry is the rest of the key.
+		return vv, kv.NewCommonHandle(append([]byte(nil), remain...)), meta, nil
+	}
+	h, err := DecodeKVHandle(value, true)
+	return vv, h, meta, err
+}
+
+// decodeKVHandleValue decodes the kv.CommonHandle in the value of a distinct entry.
+func (c *index) decodeKVHandleValue(value []byte) (kv.Handle, error) {
+	value, _, err := c.splitValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeKVHandle(value, true)
+}
+
+// CreateWithHandle is Create for a kv.Handle. For a table with common handles, the value of a distinct
+// entry is the full encoded handle, and an existing entry's handle is returned with ErrKeyExists.
+// Untouched entries aren't supported for common handles.
+func (c *index) CreateWithHandle(sctx sessionctx.Context, rm kv.RetrieverMutator, indexedValues []types.Datum, h kv.Handle, opts ...table.CreateIdxOptFunc) (kv.Handle, error) {
+	if h.IsInt() {
+		handle, err := c.Create(sctx, rm, indexedValues, h.IntValue(), opts...)
+		return kv.IntHandle(handle), err
+	}
+	var opt table.CreateIdxOpt
+	for _, fn := range opts {
+		fn(&opt)
+	}
+	if opt.Untouched {
+		return nil, errors.Errorf("index %s doesn't support untouched entries of common handles", c.idxInfo.Name)
+	}
+	if opt.PlacementHint != "" && !c.placementHints {
+		return nil, errors.Errorf("index %s doesn't store placement hints", c.idxInfo.Name)
+	}
+	if len(opt.PlacementHint) > maxPlacementHintLen {
+		return nil, errors.Errorf("placement hint of index %s is longer than %d bytes", c.idxInfo.Name, maxPlacementHintLen)
+	}
+	if err := c.checkIncluded(opt.IncludedValues); err != nil {
+		return nil, err
+	}
+	vars := sctx.GetSessionVars()
+	key, distinct, err := c.GenIndexKeyWithHandle(vars.StmtCtx, indexedValues, h, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !distinct {
+		value, err := c.nonDistinctValue(vars.StmtCtx, opt.IncludedValues)
+		if err != nil {
+			return nil, err
+		}
+		return nil, rm.Set(key, c.stampValue(value, opt.PlacementHint))
+	}
+	value := c.stampValue(EncodeKVHandle(h), opt.PlacementHint)
+	if vars.StmtCtx.BatchCheck {
+		return nil, rm.Set(key, value)
+	}
+	existing, err := c.get(context.TODO(), rm, key)
+	if kv.IsErrNotFound(err) {
+		return nil, rm.Set(key, value)
+	}
+	if err != nil {
+		return nil, err
+	}
+	handle, err := c.decodeKVHandleValue(existing)
+	if err != nil {
+		return nil, err
+	}
+	return handle, table.NewDupKeyErrorWithHandle(c.idxInfo.Name.O, handle, indexedValues)
+}
+
+// DeleteWithHandle is Delete for a kv.Handle. With the VerifyHandle option, the handle of a unique entry
+// is compared with h by kv.Handle.Equal.
+func (c *index) DeleteWithHandle(sc *stmtctx.StatementContext, m kv.Mutator, indexedValues []types.Datum, h kv.Handle, opts ...table.DeleteIdxOptFunc) error {
+	if h.IsInt() {
+		return c.Delete(sc, m, indexedValues, h.IntValue(), opts...)
+	}
+	var opt table.DeleteIdxOpt
+	for _, fn := range opts {
+		fn(&opt)
+	}
+	if opt.OpStats != nil {
+		m = countOps(m, opt.OpStats)
+	}
+	key, distinct, err := c.GenIndexKeyWithHandle(sc, indexedValues, h, nil)
+	if err != nil {
+		return err
+	}
+	// The handle of a non-distinct entry is in the key, so only the distinct entry needs to be verified.
+	if opt.VerifyHandle && distinct {
+		r, ok := m.(kv.Retriever)
+		if !ok {
+			return errors.New("index handle verification requires a kv.Retriever")
+		}
+		value, err := c.get(context.TODO(), r, key)
+		if kv.IsErrNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		handle, err := c.decodeKVHandleValue(value)
+		if err != nil {
+			return err
+		}
+		if !handle.Equal(h) {
+			return table.ErrIndexHandleMismatch.GenWithStackByArgs(c.idxInfo.Name, handle, h)
+		}
+	}
+	if c.tombstoneNow == nil {
+		return m.Delete(key)
+	}
+	value := []byte{'0'}
+	if distinct {
+		value = EncodeKVHandle(h)
+	}
+	return c.deleteKey(m, key, value)
+}
+
+// ExistWithHandle is Exist for a kv.Handle. The handles are compared with kv.Handle.Equal.
+func (c *index) ExistWithHandle(sc *stmtctx.StatementContext, r kv.Retriever, indexedValues []types.Datum, h kv.Handle) (bool, kv.Handle, error) {
+	if h.IsInt() {
+		ok, handle, err := c.exist(sc, r, indexedValues, h.IntValue())
+		return ok, kv.IntHandle(handle), err
+	}
+	key, distinct, err := c.GenIndexKeyWithHandle(sc, indexedValues, h, nil)
+	if err != nil {
+		return false, nil, err
+	}
+	value, err := c.get(context.TODO(), r, key)
+	if kv.IsErrNotFound(err) {
+		return false, nil, nil
+	}
+	if err != nil {
+		return false, nil, err
+	}
+	if !distinct {
+		return true, h, nil
+	}
+	handle, err := c.decodeKVHandleValue(value)
+	if err != nil {
+		return false, nil, err
+	}
+	if !handle.Equal(h) {
+		return true, handle, kv.ErrKeyExists
+	}
+	return true, handle, nil
+}
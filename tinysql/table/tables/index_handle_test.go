@@ -0,0 +1,133 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"io"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/parser/terror"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/codec"
+)
+
+func (s *testIndexInternalSuite) TestCommonHandle(c *C) {
+	pkHandle := func(pk string) kv.Handle {
+		encoded, err := codec.EncodeKey(s.sc, nil, types.NewStringDatum(pk))
+		c.Assert(err, IsNil)
+		return kv.NewCommonHandle(encoded)
+	}
+	for _, common := range []bool{false, true} {
+		for _, unique := range []bool{true, false} {
+			// The table is (pk VARCHAR, a INT), clustered by pk if common is set.
+			tblInfo := newTestTableInfo([]string{"pk", "a"}, []int{1}, unique)
+			tblInfo.IsCommonHandle = common
+			idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+			handles := []kv.Handle{kv.IntHandle(3), kv.IntHandle(1), kv.IntHandle(2)}
+			if common {
+				handles = []kv.Handle{pkHandle("c"), pkHandle("a"), pkHandle("bb")}
+			}
+			buf := newTestStore()
+			for i, h := range handles {
+				_, err := idx.CreateWithHandle(s.sctx, buf, types.MakeDatums(10+i%2), h)
+				if unique && i == 2 {
+					// The values of the third row are the same as the first one's.
+					c.Assert(kv.ErrKeyExists.Equal(err), IsTrue)
+					dupErr, ok := err.(*table.DupKeyError)
+					c.Assert(ok, IsTrue, Commentf("err %v", err))
+					c.Assert(dupErr.KVHandle.Equal(handles[0]), IsTrue)
+					c.Assert(dupErr.Entry, Equals, "10")
+					c.Assert(err, ErrorMatches, ".*Duplicate entry '10' for key 'test'")
+					continue
+				}
+				c.Assert(err, IsNil)
+			}
+
+			it, err := idx.SeekFirst(buf)
+			c.Assert(err, IsNil)
+			var got []kv.Handle
+			for {
+				vals, h, err := it.Next()
+				if terror.ErrorEqual(err, io.EOF) {
+					break
+				}
+				c.Assert(err, IsNil)
+				handle := it.(*indexIter).Handle()
+				c.Assert(handle.IsInt(), Equals, !common)
+				if !common {
+					c.Assert(handle.IntValue(), Equals, h)
+				}
+				exist, existing, err := idx.ExistWithHandle(s.sc, buf, vals, handle)
+				c.Assert(err, IsNil)
+				c.Assert(exist, IsTrue)
+				c.Assert(existing.Equal(handle), IsTrue)
+				got = append(got, handle)
+			}
+			it.Close()
+			expected := []kv.Handle{handles[2], handles[0], handles[1]}
+			if unique {
+				expected = []kv.Handle{handles[0], handles[1]}
+			}
+			c.Assert(got, HasLen, len(expected))
+			for i := range expected {
+				c.Assert(got[i].Equal(expected[i]), IsTrue, Commentf("common %v, unique %v, entry %d", common, unique, i))
+			}
+
+			if unique {
+				exist, existing, err := idx.ExistWithHandle(s.sc, buf, types.MakeDatums(10), handles[2])
+				c.Assert(kv.ErrKeyExists.Equal(err), IsTrue)
+				c.Assert(exist, IsTrue)
+				c.Assert(existing.Equal(handles[0]), IsTrue)
+			}
+			c.Assert(idx.DeleteWithHandle(s.sc, buf, types.MakeDatums(10), handles[0]), IsNil)
+			exist, _, err := idx.ExistWithHandle(s.sc, buf, types.MakeDatums(10), handles[0])
+			c.Assert(err, IsNil)
+			c.Assert(exist, IsFalse)
+		}
+	}
+}
+
+func (s *testIndexInternalSuite) TestDeleteWithHandleVerify(c *C) {
+	tblInfo := newTestTableInfo([]string{"pk", "a"}, []int{1}, true)
+	tblInfo.IsCommonHandle = true
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+	pkHandle := func(pk string) kv.Handle {
+		encoded, err := codec.EncodeKey(s.sc, nil, types.NewStringDatum(pk))
+		c.Assert(err, IsNil)
+		return kv.NewCommonHandle(encoded)
+	}
+	_, err := idx.CreateWithHandle(s.sctx, s.store, types.MakeDatums(1), pkHandle("a"))
+	c.Assert(err, IsNil)
+
+	// The entry points to "a", deleting it for "b" fails.
+	err = idx.DeleteWithHandle(s.sc, s.store, types.MakeDatums(1), pkHandle("b"), table.VerifyHandle)
+	c.Assert(terror.ErrorEqual(err, table.ErrIndexHandleMismatch), IsTrue, Commentf("err %v", err))
+	exist, _, err := idx.ExistWithHandle(s.sc, s.store, types.MakeDatums(1), pkHandle("a"))
+	c.Assert(err, IsNil)
+	c.Assert(exist, IsTrue)
+
+	var stats table.KVOpStats
+	err = idx.DeleteWithHandle(s.sc, s.store, types.MakeDatums(1), pkHandle("a"), table.VerifyHandle, table.WithDeleteOpStats(&stats))
+	c.Assert(err, IsNil)
+	c.Assert(stats, Equals, table.KVOpStats{Gets: 1, Deletes: 1})
+	exist, _, err = idx.ExistWithHandle(s.sc, s.store, types.MakeDatums(1), pkHandle("a"))
+	c.Assert(err, IsNil)
+	c.Assert(exist, IsFalse)
+
+	// Deleting a missing entry is a no-op.
+	err = idx.DeleteWithHandle(s.sc, s.store, types.MakeDatums(1), pkHandle("a"), table.VerifyHandle)
+	c.Assert(err, IsNil)
+}
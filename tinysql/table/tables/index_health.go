@@ -0,0 +1,295 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/parser/terror"
+	"github.com/pingcap/tidb/sessionctx"
+	"github.com/pingcap/tidb/sessionctx/stmtctx"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/codec"
+)
+
+// RowFetcher reads the rows of the table of an index for HealthReport.
+type RowFetcher interface {
+	// FetchRow returns the row of handle h, and false if there's no such row.
+	FetchRow(h int64) ([]types.Datum, bool, error)
+	// NextRow returns the rows of the table with their handles one by one, and false when there're no more rows.
+	NextRow() ([]types.Datum, int64, bool, error)
+}
+
+// IndexHealth is the health report of an index.
+type IndexHealth struct {
+	// Entries is the number of index entries.
+	Entries int
+	// Orphans are the handles of the entries whose rows don't exist or have other values.
+	Orphans []int64
+	// Missing are the handles of the rows which have no entries.
+	Missing []int64
+	// MinKeyLen, MaxKeyLen and MeanKeyLen are the key length stats, see KeyLengthStats.
+	MinKeyLen, MaxKeyLen, MeanKeyLen int
+	// KeyLenHistogram maps a key length to the number of keys.
+	KeyLenHistogram map[int]int
+	// ApproxSize is the total size of the keys and the values in bytes.
+	ApproxSize int64
+}
+
+// Healthy returns whether the index has neither orphan nor missing entries.
+func (h *IndexHealth) Healthy() bool {
+	return len(h.Orphans) == 0 && len(h.Missing) == 0
+}
+
+// HealthReport checks the index against its table and reports its health: the orphan entries, the
+// rows without entries, the key length stats and the approximate size. It makes one pass over the index
+// and one over the table rows, nothing is held in memory but the report, and it stops when ctx is done.
+func (c *index) HealthReport(ctx context.Context, sc *stmtctx.StatementContext, r kv.Retriever, rows RowFetcher) (*IndexHealth, error) {
+	report := &IndexHealth{KeyLenHistogram: make(map[int]int)}
+	if err := c.scanHealth(ctx, sc, r, rows, report); err != nil {
+		return nil, err
+	}
+	var vals []types.Datum
+	for count := 0; ; count++ {
+		if count%ctxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+		row, h, ok, err := rows.NextRow()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return report, nil
+		}
+		if vals, err = c.FetchValues(row, vals); err != nil {
+			return nil, err
+		}
+		exist, _, err := c.exist(sc, r, vals, h)
+		if err != nil && !terror.ErrorEqual(err, kv.ErrKeyExists) {
+			return nil, err
+		}
+		if !exist || err != nil {
+			report.Missing = append(report.Missing, h)
+		}
+	}
+}
+
+// scanHealth scans the index for the entry count, the key length stats, the size and the orphans.
+func (c *index) scanHealth(ctx context.Context, sc *stmtctx.StatementContext, r kv.Retriever, rows RowFetcher, report *IndexHealth) error {
+	it, err := c.IterRaw(r)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	total := 0
+	var vals []types.Datum
+	for ; it.Valid() && it.Key().HasPrefix(c.scanPrefix); report.Entries++ {
+		if report.Entries%ctxCheckInterval == 0 {
+			if err = ctx.Err(); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		l := len(it.Key())
+		if report.Entries == 0 || l < report.MinKeyLen {
+			report.MinKeyLen = l
+		}
+		if l > report.MaxKeyLen {
+			report.MaxKeyLen = l
+		}
+		report.KeyLenHistogram[l]++
+		total += l
+		report.ApproxSize += int64(l + len(it.Value()))
+
+		entryVals, h, err := c.decodeEntry(it.Key(), it.Value())
+		if err != nil {
+			return err
+		}
+		row, ok, err := rows.FetchRow(h)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if vals, err = c.FetchValues(row, vals); err != nil {
+				return err
+			}
+			ok, err = c.sameIndexedValues(sc, entryVals, vals)
+			if err != nil {
+				return err
+			}
+		}
+		if !ok {
+			report.Orphans = append(report.Orphans, h)
+		}
+		if err = it.Next(); err != nil {
+			return err
+		}
+	}
+	if report.Entries > 0 {
+		report.MeanKeyLen = total / report.Entries
+	}
+	return nil
+}
+
+// IndexMismatchKind is the kind of an inconsistency found by CheckIndexConsistency.
+type IndexMismatchKind int
+
+const (
+	// MismatchDangling is an entry whose row doesn't exist.
+	MismatchDangling IndexMismatchKind = iota
+	// MismatchStaleValues is an entry whose values aren't the indexed values of its row, e.g. the row
+	// is updated but the entry isn't, or the handle in the value points to another row.
+	MismatchStaleValues
+	// MismatchCorrupt is an entry which can't be decoded, e.g. the handle in the value of a unique entry.
+	MismatchCorrupt
+)
+
+// IndexMismatch is an index entry inconsistent with the table rows.
+type IndexMismatch struct {
+	Kind IndexMismatchKind
+	Key  kv.Key
+	// Handle and EntryValues are decoded from the entry, they're unset for a corrupt entry.
+	Handle      int64
+	EntryValues []types.Datum
+	// RowValues are the indexed values of the row of a stale entry, as FetchValues returns them.
+	RowValues []types.Datum
+	// Err is the decode error of a corrupt entry.
+	Err error
+}
+
+// CheckIndexConsistency checks the entries of idx, an index of t, from startKey against the rows of t read in
+// the transaction of sctx, for ADMIN CHECK INDEX. Every entry is decoded, its row is read by the handle, and the
+// indexed values of the row, truncated as the index stores them, are compared with the entry's. The dangling,
+// stale and corrupt entries are all returned instead of stopping at the first one. A nil startKey checks the
+// whole index. It doesn't find the rows without entries, see HealthReport.
+func CheckIndexConsistency(sctx sessionctx.Context, t table.Table, idx table.Index, startKey kv.Key) (mismatches []IndexMismatch, err error) {
+	c, ok := idx.(*index)
+	if !ok {
+		return nil, errors.Errorf("index %s isn't a KV index", idx.Meta().Name)
+	}
+	txn, err := sctx.Txn(true)
+	if err != nil {
+		return nil, err
+	}
+	if startKey == nil {
+		startKey = c.scanPrefix
+	}
+	if !startKey.HasPrefix(c.scanPrefix) {
+		return nil, errors.Errorf("start key %x isn't in index %s", []byte(startKey), c.idxInfo.Name)
+	}
+	it, err := txn.Iter(startKey, c.scanPrefix.PrefixNext())
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	sc := sctx.GetSessionVars().StmtCtx
+	var vals []types.Datum
+	for it.Valid() && it.Key().HasPrefix(c.scanPrefix) {
+		key := append(kv.Key(nil), it.Key()...)
+		entryVals, h, err := c.decodeEntry(key, it.Value())
+		if err != nil {
+			mismatches = append(mismatches, IndexMismatch{Kind: MismatchCorrupt, Key: key, Err: err})
+		} else {
+			row, err := t.Row(sctx, h)
+			switch {
+			case kv.IsErrNotFound(err):
+				mismatches = append(mismatches, IndexMismatch{Kind: MismatchDangling, Key: key, Handle: h, EntryValues: entryVals})
+			case err != nil:
+				return nil, err
+			default:
+				if vals, err = c.FetchValues(row, vals); err != nil {
+					return nil, err
+				}
+				same, err := c.sameIndexedValues(sc, entryVals, vals)
+				if err != nil {
+					return nil, err
+				}
+				if !same {
+					mismatches = append(mismatches, IndexMismatch{Kind: MismatchStaleValues, Key: key, Handle: h,
+						EntryValues: entryVals, RowValues: append([]types.Datum(nil), vals...)})
+				}
+			}
+		}
+		if err = it.Next(); err != nil {
+			return nil, err
+		}
+	}
+	return mismatches, nil
+}
+
+// sameIndexedValues returns whether the values decoded from an entry are the indexed values of a row.
+func (c *index) sameIndexedValues(sc *stmtctx.StatementContext, entryVals, rowVals []types.Datum) (bool, error) {
+	a, err := codec.EncodeKey(sc, nil, entryVals...)
+	if err != nil {
+		return false, err
+	}
+	b, err := codec.EncodeKey(sc, nil, TruncateIndexValuesIfNeeded(c.tblInfo, c.idxInfo, rowVals)...)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(a, b), nil
+}
+
+// CheckPrefixIndex checks the entries of an index with a prefix column, e.g. col(10), store exactly the
+// prefix of the full column value. fetch returns the full value of the prefix column of the row of a handle,
+// which is truncated to the prefix length again and compared with the entry, so a mismatch means the entry
+// was truncated wrongly. The first mismatch is returned as an error.
+func (c *index) CheckPrefixIndex(sc *stmtctx.StatementContext, r kv.Retriever, fetch func(h int64) (string, error)) error {
+	col := -1
+	for i, ic := range c.idxInfo.Columns {
+		if ic.Length != types.UnspecifiedLength {
+			if col >= 0 {
+				return errors.Errorf("index %s has more than one prefix column", c.idxInfo.Name)
+			}
+			col = i
+		}
+	}
+	if col < 0 {
+		return errors.Errorf("index %s has no prefix column", c.idxInfo.Name)
+	}
+	it, err := c.SeekFirst(r)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	for {
+		vals, h, err := it.Next()
+		if terror.ErrorEqual(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if vals[col].IsNull() {
+			continue
+		}
+		full, err := fetch(h)
+		if err != nil {
+			return err
+		}
+		rowVals := append([]types.Datum(nil), vals...)
+		rowVals[col] = types.NewStringDatum(full)
+		expected := TruncateIndexValuesIfNeeded(c.tblInfo, c.idxInfo, rowVals)[col]
+		if !bytes.Equal(expected.GetBytes(), vals[col].GetBytes()) {
+			return errors.Errorf("entry of handle %d of index %s stores prefix '%s', but the full value '%s' has prefix '%s'",
+				h, c.idxInfo.Name, types.DatumsToStrNoErr(vals[col:col+1]), full, types.DatumsToStrNoErr([]types.Datum{expected}))
+		}
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tidb/parser/mysql"
+	"github.com/pingcap/tidb/parser/terror"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/codec"
+	"github.com/pingcap/tidb/util/rowcodec"
+)
+
+// mapRows is a RowFetcher over the rows in a map.
+type mapRows struct {
+	rows    map[int64][]types.Datum
+	handles []int64
+}
+
+func newMapRows(rows map[int64][]types.Datum) *mapRows {
+	m := &mapRows{rows: rows}
+	for h := range rows {
+		m.handles = append(m.handles, h)
+	}
+	sort.Slice(m.handles, func(i, j int) bool { return m.handles[i] < m.handles[j] })
+	return m
+}
+
+func (m *mapRows) FetchRow(h int64) ([]types.Datum, bool, error) {
+	row, ok := m.rows[h]
+	return row, ok, nil
+}
+
+func (m *mapRows) NextRow() ([]types.Datum, int64, bool, error) {
+	if len(m.handles) == 0 {
+		return nil, 0, false, nil
+	}
+	h := m.handles[0]
+	m.handles = m.handles[1:]
+	return m.rows[h], h, true, nil
+}
+
+func (s *testIndexInternalSuite) TestHealthReport(c *C) {
+	tblInfo := newTestTableInfo([]string{"a", "b"}, []int{1}, true)
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+	rows := map[int64][]types.Datum{}
+	for h := int64(0); h < 10; h++ {
+		rows[h] = types.MakeDatums(h, fmt.Sprintf("v%d", h))
+		_, err := idx.Create(s.sctx, s.store, rows[h][1:], h)
+		c.Assert(err, IsNil)
+	}
+	report, err := idx.HealthReport(context.Background(), s.sc, s.store, newMapRows(rows))
+	c.Assert(err, IsNil)
+	c.Assert(report.Healthy(), IsTrue)
+	c.Assert(report.Entries, Equals, 10)
+
+	// Handle 20 has no row, the row of handle 3 is changed, the rows of handles 5 and 6 have no entries.
+	_, err = idx.Create(s.sctx, s.store, types.MakeDatums("v20"), 20)
+	c.Assert(err, IsNil)
+	rows[3] = types.MakeDatums(3, "changed")
+	c.Assert(idx.Delete(s.sc, s.store, rows[5][1:], 5), IsNil)
+	c.Assert(idx.Delete(s.sc, s.store, rows[6][1:], 6), IsNil)
+	report, err = idx.HealthReport(context.Background(), s.sc, s.store, newMapRows(rows))
+	c.Assert(err, IsNil)
+	c.Assert(report.Healthy(), IsFalse)
+	c.Assert(report.Entries, Equals, 9)
+	sort.Slice(report.Orphans, func(i, j int) bool { return report.Orphans[i] < report.Orphans[j] })
+	c.Assert(report.Orphans, DeepEquals, []int64{3, 20})
+	c.Assert(report.Missing, DeepEquals, []int64{3, 5, 6})
+	min, max, mean, histogram, err := idx.KeyLengthStats(s.store)
+	c.Assert(err, IsNil)
+	c.Assert([]int{report.MinKeyLen, report.MaxKeyLen, report.MeanKeyLen}, DeepEquals, []int{min, max, mean})
+	c.Assert(report.KeyLenHistogram, DeepEquals, histogram)
+	var size int64
+	for _, pair := range dumpKVs(c, s.store, idx.prefix) {
+		size += int64(len(pair[0]) + len(pair[1]))
+	}
+	c.Assert(report.ApproxSize, Equals, size)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = idx.HealthReport(ctx, s.sc, s.store, newMapRows(rows))
+	c.Assert(terror.ErrorEqual(err, context.Canceled), IsTrue, Commentf("err %v", err))
+}
+
+func (s *testIndexInternalSuite) TestCheckPrefixIndex(c *C) {
+	tblInfo := newTestTableInfo([]string{"a", "b"}, []int{0, 1}, true)
+	tblInfo.Indices[0].Columns[1].Length = 3
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+	full := map[int64]string{1: "abcdef", 2: "xy", 3: "abcdzz"}
+	for h := int64(1); h <= 2; h++ {
+		_, err := idx.Create(s.sctx, s.store, types.MakeDatums(h, full[h]), h)
+		c.Assert(err, IsNil)
+	}
+	fetch := func(h int64) (string, error) {
+		return full[h], nil
+	}
+	c.Assert(idx.CheckPrefixIndex(s.sc, s.store, fetch), IsNil)
+
+	// An entry storing 4 characters of the value is written by a buggy truncation.
+	key, err := codec.EncodeKey(s.sc, append([]byte{}, idx.prefix...), types.MakeDatums(3, "abcd")...)
+	c.Assert(err, IsNil)
+	c.Assert(s.store.Set(key, EncodeHandle(3)), IsNil)
+	err = idx.CheckPrefixIndex(s.sc, s.store, fetch)
+	c.Assert(err, ErrorMatches, ".*handle 3 .* stores prefix 'abcd', but the full value 'abcdzz' has prefix 'abc'")
+
+	tblInfo.Indices[0].Columns[1].Length = types.UnspecifiedLength
+	c.Assert(idx.CheckPrefixIndex(s.sc, s.store, fetch), ErrorMatches, ".*has no prefix column")
+}
+
+// bufferTxn is a transaction reading and writing a BufferStore.
+type bufferTxn struct {
+	kv.Transaction
+	buf *kv.BufferStore
+}
+
+func (t *bufferTxn) Valid() bool { return true }
+
+func (t *bufferTxn) Get(ctx context.Context, k kv.Key) ([]byte, error) {
+	return t.buf.Get(ctx, k)
+}
+
+func (t *bufferTxn) Iter(k kv.Key, upperBound kv.Key) (kv.Iterator, error) {
+	return t.buf.Iter(k, upperBound)
+}
+
+func (t *bufferTxn) IterReverse(k kv.Key) (kv.Iterator, error) { return t.buf.IterReverse(k) }
+func (t *bufferTxn) Set(k kv.Key, v []byte) error              { return t.buf.Set(k, v) }
+func (t *bufferTxn) Delete(k kv.Key) error                     { return t.buf.Delete(k) }
+
+func (s *testIndexInternalSuite) TestCheckIndexConsistency(c *C) {
+	tblInfo := newTestTableInfo([]string{"a", "b"}, []int{0}, true)
+	tblInfo.State = model.StatePublic
+	for _, col := range tblInfo.Columns {
+		col.FieldType = *types.NewFieldType(mysql.TypeLonglong)
+		col.State = model.StatePublic
+	}
+	tblInfo.Indices[0].State = model.StatePublic
+	tbl := MockTableFromMeta(tblInfo)
+	idx := tbl.Indices()[0]
+	s.sctx.Store = &txnStore{txn: &bufferTxn{buf: s.store}}
+	c.Assert(s.sctx.NewTxn(context.Background()), IsNil)
+	sc := s.sctx.GetSessionVars().StmtCtx
+	for h := int64(1); h <= 5; h++ {
+		row := types.MakeDatums(h*10, h)
+		value, err := tablecodec.EncodeRow(sc, row, []int64{1, 2}, nil, nil, &rowcodec.Encoder{})
+		c.Assert(err, IsNil)
+		c.Assert(s.store.Set(tbl.RecordKey(h), value), IsNil)
+		_, err = idx.Create(s.sctx, s.store, types.MakeDatums(h*10), h)
+		c.Assert(err, IsNil)
+	}
+	mismatches, err := CheckIndexConsistency(s.sctx, tbl, idx, nil)
+	c.Assert(err, IsNil)
+	c.Assert(mismatches, HasLen, 0)
+
+	// The row of handle 2 is deleted, the row of handle 3 is updated without its entry, and the entry of 40
+	// has a truncated handle.
+	c.Assert(s.store.Delete(tbl.RecordKey(2)), IsNil)
+	value, err := tablecodec.EncodeRow(sc, types.MakeDatums(33, 3), []int64{1, 2}, nil, nil, &rowcodec.Encoder{})
+	c.Assert(err, IsNil)
+	c.Assert(s.store.Set(tbl.RecordKey(3), value), IsNil)
+	corruptKey, _, err := idx.GenIndexKey(sc, types.MakeDatums(40), 4, nil)
+	c.Assert(err, IsNil)
+	c.Assert(s.store.Set(corruptKey, []byte{0, 4}), IsNil)
+	// The entry of 50 points to the row of handle 1.
+	key, _, err := idx.GenIndexKey(sc, types.MakeDatums(50), 5, nil)
+	c.Assert(err, IsNil)
+	c.Assert(s.store.Set(key, EncodeHandle(1)), IsNil)
+
+	mismatches, err = CheckIndexConsistency(s.sctx, tbl, idx, nil)
+	c.Assert(err, IsNil)
+	c.Assert(mismatches, HasLen, 4)
+	c.Assert(mismatches[0].Kind, Equals, MismatchDangling)
+	c.Assert(mismatches[0].Handle, Equals, int64(2))
+	c.Assert(mismatches[1].Kind, Equals, MismatchStaleValues)
+	c.Assert(mismatches[1].Handle, Equals, int64(3))
+	c.Assert(datumsString(c, mismatches[1].EntryValues), Equals, "30")
+	c.Assert(datumsString(c, mismatches[1].RowValues), Equals, "33")
+	c.Assert(mismatches[2].Kind, Equals, MismatchCorrupt)
+	c.Assert(mismatches[2].Key, DeepEquals, kv.Key(corruptKey))
+	c.Assert(mismatches[2].Err, NotNil)
+	c.Assert(mismatches[3].Kind, Equals, MismatchStaleValues)
+	c.Assert(mismatches[3].Handle, Equals, int64(1))
+
+	// The check starts at the start key.
+	mismatches, err = CheckIndexConsistency(s.sctx, tbl, idx, mismatches[2].Key)
+	c.Assert(err, IsNil)
+	c.Assert(mismatches, HasLen, 2)
+}
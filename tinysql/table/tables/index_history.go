@@ -0,0 +1,74 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"context"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/sessionctx/stmtctx"
+	"github.com/pingcap/tidb/types"
+)
+
+// RawVersion is a committed version of a key, an empty Value means the key is deleted at the version.
+type RawVersion struct {
+	Version kv.Version
+	Value   []byte
+}
+
+// HistoryReader is implemented by a backend which keeps the committed versions of the keys.
+type HistoryReader interface {
+	// GetHistory returns the committed versions of key, the oldest first.
+	GetHistory(ctx context.Context, key kv.Key) ([]RawVersion, error)
+}
+
+// VersionedValue is a committed version of an index entry.
+type VersionedValue struct {
+	Version kv.Version
+	// Value is the raw value, it's empty if the entry is deleted at the version.
+	Value []byte
+	// Handle is the handle the entry points to, it's 0 if the entry is deleted.
+	Handle  int64
+	Deleted bool
+}
+
+// KeyHistory returns the committed versions of the entry with indexedValues, the oldest first, to see when
+// it's written, overwritten or deleted, e.g. to find why a unique constraint fires. The key of a unique
+// entry without NULL doesn't have the handle, so h is ignored, otherwise h selects the entry.
+// It isn't supported by an index which keeps the insertion order, whose keys are only found by a scan.
+func (c *index) KeyHistory(ctx context.Context, sc *stmtctx.StatementContext, r HistoryReader, indexedValues []types.Datum, h int64) ([]VersionedValue, error) {
+	if c.seqGen != nil {
+		return nil, errors.Errorf("index %s keeps the insertion order, its key history isn't supported", c.idxInfo.Name)
+	}
+	key, _, err := c.GenIndexKey(sc, indexedValues, h, nil)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := r.GetHistory(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	history := make([]VersionedValue, 0, len(versions))
+	for _, v := range versions {
+		vv := VersionedValue{Version: v.Version, Value: v.Value, Deleted: len(v.Value) == 0}
+		if !vv.Deleted {
+			if _, vv.Handle, err = c.decodeEntry(key, v.Value); err != nil {
+				return nil, err
+			}
+		}
+		history = append(history, vv)
+	}
+	return history, nil
+}
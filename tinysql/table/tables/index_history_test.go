@@ -0,0 +1,74 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/types"
+)
+
+// historyStore keeps every write as a committed version of the key at ver.
+type historyStore struct {
+	*kv.BufferStore
+	ver     uint64
+	history map[string][]RawVersion
+}
+
+func (s *historyStore) Set(k kv.Key, v []byte) error {
+	s.history[string(k)] = append(s.history[string(k)], RawVersion{Version: kv.NewVersion(s.ver), Value: append([]byte{}, v...)})
+	return s.BufferStore.Set(k, v)
+}
+
+func (s *historyStore) Delete(k kv.Key) error {
+	s.history[string(k)] = append(s.history[string(k)], RawVersion{Version: kv.NewVersion(s.ver)})
+	return s.BufferStore.Delete(k)
+}
+
+func (s *historyStore) GetHistory(ctx context.Context, key kv.Key) ([]RawVersion, error) {
+	return s.history[string(key)], nil
+}
+
+func (s *testIndexInternalSuite) TestKeyHistory(c *C) {
+	for _, unique := range []bool{true, false} {
+		tblInfo := newTestTableInfo([]string{"a"}, []int{0}, unique)
+		idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+		store := &historyStore{BufferStore: newTestStore(), history: map[string][]RawVersion{}}
+		store.ver = 10
+		_, err := idx.Create(s.sctx, store, types.MakeDatums(1), 7)
+		c.Assert(err, IsNil)
+		// The entry is moved to another row, then deleted.
+		store.ver = 20
+		c.Assert(idx.Delete(s.sc, store, types.MakeDatums(1), 7), IsNil)
+		_, err = idx.Create(s.sctx, store, types.MakeDatums(1), 8)
+		c.Assert(err, IsNil)
+		store.ver = 30
+		c.Assert(idx.Delete(s.sc, store, types.MakeDatums(1), 8), IsNil)
+
+		history, err := idx.KeyHistory(context.Background(), s.sc, store, types.MakeDatums(1), 8)
+		c.Assert(err, IsNil)
+		var got []string
+		for _, v := range history {
+			got = append(got, fmt.Sprintf("%d:%d:%v", v.Version.Ver, v.Handle, v.Deleted))
+		}
+		if unique {
+			c.Assert(got, DeepEquals, []string{"10:7:false", "20:0:true", "20:8:false", "30:0:true"})
+		} else {
+			c.Assert(got, DeepEquals, []string{"20:8:false", "30:0:true"})
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/sessionctx/stmtctx"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/codec"
+)
+
+// includedValuesVersion ends the encoded values of the included columns in the value of a non-distinct entry,
+// see WithIncludeColumns. It isn't the untouched flag, so a covered value is never taken for an untouched one.
+const includedValuesVersion byte = 0x04
+
+// WithIncludeColumns returns an IndexOption which stores the values of the table columns at colOffsets
+// (offsets in the table columns) in the values of the non-distinct entries, like the INCLUDE columns of a
+// SQL Server index, so a covering read gets them from NextIncluded without a row lookup. Create stores the
+// values passed by table.WithIncludedValues, and RowIndexWriter fetches them from the row. An entry written
+// without them, e.g. before the option is set, still has the '0' value and isn't covered. The distinct
+// entries of a unique index only keep the handle. An index which keeps the insertion order or stores the
+// original values in the values isn't supported.
+func WithIncludeColumns(colOffsets ...int) IndexOption {
+	return func(c *index) {
+		c.includeCols = colOffsets
+	}
+}
+
+// FetchIncludedValues returns the values of the included columns in row, see WithIncludeColumns.
+func (c *index) FetchIncludedValues(row []types.Datum) ([]types.Datum, error) {
+	vals := make([]types.Datum, len(c.includeCols))
+	for i, offset := range c.includeCols {
+		if offset < 0 || offset >= len(row) {
+			return nil, table.ErrIndexOutBound.GenWithStackByArgs(c.idxInfo.Name, offset, row)
+		}
+		vals[i] = row[offset]
+	}
+	return vals, nil
+}
+
+// checkIncluded checks the included values passed to Create can be stored by the index.
+func (c *index) checkIncluded(included []types.Datum) error {
+	if included == nil {
+		return nil
+	}
+	if len(included) != len(c.includeCols) {
+		return errors.Errorf("index %s has %d included columns but %d values are given", c.idxInfo.Name, len(c.includeCols), len(included))
+	}
+	return nil
+}
+
+// nonDistinctValue returns the value of a non-distinct entry, the encoded included values followed by
+// includedValuesVersion if they're given, otherwise a '0' as the entry needs no value.
+func (c *index) nonDistinctValue(sc *stmtctx.StatementContext, included []types.Datum) ([]byte, error) {
+	if included == nil {
+		// non-unique index doesn't need store value, write a '0' to reduce space
+		return []byte{'0'}, nil
+	}
+	value, err := codec.EncodeValue(sc, nil, included...)
+	if err != nil {
+		return nil, err
+	}
+	return append(value, includedValuesVersion), nil
+}
+
+// decodeIncluded decodes the included values stored in the entry of key and value,
+// they're nil if the entry doesn't store them.
+func (c *index) decodeIncluded(key, value []byte) ([]types.Datum, error) {
+	if len(c.includeCols) == 0 {
+		return nil, nil
+	}
+	// The value of a distinct entry, whose key has no handle suffix, is the handle.
+	remain := key[len(c.prefix):]
+	for i := range c.idxInfo.Columns {
+		var err error
+		if remain, err = c.cutIndexValue(remain, i); err != nil {
+			return nil, err
+		}
+	}
+	if len(remain) == 0 {
+		return nil, nil
+	}
+	value, _, err := c.splitValue(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(value) < 2 || value[len(value)-1] != includedValuesVersion {
+		return nil, nil
+	}
+	return codec.Decode(value[:len(value)-1], len(c.includeCols))
+}
+
+// NextIncluded is Next also returning the values of the included columns stored in the entry, see
+// WithIncludeColumns. They're nil if the entry doesn't store them, then they must be read from the row.
+func (c *indexIter) NextIncluded() (val, included []types.Datum, h int64, err error) {
+	var key, value []byte
+	if err = c.skipTombstones(); err != nil {
+		return nil, nil, 0, err
+	}
+	if c.it != nil && c.it.Valid() {
+		key, value = c.it.Key(), c.it.Value()
+	}
+	val, h, _, err = c.NextWithMeta()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	included, err = c.idx.decodeIncluded(key, value)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return val, included, h, nil
+}
@@ -0,0 +1,152 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"io"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/parser/terror"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/types"
+)
+
+func (s *testIndexInternalSuite) TestIncludeColumns(c *C) {
+	tblInfo := newTestTableInfo([]string{"a", "b", "c"}, []int{0}, false)
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], WithIncludeColumns(2, 1)).(*index)
+	rows := [][]interface{}{{1, "x", 10}, {2, nil, 2.5}}
+	for h, row := range rows {
+		vals, err := idx.FetchValues(types.MakeDatums(row...), nil)
+		c.Assert(err, IsNil)
+		included, err := idx.FetchIncludedValues(types.MakeDatums(row...))
+		c.Assert(err, IsNil)
+		_, err = idx.Create(s.sctx, s.store, vals, int64(h), table.WithIncludedValues(included))
+		c.Assert(err, IsNil)
+	}
+	// An entry written without the included values isn't covered.
+	_, err := idx.Create(s.sctx, s.store, types.MakeDatums(3), 2)
+	c.Assert(err, IsNil)
+
+	// The rows are read from the index alone.
+	it, err := idx.SeekFirst(s.store)
+	c.Assert(err, IsNil)
+	var got []string
+	for {
+		vals, included, h, err := it.(*indexIter).NextIncluded()
+		if terror.ErrorEqual(err, io.EOF) {
+			break
+		}
+		c.Assert(err, IsNil)
+		got = append(got, datumsString(c, vals)+"|"+datumsString(c, included))
+		c.Assert(h, Equals, int64(len(got)-1))
+	}
+	it.Close()
+	c.Assert(got, DeepEquals, []string{"1|10,x", "2|2.5,NULL", "3|"})
+
+	// Deleting and creating the entry again writes the same value.
+	before := dumpKVs(c, s.store, idx.prefix)
+	c.Assert(idx.Delete(s.sc, s.store, types.MakeDatums(1), 0), IsNil)
+	c.Assert(dumpKVs(c, s.store, idx.prefix), HasLen, 2)
+	_, err = idx.Create(s.sctx, s.store, types.MakeDatums(1), 0, table.WithIncludedValues(types.MakeDatums(10, "x")))
+	c.Assert(err, IsNil)
+	c.Assert(dumpKVs(c, s.store, idx.prefix), DeepEquals, before)
+
+	// RowIndexWriter fetches the included values from the row.
+	store := newTestStore()
+	w := NewRowIndexWriter([]table.Index{idx})
+	for h, row := range rows {
+		_, err = w.WriteRow(s.sctx, store, types.MakeDatums(row...), int64(h))
+		c.Assert(err, IsNil)
+	}
+	c.Assert(dumpKVs(c, store, idx.prefix), DeepEquals, before[:2])
+
+	_, err = idx.Create(s.sctx, s.store, types.MakeDatums(4), 4, table.WithIncludedValues(types.MakeDatums(1)))
+	c.Assert(err, NotNil)
+	_, err = idx.FetchIncludedValues(types.MakeDatums(1, 2))
+	c.Assert(err, NotNil)
+}
+
+func (s *testIndexInternalSuite) TestIncludeColumnsUnique(c *C) {
+	tblInfo := newTestTableInfo([]string{"a", "b"}, []int{0}, true)
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], WithIncludeColumns(1)).(*index)
+	// The distinct entry keeps the handle only, the NULL entry is covered. The handle 4 ends the
+	// distinct value with the byte of includedValuesVersion.
+	_, err := idx.Create(s.sctx, s.store, types.MakeDatums(1), 4, table.WithIncludedValues(types.MakeDatums("x")))
+	c.Assert(err, IsNil)
+	_, err = idx.Create(s.sctx, s.store, types.MakeDatums(nil), 5, table.WithIncludedValues(types.MakeDatums("y")))
+	c.Assert(err, IsNil)
+
+	it, err := idx.SeekFirst(s.store)
+	c.Assert(err, IsNil)
+	defer it.Close()
+	vals, included, h, err := it.(*indexIter).NextIncluded()
+	c.Assert(err, IsNil)
+	c.Assert(datumsString(c, vals), Equals, "NULL")
+	c.Assert(datumsString(c, included), Equals, "y")
+	c.Assert(h, Equals, int64(5))
+	vals, included, h, err = it.(*indexIter).NextIncluded()
+	c.Assert(err, IsNil)
+	c.Assert(datumsString(c, vals), Equals, "1")
+	c.Assert(included, IsNil)
+	c.Assert(h, Equals, int64(4))
+}
+
+func (s *testIndexInternalSuite) TestIncludeColumnsBatch(c *C) {
+	tblInfo := newTestTableInfo([]string{"a", "b", "c"}, []int{0}, false)
+	rows := [][]types.Datum{types.MakeDatums(1, "x", 10), types.MakeDatums(2, nil, 2.5)}
+	slowLog := WithSlowLog(time.Hour, func(idxName string, op string, cost time.Duration) {})
+	// The entries created one by one are the expected ones.
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], WithIncludeColumns(2, 1)).(*index)
+	var entries []IndexEntry
+	for h, row := range rows {
+		vals, err := idx.FetchValues(row, nil)
+		c.Assert(err, IsNil)
+		included, err := idx.FetchIncludedValues(row)
+		c.Assert(err, IsNil)
+		_, err = idx.Create(s.sctx, s.store, vals, int64(h), table.WithIncludedValues(included))
+		c.Assert(err, IsNil)
+		entries = append(entries, IndexEntry{Values: vals, Handle: int64(h), Included: included})
+	}
+	expected := dumpKVs(c, s.store, idx.prefix)
+
+	// CreateBatch stores the included values of the entries, on the path writing the keys at once and on
+	// the one creating the entries one by one.
+	for _, opts := range [][]IndexOption{{WithIncludeColumns(2, 1)}, {WithIncludeColumns(2, 1), slowLog}} {
+		idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], opts...).(*index)
+		store := newTestStore()
+		c.Assert(idx.CreateBatch(s.sctx, store, entries), IsNil)
+		c.Assert(dumpKVs(c, store, idx.prefix), DeepEquals, expected)
+	}
+	entries[0].Included = types.MakeDatums(10)
+	c.Assert(idx.CreateBatch(s.sctx, newTestStore(), entries), NotNil)
+
+	// The backfill and the repairs fetch the included values from the rows.
+	var sizes []int
+	store := newTestStore()
+	created, err := idx.BuildFromRows(s.sctx, chunkRunner(store, &sizes), sliceRows(rows), 0)
+	c.Assert(err, IsNil)
+	c.Assert(created, Equals, 2)
+	c.Assert(dumpKVs(c, store, idx.prefix), DeepEquals, expected)
+	store = newTestStore()
+	created, err = idx.RepairFromTableInBatches(s.sctx, chunkRunner(store, &sizes), sliceRows(rows), 1)
+	c.Assert(err, IsNil)
+	c.Assert(created, Equals, 2)
+	c.Assert(dumpKVs(c, store, idx.prefix), DeepEquals, expected)
+	store = newTestStore()
+	created, err = idx.RepairFromTable(s.sctx, store, sliceRows(rows))
+	c.Assert(err, IsNil)
+	c.Assert(created, Equals, 2)
+	c.Assert(dumpKVs(c, store, idx.prefix), DeepEquals, expected)
+}
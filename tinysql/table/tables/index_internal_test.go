@@ -0,0 +1,2054 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	goerrors "errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/parser/charset"
+	"github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tidb/parser/mysql"
+	"github.com/pingcap/tidb/parser/terror"
+	"github.com/pingcap/tidb/sessionctx"
+	"github.com/pingcap/tidb/sessionctx/stmtctx"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/codec"
+	"github.com/pingcap/tidb/util/mock"
+)
+
+var _ = Suite(&testIndexInternalSuite{})
+
+type testIndexInternalSuite struct {
+	// sctx, sc and store are reset before every test.
+	sctx  *mock.Context
+	sc    *stmtctx.StatementContext
+	store *kv.BufferStore
+}
+
+func (s *testIndexInternalSuite) SetUpTest(c *C) {
+	s.sctx = mock.NewContext()
+	s.sc = &stmtctx.StatementContext{TimeZone: time.Local}
+	s.store = newTestStore()
+}
+
+// newIndex builds a table with the named columns and returns its index over all of them.
+func (s *testIndexInternalSuite) newIndex(colNames []string, unique bool, opts ...IndexOption) *index {
+	offsets := make([]int, len(colNames))
+	for i := range offsets {
+		offsets[i] = i
+	}
+	tblInfo := newTestTableInfo(colNames, offsets, unique)
+	return NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], opts...).(*index)
+}
+
+// newTestTableInfo builds a table with the named columns and one index over the columns at idxOffsets.
+func newTestTableInfo(colNames []string, idxOffsets []int, unique bool) *model.TableInfo {
+	tblInfo := &model.TableInfo{ID: 1}
+	for i, name := range colNames {
+		tblInfo.Columns = append(tblInfo.Columns, &model.ColumnInfo{
+			ID:     int64(i + 1),
+			Name:   model.NewCIStr(name),
+			Offset: i,
+		})
+	}
+	idxInfo := &model.IndexInfo{
+		ID:     2,
+		Name:   model.NewCIStr("test"),
+		Unique: unique,
+	}
+	for _, offset := range idxOffsets {
+		idxInfo.Columns = append(idxInfo.Columns, &model.IndexColumn{
+			Name:   model.NewCIStr(colNames[offset]),
+			Offset: offset,
+			Length: -1,
+		})
+	}
+	tblInfo.Indices = []*model.IndexInfo{idxInfo}
+	return tblInfo
+}
+
+// newTestStore returns an empty store which hides the deleted entries like a transaction does.
+func newTestStore() *kv.BufferStore {
+	return kv.NewBufferStore(kv.NewMemDbBuffer(4096), 4096)
+}
+
+// datumsString renders vals as comma separated strings for comparisons.
+func datumsString(c *C, vals []types.Datum) string {
+	strs := make([]string, 0, len(vals))
+	for _, v := range vals {
+		if v.IsNull() {
+			strs = append(strs, "NULL")
+			continue
+		}
+		str, err := v.ToString()
+		c.Assert(err, IsNil)
+		strs = append(strs, str)
+	}
+	return strings.Join(strs, ",")
+}
+
+func (s *testIndexInternalSuite) TestNewIndexWithCheck(c *C) {
+	tblInfo := newTestTableInfo([]string{"a", "b", "c"}, []int{1, 2}, false)
+	idx, err := NewIndexWithCheck(tblInfo.ID, tblInfo, tblInfo.Indices[0])
+	c.Assert(err, IsNil)
+	c.Assert(idx, NotNil)
+
+	// The offset of column "b" drifts to point at column "c".
+	tblInfo.Indices[0].Columns[0].Offset = 2
+	_, err = NewIndexWithCheck(tblInfo.ID, tblInfo, tblInfo.Indices[0])
+	c.Assert(terror.ErrorEqual(err, table.ErrKeyColumnDoesNotExist), IsTrue, Commentf("err %v", err))
+
+	tblInfo.Indices[0].Columns[0].Offset = 3
+	_, err = NewIndexWithCheck(tblInfo.ID, tblInfo, tblInfo.Indices[0])
+	c.Assert(terror.ErrorEqual(err, table.ErrKeyColumnDoesNotExist), IsTrue, Commentf("err %v", err))
+}
+
+func (s *testIndexInternalSuite) TestValidateOptions(c *C) {
+	seq := WithInsertionSequence(func() int64 { return 1 })
+	hashed := WithHashedColumns(func(d types.Datum) uint64 { return uint64(d.GetInt64()) }, 0)
+	for _, opts := range [][]IndexOption{
+		{seq, hashed},
+		{seq, WithSortKey(0, naturalSortKey)},
+		{WithCompactHandles(), seq},
+		{WithCompactHandles(), hashed},
+		{WithCompactHandles(), WithFormatMagic()},
+		{WithLegacyValues(), WithGlobal()},
+		{WithLegacyValues(), WithPlacementHints()},
+		{WithLegacyValues(), WithWriteTime(nil)},
+		{WithLegacyValues(), WithTombstones(nil)},
+		{WithTombstones(nil), seq},
+		{WithTombstones(nil), hashed},
+		{WithIncludeColumns(1), seq},
+		{WithIncludeColumns(1), WithSortKey(0, naturalSortKey)},
+		{WithDeferredUnique(), seq},
+	} {
+		tblInfo := newTestTableInfo([]string{"a", "b"}, []int{0}, true)
+		_, err := NewIndexWithCheck(tblInfo.ID, tblInfo, tblInfo.Indices[0], opts...)
+		c.Assert(err, ErrorMatches, "index test doesn't support .* with .*")
+
+		// NewIndex keeps the error for the writes and the seeks.
+		idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], opts...)
+		_, err = idx.Create(s.sctx, s.store, types.MakeDatums(1, 2), 1)
+		c.Assert(err, ErrorMatches, "index test doesn't support .* with .*")
+		_, err = idx.SeekFirst(s.store)
+		c.Assert(err, ErrorMatches, "index test doesn't support .* with .*")
+		c.Assert(dumpKVs(c, s.store, idx.(*index).prefix), HasLen, 0)
+	}
+
+	// The options are independent of their order, and the supported combinations are accepted.
+	tblInfo := newTestTableInfo([]string{"a", "b"}, []int{0}, true)
+	_, err := NewIndexWithCheck(tblInfo.ID, tblInfo, tblInfo.Indices[0], seq, WithCompactHandles())
+	c.Assert(err, NotNil)
+	_, err = NewIndexWithCheck(tblInfo.ID, tblInfo, tblInfo.Indices[0], WithTombstones(nil), WithIncludeColumns(1), WithCompactHandles(), WithDeferredUnique(), WithPlacementHints())
+	c.Assert(err, IsNil)
+}
+
+// slowMutator delays every write to simulate a slow storage.
+type slowMutator struct {
+	kv.RetrieverMutator
+	delay time.Duration
+}
+
+func (m *slowMutator) Set(k kv.Key, v []byte) error {
+	time.Sleep(m.delay)
+	return m.RetrieverMutator.Set(k, v)
+}
+
+func (m *slowMutator) Delete(k kv.Key) error {
+	time.Sleep(m.delay)
+	return m.RetrieverMutator.Delete(k)
+}
+
+func (s *testIndexInternalSuite) TestSlowLog(c *C) {
+	type slowOp struct {
+		idxName string
+		op      string
+		cost    time.Duration
+	}
+	var ops []slowOp
+	tblInfo := newTestTableInfo([]string{"a", "b"}, []int{0, 1}, false)
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], WithSlowLog(10*time.Millisecond, func(idxName string, op string, cost time.Duration) {
+		ops = append(ops, slowOp{idxName, op, cost})
+	}))
+
+	values := types.MakeDatums(1, 2)
+	_, err := idx.Create(s.sctx, s.store, values, 1)
+	c.Assert(err, IsNil)
+	it, _, err := idx.Seek(s.sc, s.store, values)
+	c.Assert(err, IsNil)
+	it.Close()
+	c.Assert(ops, HasLen, 0)
+
+	slow := &slowMutator{RetrieverMutator: s.store, delay: 20 * time.Millisecond}
+	_, err = idx.Create(s.sctx, slow, values, 2)
+	c.Assert(err, IsNil)
+	err = idx.Delete(s.sc, slow, values, 2)
+	c.Assert(err, IsNil)
+	c.Assert(ops, HasLen, 2)
+	c.Assert(ops[0].idxName, Equals, "test")
+	c.Assert(ops[0].op, Equals, IndexOpCreate)
+	c.Assert(ops[0].cost >= 20*time.Millisecond, IsTrue)
+	c.Assert(ops[1].op, Equals, IndexOpDelete)
+}
+
+func (s *testIndexInternalSuite) TestHashedColumns(c *C) {
+	tblInfo := newTestTableInfo([]string{"a"}, []int{0}, true)
+	// The length of the string is a hash with lots of collisions.
+	lenHash := func(d types.Datum) uint64 { return uint64(len(d.GetBytes())) }
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], WithHashedColumns(lenHash, 0)).(*index)
+
+	wide := strings.Repeat("x", 1024)
+	key, distinct, err := idx.GenIndexKey(s.sc, types.MakeDatums(wide), 1, nil)
+	c.Assert(err, IsNil)
+	c.Assert(distinct, IsFalse)
+	c.Assert(len(key) < 64, IsTrue)
+
+	_, err = idx.Create(s.sctx, s.store, types.MakeDatums("abc"), 1)
+	c.Assert(err, IsNil)
+	_, err = idx.Create(s.sctx, s.store, types.MakeDatums("xyz"), 2)
+	c.Assert(err, IsNil)
+
+	handles, err := idx.HashLookup(s.sc, s.store, types.MakeDatums("abc"))
+	c.Assert(err, IsNil)
+	c.Assert(handles, DeepEquals, []int64{1})
+	handles, err = idx.HashLookup(s.sc, s.store, types.MakeDatums("xyz"))
+	c.Assert(err, IsNil)
+	c.Assert(handles, DeepEquals, []int64{2})
+	handles, err = idx.HashLookup(s.sc, s.store, types.MakeDatums("qqq"))
+	c.Assert(err, IsNil)
+	c.Assert(handles, HasLen, 0)
+
+	// The same value conflicts, but a hash collision doesn't.
+	h, err := idx.Create(s.sctx, s.store, types.MakeDatums("abc"), 3)
+	c.Assert(terror.ErrorEqual(err, kv.ErrKeyExists), IsTrue, Commentf("err %v", err))
+	c.Assert(h, Equals, int64(1))
+	_, err = idx.Create(s.sctx, s.store, types.MakeDatums("qqq"), 3)
+	c.Assert(err, IsNil)
+
+	// The iterator returns the original values.
+	it, err := idx.SeekFirst(s.store)
+	c.Assert(err, IsNil)
+	defer it.Close()
+	vals, h, err := it.Next()
+	c.Assert(err, IsNil)
+	c.Assert(vals[0].GetString(), Equals, "abc")
+	c.Assert(h, Equals, int64(1))
+}
+
+func (s *testIndexInternalSuite) TestHashedPrefixConflict(c *C) {
+	lenHash := func(d types.Datum) uint64 { return uint64(len(d.GetBytes())) }
+	for _, opt := range []IndexOption{WithHashedColumns(lenHash, 0), WithSortKey(0, naturalSortKey), WithCollations()} {
+		tblInfo := newTestTableInfo([]string{"a"}, []int{0}, true)
+		tblInfo.Columns[0].Collate = "utf8mb4_general_ci"
+		tblInfo.Indices[0].Columns[0].Length = 3
+		idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], opt).(*index)
+		buf := newTestStore()
+		_, err := idx.Create(s.sctx, buf, types.MakeDatums("abcXXX"), 1)
+		c.Assert(err, IsNil)
+		// The values only share the indexed prefix, which is stored instead of the full value.
+		h, err := idx.Create(s.sctx, buf, types.MakeDatums("abcYYY"), 2)
+		c.Assert(kv.ErrKeyExists.Equal(err), IsTrue, Commentf("err %v", err))
+		c.Assert(h, Equals, int64(1))
+		if idx.hashFunc != nil {
+			handles, err := idx.HashLookup(s.sc, buf, types.MakeDatums("abcXXX"))
+			c.Assert(err, IsNil)
+			c.Assert(handles, DeepEquals, []int64{1})
+		}
+	}
+}
+
+func (s *testIndexInternalSuite) TestRangePrefixLen(c *C) {
+	c.Assert(RangePrefixLen(types.MakeDatums(1, "a", 3), types.MakeDatums(1, "a", 10)), Equals, 2)
+	c.Assert(RangePrefixLen(types.MakeDatums(1, "a", 3), types.MakeDatums(1, "a", 3)), Equals, 3)
+	c.Assert(RangePrefixLen(types.MakeDatums(1, "a"), types.MakeDatums(2, "a")), Equals, 0)
+	c.Assert(RangePrefixLen(types.MakeDatums(1, 2), types.MakeDatums(1)), Equals, 1)
+	c.Assert(RangePrefixLen(nil, nil), Equals, 0)
+}
+
+func (s *testIndexInternalSuite) TestDistinctValues(c *C) {
+	idx := s.newIndex([]string{"a", "b"}, false)
+	rows := [][]interface{}{{1, "a"}, {1, "a"}, {1, "b"}, {2, "a"}, {3, "c"}, {3, "c"}, {nil, "a"}}
+	for i, row := range rows {
+		_, err := idx.Create(s.sctx, s.store, types.MakeDatums(row...), int64(i))
+		c.Assert(err, IsNil)
+	}
+
+	collect := func(numCols int) []string {
+		it, err := idx.DistinctValues(s.sc, s.store, numCols)
+		c.Assert(err, IsNil)
+		defer it.Close()
+		var tuples []string
+		for {
+			vals, _, err := it.Next()
+			if terror.ErrorEqual(err, io.EOF) {
+				return tuples
+			}
+			c.Assert(err, IsNil)
+			c.Assert(vals, HasLen, numCols)
+			tuples = append(tuples, datumsString(c, vals))
+		}
+	}
+	c.Assert(collect(1), DeepEquals, []string{"NULL", "1", "2", "3"})
+	c.Assert(collect(2), DeepEquals, []string{"NULL,a", "1,a", "1,b", "2,a", "3,c"})
+
+	_, err := idx.DistinctValues(s.sc, s.store, 3)
+	c.Assert(err, NotNil)
+}
+
+func (s *testIndexInternalSuite) TestBinaryPadding(c *C) {
+	tblInfo := newTestTableInfo([]string{"bin", "varbin"}, []int{0, 1}, true)
+	binTp := types.NewFieldType(mysql.TypeString)
+	binTp.Flen, binTp.Charset, binTp.Collate = 4, charset.CharsetBin, charset.CollationBin
+	varbinTp := types.NewFieldType(mysql.TypeVarchar)
+	varbinTp.Flen, varbinTp.Charset, varbinTp.Collate = 4, charset.CharsetBin, charset.CollationBin
+	tblInfo.Columns[0].FieldType = *binTp
+	tblInfo.Columns[1].FieldType = *varbinTp
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+
+	genKey := func(vals ...interface{}) []byte {
+		key, _, err := idx.GenIndexKey(s.sc, types.MakeDatums(vals...), 1, nil)
+		c.Assert(err, IsNil)
+		return key
+	}
+	// BINARY pads 'ab' to 'ab\x00\x00', VARBINARY keeps it as is.
+	c.Assert(genKey([]byte("ab"), []byte("x")), BytesEquals, genKey([]byte("ab\x00\x00"), []byte("x")))
+	c.Assert(genKey("ab", []byte("x")), BytesEquals, genKey([]byte("ab\x00\x00"), []byte("x")))
+	c.Assert(genKey([]byte("ab"), []byte("x")), Not(BytesEquals), genKey([]byte("ab"), []byte("x\x00")))
+	c.Assert(bytes.Compare(genKey([]byte("ab"), []byte("x")), genKey([]byte("ab\x01"), []byte("x"))) < 0, IsTrue)
+
+	_, err := idx.Create(s.sctx, s.store, types.MakeDatums([]byte("ab\x00\x00"), []byte("x")), 1)
+	c.Assert(err, IsNil)
+	it, hit, err := idx.Seek(s.sc, s.store, types.MakeDatums([]byte("ab"), []byte("x")))
+	c.Assert(err, IsNil)
+	c.Assert(hit, IsTrue)
+	vals, _, err := it.Next()
+	c.Assert(err, IsNil)
+	c.Assert(vals[0].GetBytes(), BytesEquals, []byte("ab\x00\x00"))
+	it.Close()
+}
+
+func (s *testIndexInternalSuite) TestDeleteVerifyHandle(c *C) {
+	tblInfo := newTestTableInfo([]string{"a"}, []int{0}, true)
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0])
+	_, err := idx.Create(s.sctx, s.store, types.MakeDatums(1), 1)
+	c.Assert(err, IsNil)
+
+	// The entry was rewritten to point to handle 1, deleting it for handle 2 fails.
+	err = idx.Delete(s.sc, s.store, types.MakeDatums(1), 2, table.VerifyHandle)
+	c.Assert(terror.ErrorEqual(err, table.ErrIndexHandleMismatch), IsTrue, Commentf("err %v", err))
+	exist, _, err := idx.Exist(s.sc, s.store, types.MakeDatums(1), 1)
+	c.Assert(err, IsNil)
+	c.Assert(exist, IsTrue)
+
+	err = idx.Delete(s.sc, s.store, types.MakeDatums(1), 1, table.VerifyHandle)
+	c.Assert(err, IsNil)
+	exist, _, err = idx.Exist(s.sc, s.store, types.MakeDatums(1), 1)
+	c.Assert(err, IsNil)
+	c.Assert(exist, IsFalse)
+
+	// Deleting a missing entry is a no-op.
+	err = idx.Delete(s.sc, s.store, types.MakeDatums(1), 1, table.VerifyHandle)
+	c.Assert(err, IsNil)
+}
+
+func (s *testIndexInternalSuite) TestKeyLengthStats(c *C) {
+	idx := s.newIndex([]string{"a"}, false)
+
+	min, max, mean, histogram, err := idx.KeyLengthStats(s.store)
+	c.Assert(err, IsNil)
+	c.Assert(min, Equals, 0)
+	c.Assert(max, Equals, 0)
+	c.Assert(mean, Equals, 0)
+	c.Assert(histogram, HasLen, 0)
+
+	// The prefix is 19 bytes, a string up to 8 bytes takes 10 bytes and the handle takes 9 bytes.
+	for i, v := range []string{"a", "b", "abcdefghi", "c"} {
+		_, err = idx.Create(s.sctx, s.store, types.MakeDatums(v), int64(i))
+		c.Assert(err, IsNil)
+	}
+	min, max, mean, histogram, err = idx.KeyLengthStats(s.store)
+	c.Assert(err, IsNil)
+	c.Assert(min, Equals, 38)
+	c.Assert(max, Equals, 47)
+	c.Assert(mean, Equals, (38*3+47)/4)
+	c.Assert(histogram, DeepEquals, map[int]int{38: 3, 47: 1})
+}
+
+// dumpKVs returns all the key/value pairs in r with the prefix.
+func dumpKVs(c *C, r kv.Retriever, prefix kv.Key) [][2]string {
+	it, err := r.Iter(prefix, prefix.PrefixNext())
+	c.Assert(err, IsNil)
+	defer it.Close()
+	var kvs [][2]string
+	for it.Valid() {
+		kvs = append(kvs, [2]string{string(it.Key()), string(it.Value())})
+		c.Assert(it.Next(), IsNil)
+	}
+	return kvs
+}
+
+func (s *testIndexInternalSuite) TestInsertionSequence(c *C) {
+	idx := s.newIndex([]string{"a"}, false, WithInsertionSequence(nil))
+
+	// The handles aren't monotonic, the entries are still consumed in insertion order.
+	handles := []int64{50, 3, 99, 7, 20}
+	for _, h := range handles {
+		_, err := idx.Create(s.sctx, s.store, types.MakeDatums("q"), h)
+		c.Assert(err, IsNil)
+	}
+	_, err := idx.Create(s.sctx, s.store, types.MakeDatums("p"), 1)
+	c.Assert(err, IsNil)
+
+	consume := func() []int64 {
+		it, hit, err := idx.Seek(s.sc, s.store, types.MakeDatums("q"))
+		c.Assert(err, IsNil)
+		c.Assert(hit, IsTrue)
+		defer it.Close()
+		var got []int64
+		for {
+			vals, h, err := it.Next()
+			if terror.ErrorEqual(err, io.EOF) {
+				return got
+			}
+			c.Assert(err, IsNil)
+			c.Assert(vals, HasLen, 1)
+			got = append(got, h)
+		}
+	}
+	c.Assert(consume(), DeepEquals, handles)
+
+	// Creating an entry again keeps its position.
+	_, err = idx.Create(s.sctx, s.store, types.MakeDatums("q"), 3)
+	c.Assert(err, IsNil)
+	c.Assert(consume(), DeepEquals, handles)
+
+	exist, h, err := idx.Exist(s.sc, s.store, types.MakeDatums("q"), 99)
+	c.Assert(err, IsNil)
+	c.Assert(exist, IsTrue)
+	c.Assert(h, Equals, int64(99))
+	c.Assert(idx.Delete(s.sc, s.store, types.MakeDatums("q"), 50), IsNil)
+	c.Assert(consume(), DeepEquals, handles[1:])
+	exist, _, err = idx.Exist(s.sc, s.store, types.MakeDatums("q"), 50)
+	c.Assert(err, IsNil)
+	c.Assert(exist, IsFalse)
+
+	// A supplied sequence places the entry before the generated ones.
+	_, err = idx.Create(s.sctx, s.store, types.MakeDatums("q"), 8, table.WithSequence(0))
+	c.Assert(err, IsNil)
+	c.Assert(consume(), DeepEquals, append([]int64{8}, handles[1:]...))
+
+	// The unique check of a hashed index can't find the entries behind the sequence, so it's rejected.
+	hashed := s.newIndex([]string{"a"}, true, WithInsertionSequence(nil), WithHashedColumns(func(d types.Datum) uint64 { return 1 }, 0))
+	n := len(dumpKVs(c, s.store, hashed.prefix))
+	_, err = hashed.Create(s.sctx, s.store, types.MakeDatums("q"), 1)
+	c.Assert(err, ErrorMatches, ".*doesn't support the insertion order with hashed or sort-keyed columns")
+	c.Assert(dumpKVs(c, s.store, hashed.prefix), HasLen, n)
+}
+
+func (s *testIndexInternalSuite) TestTruncateIdempotent(c *C) {
+	tblInfo := newTestTableInfo([]string{"a", "b"}, []int{0, 1}, false)
+	tblInfo.Columns[0].Charset = charset.CharsetUTF8MB4
+	tblInfo.Columns[1].Charset = charset.CharsetBin
+	tblInfo.Indices[0].Columns[0].Length = 2
+	tblInfo.Indices[0].Columns[1].Length = 3
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0])
+
+	values := types.MakeDatums("你好世界", []byte("abcdef"))
+	key1, _, err := idx.GenIndexKey(s.sc, values, 1, nil)
+	c.Assert(err, IsNil)
+	// The values aren't truncated in place.
+	c.Assert(values[0].GetString(), Equals, "你好世界")
+	c.Assert(values[1].GetBytes(), BytesEquals, []byte("abcdef"))
+	key2, _, err := idx.GenIndexKey(s.sc, values, 1, nil)
+	c.Assert(err, IsNil)
+	c.Assert(key2, BytesEquals, key1)
+
+	// Truncating the truncated values again doesn't change them.
+	truncated := TruncateIndexValuesIfNeeded(tblInfo, tblInfo.Indices[0], values)
+	c.Assert(datumsString(c, truncated), Equals, "你好,abc")
+	c.Assert(datumsString(c, TruncateIndexValuesIfNeeded(tblInfo, tblInfo.Indices[0], truncated)), Equals, "你好,abc")
+	key3, _, err := idx.GenIndexKey(s.sc, truncated, 1, nil)
+	c.Assert(err, IsNil)
+	c.Assert(key3, BytesEquals, key1)
+
+	// The entry created from the values is deleted by the same values.
+	_, err = idx.Create(s.sctx, s.store, values, 1)
+	c.Assert(err, IsNil)
+	c.Assert(idx.Delete(s.sc, s.store, values, 1), IsNil)
+	c.Assert(dumpKVs(c, s.store, tablecodec.EncodeTableIndexPrefix(tblInfo.ID, tblInfo.Indices[0].ID)), HasLen, 0)
+}
+
+func (s *testIndexInternalSuite) TestHandleEncodings(c *C) {
+	idx := s.newIndex([]string{"a"}, true)
+
+	for _, h := range []int64{0, 1, -1, 1 << 40, math.MinInt64, math.MaxInt64} {
+		buf := newTestStore()
+		// A distinct entry has the raw handle in the value.
+		_, err := idx.Create(s.sctx, buf, types.MakeDatums(1), h)
+		c.Assert(err, IsNil)
+		// A NULL entry of a unique index isn't distinct, it has the handle datum in the key.
+		_, err = idx.Create(s.sctx, buf, types.MakeDatums(nil), h)
+		c.Assert(err, IsNil)
+
+		kvs := dumpKVs(c, buf, idx.prefix)
+		c.Assert(kvs, HasLen, 2)
+		nullKey, nullValue := kvs[0][0], kvs[0][1]
+		distinctKey, distinctValue := kvs[1][0], kvs[1][1]
+
+		// The values are in the versioned envelope without any field.
+		c.Assert([]byte(distinctValue), BytesEquals, append([]byte{kv.IndexValueVersion, 0}, EncodeHandle(h)...))
+		rawHandle, err := DecodeHandle([]byte(distinctValue[2:]))
+		c.Assert(err, IsNil)
+
+		handleDatum, err := codec.EncodeKey(s.sc, nil, types.NewIntDatum(h))
+		c.Assert(err, IsNil)
+		c.Assert(handleDatum, HasLen, 9)
+		c.Assert(strings.HasSuffix(nullKey, string(handleDatum)), IsTrue)
+		c.Assert(nullValue, Equals, "\xfe\x000")
+		_, d, err := codec.DecodeOne([]byte(nullKey[len(nullKey)-9:]))
+		c.Assert(err, IsNil)
+
+		c.Assert(rawHandle, Equals, h)
+		c.Assert(d.GetInt64(), Equals, h)
+		_, h1, err := idx.decodeEntry([]byte(distinctKey), []byte(distinctValue))
+		c.Assert(err, IsNil)
+		_, h2, err := idx.decodeEntry([]byte(nullKey), []byte(nullValue))
+		c.Assert(err, IsNil)
+		c.Assert(h1, Equals, h)
+		c.Assert(h2, Equals, h)
+	}
+}
+
+// sliceRows returns a row source of RepairFromTable over rows, using the row offsets as the handles.
+func sliceRows(rows [][]types.Datum) func() ([]types.Datum, int64, bool, error) {
+	i := 0
+	return func() ([]types.Datum, int64, bool, error) {
+		if i >= len(rows) {
+			return nil, 0, false, nil
+		}
+		i++
+		return rows[i-1], int64(i - 1), true, nil
+	}
+}
+
+func (s *testIndexInternalSuite) TestRepairFromTable(c *C) {
+	tblInfo := newTestTableInfo([]string{"a", "b"}, []int{1}, true)
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+	rows := [][]types.Datum{types.MakeDatums(1, "a"), types.MakeDatums(2, "b"), types.MakeDatums(3, "c")}
+	for i, row := range rows {
+		_, err := idx.Create(s.sctx, s.store, row[1:], int64(i))
+		c.Assert(err, IsNil)
+	}
+	expected := dumpKVs(c, s.store, idx.prefix)
+	c.Assert(idx.Delete(s.sc, s.store, rows[1][1:], 1), IsNil)
+
+	created, err := idx.RepairFromTable(s.sctx, s.store, sliceRows(rows))
+	c.Assert(err, IsNil)
+	c.Assert(created, Equals, 1)
+	c.Assert(dumpKVs(c, s.store, idx.prefix), DeepEquals, expected)
+
+	created, err = idx.RepairFromTable(s.sctx, s.store, sliceRows(rows))
+	c.Assert(err, IsNil)
+	c.Assert(created, Equals, 0)
+}
+
+func (s *testIndexInternalSuite) TestRepairEntry(c *C) {
+	for _, unique := range []bool{true, false} {
+		s.store = newTestStore()
+		tblInfo := newTestTableInfo([]string{"a", "b"}, []int{1}, unique)
+		idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+		rows := [][]types.Datum{types.MakeDatums(1, "a"), types.MakeDatums(2, "b"), types.MakeDatums(3, "c")}
+		for i, row := range rows {
+			_, err := idx.Create(s.sctx, s.store, row[1:], int64(i))
+			c.Assert(err, IsNil)
+		}
+		expected := dumpKVs(c, s.store, idx.prefix)
+
+		// Corrupt the value of the entry of row 1 and delete the entry of row 2.
+		key1, _, err := idx.GenIndexKey(s.sc, rows[1][1:], 1, nil)
+		c.Assert(err, IsNil)
+		c.Assert(s.store.Set(key1, []byte("xx")), IsNil)
+		c.Assert(idx.Delete(s.sc, s.store, rows[2][1:], 2), IsNil)
+		c.Assert(dumpKVs(c, s.store, idx.prefix), Not(DeepEquals), expected)
+
+		for i := 1; i <= 2; i++ {
+			c.Assert(idx.RepairEntry(s.sctx, s.store, rows[i], int64(i)), IsNil)
+		}
+		c.Assert(dumpKVs(c, s.store, idx.prefix), DeepEquals, expected)
+		exist, h, err := idx.Exist(s.sc, s.store, rows[1][1:], 1)
+		c.Assert(err, IsNil)
+		c.Assert(exist, IsTrue)
+		c.Assert(h, Equals, int64(1))
+		// Repairing again changes nothing.
+		c.Assert(idx.RepairEntry(s.sctx, s.store, rows[1], 1), IsNil)
+		c.Assert(dumpKVs(c, s.store, idx.prefix), DeepEquals, expected)
+
+		// A row sharing the values of another row's unique entry can't take it over.
+		err = idx.RepairEntry(s.sctx, s.store, types.MakeDatums(9, "a"), 9)
+		if unique {
+			c.Assert(kv.ErrKeyExists.Equal(err), IsTrue, Commentf("err %v", err))
+			c.Assert(dumpKVs(c, s.store, idx.prefix), DeepEquals, expected)
+		} else {
+			c.Assert(err, IsNil)
+		}
+	}
+}
+
+func (s *testIndexInternalSuite) TestTenantPrefix(c *C) {
+	tblInfo := newTestTableInfo([]string{"tenant_id", "a"}, []int{0, 1}, false)
+	shared := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0])
+	tenant2 := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], WithTenantPrefix(2))
+	rows := [][]interface{}{{1, "a"}, {1, "z"}, {2, "b"}, {2, "c"}, {3, "a"}, {nil, "a"}}
+	for i, row := range rows {
+		_, err := shared.Create(s.sctx, s.store, types.MakeDatums(row...), int64(i))
+		c.Assert(err, IsNil)
+	}
+
+	collect := func(it table.IndexIterator) []string {
+		defer it.Close()
+		var got []string
+		for {
+			vals, _, err := it.Next()
+			if terror.ErrorEqual(err, io.EOF) {
+				return got
+			}
+			c.Assert(err, IsNil)
+			got = append(got, datumsString(c, vals))
+		}
+	}
+	it, err := tenant2.SeekFirst(s.store)
+	c.Assert(err, IsNil)
+	c.Assert(collect(it), DeepEquals, []string{"2,b", "2,c"})
+
+	// A predicate which seeks from a lower value of the tenant still stops at the tenant's end.
+	it, _, err = tenant2.Seek(s.sc, s.store, types.MakeDatums(2, "a"))
+	c.Assert(err, IsNil)
+	c.Assert(collect(it), DeepEquals, []string{"2,b", "2,c"})
+
+	// A predicate on another tenant fails instead of reading its entries.
+	_, _, err = tenant2.Seek(s.sc, s.store, types.MakeDatums(1, "a"))
+	c.Assert(err, NotNil)
+	_, err = tenant2.Create(s.sctx, s.store, types.MakeDatums(3, "x"), 10)
+	c.Assert(err, NotNil)
+
+	_, done, err := tenant2.Drop(s.store)
+	c.Assert(err, IsNil)
+	c.Assert(done, IsTrue)
+	it, err = shared.SeekFirst(s.store)
+	c.Assert(err, IsNil)
+	c.Assert(collect(it), DeepEquals, []string{"NULL,a", "1,a", "1,z", "3,a"})
+}
+
+func (s *testIndexInternalSuite) TestFirstLastKey(c *C) {
+	idx := s.newIndex([]string{"a"}, false)
+	// Another index of the table after this one.
+	otherInfo := newTestTableInfo([]string{"a"}, []int{0}, false).Indices[0]
+	otherInfo.ID = 3
+	other := NewIndex(idx.tblInfo.ID, idx.tblInfo, otherInfo)
+	_, err := other.Create(s.sctx, s.store, types.MakeDatums(0), 1)
+	c.Assert(err, IsNil)
+
+	_, ok, err := idx.FirstKey(s.store)
+	c.Assert(err, IsNil)
+	c.Assert(ok, IsFalse)
+	_, ok, err = idx.LastKey(s.store)
+	c.Assert(err, IsNil)
+	c.Assert(ok, IsFalse)
+
+	for i, v := range []int64{5, -3, 42, 7} {
+		_, err = idx.Create(s.sctx, s.store, types.MakeDatums(v), int64(i))
+		c.Assert(err, IsNil)
+	}
+	minKey, _, err := idx.GenIndexKey(s.sc, types.MakeDatums(-3), 1, nil)
+	c.Assert(err, IsNil)
+	maxKey, _, err := idx.GenIndexKey(s.sc, types.MakeDatums(42), 2, nil)
+	c.Assert(err, IsNil)
+	first, ok, err := idx.FirstKey(s.store)
+	c.Assert(err, IsNil)
+	c.Assert(ok, IsTrue)
+	c.Assert(first, BytesEquals, minKey)
+	last, ok, err := idx.LastKey(s.store)
+	c.Assert(err, IsNil)
+	c.Assert(ok, IsTrue)
+	c.Assert(last, BytesEquals, maxKey)
+}
+
+func (s *testIndexInternalSuite) TestNullsLast(c *C) {
+	idx := s.newIndex([]string{"a", "b"}, false, WithNullsLast())
+	rows := [][]interface{}{{nil, 1}, {3, nil}, {nil, nil}, {1, 2}, {int64(math.MaxInt64), 0}}
+	for i, row := range rows {
+		_, err := idx.Create(s.sctx, s.store, types.MakeDatums(row...), int64(i))
+		c.Assert(err, IsNil)
+	}
+
+	it, err := idx.SeekFirst(s.store)
+	c.Assert(err, IsNil)
+	var got []string
+	for {
+		vals, _, err := it.Next()
+		if terror.ErrorEqual(err, io.EOF) {
+			break
+		}
+		c.Assert(err, IsNil)
+		got = append(got, datumsString(c, vals))
+	}
+	it.Close()
+	// Only the leading column sorts NULL last.
+	c.Assert(got, DeepEquals, []string{"1,2", "3,NULL", "9223372036854775807,0", "NULL,NULL", "NULL,1"})
+
+	it, hit, err := idx.Seek(s.sc, s.store, types.MakeDatums(nil, 1))
+	c.Assert(err, IsNil)
+	c.Assert(hit, IsTrue)
+	vals, h, err := it.Next()
+	c.Assert(err, IsNil)
+	c.Assert(datumsString(c, vals), Equals, "NULL,1")
+	c.Assert(h, Equals, int64(0))
+	it.Close()
+
+	distinct, err := idx.DistinctValues(s.sc, s.store, 1)
+	c.Assert(err, IsNil)
+	var tuples []string
+	for {
+		vals, _, err := distinct.Next()
+		if terror.ErrorEqual(err, io.EOF) {
+			break
+		}
+		c.Assert(err, IsNil)
+		tuples = append(tuples, datumsString(c, vals))
+	}
+	c.Assert(tuples, DeepEquals, []string{"1", "3", "9223372036854775807", "NULL"})
+
+	c.Assert(idx.Delete(s.sc, s.store, types.MakeDatums(nil, 1), 0), IsNil)
+	exist, _, err := idx.Exist(s.sc, s.store, types.MakeDatums(nil, 1), 0)
+	c.Assert(err, IsNil)
+	c.Assert(exist, IsFalse)
+}
+
+// naturalSortKey pads every run of digits to 20 digits, so the numbers in the strings compare numerically.
+func naturalSortKey(d types.Datum) []byte {
+	var key []byte
+	str := d.GetString()
+	for i := 0; i < len(str); {
+		j := i
+		for j < len(str) && str[j] >= '0' && str[j] <= '9' {
+			j++
+		}
+		if j == i {
+			key = append(key, str[i])
+			i++
+			continue
+		}
+		key = append(key, strings.Repeat("0", 20-(j-i))...)
+		key = append(key, str[i:j]...)
+		i = j
+	}
+	return key
+}
+
+func (s *testIndexInternalSuite) TestSortKey(c *C) {
+	idx := s.newIndex([]string{"a"}, true, WithSortKey(0, naturalSortKey))
+	for i, v := range []string{"a10", "a2", "b1", "a1", "a02"} {
+		_, err := idx.Create(s.sctx, s.store, types.MakeDatums(v), int64(i))
+		c.Assert(err, IsNil)
+	}
+
+	it, err := idx.SeekFirst(s.store)
+	c.Assert(err, IsNil)
+	var got []string
+	for {
+		vals, _, err := it.Next()
+		if terror.ErrorEqual(err, io.EOF) {
+			break
+		}
+		c.Assert(err, IsNil)
+		got = append(got, datumsString(c, vals))
+	}
+	it.Close()
+	// "a02" and "a2" share the sort key, they're ordered by handle.
+	c.Assert(got, DeepEquals, []string{"a1", "a2", "a02", "a10", "b1"})
+
+	// Seek uses the same sort key.
+	it, _, err = idx.Seek(s.sc, s.store, types.MakeDatums("a3"))
+	c.Assert(err, IsNil)
+	vals, h, err := it.Next()
+	c.Assert(err, IsNil)
+	c.Assert(datumsString(c, vals), Equals, "a10")
+	c.Assert(h, Equals, int64(0))
+	it.Close()
+
+	// The unique check compares the original values.
+	h, err = idx.Create(s.sctx, s.store, types.MakeDatums("a2"), 10)
+	c.Assert(terror.ErrorEqual(err, kv.ErrKeyExists), IsTrue, Commentf("err %v", err))
+	c.Assert(h, Equals, int64(1))
+	handles, err := idx.HashLookup(s.sc, s.store, types.MakeDatums("a02"))
+	c.Assert(err, IsNil)
+	c.Assert(handles, DeepEquals, []int64{4})
+}
+
+func (s *testIndexInternalSuite) TestPrefixConflictDiagnostics(c *C) {
+	tblInfo := newTestTableInfo([]string{"a"}, []int{0}, true)
+	tblInfo.Columns[0].Charset = charset.CharsetUTF8MB4
+	tblInfo.Indices[0].Columns[0].Length = 5
+	rows := map[int64][]types.Datum{}
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], WithPrefixConflictDiagnostics(func(h int64) ([]types.Datum, error) {
+		return rows[h], nil
+	}))
+	rows[1] = types.MakeDatums("abcdexyz")
+	_, err := idx.Create(s.sctx, s.store, rows[1], 1)
+	c.Assert(err, IsNil)
+
+	h, err := idx.Create(s.sctx, s.store, types.MakeDatums("abcde123"), 2)
+	c.Assert(terror.ErrorEqual(err, kv.ErrKeyExists), IsTrue, Commentf("err %v", err))
+	c.Assert(h, Equals, int64(1))
+	c.Assert(err.Error(), Matches, ".*caused by the prefix length.*")
+	c.Assert(strings.Contains(err.Error(), "abcde123"), IsTrue)
+	c.Assert(strings.Contains(err.Error(), "abcdexyz"), IsTrue)
+
+	// An exact duplicate isn't flagged.
+	_, err = idx.Create(s.sctx, s.store, types.MakeDatums("abcdexyz"), 3)
+	c.Assert(terror.ErrorEqual(err, kv.ErrKeyExists), IsTrue, Commentf("err %v", err))
+	c.Assert(strings.Contains(err.Error(), "prefix"), IsFalse)
+}
+
+func (s *testIndexInternalSuite) TestExpressionColumn(c *C) {
+	tblInfo := newTestTableInfo([]string{"a", "b"}, []int{0, 1}, true)
+	lower := func(row []types.Datum) (types.Datum, error) {
+		if row[1].IsNull() {
+			return types.Datum{}, nil
+		}
+		return types.NewStringDatum(strings.ToLower(row[1].GetString())), nil
+	}
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], WithExpressionColumn(1, lower))
+	create := func(h int64, row ...interface{}) error {
+		vals, err := idx.FetchValues(types.MakeDatums(row...), nil)
+		c.Assert(err, IsNil)
+		_, err = idx.Create(s.sctx, s.store, vals, h)
+		return err
+	}
+
+	vals, err := idx.FetchValues(types.MakeDatums(1, "Foo"), nil)
+	c.Assert(err, IsNil)
+	c.Assert(datumsString(c, vals), Equals, "1,foo")
+	key, distinct, err := idx.GenIndexKey(s.sc, vals, 1, nil)
+	c.Assert(err, IsNil)
+	c.Assert(distinct, IsTrue)
+	expected, _, err := idx.GenIndexKey(s.sc, types.MakeDatums(1, "foo"), 1, nil)
+	c.Assert(err, IsNil)
+	c.Assert(key, BytesEquals, expected)
+
+	c.Assert(create(1, 1, "Foo"), IsNil)
+	err = create(2, 1, "FOO")
+	c.Assert(terror.ErrorEqual(err, kv.ErrKeyExists), IsTrue, Commentf("err %v", err))
+	c.Assert(create(3, 2, "FOO"), IsNil)
+	// A NULL expression value isn't distinct.
+	vals, err = idx.FetchValues(types.MakeDatums(1, nil), nil)
+	c.Assert(err, IsNil)
+	_, distinct, err = idx.GenIndexKey(s.sc, vals, 4, nil)
+	c.Assert(err, IsNil)
+	c.Assert(distinct, IsFalse)
+	c.Assert(create(4, 1, nil), IsNil)
+	c.Assert(create(5, 1, nil), IsNil)
+}
+
+func (s *testIndexInternalSuite) TestFormatMagic(c *C) {
+	idx := s.newIndex([]string{"a"}, true, WithFormatMagic())
+	_, err := idx.Create(s.sctx, s.store, types.MakeDatums(1), 300)
+	c.Assert(err, IsNil)
+	kvs := dumpKVs(c, s.store, idx.prefix)
+	c.Assert(kvs, HasLen, 1)
+	c.Assert([]byte(kvs[0][1]), BytesEquals, append(append([]byte{kv.IndexValueVersion, 0}, EncodeHandle(300)...), handleFormatMagic))
+	exist, h, err := idx.Exist(s.sc, s.store, types.MakeDatums(1), 300)
+	c.Assert(err, IsNil)
+	c.Assert(exist, IsTrue)
+	c.Assert(h, Equals, int64(300))
+
+	// An entry written by a build with a little-endian handle and its own magic byte.
+	key, _, err := idx.GenIndexKey(s.sc, types.MakeDatums(2), 0, nil)
+	c.Assert(err, IsNil)
+	value := make([]byte, 8, 9)
+	binary.LittleEndian.PutUint64(value, 300)
+	c.Assert(s.store.Set(key, append(value, 0x1e)), IsNil)
+	_, _, err = idx.Exist(s.sc, s.store, types.MakeDatums(2), 300)
+	c.Assert(terror.ErrorEqual(err, table.ErrIndexFormatMismatch), IsTrue, Commentf("err %v", err))
+	it, _, err := idx.Seek(s.sc, s.store, types.MakeDatums(2))
+	c.Assert(err, IsNil)
+	_, _, err = it.Next()
+	c.Assert(terror.ErrorEqual(err, table.ErrIndexFormatMismatch), IsTrue, Commentf("err %v", err))
+	it.Close()
+
+	// A bare handle written without the magic byte is rejected too.
+	key, _, err = idx.GenIndexKey(s.sc, types.MakeDatums(3), 0, nil)
+	c.Assert(err, IsNil)
+	c.Assert(s.store.Set(key, EncodeHandle(7)), IsNil)
+	_, _, err = idx.Exist(s.sc, s.store, types.MakeDatums(3), 7)
+	c.Assert(terror.ErrorEqual(err, table.ErrIndexFormatMismatch), IsTrue, Commentf("err %v", err))
+}
+
+func (s *testIndexInternalSuite) TestNextNamed(c *C) {
+	tblInfo := newTestTableInfo([]string{"a", "B", "c"}, []int{2, 1}, false)
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0])
+	_, err := idx.Create(s.sctx, s.store, types.MakeDatums("x", 1), 5)
+	c.Assert(err, IsNil)
+
+	it, err := idx.SeekFirst(s.store)
+	c.Assert(err, IsNil)
+	defer it.Close()
+	named, h, err := it.(NamedIndexIterator).NextNamed()
+	c.Assert(err, IsNil)
+	c.Assert(h, Equals, int64(5))
+	c.Assert(named, HasLen, 2)
+	c.Assert(named[0].Name, Equals, "c")
+	c.Assert(named[0].Datum.GetString(), Equals, "x")
+	c.Assert(named[1].Name, Equals, "B")
+	c.Assert(named[1].Datum.GetInt64(), Equals, int64(1))
+	_, _, err = it.(NamedIndexIterator).NextNamed()
+	c.Assert(terror.ErrorEqual(err, io.EOF), IsTrue)
+}
+
+func (s *testIndexInternalSuite) TestSwapPrefixes(c *C) {
+	tblInfo := newTestTableInfo([]string{"a"}, []int{0}, true)
+	part := NewIndex(10, tblInfo, tblInfo.Indices[0]).(*index)
+	standalone := NewIndex(20, tblInfo, tblInfo.Indices[0]).(*index)
+	for i := 0; i < 3; i++ {
+		_, err := part.Create(s.sctx, s.store, types.MakeDatums(i), int64(i))
+		c.Assert(err, IsNil)
+	}
+	_, err := standalone.Create(s.sctx, s.store, types.MakeDatums("x"), 100)
+	c.Assert(err, IsNil)
+
+	collect := func(idx *index) []string {
+		it, err := idx.SeekFirst(s.store)
+		c.Assert(err, IsNil)
+		defer it.Close()
+		var got []string
+		for {
+			vals, h, err := it.Next()
+			if terror.ErrorEqual(err, io.EOF) {
+				return got
+			}
+			c.Assert(err, IsNil)
+			got = append(got, fmt.Sprintf("%s:%d", datumsString(c, vals), h))
+		}
+	}
+	partEntries, standaloneEntries := collect(part), collect(standalone)
+	c.Assert(part.SwapPrefixes(s.store, 20), IsNil)
+	c.Assert(collect(part), DeepEquals, standaloneEntries)
+	c.Assert(collect(standalone), DeepEquals, partEntries)
+
+	c.Assert(standalone.SwapPrefixes(s.store, 10), IsNil)
+	c.Assert(collect(part), DeepEquals, partEntries)
+	c.Assert(collect(standalone), DeepEquals, standaloneEntries)
+}
+
+func (s *testIndexInternalSuite) TestCoveringScan(c *C) {
+	tblInfo := newTestTableInfo([]string{"id", "a", "b", "c"}, []int{2, 1}, false)
+	tblInfo.PKIsHandle = true
+	tblInfo.Columns[0].Flag = mysql.PriKeyFlag
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+	rows := map[int64][]types.Datum{
+		1: types.MakeDatums(1, "x", 30, "c1"),
+		2: types.MakeDatums(2, "y", 10, "c2"),
+		3: types.MakeDatums(3, "z", 20, "c3"),
+	}
+	for h, row := range rows {
+		vals, err := idx.FetchValues(row, nil)
+		c.Assert(err, IsNil)
+		_, err = idx.Create(s.sctx, s.store, vals, h)
+		c.Assert(err, IsNil)
+	}
+	projection := []int{1, 0, 2}
+
+	// The index scan followed by the row lookups.
+	lookups := 0
+	fetchRow := func(h int64) []types.Datum {
+		lookups++
+		return rows[h]
+	}
+	var expected []string
+	it, err := idx.SeekFirst(s.store)
+	c.Assert(err, IsNil)
+	for {
+		_, h, err := it.Next()
+		if terror.ErrorEqual(err, io.EOF) {
+			break
+		}
+		c.Assert(err, IsNil)
+		row := fetchRow(h)
+		expected = append(expected, datumsString(c, []types.Datum{row[1], row[0], row[2]}))
+	}
+	it.Close()
+	c.Assert(lookups, Equals, 3)
+
+	lookups = 0
+	var got []string
+	it, err = idx.CoveringScan(s.sc, s.store, projection)
+	c.Assert(err, IsNil)
+	for {
+		row, _, err := it.Next()
+		if terror.ErrorEqual(err, io.EOF) {
+			break
+		}
+		c.Assert(err, IsNil)
+		got = append(got, datumsString(c, row))
+	}
+	it.Close()
+	c.Assert(got, DeepEquals, expected)
+	c.Assert(lookups, Equals, 0)
+
+	_, err = idx.CoveringScan(s.sc, s.store, []int{3})
+	c.Assert(err, NotNil)
+}
+
+func (s *testIndexInternalSuite) TestAggScan(c *C) {
+	idx := s.newIndex([]string{"a", "b"}, false)
+
+	for _, agg := range []AggKind{AggMin, AggMax} {
+		d, err := idx.AggScan(s.sc, s.store, agg, 0)
+		c.Assert(err, IsNil)
+		c.Assert(d.IsNull(), IsTrue)
+	}
+	d, err := idx.AggScan(s.sc, s.store, AggCount, 0)
+	c.Assert(err, IsNil)
+	c.Assert(d.GetInt64(), Equals, int64(0))
+
+	rows := [][]interface{}{{nil, 1}, {17, nil}, {-4, 2}, {99, 3}, {5, nil}, {nil, 4}, {5, 5}}
+	for i, row := range rows {
+		_, err = idx.Create(s.sctx, s.store, types.MakeDatums(row...), int64(i))
+		c.Assert(err, IsNil)
+	}
+	var min, max, countA, countB int64
+	min, max = math.MaxInt64, math.MinInt64
+	for _, row := range rows {
+		if row[0] != nil {
+			v := int64(row[0].(int))
+			if v < min {
+				min = v
+			}
+			if v > max {
+				max = v
+			}
+			countA++
+		}
+		if row[1] != nil {
+			countB++
+		}
+	}
+	d, err = idx.AggScan(s.sc, s.store, AggMin, 0)
+	c.Assert(err, IsNil)
+	c.Assert(d.GetInt64(), Equals, min)
+	d, err = idx.AggScan(s.sc, s.store, AggMax, 0)
+	c.Assert(err, IsNil)
+	c.Assert(d.GetInt64(), Equals, max)
+	d, err = idx.AggScan(s.sc, s.store, AggCount, 0)
+	c.Assert(err, IsNil)
+	c.Assert(d.GetInt64(), Equals, countA)
+	d, err = idx.AggScan(s.sc, s.store, AggCount, 1)
+	c.Assert(err, IsNil)
+	c.Assert(d.GetInt64(), Equals, countB)
+
+	_, err = idx.AggScan(s.sc, s.store, AggMax, 1)
+	c.Assert(err, NotNil)
+}
+
+func (s *testIndexInternalSuite) TestFindDuplicateIndexes(c *C) {
+	cols := []string{"a", "b", "c"}
+	newIdx := func(id int64, unique bool, offsets ...int) *model.IndexInfo {
+		idxInfo := newTestTableInfo(cols, offsets, unique).Indices[0]
+		idxInfo.ID = id
+		return idxInfo
+	}
+	tblInfo := newTestTableInfo(cols, nil, false)
+	tblInfo.Indices = []*model.IndexInfo{
+		newIdx(1, false, 0, 1),
+		newIdx(2, false, 1, 0),
+		newIdx(3, true, 0, 1),
+		newIdx(4, false, 0, 1),
+		newIdx(5, false, 0),
+		newIdx(6, false, 0, 1),
+	}
+	prefixed := newIdx(7, false, 0, 1)
+	prefixed.Columns[1].Length = 3
+	tblInfo.Indices = append(tblInfo.Indices, prefixed)
+
+	c.Assert(FindDuplicateIndexes(tblInfo), DeepEquals, [][2]int64{{1, 4}, {1, 6}, {4, 6}})
+	tblInfo.Indices = tblInfo.Indices[1:4]
+	c.Assert(FindDuplicateIndexes(tblInfo), HasLen, 0)
+}
+
+func (s *testIndexInternalSuite) TestWriteTime(c *C) {
+	now := time.Unix(1700000000, 123456789)
+	clock := func() time.Time { return now }
+	for _, unique := range []bool{true, false} {
+		tblInfo := newTestTableInfo([]string{"a"}, []int{0}, unique)
+		idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], WithWriteTime(clock)).(*index)
+		buf := newTestStore()
+		_, err := idx.Create(s.sctx, buf, types.MakeDatums(1), 10)
+		c.Assert(err, IsNil)
+		// An entry written before the index stamps its entries, a '0' or a handle ending with 0x01.
+		key, distinct, err := idx.GenIndexKey(s.sc, types.MakeDatums(2), 1, nil)
+		c.Assert(err, IsNil)
+		value := []byte{'0'}
+		if distinct {
+			value = EncodeHandle(1)
+		}
+		c.Assert(buf.Set(key, value), IsNil)
+		exist, h, err := idx.Exist(s.sc, buf, types.MakeDatums(2), 1)
+		c.Assert(err, IsNil)
+		c.Assert(exist, IsTrue)
+		c.Assert(h, Equals, int64(1))
+
+		exist, h, err = idx.Exist(s.sc, buf, types.MakeDatums(1), 10)
+		c.Assert(err, IsNil)
+		c.Assert(exist, IsTrue)
+		c.Assert(h, Equals, int64(10))
+		ts, ok, err := idx.WriteTime(s.sc, buf, types.MakeDatums(1), 10)
+		c.Assert(err, IsNil)
+		c.Assert(ok, IsTrue)
+		c.Assert(ts.Equal(now), IsTrue, Commentf("unique %v, ts %v", unique, ts))
+		_, ok, err = idx.WriteTime(s.sc, buf, types.MakeDatums(3), 10)
+		c.Assert(err, IsNil)
+		c.Assert(ok, IsFalse)
+
+		it, err := idx.SeekFirst(buf)
+		c.Assert(err, IsNil)
+		vals, h, ts, err := it.(*indexIter).NextWithWriteTime()
+		c.Assert(err, IsNil)
+		c.Assert(datumsString(c, vals), Equals, "1")
+		c.Assert(h, Equals, int64(10))
+		c.Assert(ts.Equal(now), IsTrue)
+		_, h, ts, err = it.(*indexIter).NextWithWriteTime()
+		c.Assert(err, IsNil)
+		c.Assert(h, Equals, int64(1))
+		c.Assert(ts.IsZero(), IsTrue)
+		it.Close()
+	}
+
+	// The default format has no stamp, the envelope has no field.
+	tblInfo := newTestTableInfo([]string{"a"}, []int{0}, false)
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+	buf := newTestStore()
+	_, err := idx.Create(s.sctx, buf, types.MakeDatums(1), 10)
+	c.Assert(err, IsNil)
+	kvs := dumpKVs(c, buf, idx.prefix)
+	c.Assert(kvs[0][1], Equals, "\xfe\x000")
+}
+
+func (s *testIndexInternalSuite) TestRawOrderScan(c *C) {
+	tblInfo := newTestTableInfo([]string{"a"}, []int{0}, false)
+	tblInfo.Columns[0].Collate = "utf8mb4_general_ci"
+	ciSortKey := func(d types.Datum) []byte { return []byte(strings.ToLower(d.GetString())) }
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], WithSortKey(0, ciSortKey)).(*index)
+	buf := newTestStore()
+	for i, v := range []string{"b", "A", "a", "C", "B"} {
+		_, err := idx.Create(s.sctx, buf, types.MakeDatums(v), int64(i))
+		c.Assert(err, IsNil)
+	}
+	scan := func(it table.IndexIterator) []string {
+		defer it.Close()
+		var got []string
+		for {
+			vals, h, err := it.Next()
+			if terror.ErrorEqual(err, io.EOF) {
+				return got
+			}
+			c.Assert(err, IsNil)
+			got = append(got, fmt.Sprintf("%s/%d", datumsString(c, vals), h))
+		}
+	}
+
+	it, err := idx.SeekFirst(buf)
+	c.Assert(err, IsNil)
+	c.Assert(scan(it), DeepEquals, []string{"A/1", "a/2", "b/0", "B/4", "C/3"})
+	it, err = idx.RawOrderScan(s.sc, buf)
+	c.Assert(err, IsNil)
+	c.Assert(scan(it), DeepEquals, []string{"A/1", "B/4", "C/3", "a/2", "b/0"})
+
+	// Without sort keys both orders are the key order.
+	tblInfo = newTestTableInfo([]string{"a"}, []int{0}, false)
+	idx = NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+	buf = newTestStore()
+	for i, v := range []string{"b", "A", "a"} {
+		_, err = idx.Create(s.sctx, buf, types.MakeDatums(v), int64(i))
+		c.Assert(err, IsNil)
+	}
+	it, err = idx.RawOrderScan(s.sc, buf)
+	c.Assert(err, IsNil)
+	c.Assert(scan(it), DeepEquals, []string{"A/1", "a/2", "b/0"})
+}
+
+func (s *testIndexInternalSuite) TestGenIndexKeyPadded(c *C) {
+	for _, nullsLast := range []bool{false, true} {
+		for _, unique := range []bool{false, true} {
+			tblInfo := newTestTableInfo([]string{"a", "b", "c"}, []int{0, 1, 2}, unique)
+			var opts []IndexOption
+			if nullsLast {
+				opts = append(opts, WithNullsLast())
+			}
+			idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], opts...).(*index)
+			buf := newTestStore()
+			rows := [][]interface{}{
+				{1, nil, nil}, {1, nil, "x"}, {1, 0, ""}, {1, 5, "z"}, {1, math.MaxInt64, nil},
+				{0, 9, "z"}, {2, nil, nil}, {nil, 1, "a"},
+			}
+			for i, row := range rows {
+				_, err := idx.Create(s.sctx, buf, types.MakeDatums(row...), int64(i))
+				c.Assert(err, IsNil)
+			}
+			comment := Commentf("nullsLast %v, unique %v", nullsLast, unique)
+			for _, partial := range [][]interface{}{{}, {1}, {1, nil}, {1, 5}, {nil}} {
+				min, err := idx.GenIndexKeyPaddedMin(s.sc, types.MakeDatums(partial...))
+				c.Assert(err, IsNil)
+				max, err := idx.GenIndexKeyPaddedMax(s.sc, types.MakeDatums(partial...))
+				c.Assert(err, IsNil)
+				var count int
+				for _, row := range rows {
+					key, _, err := idx.GenIndexKey(s.sc, types.MakeDatums(row...), math.MaxInt64, nil)
+					c.Assert(err, IsNil)
+					prefixKey, err := idx.genValuesKey(s.sc, types.MakeDatums(row[:len(partial)]...))
+					c.Assert(err, IsNil)
+					partialKey, err := idx.genValuesKey(s.sc, types.MakeDatums(partial...))
+					c.Assert(err, IsNil)
+					if !bytes.Equal(prefixKey, partialKey) {
+						continue
+					}
+					count++
+					c.Assert(bytes.Compare(min, key) <= 0, IsTrue, comment)
+					c.Assert(bytes.Compare(key, max) < 0, IsTrue, comment)
+				}
+				// The padded keys bound a range with exactly the entries of the prefix.
+				it, err := buf.Iter(min, max)
+				c.Assert(err, IsNil)
+				var scanned int
+				for it.Valid() && bytes.Compare(it.Key(), max) < 0 {
+					scanned++
+					c.Assert(it.Next(), IsNil)
+				}
+				it.Close()
+				c.Assert(scanned, Equals, count, Commentf("partial %v, %s", partial, comment.CheckCommentString()))
+			}
+		}
+	}
+
+	tblInfo := newTestTableInfo([]string{"a"}, []int{0}, false)
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+	_, err := idx.GenIndexKeyPaddedMin(s.sc, types.MakeDatums(1, 2))
+	c.Assert(err, NotNil)
+}
+
+func (s *testIndexInternalSuite) TestUpdateMeta(c *C) {
+	idx := s.newIndex([]string{"a", "b"}, true)
+	for i := 0; i < 3; i++ {
+		_, err := idx.Create(s.sctx, s.store, types.MakeDatums(i, i), int64(i))
+		c.Assert(err, IsNil)
+	}
+	it, err := idx.SeekFirst(s.store)
+	c.Assert(err, IsNil)
+	defer it.Close()
+	_, h, err := it.Next()
+	c.Assert(err, IsNil)
+	c.Assert(h, Equals, int64(0))
+
+	// A visibility change is applied in place, the open iterator goes on.
+	invisible := idx.tblInfo.Indices[0].Clone()
+	invisible.Invisible = true
+	invisible.Comment = "hidden"
+	c.Assert(idx.UpdateMeta(invisible), IsNil)
+	c.Assert(idx.Meta().Invisible, IsTrue)
+	c.Assert(idx.Meta().Comment, Equals, "hidden")
+	_, h, err = it.Next()
+	c.Assert(err, IsNil)
+	c.Assert(h, Equals, int64(1))
+
+	// A column change is rejected and the index info is kept.
+	changed := invisible.Clone()
+	changed.Columns = changed.Columns[:1]
+	c.Assert(idx.UpdateMeta(changed), NotNil)
+	changed = invisible.Clone()
+	changed.Columns[1].Length = 3
+	c.Assert(idx.UpdateMeta(changed), NotNil)
+	changed = invisible.Clone()
+	changed.Unique = false
+	c.Assert(idx.UpdateMeta(changed), NotNil)
+	c.Assert(idx.Meta(), Equals, invisible)
+}
+
+func (s *testIndexInternalSuite) TestCapacityThresholds(c *C) {
+	tblInfo := newTestTableInfo([]string{"a"}, []int{0}, true)
+	var reported [][2]int64
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], WithCapacityThresholds(2, []int64{10, 5, 2, 6}, func(name string, threshold, count int64) {
+		c.Assert(name, Equals, "test")
+		reported = append(reported, [2]int64{threshold, count})
+	})).(*index)
+	for i := 0; i < 7; i++ {
+		_, err := idx.Create(s.sctx, s.store, types.MakeDatums(i), int64(i))
+		c.Assert(err, IsNil)
+		// A failed Create isn't counted.
+		_, err = idx.Create(s.sctx, s.store, types.MakeDatums(i), int64(i+100))
+		c.Assert(terror.ErrorEqual(err, kv.ErrKeyExists), IsTrue)
+	}
+	// The count starts from 2, so 2 is already crossed, 5 and 6 are crossed by the 3rd and the 4th entries.
+	c.Assert(reported, DeepEquals, [][2]int64{{5, 5}, {6, 6}})
+	for i := 7; i < 9; i++ {
+		_, err := idx.Create(s.sctx, s.store, types.MakeDatums(i), int64(i))
+		c.Assert(err, IsNil)
+	}
+	c.Assert(reported, DeepEquals, [][2]int64{{5, 5}, {6, 6}, {10, 10}})
+}
+
+func (s *testIndexInternalSuite) TestNestedIndexKey(c *C) {
+	innerInfo := newTestTableInfo([]string{"a", "b"}, []int{0, 1}, false)
+	inner := NewIndex(innerInfo.ID, innerInfo, innerInfo.Indices[0]).(*index)
+	var nested [][]byte
+	for _, row := range [][]interface{}{
+		{nil, "x"}, {-5, ""}, {-5, "\x00"}, {-5, "\x00\x00\x00\x00\x00\x00\x00\x00\x00"}, {0, "abcdefgh"},
+		{0, "abcdefghi"}, {0, "abcdefgh\xff"}, {7, "b"}, {math.MaxInt64, "\xff\xff"},
+	} {
+		for h := int64(-1); h <= 1; h++ {
+			key, _, err := inner.GenIndexKey(s.sc, types.MakeDatums(row...), h, nil)
+			c.Assert(err, IsNil)
+			nested = append(nested, key)
+		}
+	}
+
+	// The outer index has the nested key as its bytes column, its keys sort like the nested keys.
+	outerInfo := newTestTableInfo([]string{"k"}, []int{0}, false)
+	outer := NewIndex(outerInfo.ID+1, outerInfo, outerInfo.Indices[0]).(*index)
+	for i := 0; i < len(nested); i++ {
+		for j := 0; j < len(nested); j++ {
+			ki, _, err := outer.GenIndexKey(s.sc, []types.Datum{types.NewBytesDatum(nested[i])}, 1, nil)
+			c.Assert(err, IsNil)
+			kj, _, err := outer.GenIndexKey(s.sc, []types.Datum{types.NewBytesDatum(nested[j])}, 1, nil)
+			c.Assert(err, IsNil)
+			c.Assert(bytes.Compare(ki, kj), Equals, bytes.Compare(nested[i], nested[j]), Commentf("%x %x", nested[i], nested[j]))
+		}
+		// The nested key is decoded verbatim.
+		key, _, err := outer.GenIndexKey(s.sc, []types.Datum{types.NewBytesDatum(nested[i])}, 1, nil)
+		c.Assert(err, IsNil)
+		vals, _, err := outer.decodeEntry(key, []byte{'0'})
+		c.Assert(err, IsNil)
+		c.Assert(vals[0].GetBytes(), BytesEquals, nested[i])
+	}
+}
+
+func (s *testIndexInternalSuite) TestSeekHandleRange(c *C) {
+	for _, unique := range []bool{true, false} {
+		tblInfo := newTestTableInfo([]string{"a"}, []int{0}, unique)
+		idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+		buf := newTestStore()
+		for i := 0; i < 30; i++ {
+			// The handles are out of the index order.
+			h := int64(i*7%30) * 100
+			_, err := idx.Create(s.sctx, buf, types.MakeDatums(i), h)
+			c.Assert(err, IsNil)
+		}
+		_, err := idx.Create(s.sctx, buf, types.MakeDatums(nil), 1500)
+		c.Assert(err, IsNil)
+
+		it, err := idx.SeekHandleRange(s.sc, buf, 1000, 2000)
+		c.Assert(err, IsNil)
+		var handles []int64
+		for {
+			_, h, err := it.Next()
+			if terror.ErrorEqual(err, io.EOF) {
+				break
+			}
+			c.Assert(err, IsNil)
+			c.Assert(h >= 1000 && h < 2000, IsTrue, Commentf("handle %d", h))
+			handles = append(handles, h)
+		}
+		it.Close()
+		sort.Slice(handles, func(i, j int) bool { return handles[i] < handles[j] })
+		c.Assert(handles, DeepEquals, []int64{1000, 1100, 1200, 1300, 1400, 1500, 1500, 1600, 1700, 1800, 1900})
+	}
+}
+
+func (s *testIndexInternalSuite) TestHandleOffset(c *C) {
+	seq := int64(0)
+	for _, unique := range []bool{true, false} {
+		for _, opts := range [][]IndexOption{nil, {WithInsertionSequence(func() int64 { seq++; return seq })}} {
+			tblInfo := newTestTableInfo([]string{"a", "b"}, []int{0, 1}, unique)
+			idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], opts...).(*index)
+			for _, vals := range [][]types.Datum{types.MakeDatums(1, "abc"), types.MakeDatums(nil, "abcdefghijk")} {
+				key, distinct, offset, err := idx.GenIndexKeyWithHandleOffset(s.sc, vals, 42, nil)
+				c.Assert(err, IsNil)
+				valuesKey, err := idx.genValuesKey(s.sc, vals)
+				c.Assert(err, IsNil)
+				if distinct {
+					c.Assert(offset, Equals, -1)
+					c.Assert([]byte(key), BytesEquals, []byte(valuesKey))
+					continue
+				}
+				c.Assert(key[:offset], BytesEquals, []byte(valuesKey))
+				// The last datum of the suffix is the handle.
+				_, d, err := codec.DecodeOne(key[len(key)-9:])
+				c.Assert(err, IsNil)
+				c.Assert(d.GetInt64(), Equals, int64(42))
+				// The keys of another handle share the part before the offset.
+				other, _, otherOffset, err := idx.GenIndexKeyWithHandleOffset(s.sc, vals, -7, nil)
+				c.Assert(err, IsNil)
+				c.Assert(otherOffset, Equals, offset)
+				c.Assert(other[:otherOffset], BytesEquals, key[:offset])
+			}
+		}
+	}
+}
+
+func (s *testIndexInternalSuite) TestEncodeErrorValue(c *C) {
+	idx := s.newIndex([]string{"a", "b"}, false)
+	var bad types.Datum
+	bad.SetBinaryLiteral(types.BinaryLiteral("xyz"))
+	_, _, err := idx.GenIndexKey(s.sc, []types.Datum{types.NewIntDatum(1), bad}, 1, nil)
+	c.Assert(err, ErrorMatches, "cannot encode value 'KindBinaryLiteral 0x78797a' for index column 'b' of index test: .*")
+
+	// A long value is truncated.
+	bad.SetBinaryLiteral(types.BinaryLiteral(strings.Repeat("x", 1000)))
+	_, _, err = idx.GenIndexKey(s.sc, []types.Datum{types.NewIntDatum(1), bad}, 1, nil)
+	c.Assert(err, ErrorMatches, `cannot encode value 'KindBinaryLiteral 0x7878[78]*\.\.\.' for index column 'b' of index test: .*`)
+	c.Assert(len(err.Error()) < 200, IsTrue, Commentf("err %v", err))
+}
+
+func (s *testIndexInternalSuite) TestPlacementHint(c *C) {
+	now := time.Unix(1700000000, 0)
+	for _, unique := range []bool{true, false} {
+		for _, opts := range [][]IndexOption{
+			{WithPlacementHints()},
+			{WithPlacementHints(), WithWriteTime(func() time.Time { return now })},
+			{WithPlacementHints(), WithHashedColumns(func(d types.Datum) uint64 { return uint64(d.GetInt64() % 2) }, 0)},
+		} {
+			tblInfo := newTestTableInfo([]string{"a"}, []int{0}, unique)
+			idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], opts...).(*index)
+			buf := newTestStore()
+			_, err := idx.Create(s.sctx, buf, types.MakeDatums(1), 10, table.WithPlacementHint("us-west-1/replica-2"))
+			c.Assert(err, IsNil)
+			_, err = idx.Create(s.sctx, buf, types.MakeDatums(2), 20)
+			c.Assert(err, IsNil)
+			// An entry written before the index stores hints, a '0' or a handle whose low byte is valueHintFlag.
+			key, distinct, err := idx.GenIndexKey(s.sc, types.MakeDatums(3), 2, nil)
+			c.Assert(err, IsNil)
+			value := []byte{'0'}
+			if distinct {
+				value = EncodeHandle(2)
+			} else if idx.storesOriginal() {
+				value, err = codec.EncodeKey(s.sc, []byte{'0'}, types.NewIntDatum(3))
+				c.Assert(err, IsNil)
+			}
+			c.Assert(buf.Set(key, value), IsNil)
+
+			hint, ok, err := idx.PlacementHint(s.sc, buf, types.MakeDatums(1), 10)
+			c.Assert(err, IsNil)
+			c.Assert(ok, IsTrue)
+			c.Assert(hint, Equals, "us-west-1/replica-2")
+			exist, h, err := idx.Exist(s.sc, buf, types.MakeDatums(1), 10)
+			c.Assert(err, IsNil)
+			c.Assert(exist, IsTrue)
+			c.Assert(h, Equals, int64(10))
+			hint, ok, err = idx.PlacementHint(s.sc, buf, types.MakeDatums(3), 2)
+			c.Assert(err, IsNil)
+			c.Assert(ok, IsTrue)
+			c.Assert(hint, Equals, "")
+			exist, h, err = idx.Exist(s.sc, buf, types.MakeDatums(3), 2)
+			c.Assert(err, IsNil)
+			c.Assert(exist, IsTrue)
+			c.Assert(h, Equals, int64(2))
+
+			it, err := idx.SeekFirst(buf)
+			c.Assert(err, IsNil)
+			var got []string
+			for {
+				vals, h, meta, err := it.(*indexIter).NextWithMeta()
+				if terror.ErrorEqual(err, io.EOF) {
+					break
+				}
+				c.Assert(err, IsNil)
+				got = append(got, fmt.Sprintf("%s/%d/%s", datumsString(c, vals), h, meta.PlacementHint))
+				if h != 2 && idx.writeTimeNow != nil {
+					c.Assert(meta.WriteTime.Equal(now), IsTrue)
+				}
+			}
+			it.Close()
+			sort.Strings(got)
+			c.Assert(got, DeepEquals, []string{"1/10/us-west-1/replica-2", "2/20/", "3/2/"})
+		}
+	}
+
+	tblInfo := newTestTableInfo([]string{"a"}, []int{0}, false)
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+	_, err := idx.Create(s.sctx, newTestStore(), types.MakeDatums(1), 10, table.WithPlacementHint("x"))
+	c.Assert(err, ErrorMatches, ".*doesn't store placement hints")
+}
+
+func (s *testIndexInternalSuite) TestEstimateEqualMatches(c *C) {
+	for _, unique := range []bool{true, false} {
+		tblInfo := newTestTableInfo([]string{"a", "b"}, []int{0, 1}, unique)
+		idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+		buf := newTestStore()
+		h := int64(0)
+		for a := 0; a < 5; a++ {
+			for b := 0; b < a; b++ {
+				h++
+				_, err := idx.Create(s.sctx, buf, types.MakeDatums(a, b), h)
+				c.Assert(err, IsNil)
+			}
+		}
+		for i := 0; i < 3; i++ {
+			h++
+			_, err := idx.Create(s.sctx, buf, types.MakeDatums(3, nil), h)
+			c.Assert(err, IsNil)
+		}
+		for _, t := range []struct {
+			vals  []interface{}
+			count int64
+		}{
+			{[]interface{}{0}, 0},
+			{[]interface{}{1}, 1},
+			{[]interface{}{3}, 6},
+			{[]interface{}{4}, 4},
+			{[]interface{}{3, 1}, 1},
+			{[]interface{}{3, 5}, 0},
+			{[]interface{}{3, nil}, 3},
+			{[]interface{}{}, 13},
+		} {
+			count, err := idx.EstimateEqualMatches(s.sc, buf, types.MakeDatums(t.vals...))
+			c.Assert(err, IsNil)
+			c.Assert(count, Equals, t.count, Commentf("unique %v, values %v", unique, t.vals))
+		}
+	}
+}
+
+func (s *testIndexInternalSuite) TestPlanSplits(c *C) {
+	idx := s.newIndex([]string{"a", "b"}, false)
+	checkSplits := func(splits [][]byte, numRegions int) {
+		c.Assert(splits, HasLen, numRegions-1)
+		end := idx.prefix.PrefixNext()
+		for i, key := range splits {
+			c.Assert(bytes.Compare(key, idx.prefix) > 0, IsTrue)
+			c.Assert(bytes.Compare(key, end) < 0, IsTrue)
+			if i > 0 {
+				c.Assert(bytes.Compare(splits[i-1], key) < 0, IsTrue)
+			}
+		}
+	}
+	for _, n := range []int{1, 2, 7, 100} {
+		splits, err := idx.PlanSplits(n, nil)
+		c.Assert(err, IsNil)
+		checkSplits(splits, n)
+	}
+
+	// The boundaries are at the sample quantiles, so the samples are spread evenly.
+	var samples [][]types.Datum
+	for i := 0; i < 100; i++ {
+		samples = append(samples, types.MakeDatums(i*i, "x"))
+	}
+	splits, err := idx.PlanSplits(4, samples)
+	c.Assert(err, IsNil)
+	checkSplits(splits, 4)
+	counts := make([]int, 4)
+	for _, vals := range samples {
+		key, _, err := idx.GenIndexKey(s.sc, vals, 1, nil)
+		c.Assert(err, IsNil)
+		region := sort.Search(len(splits), func(i int) bool { return bytes.Compare(key, splits[i]) < 0 })
+		counts[region]++
+	}
+	c.Assert(counts, DeepEquals, []int{25, 25, 25, 25})
+	// The leading values are enough.
+	splits, err = idx.PlanSplits(2, [][]types.Datum{types.MakeDatums(1), types.MakeDatums(3), types.MakeDatums(2)})
+	c.Assert(err, IsNil)
+	checkSplits(splits, 2)
+	expected, err := idx.genLeadingKey(s.sc, types.MakeDatums(2))
+	c.Assert(err, IsNil)
+	c.Assert(splits[0], BytesEquals, []byte(expected))
+
+	// Too few distinct samples fall back to the uniform split.
+	splits, err = idx.PlanSplits(5, [][]types.Datum{types.MakeDatums(1), types.MakeDatums(1)})
+	c.Assert(err, IsNil)
+	checkSplits(splits, 5)
+	_, err = idx.PlanSplits(0, nil)
+	c.Assert(err, NotNil)
+}
+
+func (s *testIndexInternalSuite) TestAnyMatch(c *C) {
+	for _, unique := range []bool{true, false} {
+		tblInfo := newTestTableInfo([]string{"a", "b"}, []int{0, 1}, unique)
+		idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+		buf := newTestStore()
+		for i, vals := range [][]interface{}{{1, "x"}, {1, "y"}, {3, nil}, {3, nil}, {5, "x"}} {
+			_, err := idx.Create(s.sctx, buf, types.MakeDatums(vals...), int64(i))
+			c.Assert(err, IsNil)
+		}
+		for _, t := range []struct {
+			vals  []interface{}
+			match bool
+		}{
+			{[]interface{}{1}, true},
+			{[]interface{}{1, "y"}, true},
+			{[]interface{}{1, "z"}, false},
+			{[]interface{}{2}, false},
+			{[]interface{}{3, nil}, true},
+			{[]interface{}{5, nil}, false},
+			{[]interface{}{6}, false},
+		} {
+			match, err := idx.AnyMatch(s.sc, buf, types.MakeDatums(t.vals...))
+			c.Assert(err, IsNil)
+			c.Assert(match, Equals, t.match, Commentf("unique %v, values %v", unique, t.vals))
+		}
+	}
+}
+
+// compactStore is a store recording the ranges it's asked to compact.
+type compactStore struct {
+	*kv.BufferStore
+	ranges [][2]kv.Key
+}
+
+func (s *compactStore) CompactRange(ctx context.Context, start, end kv.Key) error {
+	s.ranges = append(s.ranges, [2]kv.Key{start, end})
+	return nil
+}
+
+func (s *testIndexInternalSuite) TestCompact(c *C) {
+	idx := s.newIndex([]string{"a"}, false)
+	ctx := context.Background()
+	c.Assert(idx.Compact(ctx, newTestStore()), IsNil)
+
+	store := &compactStore{BufferStore: newTestStore()}
+	c.Assert(idx.Compact(ctx, store), IsNil)
+	c.Assert(store.ranges, HasLen, 1)
+	prefix := tablecodec.EncodeTableIndexPrefix(idx.tblInfo.ID, idx.tblInfo.Indices[0].ID)
+	c.Assert(store.ranges[0][0], DeepEquals, prefix)
+	c.Assert(store.ranges[0][1], DeepEquals, prefix.PrefixNext())
+}
+
+func (s *testIndexInternalSuite) TestCollationVersion(c *C) {
+	idx := s.newIndex([]string{"a"}, false, WithSortKey(0, naturalSortKey), WithCollationVersion(1))
+	rows := [][]types.Datum{types.MakeDatums("a10"), types.MakeDatums("a2")}
+	var sizes []int
+	_, err := idx.BuildFromRows(s.sctx, chunkRunner(s.store, &sizes), sliceRows(rows), 0)
+	c.Assert(err, IsNil)
+	c.Assert(idx.tblInfo.Indices[0].CollationVersion, Equals, uint32(1))
+
+	// The same version reads and writes the index.
+	_, err = idx.Create(s.sctx, s.store, types.MakeDatums("a1"), 2)
+	c.Assert(err, IsNil)
+	it, err := idx.SeekFirst(s.store)
+	c.Assert(err, IsNil)
+	it.Close()
+
+	// Another version of the weights is refused.
+	newIdx := NewIndex(idx.tblInfo.ID, idx.tblInfo, idx.tblInfo.Indices[0], WithSortKey(0, naturalSortKey), WithCollationVersion(2)).(*index)
+	_, err = newIdx.Create(s.sctx, s.store, types.MakeDatums("a3"), 3)
+	c.Assert(terror.ErrorEqual(err, table.ErrIndexCollationVersion), IsTrue, Commentf("err %v", err))
+	_, _, err = newIdx.Seek(s.sc, s.store, types.MakeDatums("a2"))
+	c.Assert(terror.ErrorEqual(err, table.ErrIndexCollationVersion), IsTrue, Commentf("err %v", err))
+	_, err = newIdx.SeekFirst(s.store)
+	c.Assert(terror.ErrorEqual(err, table.ErrIndexCollationVersion), IsTrue, Commentf("err %v", err))
+	c.Assert(newIdx.Delete(s.sc, s.store, types.MakeDatums("a1"), 2), NotNil)
+
+	// An index without a recorded version isn't checked.
+	idx.tblInfo.Indices[0].CollationVersion = 0
+	_, err = newIdx.Create(s.sctx, s.store, types.MakeDatums("a3"), 3)
+	c.Assert(err, IsNil)
+}
+
+func (s *testIndexInternalSuite) TestIterReverse(c *C) {
+	for _, unique := range []bool{true, false} {
+		tblInfo := newTestTableInfo([]string{"a", "b"}, []int{0, 1}, unique)
+		idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+		buf := newTestStore()
+		it, err := idx.IterReverse(s.sc, buf, nil)
+		c.Assert(err, IsNil)
+		_, _, err = it.Next()
+		c.Assert(terror.ErrorEqual(err, io.EOF), IsTrue)
+		it.Close()
+
+		for i, vals := range [][]interface{}{{1, "x"}, {2, "x"}, {2, "y"}, {3, "x"}} {
+			_, err := idx.Create(s.sctx, buf, types.MakeDatums(vals...), int64(i))
+			c.Assert(err, IsNil)
+		}
+		collect := func(upper ...interface{}) []int64 {
+			it, err := idx.IterReverse(s.sc, buf, types.MakeDatums(upper...))
+			c.Assert(err, IsNil)
+			defer it.Close()
+			var handles []int64
+			for {
+				_, h, err := it.Next()
+				if terror.ErrorEqual(err, io.EOF) {
+					return handles
+				}
+				c.Assert(err, IsNil)
+				handles = append(handles, h)
+			}
+		}
+		// The last inserted entry has the largest values, so it's returned first.
+		c.Assert(collect(), DeepEquals, []int64{3, 2, 1, 0})
+		c.Assert(collect(2), DeepEquals, []int64{2, 1, 0})
+		c.Assert(collect(2, "x"), DeepEquals, []int64{1, 0})
+		c.Assert(collect(0), IsNil)
+	}
+}
+
+func (s *testIndexInternalSuite) TestGenIndexKeys(c *C) {
+	idx := s.newIndex([]string{"a", "b"}, true)
+	rows := [][]types.Datum{types.MakeDatums(1, "x"), types.MakeDatums(2, nil), types.MakeDatums(3, "a long value to grow the buffer")}
+	handles := []int64{10, 20, 30}
+	for _, buf := range [][]byte{nil, make([]byte, 0, 4)} {
+		keys, distincts, err := idx.GenIndexKeys(s.sc, rows, handles, buf)
+		c.Assert(err, IsNil)
+		c.Assert(keys, HasLen, len(rows))
+		for i := range rows {
+			key, distinct, err := idx.GenIndexKey(s.sc, rows[i], handles[i], nil)
+			c.Assert(err, IsNil)
+			c.Assert(keys[i], BytesEquals, key)
+			c.Assert(distincts[i], Equals, distinct)
+		}
+		// Appending to a key doesn't overwrite the next one.
+		_ = append(keys[0], 0xff)
+		key, _, err := idx.GenIndexKey(s.sc, rows[1], handles[1], nil)
+		c.Assert(err, IsNil)
+		c.Assert(keys[1], BytesEquals, key)
+	}
+	_, _, err := idx.GenIndexKeys(s.sc, rows, handles[:1], nil)
+	c.Assert(err, NotNil)
+}
+
+// benchIndexRows returns the index and the indexed values of n rows for the key generation benchmarks.
+func benchIndexRows(n int) (*index, [][]types.Datum, []int64) {
+	tblInfo := newTestTableInfo([]string{"a", "b"}, []int{0, 1}, false)
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+	rows := make([][]types.Datum, n)
+	handles := make([]int64, n)
+	for i := range rows {
+		rows[i] = types.MakeDatums(i, fmt.Sprintf("value%d", i))
+		handles[i] = int64(i)
+	}
+	return idx, rows, handles
+}
+
+func BenchmarkGenIndexKeyLoop(b *testing.B) {
+	sc := &stmtctx.StatementContext{TimeZone: time.Local}
+	idx, rows, handles := benchIndexRows(256)
+	keys := make([][]byte, len(rows))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := range rows {
+			// Every key is kept for the write loop, so the buffer can't be reused.
+			key, _, err := idx.GenIndexKey(sc, rows[j], handles[j], nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			keys[j] = key
+		}
+	}
+}
+
+func BenchmarkGenIndexKeys(b *testing.B) {
+	sc := &stmtctx.StatementContext{TimeZone: time.Local}
+	idx, rows, handles := benchIndexRows(256)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := idx.GenIndexKeys(sc, rows, handles, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// batchGetStore is a store counting its Gets and BatchGets.
+type batchGetStore struct {
+	*kv.BufferStore
+	gets, batchGets int
+}
+
+func (s *batchGetStore) Get(ctx context.Context, k kv.Key) ([]byte, error) {
+	s.gets++
+	return s.BufferStore.Get(ctx, k)
+}
+
+func (s *batchGetStore) BatchGet(ctx context.Context, keys []kv.Key) (map[string][]byte, error) {
+	s.batchGets++
+	values := make(map[string][]byte)
+	for _, k := range keys {
+		if v, err := s.BufferStore.Get(ctx, k); err == nil {
+			values[string(k)] = v
+		}
+	}
+	return values, nil
+}
+
+func (s *testIndexInternalSuite) TestCount(c *C) {
+	for _, unique := range []bool{true, false} {
+		tblInfo := newTestTableInfo([]string{"a"}, []int{0}, unique)
+		idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+		buf := newTestStore()
+		count, err := idx.Count(context.Background(), buf)
+		c.Assert(err, IsNil)
+		c.Assert(count, Equals, int64(0))
+
+		for i := 0; i < 100; i++ {
+			_, err := idx.Create(s.sctx, buf, types.MakeDatums(i), int64(i))
+			c.Assert(err, IsNil)
+		}
+		// The entries of another index aren't counted.
+		other := newTestTableInfo([]string{"a"}, []int{0}, unique)
+		other.Indices[0].ID++
+		_, err = NewIndex(other.ID, other, other.Indices[0]).Create(s.sctx, buf, types.MakeDatums(1), 1)
+		c.Assert(err, IsNil)
+		count, err = idx.Count(context.Background(), buf)
+		c.Assert(err, IsNil)
+		c.Assert(count, Equals, int64(100))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = idx.Count(ctx, buf)
+		c.Assert(errors.Cause(err), Equals, context.Canceled)
+	}
+}
+
+func (s *testIndexInternalSuite) TestDropResumable(c *C) {
+	idx := s.newIndex([]string{"a"}, false)
+	other := newTestTableInfo([]string{"a"}, []int{0}, false)
+	other.Indices[0].ID++
+	otherIdx := NewIndex(other.ID, other, other.Indices[0])
+	fill := func() *kv.BufferStore {
+		buf := newTestStore()
+		for i := 0; i < 10; i++ {
+			_, err := idx.Create(s.sctx, buf, types.MakeDatums(i), int64(i))
+			c.Assert(err, IsNil)
+		}
+		_, err := otherIdx.Create(s.sctx, buf, types.MakeDatums(1), 1)
+		c.Assert(err, IsNil)
+		return buf
+	}
+
+	// Without a batch size, the whole index is dropped at once.
+	buf := fill()
+	next, done, err := idx.Drop(buf)
+	c.Assert(err, IsNil)
+	c.Assert(done, IsTrue)
+	c.Assert(next, IsNil)
+	c.Assert(dumpKVs(c, buf, idx.prefix), HasLen, 0)
+	c.Assert(dumpKVs(c, buf, otherIdx.(*index).prefix), HasLen, 1)
+
+	// Every batch deletes at most 4 entries, and the next one resumes from its continuation key.
+	buf = fill()
+	var batches []int
+	var start kv.Key
+	for {
+		before := len(dumpKVs(c, buf, idx.prefix))
+		next, done, err = idx.Drop(buf, table.WithDropBatchSize(4), table.WithDropStartKey(start))
+		c.Assert(err, IsNil)
+		batches = append(batches, before-len(dumpKVs(c, buf, idx.prefix)))
+		if done {
+			break
+		}
+		c.Assert(next.HasPrefix(idx.prefix), IsTrue)
+		start = next
+	}
+	c.Assert(batches, DeepEquals, []int{4, 4, 2})
+	c.Assert(dumpKVs(c, buf, idx.prefix), HasLen, 0)
+	c.Assert(dumpKVs(c, buf, otherIdx.(*index).prefix), HasLen, 1)
+
+	_, _, err = idx.Drop(buf, table.WithDropStartKey(otherIdx.(*index).prefix))
+	c.Assert(err, NotNil)
+}
+
+func (s *testIndexInternalSuite) TestCollations(c *C) {
+	for _, unique := range []bool{true, false} {
+		tblInfo := newTestTableInfo([]string{"a", "b"}, []int{0}, unique)
+		tblInfo.Columns[0].Collate = "utf8mb4_general_ci"
+		idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], WithCollations()).(*index)
+		buf := newTestStore()
+		_, err := idx.Create(s.sctx, buf, types.MakeDatums("abc"), 1)
+		c.Assert(err, IsNil)
+		h, err := idx.Create(s.sctx, buf, types.MakeDatums("ABC"), 2)
+		if unique {
+			c.Assert(kv.ErrKeyExists.Equal(err), IsTrue, Commentf("err %v", err))
+			c.Assert(h, Equals, int64(1))
+		} else {
+			c.Assert(err, IsNil)
+		}
+		for i, v := range []string{"abd", "ABB", "b"} {
+			_, err := idx.Create(s.sctx, buf, types.MakeDatums(v), int64(10+i))
+			c.Assert(err, IsNil)
+		}
+
+		// The original values are returned in the collation order.
+		it, err := idx.SeekFirst(buf)
+		c.Assert(err, IsNil)
+		var got []string
+		for {
+			vals, _, err := it.Next()
+			if terror.ErrorEqual(err, io.EOF) {
+				break
+			}
+			c.Assert(err, IsNil)
+			got = append(got, datumsString(c, vals))
+		}
+		it.Close()
+		if unique {
+			c.Assert(got, DeepEquals, []string{"ABB", "abc", "abd", "b"})
+		} else {
+			c.Assert(got, DeepEquals, []string{"ABB", "abc", "ABC", "abd", "b"})
+		}
+
+		// A lookup of 'ABC ' finds 'abc'.
+		handles, err := idx.HashLookup(s.sc, buf, types.MakeDatums("ABC "))
+		c.Assert(err, IsNil)
+		if unique {
+			c.Assert(handles, DeepEquals, []int64{1})
+		} else {
+			c.Assert(handles, DeepEquals, []int64{1, 2})
+		}
+		it, _, err = idx.Seek(s.sc, buf, types.MakeDatums("AbC"))
+		c.Assert(err, IsNil)
+		vals, _, err := it.Next()
+		c.Assert(err, IsNil)
+		c.Assert(datumsString(c, vals), Equals, "abc")
+		it.Close()
+
+		key, _, err := idx.GenIndexKey(s.sc, types.MakeDatums("abc"), 1, nil)
+		c.Assert(err, IsNil)
+		value, err := buf.Get(context.Background(), key)
+		c.Assert(err, IsNil)
+		vals, h, err = DecodeIndexKeyValue(tblInfo, tblInfo.Indices[0], key, value, WithCollations())
+		c.Assert(err, IsNil)
+		c.Assert(datumsString(c, vals), Equals, "abc")
+		c.Assert(h, Equals, int64(1))
+	}
+
+	// A binary collation isn't changed.
+	tblInfo := newTestTableInfo([]string{"a"}, []int{0}, true)
+	tblInfo.Columns[0].Collate = "utf8mb4_bin"
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], WithCollations()).(*index)
+	c.Assert(idx.storesOriginal(), IsFalse)
+}
+
+func (s *testIndexInternalSuite) TestDeleteExact(c *C) {
+	for _, unique := range []bool{true, false} {
+		tblInfo := newTestTableInfo([]string{"a"}, []int{0}, unique)
+		idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+		buf := newTestStore()
+		_, err := idx.Create(s.sctx, buf, types.MakeDatums(1), 1)
+		c.Assert(err, IsNil)
+
+		// The entry of a unique index points to handle 1, while the non-unique index has no entry of handle 2.
+		deleted, err := idx.DeleteExact(s.sc, buf, types.MakeDatums(1), 2)
+		c.Assert(err, IsNil)
+		c.Assert(deleted, IsFalse)
+		c.Assert(dumpKVs(c, buf, idx.prefix), HasLen, 1)
+
+		deleted, err = idx.DeleteExact(s.sc, buf, types.MakeDatums(2), 1)
+		c.Assert(err, IsNil)
+		c.Assert(deleted, IsFalse)
+
+		deleted, err = idx.DeleteExact(s.sc, buf, types.MakeDatums(1), 1)
+		c.Assert(err, IsNil)
+		c.Assert(deleted, IsTrue)
+		c.Assert(dumpKVs(c, buf, idx.prefix), HasLen, 0)
+
+		deleted, err = idx.DeleteExact(s.sc, buf, types.MakeDatums(1), 1)
+		c.Assert(err, IsNil)
+		c.Assert(deleted, IsFalse)
+	}
+}
+
+func (s *testIndexInternalSuite) TestGeneratedColumns(c *C) {
+	// The unique index is on the hidden generated column of LOWER(name), which isn't in the rows.
+	tblInfo := newTestTableInfo([]string{"id", "name", "_v$_idx_0"}, []int{2}, true)
+	tblInfo.Columns[2].Hidden = true
+	tblInfo.Columns[2].GeneratedExprString = "lower(`name`)"
+	compile := func(ctx sessionctx.Context, col *model.ColumnInfo) (ExprFunc, error) {
+		c.Assert(ctx, Equals, s.sctx)
+		if col.GeneratedExprString != "lower(`name`)" {
+			return nil, errors.Errorf("unsupported expression %s", col.GeneratedExprString)
+		}
+		return func(row []types.Datum) (types.Datum, error) {
+			// LOWER(NULL) is NULL.
+			if row[1].IsNull() {
+				return types.Datum{}, nil
+			}
+			return types.NewStringDatum(strings.ToLower(row[1].GetString())), nil
+		}, nil
+	}
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], WithGeneratedColumns(s.sctx, compile))
+	sc := s.sctx.GetSessionVars().StmtCtx
+	fetch := func(row ...interface{}) []types.Datum {
+		vals, err := idx.FetchValues(types.MakeDatums(row...), nil)
+		c.Assert(err, IsNil)
+		return vals
+	}
+	c.Assert(datumsString(c, fetch(1, "ABC")), Equals, "abc")
+
+	_, err := idx.Create(s.sctx, s.store, fetch(1, "ABC"), 1)
+	c.Assert(err, IsNil)
+	h, err := idx.Create(s.sctx, s.store, fetch(2, "abc"), 2)
+	c.Assert(kv.ErrKeyExists.Equal(err), IsTrue)
+	c.Assert(h, Equals, int64(1))
+	// The NULL values of the expression aren't distinct.
+	_, err = idx.Create(s.sctx, s.store, fetch(3, nil), 3)
+	c.Assert(err, IsNil)
+	_, err = idx.Create(s.sctx, s.store, fetch(4, nil), 4)
+	c.Assert(err, IsNil)
+
+	ok, h, err := idx.Exist(sc, s.store, fetch(1, "aBc"), 1)
+	c.Assert(err, IsNil)
+	c.Assert(ok, IsTrue)
+	c.Assert(h, Equals, int64(1))
+	c.Assert(idx.Delete(sc, s.store, fetch(1, "Abc"), 1), IsNil)
+	_, err = idx.Create(s.sctx, s.store, fetch(2, "abc"), 2)
+	c.Assert(err, IsNil)
+
+	tblInfo.Columns[2].GeneratedExprString = "upper(`name`)"
+	idx = NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], WithGeneratedColumns(s.sctx, compile))
+	_, err = idx.FetchValues(types.MakeDatums(1, "ABC"), nil)
+	c.Assert(err, ErrorMatches, ".*unsupported expression.*")
+}
+
+func (s *testIndexInternalSuite) TestDupKeyError(c *C) {
+	tblInfo := newTestTableInfo([]string{"a", "b"}, []int{0, 1}, true)
+	tblInfo.Indices[0].Name = model.NewCIStr("idx_ab")
+	for _, opts := range [][]IndexOption{nil, {WithInsertionSequence(func() int64 { return 1 })}} {
+		idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], opts...)
+		buf := newTestStore()
+		_, err := idx.Create(s.sctx, buf, types.MakeDatums("x", 1), 7)
+		c.Assert(err, IsNil)
+		h, err := idx.Create(s.sctx, buf, types.MakeDatums("x", 1), 8)
+		c.Assert(h, Equals, int64(7))
+		c.Assert(err, ErrorMatches, ".*Duplicate entry 'x-1' for key 'idx_ab'")
+		c.Assert(goerrors.Is(err, kv.ErrKeyExists), IsTrue)
+		c.Assert(kv.ErrKeyExists.Equal(err), IsTrue)
+		c.Assert(terror.ErrorEqual(err, kv.ErrKeyExists), IsTrue)
+		c.Assert(goerrors.Is(err, kv.ErrNotExist), IsFalse)
+		dupErr, ok := err.(*table.DupKeyError)
+		c.Assert(ok, IsTrue)
+		c.Assert(dupErr.IndexName, Equals, "idx_ab")
+		c.Assert(dupErr.Handle, Equals, int64(7))
+		c.Assert(dupErr.Entry, Equals, "x-1")
+	}
+}
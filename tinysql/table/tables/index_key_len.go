@@ -0,0 +1,128 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"unicode/utf8"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/parser/charset"
+	"github.com/pingcap/tidb/sessionctx/stmtctx"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/codec"
+)
+
+// WithMaxKeyLen returns an IndexOption which limits the keys to maxLen bytes, e.g. the max key size of the
+// KV engine, so a long VARCHAR or BLOB value without a prefix length fails GenIndexKey, and so the writes,
+// with ErrIndexKeyTooLong instead of failing opaquely in the storage. In lenient mode, the longest string
+// values are truncated until the key fits, with a warning, like a prefix length does, so the values sharing
+// the truncated bytes share a key. An index which stores the original values in the values isn't truncated.
+func WithMaxKeyLen(maxLen int, lenient bool) IndexOption {
+	return func(c *index) {
+		c.maxKeyLen = maxLen
+		c.lenientKeyLen = lenient
+	}
+}
+
+// genShrunkKey is genIndexKey for the values whose key of keyLen bytes is longer than the max key length,
+// it returns ErrIndexKeyTooLong unless the index is lenient and the values can be truncated to fit.
+// indexedValues are the values already truncated to the prefix lengths.
+func (c *index) genShrunkKey(sc *stmtctx.StatementContext, indexedValues []types.Datum, h int64, buf []byte, seq *int64, keyLen int) ([]byte, bool, error) {
+	tooLong := table.ErrIndexKeyTooLong.GenWithStackByArgs(c.idxInfo.Name, keyLen, c.maxKeyLen)
+	if !c.lenientKeyLen || c.storesOriginal() {
+		return nil, false, tooLong
+	}
+	vals := append([]types.Datum(nil), indexedValues...)
+	distinct := c.uniqueValues(vals)
+	for {
+		size, err := c.EstimateIndexKeySize(sc, vals, distinct)
+		if err != nil {
+			return nil, false, err
+		}
+		if size <= c.maxKeyLen {
+			break
+		}
+		i := longestBytesValue(vals)
+		if i < 0 {
+			return nil, false, tooLong
+		}
+		// Every 8 bytes cut shrink the memcomparable encoding by a 9-byte group.
+		b := vals[i].GetBytes()
+		n := len(b) - (size-c.maxKeyLen+8)/9*8
+		if n < 0 {
+			n = 0
+		}
+		col := c.tblInfo.Columns[c.idxInfo.Columns[i].Offset]
+		if col.Charset == charset.CharsetUTF8 || col.Charset == charset.CharsetUTF8MB4 {
+			// Cut at a rune boundary.
+			for n > 0 && n < len(b) && !utf8.RuneStart(b[n]) {
+				n--
+			}
+		}
+		if sc != nil {
+			sc.AppendWarning(errors.Errorf("index %s truncates a value of %d bytes to %d bytes to fit the max key length %d", c.idxInfo.Name, len(b), n, c.maxKeyLen))
+		}
+		setSameKind(&vals[i], b[:n])
+	}
+	return c.genIndexKey(sc, vals, h, buf, seq, nil)
+}
+
+// longestBytesValue returns the offset of the longest non-empty string or bytes value in vals, -1 if there's none.
+func longestBytesValue(vals []types.Datum) int {
+	longest := -1
+	for i := range vals {
+		k := vals[i].Kind()
+		if (k != types.KindString && k != types.KindBytes) || len(vals[i].GetBytes()) == 0 {
+			continue
+		}
+		if longest < 0 || len(vals[i].GetBytes()) > len(vals[longest].GetBytes()) {
+			longest = i
+		}
+	}
+	return longest
+}
+
+// EstimateIndexKeySize returns the length of the key GenIndexKey generates for indexedValues without building
+// it, e.g. to presize the buffers, distinct is the distinct GenIndexKey returns for the values, i.e. whether the
+// key has no handle suffix. Only the padding of a BINARY(N) value and a sort key allocate. The estimate is exact,
+// except that the suffix of a compact handle is counted at its maximum length, as the handle isn't given.
+func (c *index) EstimateIndexKeySize(sc *stmtctx.StatementContext, indexedValues []types.Datum, distinct bool) (int, error) {
+	size := len(c.prefix)
+	for i, v := range indexedValues {
+		if i < len(c.idxInfo.Columns) {
+			v, _ = truncateIndexValue(c.tblInfo, c.idxInfo.Columns[i], v)
+		}
+		if c.storesOriginal() {
+			v = c.hashIndexValue(i, v)
+		}
+		n, err := codec.EstimateKeySize(v)
+		if err != nil {
+			return 0, c.wrapEncodeErr(indexedValues, err)
+		}
+		size += n
+	}
+	if c.seqGen != nil {
+		// The sequence is an encoded int datum.
+		size += 9
+	}
+	if c.storesOriginal() || c.seqGen != nil {
+		distinct = false
+	}
+	if !distinct {
+		// Both an encoded int datum and the longest compact handle are 9 bytes.
+		size += 9
+	}
+	return size, nil
+}
@@ -0,0 +1,160 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"unicode/utf8"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/parser/charset"
+	"github.com/pingcap/tidb/parser/terror"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/types"
+)
+
+func (s *testIndexInternalSuite) TestMaxKeyLen(c *C) {
+	idx := s.newIndex([]string{"a", "b"}, true, WithMaxKeyLen(64, false))
+	_, err := idx.Create(s.sctx, s.store, types.MakeDatums(1, "short"), 1)
+	c.Assert(err, IsNil)
+
+	long := strings.Repeat("x", 100)
+	_, err = idx.Create(s.sctx, s.store, types.MakeDatums(1, long), 2)
+	c.Assert(terror.ErrorEqual(err, table.ErrIndexKeyTooLong), IsTrue, Commentf("err %v", err))
+	c.Assert(err, ErrorMatches, ".*Index key of test is [0-9]+ bytes long, longer than the max 64 bytes")
+	_, _, err = idx.Exist(s.sc, s.store, types.MakeDatums(1, long), 2)
+	c.Assert(terror.ErrorEqual(err, table.ErrIndexKeyTooLong), IsTrue, Commentf("err %v", err))
+	c.Assert(dumpKVs(c, s.store, idx.prefix), HasLen, 1)
+}
+
+func (s *testIndexInternalSuite) TestMaxKeyLenLenient(c *C) {
+	tblInfo := newTestTableInfo([]string{"a", "b"}, []int{0, 1}, true)
+	tblInfo.Columns[1].Charset = charset.CharsetUTF8MB4
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], WithMaxKeyLen(64, true)).(*index)
+
+	long := strings.Repeat("你", 40)
+	sc := s.sctx.GetSessionVars().StmtCtx
+	warnings := sc.WarningCount()
+	_, err := idx.Create(s.sctx, s.store, types.MakeDatums(1, long), 1)
+	c.Assert(err, IsNil)
+	c.Assert(sc.WarningCount() > warnings, IsTrue)
+	kvs := dumpKVs(c, s.store, idx.prefix)
+	c.Assert(kvs, HasLen, 1)
+	c.Assert(len(kvs[0][0]) <= 64, IsTrue)
+
+	// The value is truncated at a rune boundary, and the full value finds the entry.
+	it, err := idx.SeekFirst(s.store)
+	c.Assert(err, IsNil)
+	vals, h, err := it.Next()
+	it.Close()
+	c.Assert(err, IsNil)
+	c.Assert(h, Equals, int64(1))
+	truncated := vals[1].GetString()
+	c.Assert(utf8.ValidString(truncated), IsTrue)
+	c.Assert(strings.HasPrefix(long, truncated), IsTrue)
+	c.Assert(len(truncated) < len(long), IsTrue)
+	exist, h, err := idx.Exist(s.sc, s.store, types.MakeDatums(1, long), 1)
+	c.Assert(err, IsNil)
+	c.Assert(exist, IsTrue)
+	c.Assert(h, Equals, int64(1))
+
+	// The values sharing the truncated bytes share the key.
+	_, err = idx.Create(s.sctx, s.store, types.MakeDatums(1, long+"x"), 2)
+	c.Assert(terror.ErrorEqual(err, kv.ErrKeyExists), IsTrue, Commentf("err %v", err))
+
+	// A key without a string value to truncate is still too long.
+	idx = s.newIndex([]string{"a", "b", "c", "d", "e", "f", "g"}, false, WithMaxKeyLen(64, true))
+	_, err = idx.Create(s.sctx, s.store, types.MakeDatums(1, 2, 3, 4, 5, 6, 7), 1)
+	c.Assert(terror.ErrorEqual(err, table.ErrIndexKeyTooLong), IsTrue, Commentf("err %v", err))
+}
+
+// randomIndexDatum returns a random datum of any kind an index key encodes, the strings mix multi-byte
+// characters and invalid bytes to exercise the prefix truncation.
+func randomIndexDatum(rng *rand.Rand) types.Datum {
+	runes := []string{"a", "Z", "\x00", "é", "你", "\U0001F600", "\xff"}
+	randomString := func() string {
+		var sb strings.Builder
+		for n := rng.Intn(12); n > 0; n-- {
+			sb.WriteString(runes[rng.Intn(len(runes))])
+		}
+		return sb.String()
+	}
+	switch rng.Intn(6) {
+	case 0:
+		return types.NewIntDatum(rng.Int63() - rng.Int63())
+	case 1:
+		return types.NewUintDatum(rng.Uint64())
+	case 2:
+		return types.NewFloat64Datum(rng.NormFloat64())
+	case 3:
+		return types.NewStringDatum(randomString())
+	case 4:
+		return types.NewBytesDatum([]byte(randomString()))
+	}
+	return types.Datum{}
+}
+
+func (s *testIndexInternalSuite) TestEstimateIndexKeySize(c *C) {
+	rng := rand.New(rand.NewSource(1))
+	sortKey := func(d types.Datum) []byte { return bytes.Repeat([]byte{'k'}, len(d.GetBytes())%5) }
+	hash := func(d types.Datum) uint64 { return uint64(len(d.GetBytes())) }
+	for _, opts := range [][]IndexOption{
+		nil,
+		{WithNullsLast()},
+		{WithInsertionSequence(nil)},
+		{WithHashedColumns(hash, 0)},
+		{WithSortKey(1, sortKey)},
+	} {
+		for _, unique := range []bool{false, true} {
+			tblInfo := newTruncateTableInfo()
+			tblInfo.Indices[0].Unique = unique
+			idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], opts...).(*index)
+			for i := 0; i < 200; i++ {
+				vals := []types.Datum{randomIndexDatum(rng), randomIndexDatum(rng), randomIndexDatum(rng)}
+				h := rng.Int63() - rng.Int63()
+				key, distinct, err := idx.GenIndexKey(s.sc, vals, h, nil)
+				c.Assert(err, IsNil)
+				size, err := idx.EstimateIndexKeySize(s.sc, vals, distinct)
+				c.Assert(err, IsNil)
+				c.Assert(size, Equals, len(key), Commentf("values %v, options %d", vals, len(opts)))
+			}
+		}
+	}
+
+	// A compact handle is counted at its maximum length.
+	idx := s.newIndex([]string{"a"}, false, WithCompactHandles())
+	key, distinct, err := idx.GenIndexKey(s.sc, types.MakeDatums(1), 3, nil)
+	c.Assert(err, IsNil)
+	size, err := idx.EstimateIndexKeySize(s.sc, types.MakeDatums(1), distinct)
+	c.Assert(err, IsNil)
+	c.Assert(size >= len(key), IsTrue)
+	c.Assert(size, Equals, len(idx.prefix)+9+9)
+
+	// GenIndexKeys doesn't grow a buffer of the exact size of the keys.
+	rows := [][]types.Datum{types.MakeDatums(1), types.MakeDatums("a long value to grow the buffer"), types.MakeDatums(nil)}
+	idx = s.newIndex([]string{"a"}, true)
+	total := 0
+	for i, vals := range rows {
+		key, _, err := idx.GenIndexKey(s.sc, vals, int64(i), nil)
+		c.Assert(err, IsNil)
+		total += len(key)
+	}
+	buf := make([]byte, 0, total)
+	keys, _, err := idx.GenIndexKeys(s.sc, rows, []int64{0, 1, 2}, buf)
+	c.Assert(err, IsNil)
+	c.Assert(&keys[0][0], Equals, &buf[:1][0])
+}
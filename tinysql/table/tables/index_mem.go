@@ -0,0 +1,238 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"io"
+	"sort"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tidb/sessionctx"
+	"github.com/pingcap/tidb/sessionctx/stmtctx"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/types"
+)
+
+// MemIndex is a table.Index keeping its entries in a sorted in-memory map instead of a KV store, so the tests
+// of the higher layers don't need a mock store. The keys and the values are the ones of the index NewIndex
+// builds with the same arguments, so they compare with the keys of a real index, and so do the unique checks,
+// where the values with a NULL aren't distinct. The kv.Retriever and kv.Mutator arguments are ignored, so are
+// the CreateIdxOptFuncs. A MemIndex isn't safe for concurrent use.
+type MemIndex struct {
+	idx *index
+	// keys are the keys of the entries in order, values maps them to their values.
+	keys   []string
+	values map[string][]byte
+}
+
+var _ table.Index = &MemIndex{}
+
+// NewMemIndex builds an empty MemIndex.
+func NewMemIndex(physicalID int64, tblInfo *model.TableInfo, indexInfo *model.IndexInfo) *MemIndex {
+	return &MemIndex{
+		idx:    NewIndex(physicalID, tblInfo, indexInfo).(*index),
+		values: make(map[string][]byte),
+	}
+}
+
+// Meta implements table.Index Meta interface.
+func (m *MemIndex) Meta() *model.IndexInfo {
+	return m.idx.idxInfo
+}
+
+// GenIndexKey implements table.Index GenIndexKey interface.
+func (m *MemIndex) GenIndexKey(sc *stmtctx.StatementContext, indexedValues []types.Datum, h int64, buf []byte) (key []byte, distinct bool, err error) {
+	return m.idx.GenIndexKey(sc, indexedValues, h, buf)
+}
+
+// FetchValues implements table.Index FetchValues interface.
+func (m *MemIndex) FetchValues(row []types.Datum, vals []types.Datum) ([]types.Datum, error) {
+	return m.idx.FetchValues(row, vals)
+}
+
+// Create implements table.Index Create interface.
+func (m *MemIndex) Create(sctx sessionctx.Context, _ kv.RetrieverMutator, indexedValues []types.Datum, h int64, _ ...table.CreateIdxOptFunc) (int64, error) {
+	sc := sctx.GetSessionVars().StmtCtx
+	key, distinct, err := m.idx.GenIndexKey(sc, indexedValues, h, nil)
+	if err != nil {
+		return 0, err
+	}
+	if !distinct {
+		m.set(key, m.idx.stampValue([]byte{'0'}, ""))
+		return 0, nil
+	}
+	if value, ok := m.values[string(key)]; ok && !sc.BatchCheck {
+		handle, err := m.idx.decodeHandleValue(value)
+		if err != nil {
+			return 0, err
+		}
+		return handle, table.NewDupKeyError(m.idx.idxInfo.Name.O, handle, indexedValues)
+	}
+	m.set(key, m.idx.stampValue(EncodeHandle(h), ""))
+	return 0, nil
+}
+
+// Delete implements table.Index Delete interface.
+func (m *MemIndex) Delete(sc *stmtctx.StatementContext, _ kv.Mutator, indexedValues []types.Datum, h int64, opts ...table.DeleteIdxOptFunc) error {
+	var opt table.DeleteIdxOpt
+	for _, fn := range opts {
+		fn(&opt)
+	}
+	key, distinct, err := m.idx.GenIndexKey(sc, indexedValues, h, nil)
+	if err != nil {
+		return err
+	}
+	value, ok := m.values[string(key)]
+	if !ok {
+		return nil
+	}
+	if distinct && opt.VerifyHandle {
+		handle, err := m.idx.decodeHandleValue(value)
+		if err != nil {
+			return err
+		}
+		if handle != h {
+			return table.ErrIndexHandleMismatch.GenWithStackByArgs(m.idx.idxInfo.Name, handle, h)
+		}
+	}
+	m.delete(string(key))
+	return nil
+}
+
+// Drop implements table.Index Drop interface.
+func (m *MemIndex) Drop(_ kv.RetrieverMutator, opts ...table.DropIdxOptFunc) (next kv.Key, done bool, err error) {
+	var opt table.DropIdxOpt
+	for _, fn := range opts {
+		fn(&opt)
+	}
+	start := m.idx.scanPrefix
+	if opt.StartKey != nil {
+		if !opt.StartKey.HasPrefix(m.idx.scanPrefix) {
+			return nil, false, errors.Errorf("start key %x is out of index %s", []byte(opt.StartKey), m.idx.idxInfo.Name)
+		}
+		start = opt.StartKey
+	}
+	i := sort.SearchStrings(m.keys, string(start))
+	j := i
+	for ; j < len(m.keys) && kv.Key(m.keys[j]).HasPrefix(m.idx.scanPrefix); j++ {
+		deleted := j - i
+		if opt.BatchSize > 0 && deleted >= opt.BatchSize {
+			break
+		}
+		if opt.Ctx != nil && deleted%ctxCheckInterval == 0 {
+			if err = opt.Ctx.Err(); err != nil {
+				break
+			}
+		}
+		delete(m.values, m.keys[j])
+	}
+	if j < len(m.keys) && kv.Key(m.keys[j]).HasPrefix(m.idx.scanPrefix) {
+		next = kv.Key(m.keys[j])
+	}
+	m.keys = append(m.keys[:i], m.keys[j:]...)
+	return next, next == nil, errors.Trace(err)
+}
+
+// Exist implements table.Index Exist interface.
+func (m *MemIndex) Exist(sc *stmtctx.StatementContext, _ kv.RetrieverMutator, indexedValues []types.Datum, h int64) (bool, int64, error) {
+	key, distinct, err := m.idx.GenIndexKey(sc, indexedValues, h, nil)
+	if err != nil {
+		return false, 0, err
+	}
+	value, ok := m.values[string(key)]
+	if !ok {
+		return false, 0, nil
+	}
+	if !distinct {
+		return true, h, nil
+	}
+	handle, err := m.idx.decodeHandleValue(value)
+	if err != nil {
+		return false, 0, err
+	}
+	if handle != h {
+		return true, handle, kv.ErrKeyExists
+	}
+	return true, handle, nil
+}
+
+// Seek implements table.Index Seek interface.
+func (m *MemIndex) Seek(sc *stmtctx.StatementContext, _ kv.Retriever, indexedValues []types.Datum) (iter table.IndexIterator, hit bool, err error) {
+	key, _, err := m.idx.GenIndexKey(sc, indexedValues, 0, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	it := m.iter(key)
+	return it, len(it.keys) > 0 && it.keys[0] == string(key), nil
+}
+
+// SeekFirst implements table.Index SeekFirst interface.
+func (m *MemIndex) SeekFirst(_ kv.Retriever) (iter table.IndexIterator, err error) {
+	return m.iter(m.idx.scanPrefix), nil
+}
+
+// iter returns an iterator over a snapshot of the entries from start.
+func (m *MemIndex) iter(start kv.Key) *memIndexIter {
+	it := &memIndexIter{idx: m.idx}
+	for i := sort.SearchStrings(m.keys, string(start)); i < len(m.keys) && kv.Key(m.keys[i]).HasPrefix(m.idx.scanPrefix); i++ {
+		it.keys = append(it.keys, m.keys[i])
+		it.values = append(it.values, m.values[m.keys[i]])
+	}
+	return it
+}
+
+// set sets the value of key, keeping the keys sorted.
+func (m *MemIndex) set(key []byte, value []byte) {
+	k := string(key)
+	if _, ok := m.values[k]; !ok {
+		i := sort.SearchStrings(m.keys, k)
+		m.keys = append(m.keys, "")
+		copy(m.keys[i+1:], m.keys[i:])
+		m.keys[i] = k
+	}
+	m.values[k] = value
+}
+
+func (m *MemIndex) delete(key string) {
+	i := sort.SearchStrings(m.keys, key)
+	m.keys = append(m.keys[:i], m.keys[i+1:]...)
+	delete(m.values, key)
+}
+
+// memIndexIter is the iterator of a MemIndex, over the entries when it's created.
+type memIndexIter struct {
+	idx    *index
+	keys   []string
+	values [][]byte
+}
+
+// Next implements table.IndexIterator Next interface.
+func (it *memIndexIter) Next() ([]types.Datum, int64, error) {
+	if len(it.keys) == 0 {
+		return nil, 0, errors.Trace(io.EOF)
+	}
+	vals, h, err := it.idx.decodeEntry([]byte(it.keys[0]), it.values[0])
+	if err != nil {
+		return nil, 0, err
+	}
+	it.keys, it.values = it.keys[1:], it.values[1:]
+	return vals, h, nil
+}
+
+// Close implements table.IndexIterator Close interface.
+func (it *memIndexIter) Close() {
+	it.keys, it.values = nil, nil
+}
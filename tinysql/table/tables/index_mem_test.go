@@ -0,0 +1,103 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"fmt"
+	"io"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/parser/terror"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/types"
+)
+
+// runIndexScenario runs the same operations on idx and returns what they observe, one line per result.
+func (s *testIndexInternalSuite) runIndexScenario(c *C, idx table.Index, rm kv.RetrieverMutator) []string {
+	var log []string
+	logf := func(format string, args ...interface{}) {
+		log = append(log, fmt.Sprintf(format, args...))
+	}
+	scan := func(it table.IndexIterator, err error) {
+		c.Assert(err, IsNil)
+		defer it.Close()
+		for {
+			vals, h, err := it.Next()
+			if terror.ErrorEqual(err, io.EOF) {
+				break
+			}
+			c.Assert(err, IsNil)
+			logf("next %s %d", datumsString(c, vals), h)
+		}
+	}
+	rows := [][]interface{}{{1, "a"}, {2, "b"}, {1, "a"}, {1, nil}, {1, nil}, {3, "c"}, {nil, nil}, {2, "bb"}}
+	for i, row := range rows {
+		vals, err := idx.FetchValues(types.MakeDatums(row...), nil)
+		c.Assert(err, IsNil)
+		h, err := idx.Create(s.sctx, rm, vals, int64(i+1))
+		logf("create %v: %d %v", row, h, err)
+	}
+	for i, row := range rows {
+		exist, h, err := idx.Exist(s.sc, rm, types.MakeDatums(row...), int64(i+1))
+		logf("exist %v: %v %d %v", row, exist, h, err)
+	}
+	scan(idx.SeekFirst(rm))
+	it, hit, err := idx.Seek(s.sc, rm, types.MakeDatums(2, "b"))
+	logf("seek hit %v", hit)
+	scan(it, err)
+	it, hit, err = idx.Seek(s.sc, rm, types.MakeDatums(1, "b"))
+	logf("seek hit %v", hit)
+	scan(it, err)
+
+	logf("delete %v", idx.Delete(s.sc, rm, types.MakeDatums(2, "b"), 5, table.VerifyHandle))
+	logf("delete %v", idx.Delete(s.sc, rm, types.MakeDatums(2, "b"), 2, table.VerifyHandle))
+	logf("delete %v", idx.Delete(s.sc, rm, types.MakeDatums(1, nil), 4))
+	logf("delete %v", idx.Delete(s.sc, rm, types.MakeDatums(9, "z"), 9))
+	scan(idx.SeekFirst(rm))
+
+	next, done, err := idx.Drop(rm, table.WithDropBatchSize(2))
+	logf("drop %v %v", done, err)
+	_, done, err = idx.Drop(rm, table.WithDropStartKey(next))
+	logf("drop %v %v", done, err)
+	scan(idx.SeekFirst(rm))
+	return log
+}
+
+func (s *testIndexInternalSuite) TestMemIndexConformance(c *C) {
+	for _, unique := range []bool{true, false} {
+		s.store = newTestStore()
+		tblInfo := newTestTableInfo([]string{"a", "b"}, []int{0, 1}, unique)
+		idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0])
+		expected := s.runIndexScenario(c, idx, s.store)
+		mem := NewMemIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0])
+		c.Assert(s.runIndexScenario(c, mem, nil), DeepEquals, expected)
+
+		// The keys are the same as the idx index.
+		s.store = newTestStore()
+		for i, row := range [][]interface{}{{1, "a"}, {1, nil}, {2, "b"}} {
+			vals := types.MakeDatums(row...)
+			_, err := idx.Create(s.sctx, s.store, vals, int64(i))
+			c.Assert(err, IsNil)
+			_, err = mem.Create(s.sctx, nil, vals, int64(i))
+			c.Assert(err, IsNil)
+		}
+		var keys []string
+		for _, e := range dumpKVs(c, s.store, idx.(*index).prefix) {
+			keys = append(keys, e[0])
+			c.Assert(string(mem.values[e[0]]), Equals, e[1])
+		}
+		c.Assert(mem.keys, DeepEquals, keys)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"bytes"
+	"container/heap"
+	"io"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/parser/terror"
+	"github.com/pingcap/tidb/sessionctx/stmtctx"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/codec"
+)
+
+// CompareIndexKeys compares two raw keys of the index, which may be of different partitions of it, in the
+// order of the index scan, i.e. by the encoded values and then the handle. The keys are compared after the
+// prefixes, which have the same length for all the partitions.
+func (c *index) CompareIndexKeys(a, b []byte) int {
+	n := len(c.prefix)
+	if len(a) < n || len(b) < n {
+		return bytes.Compare(a, b)
+	}
+	return bytes.Compare(a[n:], b[n:])
+}
+
+// mergeEntry is an entry of a source iterator of a mergeIter, key is its order in the index.
+type mergeEntry struct {
+	vals []types.Datum
+	h    int64
+	key  []byte
+	src  int
+}
+
+// mergeHeap is a min-heap of the next entries of the source iterators.
+type mergeHeap []*mergeEntry
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	if cmp := bytes.Compare(h[i].key, h[j].key); cmp != 0 {
+		return cmp < 0
+	}
+	return h[i].src < h[j].src
+}
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *mergeHeap) Push(x interface{}) {
+	*h = append(*h, x.(*mergeEntry))
+}
+
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// mergeIter is the k-way merge of several iterators of the index, see MergeIterators.
+type mergeIter struct {
+	idx   *index
+	sc    *stmtctx.StatementContext
+	iters []table.IndexIterator
+	heap  mergeHeap
+	// seen is the set of the returned handles if the merge deduplicates them.
+	seen    map[int64]struct{}
+	started bool
+}
+
+// MergeIterators returns an iterator merging the entries of iters, e.g. the scans of the partitions of the
+// index, into the global order of the index. Every iterator must return its entries in the index order,
+// which for an index keeping the insertion order isn't the order of the values. Close closes all of iters.
+func (c *index) MergeIterators(iters ...table.IndexIterator) table.IndexIterator {
+	return &mergeIter{idx: c, sc: &stmtctx.StatementContext{}, iters: iters}
+}
+
+// MergeIteratorsDistinct is MergeIterators returning only the first entry of each handle, e.g. for the
+// scans of the partitions of a global index during a partition reorganization, where a row can have an
+// entry in two of them. The returned handles are kept until the iterator is closed.
+func (c *index) MergeIteratorsDistinct(iters ...table.IndexIterator) table.IndexIterator {
+	return &mergeIter{idx: c, sc: &stmtctx.StatementContext{}, iters: iters, seen: make(map[int64]struct{})}
+}
+
+// sortKey encodes the values and the handle of an entry in the order of the index.
+func (c *mergeIter) sortKey(vals []types.Datum, h int64) ([]byte, error) {
+	if len(vals) != len(c.idx.idxInfo.Columns) {
+		return nil, errors.Errorf("index %s has %d columns but the entry has %d values", c.idx.idxInfo.Name, len(c.idx.idxInfo.Columns), len(vals))
+	}
+	if c.idx.storesOriginal() {
+		vals = c.idx.hashIndexValues(vals)
+	}
+	key, err := c.idx.encodeIndexValues(c.sc, nil, vals)
+	if err != nil {
+		return nil, err
+	}
+	return codec.EncodeKey(c.sc, key, types.NewIntDatum(h))
+}
+
+// fill pushes the next entry of the iterator src if there's one.
+func (c *mergeIter) fill(src int) error {
+	vals, h, err := c.iters[src].Next()
+	if terror.ErrorEqual(err, io.EOF) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	key, err := c.sortKey(vals, h)
+	if err != nil {
+		return err
+	}
+	heap.Push(&c.heap, &mergeEntry{vals: vals, h: h, key: key, src: src})
+	return nil
+}
+
+// Next implements table.IndexIterator Next interface.
+func (c *mergeIter) Next() ([]types.Datum, int64, error) {
+	if !c.started {
+		c.started = true
+		for i := range c.iters {
+			if err := c.fill(i); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	for c.heap.Len() > 0 {
+		e := heap.Pop(&c.heap).(*mergeEntry)
+		if err := c.fill(e.src); err != nil {
+			return nil, 0, err
+		}
+		if c.seen != nil {
+			if _, ok := c.seen[e.h]; ok {
+				continue
+			}
+			c.seen[e.h] = struct{}{}
+		}
+		return e.vals, e.h, nil
+	}
+	return nil, 0, errors.Trace(io.EOF)
+}
+
+// Close implements table.IndexIterator Close interface.
+func (c *mergeIter) Close() {
+	for _, it := range c.iters {
+		it.Close()
+	}
+	c.heap = nil
+	c.seen = nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"fmt"
+	"io"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/parser/terror"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/types"
+)
+
+func (s *testIndexInternalSuite) TestMergeIterators(c *C) {
+	tblInfo := newTestTableInfo([]string{"a"}, []int{0}, false)
+	// The entries of each partition, as value:handle. Handle 7 has an entry in two partitions.
+	partitions := [][][2]interface{}{
+		{{5, 1}, {nil, 2}, {20, 3}, {9, 7}},
+		{{1, 4}, {9, 5}, {30, 6}},
+		{{9, 7}, {5, 8}, {nil, 9}, {2, 10}},
+	}
+	indices := make([]*index, len(partitions))
+	for i, entries := range partitions {
+		indices[i] = NewIndex(tblInfo.ID+int64(i)+1, tblInfo, tblInfo.Indices[0]).(*index)
+		for _, e := range entries {
+			_, err := indices[i].Create(s.sctx, s.store, types.MakeDatums(e[0]), int64(e[1].(int)))
+			c.Assert(err, IsNil)
+		}
+	}
+
+	scan := func(distinct bool) []string {
+		iters := make([]table.IndexIterator, len(indices))
+		for i, idx := range indices {
+			it, err := idx.SeekFirst(s.store)
+			c.Assert(err, IsNil)
+			iters[i] = it
+		}
+		merge := indices[0].MergeIterators
+		if distinct {
+			merge = indices[0].MergeIteratorsDistinct
+		}
+		it := merge(iters...)
+		defer it.Close()
+		var got []string
+		for {
+			vals, h, err := it.Next()
+			if terror.ErrorEqual(err, io.EOF) {
+				break
+			}
+			c.Assert(err, IsNil)
+			got = append(got, fmt.Sprintf("%s:%d", datumsString(c, vals), h))
+		}
+		return got
+	}
+	c.Assert(scan(false), DeepEquals, []string{"NULL:2", "NULL:9", "1:4", "2:10", "5:1", "5:8", "9:5", "9:7", "9:7", "20:3", "30:6"})
+	c.Assert(scan(true), DeepEquals, []string{"NULL:2", "NULL:9", "1:4", "2:10", "5:1", "5:8", "9:5", "9:7", "20:3", "30:6"})
+
+	// The raw keys of the partitions compare by the values and the handles, not by the partition prefixes.
+	key := func(p int, v interface{}, h int64) []byte {
+		k, _, err := indices[p].GenIndexKey(s.sc, types.MakeDatums(v), h, nil)
+		c.Assert(err, IsNil)
+		return k
+	}
+	c.Assert(indices[0].CompareIndexKeys(key(2, 2, 10), key(0, 5, 1)), Equals, -1)
+	c.Assert(indices[0].CompareIndexKeys(key(0, 9, 7), key(2, 9, 7)), Equals, 0)
+	c.Assert(indices[0].CompareIndexKeys(key(0, 9, 7), key(1, 9, 5)), Equals, 1)
+	c.Assert(indices[0].CompareIndexKeys(key(1, nil, 1), key(0, 1, 0)), Equals, -1)
+}
@@ -0,0 +1,128 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/parser/terror"
+	"github.com/pingcap/tidb/tablecodec"
+)
+
+// IndexCounter is a counter of the index operations reported to a MetricsHook.
+type IndexCounter int
+
+// The counters reported to a MetricsHook.
+const (
+	// CounterEntriesCreated counts the entries written by Create, the untouched entries aren't counted.
+	CounterEntriesCreated IndexCounter = iota
+	// CounterEntriesDeleted counts the keys deleted by Delete and Drop.
+	CounterEntriesDeleted
+	// CounterUniqueConflicts counts the Creates failed with ErrKeyExists.
+	CounterUniqueConflicts
+	// CounterBytesWritten counts the bytes of the keys and the values written by Create.
+	CounterBytesWritten
+	// CounterSeeks counts the Seeks.
+	CounterSeeks
+)
+
+var indexCounterNames = [...]string{
+	CounterEntriesCreated:  "entries_created",
+	CounterEntriesDeleted:  "entries_deleted",
+	CounterUniqueConflicts: "unique_conflicts",
+	CounterBytesWritten:    "bytes_written",
+	CounterSeeks:           "seeks",
+}
+
+// String implements the fmt.Stringer interface, it's the name of the counter, e.g. for a Prometheus label.
+func (k IndexCounter) String() string {
+	if k < 0 || int(k) >= len(indexCounterNames) {
+		return "unknown"
+	}
+	return indexCounterNames[k]
+}
+
+// MetricsHook receives the counters of the index operations, e.g. to add them to Prometheus counters
+// labeled by the index name and the table ID. The table ID is the physical ID the index is built with.
+// AddIndexCounter is called once per non-zero counter after each operation, so it must be cheap and safe
+// for concurrent use.
+type MetricsHook interface {
+	AddIndexCounter(indexName string, tableID int64, counter IndexCounter, delta int64)
+}
+
+// WithMetricsHook returns an IndexOption which reports the counters of Create, Delete, Drop and Seek to hook.
+func WithMetricsHook(hook MetricsHook) IndexOption {
+	return func(c *index) {
+		if hook != nil {
+			c.metrics = hook
+		}
+	}
+}
+
+// metricsMutator counts the deletes and the bytes written to a kv.Mutator for the MetricsHook.
+type metricsMutator struct {
+	kv.Mutator
+	deleted int64
+	written int64
+}
+
+// Set implements the kv.Mutator interface.
+func (m *metricsMutator) Set(k kv.Key, v []byte) error {
+	err := m.Mutator.Set(k, v)
+	if err == nil {
+		m.written += int64(len(k) + len(v))
+	}
+	return err
+}
+
+// Delete implements the kv.Mutator interface.
+func (m *metricsMutator) Delete(k kv.Key) error {
+	err := m.Mutator.Delete(k)
+	if err == nil {
+		m.deleted++
+	}
+	return err
+}
+
+// metricsRM is a metricsMutator of a kv.RetrieverMutator.
+type metricsRM struct {
+	*metricsMutator
+	kv.Retriever
+}
+
+// countMetrics returns m counting its writes in the returned metricsMutator, which is still a kv.Retriever if m is.
+func countMetrics(m kv.Mutator) (kv.Mutator, *metricsMutator) {
+	mm := &metricsMutator{Mutator: m}
+	if r, ok := m.(kv.Retriever); ok {
+		return &metricsRM{mm, r}, mm
+	}
+	return mm, mm
+}
+
+// addCounter reports a non-zero delta of counter to the MetricsHook.
+func (c *index) addCounter(counter IndexCounter, delta int64) {
+	if delta != 0 {
+		c.metrics.AddIndexCounter(c.idxInfo.Name.O, tablecodec.DecodeTableID(c.prefix), counter, delta)
+	}
+}
+
+// reportCreate reports the counters of a Create which has written to m and returned err.
+func (c *index) reportCreate(m *metricsMutator, untouched bool, err error) {
+	switch {
+	case err == nil && !untouched:
+		c.addCounter(CounterEntriesCreated, 1)
+	case terror.ErrorEqual(err, kv.ErrKeyExists):
+		c.addCounter(CounterUniqueConflicts, 1)
+	}
+	c.addCounter(CounterBytesWritten, m.written)
+}
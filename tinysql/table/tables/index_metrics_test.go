@@ -0,0 +1,75 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tidb/types"
+)
+
+// counterHook is a MetricsHook keeping the counters in memory.
+type counterHook map[IndexCounter]int64
+
+func (h counterHook) AddIndexCounter(indexName string, tableID int64, counter IndexCounter, delta int64) {
+	if indexName == "idx_ab" && tableID == 1 {
+		h[counter] += delta
+	}
+}
+
+func (s *testIndexInternalSuite) TestMetricsHook(c *C) {
+	tblInfo := newTestTableInfo([]string{"a", "b"}, []int{0, 1}, true)
+	tblInfo.Indices[0].Name = model.NewCIStr("idx_ab")
+	hook := counterHook{}
+	idx := NewIndex(1, tblInfo, tblInfo.Indices[0], WithMetricsHook(hook)).(*index)
+	sc := s.sctx.GetSessionVars().StmtCtx
+
+	var written int64
+	for h, v := range []string{"x", "y", "z"} {
+		vals := types.MakeDatums(v, 1)
+		_, err := idx.Create(s.sctx, s.store, vals, int64(h))
+		c.Assert(err, IsNil)
+		key, _, err := idx.GenIndexKey(sc, vals, int64(h), nil)
+		c.Assert(err, IsNil)
+		written += int64(len(key) + len(idx.stampValue(idx.encodeHandleValue(int64(h)), "")))
+	}
+	_, err := idx.Create(s.sctx, s.store, types.MakeDatums("x", 1), 9)
+	c.Assert(kv.ErrKeyExists.Equal(err), IsTrue)
+	c.Assert(idx.Delete(sc, s.store, types.MakeDatums("y", 1), 1), IsNil)
+	for i := 0; i < 2; i++ {
+		it, _, err := idx.Seek(sc, s.store, types.MakeDatums("x", 1))
+		c.Assert(err, IsNil)
+		it.Close()
+	}
+	c.Assert(hook, DeepEquals, counterHook{
+		CounterEntriesCreated:  3,
+		CounterUniqueConflicts: 1,
+		CounterBytesWritten:    written,
+		CounterEntriesDeleted:  1,
+		CounterSeeks:           2,
+	})
+
+	_, done, err := idx.Drop(s.store)
+	c.Assert(err, IsNil)
+	c.Assert(done, IsTrue)
+	c.Assert(hook[CounterEntriesDeleted], Equals, int64(3))
+	c.Assert(CounterBytesWritten.String(), Equals, "bytes_written")
+
+	// An index without a hook reports nothing.
+	idx = NewIndex(1, tblInfo, tblInfo.Indices[0]).(*index)
+	_, err = idx.Create(s.sctx, s.store, types.MakeDatums("x", 1), 1)
+	c.Assert(err, IsNil)
+	c.Assert(hook[CounterEntriesCreated], Equals, int64(3))
+}
@@ -0,0 +1,180 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/sessionctx"
+	"github.com/pingcap/tidb/sessionctx/stmtctx"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/types"
+)
+
+// WithMultiValued returns an IndexOption which makes the index column at colOffset (offset in the index
+// columns) multi-valued, like a MySQL 8 multi-valued index: the column holds a JSON array, as a string or
+// bytes value, and Create and Delete write and remove an entry for each distinct element of the array,
+// all pointing to the same handle, Exist and the repairs check the entry of each element. The other
+// operations, e.g. Seek, take the value of an element.
+// A unique multi-valued index only rejects an element of another row, the duplicate elements of a row
+// are a single entry.
+func WithMultiValued(colOffset int) IndexOption {
+	return func(c *index) {
+		c.multiValued = true
+		c.multiValuedCol = colOffset
+	}
+}
+
+// ExpandMultiValued returns the values of the entries of indexedValues, one for each distinct element of
+// the JSON array in the multi-valued column, in the array order. Like MySQL, a JSON scalar is a single
+// element, and a NULL value is a single NULL element. An index which isn't multi-valued has a single entry.
+func (c *index) ExpandMultiValued(indexedValues []types.Datum) ([][]types.Datum, error) {
+	if !c.multiValued {
+		return [][]types.Datum{indexedValues}, nil
+	}
+	if c.multiValuedCol < 0 || c.multiValuedCol >= len(indexedValues) {
+		return nil, errors.Errorf("index %s has %d values but its multi-valued column is at %d", c.idxInfo.Name, len(indexedValues), c.multiValuedCol)
+	}
+	v := indexedValues[c.multiValuedCol]
+	if v.IsNull() {
+		return [][]types.Datum{indexedValues}, nil
+	}
+	elems, err := c.decodeJSONElements(v.GetBytes())
+	if err != nil {
+		return nil, err
+	}
+	sets := make([][]types.Datum, 0, len(elems))
+	seen := make(map[string]struct{}, len(elems))
+	for _, elem := range elems {
+		id := fmt.Sprintf("%d:%v", elem.Kind(), elem.GetValue())
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		vals := append([]types.Datum(nil), indexedValues...)
+		vals[c.multiValuedCol] = elem
+		sets = append(sets, vals)
+	}
+	return sets, nil
+}
+
+// decodeJSONElements decodes the elements of the JSON array, or the JSON scalar, b.
+// The numbers are integers if they're integral, otherwise floats, and the booleans are 1 and 0.
+func (c *index) decodeJSONElements(b []byte) ([]types.Datum, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, errors.Annotatef(err, "index %s has an invalid JSON value %q", c.idxInfo.Name, b)
+	}
+	items, ok := doc.([]interface{})
+	if !ok {
+		items = []interface{}{doc}
+	}
+	elems := make([]types.Datum, 0, len(items))
+	for _, item := range items {
+		var d types.Datum
+		switch x := item.(type) {
+		case nil:
+		case bool:
+			if x {
+				d.SetInt64(1)
+			} else {
+				d.SetInt64(0)
+			}
+		case json.Number:
+			if i, err := x.Int64(); err == nil {
+				d.SetInt64(i)
+				break
+			}
+			f, err := x.Float64()
+			if err != nil {
+				return nil, errors.Annotatef(err, "index %s has an invalid JSON number %s", c.idxInfo.Name, x)
+			}
+			d.SetFloat64(f)
+		case string:
+			d.SetString(x)
+		default:
+			return nil, errors.Errorf("index %s can't index the nested JSON value %s", c.idxInfo.Name, b)
+		}
+		elems = append(elems, d)
+	}
+	return elems, nil
+}
+
+// FetchMultiValues is FetchValues expanded by ExpandMultiValued, it returns the values of every entry of row.
+func (c *index) FetchMultiValues(row []types.Datum) ([][]types.Datum, error) {
+	vals, err := c.FetchValues(row, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.ExpandMultiValued(vals)
+}
+
+// createMultiValued creates the entries of the elements of indexedValues. The entries of a row are created
+// all or none, a conflict of an element removes the entries created for the elements before it.
+func (c *index) createMultiValued(sctx sessionctx.Context, rm kv.RetrieverMutator, indexedValues []types.Datum, h int64, opts []table.CreateIdxOptFunc) (int64, error) {
+	sets, err := c.ExpandMultiValued(indexedValues)
+	if err != nil {
+		return 0, err
+	}
+	for i, vals := range sets {
+		handle, err := c.create(sctx, rm, vals, h, opts...)
+		if err == nil {
+			continue
+		}
+		sc := sctx.GetSessionVars().StmtCtx
+		for _, created := range sets[:i] {
+			if err1 := c.deleteEntry(sc, rm, created, h); err1 != nil {
+				return 0, err1
+			}
+		}
+		return handle, err
+	}
+	return 0, nil
+}
+
+// deleteMultiValued removes the entries of the elements of indexedValues.
+func (c *index) deleteMultiValued(sc *stmtctx.StatementContext, m kv.Mutator, indexedValues []types.Datum, h int64, opts []table.DeleteIdxOptFunc) error {
+	sets, err := c.ExpandMultiValued(indexedValues)
+	if err != nil {
+		return err
+	}
+	for _, vals := range sets {
+		if err := c.deleteEntry(sc, m, vals, h, opts...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// existMultiValued is exist for the entries of the elements of indexedValues, which exist if all of them do.
+// The conflict of an element is returned like exist does, and an empty array has no entry to miss.
+func (c *index) existMultiValued(sc *stmtctx.StatementContext, r kv.Retriever, indexedValues []types.Datum, h int64) (bool, int64, error) {
+	sets, err := c.ExpandMultiValued(indexedValues)
+	if err != nil {
+		return false, 0, err
+	}
+	for _, vals := range sets {
+		exist, handle, err := c.existEntry(sc, r, vals, h)
+		if err != nil || !exist {
+			return exist, handle, err
+		}
+	}
+	return true, h, nil
+}
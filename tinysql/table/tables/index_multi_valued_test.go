@@ -0,0 +1,162 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"io"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/parser/terror"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/types"
+)
+
+func (s *testIndexInternalSuite) TestMultiValued(c *C) {
+	tblInfo := newTestTableInfo([]string{"id", "tags"}, []int{1}, false)
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], WithMultiValued(0)).(*index)
+
+	row := types.MakeDatums(1, "[1, 2, 3]")
+	sets, err := idx.FetchMultiValues(row)
+	c.Assert(err, IsNil)
+	var strs []string
+	for _, vals := range sets {
+		strs = append(strs, datumsString(c, vals))
+	}
+	c.Assert(strs, DeepEquals, []string{"1", "2", "3"})
+
+	vals, err := idx.FetchValues(row, nil)
+	c.Assert(err, IsNil)
+	_, err = idx.Create(s.sctx, s.store, vals, 1)
+	c.Assert(err, IsNil)
+	c.Assert(dumpKVs(c, s.store, idx.prefix), HasLen, 3)
+	it, err := idx.SeekFirst(s.store)
+	c.Assert(err, IsNil)
+	strs = strs[:0]
+	for {
+		vals, h, err := it.Next()
+		if terror.ErrorEqual(err, io.EOF) {
+			break
+		}
+		c.Assert(err, IsNil)
+		c.Assert(h, Equals, int64(1))
+		strs = append(strs, datumsString(c, vals))
+	}
+	it.Close()
+	c.Assert(strs, DeepEquals, []string{"1", "2", "3"})
+	exist, _, err := idx.Exist(s.sc, s.store, types.MakeDatums("[2]"), 1)
+	c.Assert(err, IsNil)
+	c.Assert(exist, IsTrue)
+
+	// Deleting the row removes the entry of every element.
+	c.Assert(idx.Delete(s.sc, s.store, vals, 1), IsNil)
+	c.Assert(dumpKVs(c, s.store, idx.prefix), HasLen, 0)
+
+	_, err = idx.ExpandMultiValued(types.MakeDatums("[[1], 2]"))
+	c.Assert(err, NotNil)
+	_, err = idx.ExpandMultiValued(types.MakeDatums("[1,"))
+	c.Assert(err, NotNil)
+	sets, err = idx.ExpandMultiValued(types.MakeDatums("[]"))
+	c.Assert(err, IsNil)
+	c.Assert(sets, HasLen, 0)
+	sets, err = idx.ExpandMultiValued(types.MakeDatums(`"x"`))
+	c.Assert(err, IsNil)
+	c.Assert(sets, HasLen, 1)
+	c.Assert(datumsString(c, sets[0]), Equals, "x")
+}
+
+func (s *testIndexInternalSuite) TestUniqueMultiValued(c *C) {
+	tblInfo := newTestTableInfo([]string{"id", "tags"}, []int{1}, true)
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], WithMultiValued(0)).(*index)
+
+	// The duplicate elements of a row are a single entry.
+	_, err := idx.Create(s.sctx, s.store, types.MakeDatums("[1, 1, 2]"), 1)
+	c.Assert(err, IsNil)
+	c.Assert(dumpKVs(c, s.store, idx.prefix), HasLen, 2)
+
+	// An element of another row conflicts, and none of the entries of the row is created.
+	h, err := idx.Create(s.sctx, s.store, types.MakeDatums("[3, 2]"), 2)
+	dupErr, ok := err.(*table.DupKeyError)
+	c.Assert(ok, IsTrue, Commentf("err %v", err))
+	c.Assert(dupErr.Entry, Equals, "2")
+	c.Assert(h, Equals, int64(1))
+	c.Assert(dumpKVs(c, s.store, idx.prefix), HasLen, 2)
+
+	_, err = idx.Create(s.sctx, s.store, types.MakeDatums(`[3, "x"]`), 2)
+	c.Assert(err, IsNil)
+	exist, h, err := idx.Exist(s.sc, s.store, types.MakeDatums(`["x"]`), 2)
+	c.Assert(err, IsNil)
+	c.Assert(exist, IsTrue)
+	c.Assert(h, Equals, int64(2))
+}
+
+func (s *testIndexInternalSuite) TestRepairMultiValued(c *C) {
+	tblInfo := newTestTableInfo([]string{"id", "tags"}, []int{1}, true)
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], WithMultiValued(0)).(*index)
+	rows := [][]types.Datum{types.MakeDatums(1, "[1, 2, 3]"), types.MakeDatums(2, "[4, 5]")}
+	// newStore returns a store with the entries of the rows but the one of the element 2 of the first row.
+	newStore := func() *kv.BufferStore {
+		store := newTestStore()
+		for h, row := range rows {
+			_, err := idx.Create(s.sctx, store, row[1:], int64(h))
+			c.Assert(err, IsNil)
+		}
+		c.Assert(idx.deleteEntry(s.sc, store, types.MakeDatums(2), 0), IsNil)
+		return store
+	}
+
+	// Exist checks the entry of every element.
+	store := newStore()
+	exist, _, err := idx.Exist(s.sc, store, rows[0][1:], 0)
+	c.Assert(err, IsNil)
+	c.Assert(exist, IsFalse)
+	exist, h, err := idx.Exist(s.sc, store, types.MakeDatums("[1, 3]"), 0)
+	c.Assert(err, IsNil)
+	c.Assert(exist, IsTrue)
+	c.Assert(h, Equals, int64(0))
+	exist, h, err = idx.Exist(s.sc, store, types.MakeDatums("[1, 4]"), 0)
+	c.Assert(kv.ErrKeyExists.Equal(err), IsTrue, Commentf("err %v", err))
+	c.Assert(exist, IsTrue)
+	c.Assert(h, Equals, int64(1))
+	results, err := idx.BatchExist(s.sc, store, [][]types.Datum{rows[0][1:], rows[1][1:]}, []int64{0, 1})
+	c.Assert(err, IsNil)
+	c.Assert(results, DeepEquals, []ExistResult{{}, {Exists: true, Handle: 1}})
+
+	// The repairs create the missing entry of the row, though the others exist.
+	created, err := idx.RepairFromTable(s.sctx, store, sliceRows(rows))
+	c.Assert(err, IsNil)
+	c.Assert(created, Equals, 1)
+	c.Assert(dumpKVs(c, store, idx.prefix), HasLen, 5)
+	created, err = idx.RepairFromTable(s.sctx, store, sliceRows(rows))
+	c.Assert(err, IsNil)
+	c.Assert(created, Equals, 0)
+	var sizes []int
+	store = newStore()
+	created, err = idx.RepairFromTableInBatches(s.sctx, chunkRunner(store, &sizes), sliceRows(rows), 1)
+	c.Assert(err, IsNil)
+	c.Assert(created, Equals, 1)
+	c.Assert(dumpKVs(c, store, idx.prefix), HasLen, 5)
+
+	store = newStore()
+	c.Assert(idx.RepairEntry(s.sctx, store, rows[0], 0), IsNil)
+	c.Assert(dumpKVs(c, store, idx.prefix), HasLen, 5)
+	exist, _, err = idx.Exist(s.sc, store, rows[0][1:], 0)
+	c.Assert(err, IsNil)
+	c.Assert(exist, IsTrue)
+	// An element of another row fails the repair before any entry is rewritten.
+	before := dumpKVs(c, store, idx.prefix)
+	err = idx.RepairEntry(s.sctx, store, types.MakeDatums(3, "[6, 5]"), 2)
+	c.Assert(kv.ErrKeyExists.Equal(err), IsTrue, Commentf("err %v", err))
+	c.Assert(dumpKVs(c, store, idx.prefix), DeepEquals, before)
+}
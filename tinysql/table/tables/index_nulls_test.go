@@ -0,0 +1,105 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"fmt"
+	"io"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/parser/terror"
+	"github.com/pingcap/tidb/types"
+)
+
+func (s *testIndexInternalSuite) TestNullsNotDistinct(c *C) {
+	for _, nullsNotDistinct := range []bool{false, true} {
+		tblInfo := newTestTableInfo([]string{"a", "b"}, []int{0, 1}, true)
+		tblInfo.Indices[0].NullsNotDistinct = nullsNotDistinct
+		idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+		buf := newTestStore()
+		_, err := idx.Create(s.sctx, buf, types.MakeDatums(nil, 1), 1)
+		c.Assert(err, IsNil)
+		h, err := idx.Create(s.sctx, buf, types.MakeDatums(nil, 1), 2)
+		if nullsNotDistinct {
+			c.Assert(kv.ErrKeyExists.Equal(err), IsTrue, Commentf("err %v", err))
+			c.Assert(h, Equals, int64(1))
+		} else {
+			c.Assert(err, IsNil)
+		}
+
+		it, err := idx.SeekFirst(buf)
+		c.Assert(err, IsNil)
+		var handles []int64
+		for {
+			vals, h, err := it.Next()
+			if terror.ErrorEqual(err, io.EOF) {
+				break
+			}
+			c.Assert(err, IsNil)
+			c.Assert(datumsString(c, vals), Equals, "NULL,1")
+			handles = append(handles, h)
+		}
+		it.Close()
+		if nullsNotDistinct {
+			c.Assert(handles, DeepEquals, []int64{1})
+		} else {
+			c.Assert(handles, DeepEquals, []int64{1, 2})
+		}
+		exist, h, err := idx.Exist(s.sc, buf, types.MakeDatums(nil, 1), 1)
+		c.Assert(err, IsNil)
+		c.Assert(exist, IsTrue)
+		c.Assert(h, Equals, int64(1))
+	}
+}
+
+func (s *testIndexInternalSuite) TestInterleavedNulls(c *C) {
+	idx := s.newIndex([]string{"a", "b"}, true)
+	buf := newTestStore()
+	rows := [][]interface{}{{1, 1}, {nil, 1}, {1, 2}, {nil, 1}, {2, nil}, {2, 1}}
+	for i, row := range rows {
+		_, err := idx.Create(s.sctx, buf, types.MakeDatums(row...), int64(i+1))
+		c.Assert(err, IsNil)
+	}
+
+	it, err := idx.SeekFirst(buf)
+	c.Assert(err, IsNil)
+	defer it.Close()
+	var got []string
+	for {
+		vals, h, err := it.Next()
+		if terror.ErrorEqual(err, io.EOF) {
+			break
+		}
+		c.Assert(err, IsNil)
+		c.Assert(vals, HasLen, 2)
+		got = append(got, fmt.Sprintf("%s:%d", datumsString(c, vals), h))
+	}
+	c.Assert(got, DeepEquals, []string{"NULL,1:2", "NULL,1:4", "1,1:1", "1,2:3", "2,NULL:5", "2,1:6"})
+
+	// An entry whose key disagrees with its values about the handle location fails to decode.
+	kvs := dumpKVs(c, buf, idx.prefix)
+	nullKey, nullValue := kvs[0][0], kvs[0][1]
+	distinctKey, distinctValue := kvs[2][0], kvs[2][1]
+	_, _, err = idx.decodeEntry([]byte(distinctKey+nullKey[len(nullKey)-9:]), []byte(nullValue))
+	c.Assert(err, ErrorMatches, ".*is distinct=true but its key has handle=true")
+	tblInfo := newTestTableInfo([]string{"a", "b"}, []int{0, 1}, true)
+	tblInfo.Indices[0].NullsNotDistinct = true
+	nnd := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+	_, _, err = nnd.decodeEntry([]byte(nullKey), []byte(nullValue))
+	c.Assert(err, ErrorMatches, ".*is distinct=true but its key has handle=true")
+	_, h, err := nnd.decodeEntry([]byte(distinctKey), []byte(distinctValue))
+	c.Assert(err, IsNil)
+	c.Assert(h, Equals, int64(1))
+}
@@ -0,0 +1,63 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/expression"
+	"github.com/pingcap/tidb/sessionctx"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/chunk"
+)
+
+// IndexPredicate reports whether the row, with the values of all the table columns, has an entry in a
+// partial index, see WithPredicate.
+type IndexPredicate func(sctx sessionctx.Context, row []types.Datum) (bool, error)
+
+// ExpressionPredicate returns the IndexPredicate evaluating expr, e.g. the WHERE clause of CREATE INDEX,
+// whose columns are indexed by their offsets in the table columns. A NULL result doesn't qualify the row.
+func ExpressionPredicate(expr expression.Expression) IndexPredicate {
+	return func(sctx sessionctx.Context, row []types.Datum) (bool, error) {
+		v, isNull, err := expr.EvalInt(sctx, chunk.MutRowFromDatums(row).ToRow())
+		if err != nil {
+			return false, err
+		}
+		return !isNull && v != 0, nil
+	}
+}
+
+// WithPredicate returns an IndexOption which makes the index partial, like a PostgreSQL index with a WHERE
+// clause: only the rows qualified by pred have an entry. Create and Delete need the full row, passed by
+// table.WithRow and table.WithDeleteRow, and skip the rows which don't qualify. So an UPDATE calls Delete
+// with the old row and Create with the new one, and a row moving in or out of the predicate set only gets
+// its entry created or deleted. RowIndexWriter passes the row itself, CreateBatch isn't supported.
+func WithPredicate(pred IndexPredicate) IndexOption {
+	return func(c *index) {
+		c.predicate = pred
+	}
+}
+
+// qualifies reports whether row has an entry in the index, it always has one if the index isn't partial.
+func (c *index) qualifies(sctx sessionctx.Context, row []types.Datum) (bool, error) {
+	if c.predicate == nil {
+		return true, nil
+	}
+	if row == nil {
+		return false, errors.Errorf("partial index %s needs the row to evaluate its predicate", c.idxInfo.Name)
+	}
+	if sctx == nil {
+		return false, errors.Errorf("partial index %s needs the session to evaluate its predicate", c.idxInfo.Name)
+	}
+	return c.predicate(sctx, row)
+}
@@ -0,0 +1,108 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/expression"
+	"github.com/pingcap/tidb/parser/mysql"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/types"
+)
+
+func (s *testIndexInternalSuite) TestPartialIndex(c *C) {
+	// CREATE INDEX ... ON t(a) WHERE active
+	tblInfo := newTestTableInfo([]string{"a", "active"}, []int{0}, true)
+	active := &expression.Column{Index: 1, RetType: types.NewFieldType(mysql.TypeLonglong)}
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], WithPredicate(ExpressionPredicate(active))).(*index)
+	exist := func(a, h int64) bool {
+		ok, _, err := idx.Exist(s.sc, s.store, types.MakeDatums(a), h)
+		c.Assert(err, IsNil)
+		return ok
+	}
+	insert := func(h int64, row ...interface{}) {
+		r := types.MakeDatums(row...)
+		vals, err := idx.FetchValues(r, nil)
+		c.Assert(err, IsNil)
+		_, err = idx.Create(s.sctx, s.store, vals, h, table.WithRow(r))
+		c.Assert(err, IsNil)
+	}
+	// update is what the executor does for an UPDATE: delete the entry of the old row and create the new one.
+	update := func(h int64, oldRow, newRow []interface{}) {
+		old := types.MakeDatums(oldRow...)
+		vals, err := idx.FetchValues(old, nil)
+		c.Assert(err, IsNil)
+		c.Assert(idx.Delete(s.sc, s.store, vals, h, table.WithDeleteRow(s.sctx, old)), IsNil)
+		insert(h, newRow...)
+	}
+
+	insert(1, 1, 1)
+	insert(2, 2, 0)
+	insert(3, 3, nil)
+	c.Assert(dumpKVs(c, s.store, idx.prefix), HasLen, 1)
+	c.Assert(exist(1, 1), IsTrue)
+	// The rows out of the predicate set don't conflict.
+	insert(4, 2, 0)
+
+	// In to in.
+	update(1, []interface{}{1, 1}, []interface{}{10, 1})
+	c.Assert(exist(1, 1), IsFalse)
+	c.Assert(exist(10, 1), IsTrue)
+	// In to out.
+	update(1, []interface{}{10, 1}, []interface{}{10, 0})
+	c.Assert(exist(10, 1), IsFalse)
+	// Out to in.
+	update(2, []interface{}{2, 0}, []interface{}{20, 1})
+	c.Assert(exist(20, 2), IsTrue)
+	// Out to out, to the values of a qualified row, which keeps its entry.
+	update(4, []interface{}{2, 0}, []interface{}{20, 0})
+	c.Assert(exist(20, 2), IsTrue)
+	// Deleting the unqualified row doesn't delete the entry with the same values either.
+	c.Assert(idx.Delete(s.sc, s.store, types.MakeDatums(20), 4, table.WithDeleteRow(s.sctx, types.MakeDatums(20, 0))), IsNil)
+	c.Assert(exist(20, 2), IsTrue)
+	c.Assert(dumpKVs(c, s.store, idx.prefix), HasLen, 1)
+
+	// The row must be given.
+	_, err := idx.Create(s.sctx, s.store, types.MakeDatums(5), 5)
+	c.Assert(err, ErrorMatches, ".*needs the row.*")
+	c.Assert(idx.Delete(s.sc, s.store, types.MakeDatums(20), 2), ErrorMatches, ".*needs the row.*")
+
+	// RowIndexWriter passes the row.
+	w := NewRowIndexWriter([]table.Index{idx})
+	_, err = w.WriteRow(s.sctx, s.store, types.MakeDatums(30, 1), 6)
+	c.Assert(err, IsNil)
+	_, err = w.WriteRow(s.sctx, s.store, types.MakeDatums(31, 0), 7)
+	c.Assert(err, IsNil)
+	c.Assert(exist(30, 6), IsTrue)
+	c.Assert(exist(31, 7), IsFalse)
+
+	// The backfill and the repair pass the rows, and only create the entries of the qualified ones.
+	rows := [][]types.Datum{types.MakeDatums(40, 1), types.MakeDatums(41, 0), types.MakeDatums(42, 1)}
+	var sizes []int
+	s.store = newTestStore()
+	created, err := idx.BuildFromRows(s.sctx, chunkRunner(s.store, &sizes), sliceRows(rows), 0)
+	c.Assert(err, IsNil)
+	c.Assert(created, Equals, 2)
+	c.Assert(exist(40, 0), IsTrue)
+	c.Assert(exist(41, 1), IsFalse)
+	rows = append(rows, types.MakeDatums(43, 1), types.MakeDatums(44, 0))
+	created, err = idx.RepairFromTableInBatches(s.sctx, chunkRunner(s.store, &sizes), sliceRows(rows), 2)
+	c.Assert(err, IsNil)
+	c.Assert(created, Equals, 1)
+	rows = append(rows, types.MakeDatums(45, 1))
+	created, err = idx.RepairFromTable(s.sctx, s.store, sliceRows(rows))
+	c.Assert(err, IsNil)
+	c.Assert(created, Equals, 1)
+	c.Assert(dumpKVs(c, s.store, idx.prefix), HasLen, 4)
+}
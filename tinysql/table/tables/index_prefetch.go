@@ -0,0 +1,136 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"context"
+	"io"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/sessionctx/stmtctx"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/types"
+)
+
+// defaultPrefetchChunkSize is the number of rows a PrefetchIter gets in one BatchGet if no chunk size is given.
+const defaultPrefetchChunkSize = 256
+
+// PrefetchRow is an index entry returned by PrefetchIter with the raw value of the row it points to.
+type PrefetchRow struct {
+	Values []types.Datum
+	Handle int64
+	Row    []byte
+}
+
+// PrefetchIter is an index iterator which gets the rows of the entries in chunks, see SeekWithRowPrefetch.
+type PrefetchIter struct {
+	it        *indexIter
+	idx       *index
+	sc        *stmtctx.StatementContext
+	r         kv.Retriever
+	tableID   int64
+	chunkSize int
+
+	rows []PrefetchRow
+	pos  int
+}
+
+// SeekWithRowPrefetch is Seek, but the returned iterator also returns the rows the entries point to, which
+// are read from r in one BatchGet per chunkSize entries instead of one Get per entry, e.g. for an index
+// lookup. chunkSize defaults to defaultPrefetchChunkSize. An entry whose row doesn't exist is dangling,
+// it's skipped with a warning appended to sc instead of failing the scan.
+func (c *index) SeekWithRowPrefetch(sc *stmtctx.StatementContext, r kv.Retriever, indexedValues []types.Datum, chunkSize int) (*PrefetchIter, bool, error) {
+	if c.isCommonHandle() {
+		return nil, false, errors.Errorf("index %s doesn't prefetch the rows of common handles", c.idxInfo.Name)
+	}
+	iter, hit, err := c.Seek(sc, r, indexedValues)
+	if err != nil {
+		return nil, false, err
+	}
+	if chunkSize <= 0 {
+		chunkSize = defaultPrefetchChunkSize
+	}
+	return &PrefetchIter{
+		it:        iter.(*indexIter),
+		idx:       c,
+		sc:        sc,
+		r:         r,
+		tableID:   tablecodec.DecodeTableID(c.prefix),
+		chunkSize: chunkSize,
+	}, hit, nil
+}
+
+// Next returns the next entry with its row, or io.EOF after the last entry.
+func (p *PrefetchIter) Next() (PrefetchRow, error) {
+	for p.pos >= len(p.rows) {
+		if p.it == nil {
+			return PrefetchRow{}, errors.Trace(io.EOF)
+		}
+		if err := p.fetch(); err != nil {
+			return PrefetchRow{}, err
+		}
+	}
+	row := p.rows[p.pos]
+	p.pos++
+	return row, nil
+}
+
+// fetch reads the next chunk of entries and their rows, the dangling entries are dropped.
+func (p *PrefetchIter) fetch() error {
+	p.rows, p.pos = p.rows[:0], 0
+	keys := make([]kv.Key, 0, p.chunkSize)
+	for len(keys) < p.chunkSize {
+		vals, h, err := p.it.Next()
+		if errors.Cause(err) == io.EOF {
+			p.it.Close()
+			p.it = nil
+			break
+		}
+		if err != nil {
+			return err
+		}
+		p.rows = append(p.rows, PrefetchRow{Values: vals, Handle: h})
+		// The row key of a table with HandleShards has the sharded handle, the entry has the real one.
+		keys = append(keys, tablecodec.EncodeRowKeyWithHandle(p.tableID, ShardHandle(h, p.idx.tblInfo.HandleShards)))
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	values, err := batchGet(context.TODO(), p.r, keys)
+	if err != nil {
+		return err
+	}
+	n := 0
+	for i, row := range p.rows {
+		value, ok := values[string(keys[i])]
+		if !ok {
+			p.sc.AppendWarning(errors.Errorf("index %s has a dangling entry of handle %d, whose row doesn't exist", p.idx.idxInfo.Name, row.Handle))
+			continue
+		}
+		row.Row = value
+		p.rows[n] = row
+		n++
+	}
+	p.rows = p.rows[:n]
+	return nil
+}
+
+// Close implements table.IndexIterator Close interface.
+func (p *PrefetchIter) Close() {
+	if p.it != nil {
+		p.it.Close()
+		p.it = nil
+	}
+}
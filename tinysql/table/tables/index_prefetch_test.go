@@ -0,0 +1,64 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"io"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/sessionctx/stmtctx"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/types"
+)
+
+func (s *testIndexInternalSuite) TestSeekWithRowPrefetch(c *C) {
+	for _, unique := range []bool{true, false} {
+		sc := &stmtctx.StatementContext{TimeZone: time.Local}
+		tblInfo := newTestTableInfo([]string{"a"}, []int{0}, unique)
+		idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+		store := &batchGetStore{BufferStore: newTestStore()}
+		for h := int64(1); h <= 5; h++ {
+			_, err := idx.Create(s.sctx, store.BufferStore, types.MakeDatums(h*10), h)
+			c.Assert(err, IsNil)
+			// The row of handle 3 is missing.
+			if h != 3 {
+				c.Assert(store.Set(tablecodec.EncodeRowKeyWithHandle(tblInfo.ID, h), []byte{byte(h)}), IsNil)
+			}
+		}
+
+		it, hit, err := idx.SeekWithRowPrefetch(sc, store, types.MakeDatums(20), 2)
+		c.Assert(err, IsNil)
+		c.Assert(hit, Equals, unique)
+		var handles []int64
+		for {
+			row, err := it.Next()
+			if errors.Cause(err) == io.EOF {
+				break
+			}
+			c.Assert(err, IsNil)
+			c.Assert(row.Values[0].GetInt64(), Equals, row.Handle*10)
+			c.Assert(row.Row, BytesEquals, []byte{byte(row.Handle)})
+			handles = append(handles, row.Handle)
+		}
+		it.Close()
+		c.Assert(handles, DeepEquals, []int64{2, 4, 5})
+		// The 4 entries are read in 2 chunks, and the dangling entry is reported instead of failing the scan.
+		c.Assert(store.batchGets, Equals, 2)
+		c.Assert(store.gets, Equals, 0)
+		c.Assert(sc.WarningCount(), Equals, uint16(1))
+		c.Assert(sc.GetWarnings()[0].Err, ErrorMatches, ".*dangling entry of handle 3.*")
+	}
+}
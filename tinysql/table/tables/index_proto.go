@@ -0,0 +1,180 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/types"
+)
+
+// The messages below carry IndexRow over gRPC, they're the Go form of:
+//
+//	message DatumProto {
+//	    oneof value {
+//	        bool is_null = 1;
+//	        int64 int_value = 2;
+//	        uint64 uint_value = 3;
+//	        double float_value = 4;
+//	        string string_value = 5;
+//	        bytes bytes_value = 6;
+//	    }
+//	}
+//
+//	message IndexRowProto {
+//	    repeated DatumProto values = 1;
+//	    int64 handle = 2;
+//	}
+
+// DatumProto is the protobuf message of a datum.
+type DatumProto struct {
+	// Types that are valid to be assigned to Value:
+	//	*DatumProto_IsNull
+	//	*DatumProto_IntValue
+	//	*DatumProto_UintValue
+	//	*DatumProto_FloatValue
+	//	*DatumProto_StringValue
+	//	*DatumProto_BytesValue
+	Value isDatumProto_Value `protobuf_oneof:"value"`
+}
+
+// Reset implements proto.Message interface.
+func (m *DatumProto) Reset() { *m = DatumProto{} }
+
+// String implements proto.Message interface.
+func (m *DatumProto) String() string { return proto.CompactTextString(m) }
+
+// ProtoMessage implements proto.Message interface.
+func (*DatumProto) ProtoMessage() {}
+
+// XXX_OneofWrappers is for the internal use of the proto package.
+func (*DatumProto) XXX_OneofWrappers() []interface{} {
+	return []interface{}{
+		(*DatumProto_IsNull)(nil),
+		(*DatumProto_IntValue)(nil),
+		(*DatumProto_UintValue)(nil),
+		(*DatumProto_FloatValue)(nil),
+		(*DatumProto_StringValue)(nil),
+		(*DatumProto_BytesValue)(nil),
+	}
+}
+
+type isDatumProto_Value interface {
+	isDatumProto_Value()
+}
+
+// DatumProto_IsNull is the value of a NULL datum.
+type DatumProto_IsNull struct {
+	IsNull bool `protobuf:"varint,1,opt,name=is_null,json=isNull,proto3,oneof"`
+}
+
+// DatumProto_IntValue is the value of an int datum.
+type DatumProto_IntValue struct {
+	IntValue int64 `protobuf:"varint,2,opt,name=int_value,json=intValue,proto3,oneof"`
+}
+
+// DatumProto_UintValue is the value of an uint datum.
+type DatumProto_UintValue struct {
+	UintValue uint64 `protobuf:"varint,3,opt,name=uint_value,json=uintValue,proto3,oneof"`
+}
+
+// DatumProto_FloatValue is the value of a float datum.
+type DatumProto_FloatValue struct {
+	FloatValue float64 `protobuf:"fixed64,4,opt,name=float_value,json=floatValue,proto3,oneof"`
+}
+
+// DatumProto_StringValue is the value of a string datum.
+type DatumProto_StringValue struct {
+	StringValue string `protobuf:"bytes,5,opt,name=string_value,json=stringValue,proto3,oneof"`
+}
+
+// DatumProto_BytesValue is the value of a bytes datum.
+type DatumProto_BytesValue struct {
+	BytesValue []byte `protobuf:"bytes,6,opt,name=bytes_value,json=bytesValue,proto3,oneof"`
+}
+
+func (*DatumProto_IsNull) isDatumProto_Value()      {}
+func (*DatumProto_IntValue) isDatumProto_Value()    {}
+func (*DatumProto_UintValue) isDatumProto_Value()   {}
+func (*DatumProto_FloatValue) isDatumProto_Value()  {}
+func (*DatumProto_StringValue) isDatumProto_Value() {}
+func (*DatumProto_BytesValue) isDatumProto_Value()  {}
+
+// IndexRowProto is the protobuf message of IndexRow.
+type IndexRowProto struct {
+	Values []*DatumProto `protobuf:"bytes,1,rep,name=values,proto3" json:"values,omitempty"`
+	Handle int64         `protobuf:"varint,2,opt,name=handle,proto3" json:"handle,omitempty"`
+}
+
+// Reset implements proto.Message interface.
+func (m *IndexRowProto) Reset() { *m = IndexRowProto{} }
+
+// String implements proto.Message interface.
+func (m *IndexRowProto) String() string { return proto.CompactTextString(m) }
+
+// ProtoMessage implements proto.Message interface.
+func (*IndexRowProto) ProtoMessage() {}
+
+// ToProto converts row to its protobuf message.
+// Only NULL, int, uint, float, string and bytes values are supported.
+func (row IndexRow) ToProto() (*IndexRowProto, error) {
+	m := &IndexRowProto{Values: make([]*DatumProto, 0, len(row.Values)), Handle: row.Handle}
+	for i := range row.Values {
+		d := &row.Values[i]
+		pd := &DatumProto{}
+		switch d.Kind() {
+		case types.KindNull:
+			pd.Value = &DatumProto_IsNull{IsNull: true}
+		case types.KindInt64:
+			pd.Value = &DatumProto_IntValue{IntValue: d.GetInt64()}
+		case types.KindUint64:
+			pd.Value = &DatumProto_UintValue{UintValue: d.GetUint64()}
+		case types.KindFloat32, types.KindFloat64:
+			pd.Value = &DatumProto_FloatValue{FloatValue: d.GetFloat64()}
+		case types.KindString:
+			pd.Value = &DatumProto_StringValue{StringValue: d.GetString()}
+		case types.KindBytes:
+			pd.Value = &DatumProto_BytesValue{BytesValue: d.GetBytes()}
+		default:
+			return nil, errors.Errorf("unsupported datum kind %d in index row", d.Kind())
+		}
+		m.Values = append(m.Values, pd)
+	}
+	return m, nil
+}
+
+// IndexRowFromProto converts the protobuf message m back to an IndexRow.
+// A float value is always decoded as float64.
+func IndexRowFromProto(m *IndexRowProto) (IndexRow, error) {
+	row := IndexRow{Values: make([]types.Datum, len(m.Values)), Handle: m.Handle}
+	for i, pd := range m.Values {
+		switch v := pd.Value.(type) {
+		case *DatumProto_IsNull:
+			row.Values[i].SetNull()
+		case *DatumProto_IntValue:
+			row.Values[i].SetInt64(v.IntValue)
+		case *DatumProto_UintValue:
+			row.Values[i].SetUint64(v.UintValue)
+		case *DatumProto_FloatValue:
+			row.Values[i].SetFloat64(v.FloatValue)
+		case *DatumProto_StringValue:
+			row.Values[i].SetString(v.StringValue)
+		case *DatumProto_BytesValue:
+			row.Values[i].SetBytes(v.BytesValue)
+		default:
+			return IndexRow{}, errors.Errorf("index row value %d is not set", i)
+		}
+	}
+	return row, nil
+}
@@ -0,0 +1,50 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"github.com/golang/protobuf/proto"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/sessionctx/stmtctx"
+	"github.com/pingcap/tidb/types"
+)
+
+func (s *testIndexInternalSuite) TestIndexRowProto(c *C) {
+	row := IndexRow{
+		Values: []types.Datum{types.NewIntDatum(-7), types.NewStringDatum("abc"), {}, types.NewBytesDatum([]byte{0, 1}), types.NewUintDatum(9)},
+		Handle: 42,
+	}
+	m, err := row.ToProto()
+	c.Assert(err, IsNil)
+	data, err := proto.Marshal(m)
+	c.Assert(err, IsNil)
+
+	var decoded IndexRowProto
+	c.Assert(proto.Unmarshal(data, &decoded), IsNil)
+	got, err := IndexRowFromProto(&decoded)
+	c.Assert(err, IsNil)
+	c.Assert(got.Handle, Equals, int64(42))
+	c.Assert(got.Values, HasLen, len(row.Values))
+	for i := range row.Values {
+		c.Assert(got.Values[i].Kind(), Equals, row.Values[i].Kind())
+		cmp, err := got.Values[i].CompareDatum(&stmtctx.StatementContext{}, &row.Values[i])
+		c.Assert(err, IsNil)
+		c.Assert(cmp, Equals, 0)
+	}
+
+	var bad types.Datum
+	bad.SetBinaryLiteral(types.BinaryLiteral{0x01})
+	_, err = IndexRow{Values: []types.Datum{bad}}.ToProto()
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,117 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/sessionctx/stmtctx"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/types"
+)
+
+// SeekRange returns an iterator over the entries whose leading index columns are between low and high,
+// e.g. for col BETWEEN 10 AND 20. low is inclusive, and high is inclusive if highInclusive is set. Either
+// may have fewer values than the index columns, and a nil one leaves the range open at its end. Both bounds
+// are passed to r.Iter, so the iterator ends at high instead of the end of the index. The range is empty if
+// low is above high. A NULL bound sorts as the index stores NULL, before all the other values unless NullsLast.
+// The values are compared as the index stores them, so a hashed index isn't supported.
+func (c *index) SeekRange(sc *stmtctx.StatementContext, r kv.Retriever, low, high []types.Datum, highInclusive bool) (table.IndexIterator, error) {
+	kr, err := c.KeyRange(sc, low, high, highInclusive)
+	if err != nil {
+		return nil, err
+	}
+	it, err := r.Iter(kr.StartKey, kr.EndKey)
+	if err != nil {
+		return nil, err
+	}
+	return &indexIter{it: it, idx: c, prefix: c.scanPrefix, upper: kr.EndKey}, nil
+}
+
+// KeyRange returns the [start, end) key range SeekRange scans for the same bounds without opening an iterator,
+// e.g. to push the scan down to the KV layer, which splits it by region. The range of all the entries, with
+// both bounds nil, is the whole index prefix. The range is empty, with start equal to end, if low is above high.
+func (c *index) KeyRange(sc *stmtctx.StatementContext, low, high []types.Datum, highInclusive bool) (kv.KeyRange, error) {
+	if err := c.checkUsable(); err != nil {
+		return kv.KeyRange{}, err
+	}
+	if c.hashFunc != nil {
+		return kv.KeyRange{}, errors.Errorf("hashed index %s doesn't support range scans", c.idxInfo.Name)
+	}
+	if len(low) > len(c.idxInfo.Columns) || len(high) > len(c.idxInfo.Columns) {
+		return kv.KeyRange{}, errors.Errorf("index %s has %d columns, but %d and %d bound values are given", c.idxInfo.Name, len(c.idxInfo.Columns), len(low), len(high))
+	}
+	start, end := c.scanPrefix, c.scanPrefix.PrefixNext()
+	var err error
+	if len(low) > 0 {
+		if start, err = c.genBoundKey(sc, low); err != nil {
+			return kv.KeyRange{}, err
+		}
+	}
+	if len(high) > 0 {
+		if end, err = c.genBoundKey(sc, high); err != nil {
+			return kv.KeyRange{}, err
+		}
+		if highInclusive {
+			end = end.PrefixNext()
+		}
+	}
+	if start.Cmp(end) > 0 {
+		end = start
+	}
+	return kv.KeyRange{StartKey: start, EndKey: end}, nil
+}
+
+// SeekPrefix returns an iterator of the entries whose leading index columns equal prefixValues, e.g. for
+// WHERE a = 5 on an index of (a, b). The iterator is bounded to the key range of the encoded prefix values,
+// so it stops at the first entry whose leading columns don't match. The values are compared as the index
+// stores them, e.g. truncated to the prefix lengths or hashed.
+func (c *index) SeekPrefix(sc *stmtctx.StatementContext, r kv.Retriever, prefixValues []types.Datum) (table.IndexIterator, error) {
+	if c.slowLogThreshold > 0 {
+		defer c.logSlowOp(IndexOpSeek, time.Now())
+	}
+	if c.metrics != nil {
+		c.addCounter(CounterSeeks, 1)
+	}
+	if err := c.checkUsable(); err != nil {
+		return nil, err
+	}
+	if len(prefixValues) > len(c.idxInfo.Columns) {
+		return nil, errors.Errorf("index %s has %d columns, but %d values are given", c.idxInfo.Name, len(c.idxInfo.Columns), len(prefixValues))
+	}
+	keyPrefix, err := c.genLeadingKey(sc, prefixValues)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.checkTenant(keyPrefix); err != nil {
+		return nil, err
+	}
+	upper := keyPrefix.PrefixNext()
+	it, err := r.Iter(keyPrefix, upper)
+	if err != nil {
+		return nil, err
+	}
+	return &indexIter{it: it, idx: c, prefix: keyPrefix, upper: upper}, nil
+}
+
+// genBoundKey is genLeadingKey for a bound of SeekRange, which must be in the scanned tenant.
+func (c *index) genBoundKey(sc *stmtctx.StatementContext, bound []types.Datum) (kv.Key, error) {
+	key, err := c.genLeadingKey(sc, bound)
+	if err != nil {
+		return nil, err
+	}
+	return key, c.checkTenant(key)
+}
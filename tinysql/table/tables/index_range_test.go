@@ -0,0 +1,173 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"io"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/parser/terror"
+	"github.com/pingcap/tidb/types"
+)
+
+func (s *testIndexInternalSuite) TestSeekPrefix(c *C) {
+	idx := s.newIndex([]string{"a", "b"}, false)
+	rows := [][]interface{}{{4, "z"}, {5, "y"}, {5, nil}, {5, "a"}, {6, "a"}, {6, nil}}
+	for h, row := range rows {
+		_, err := idx.Create(s.sctx, s.store, types.MakeDatums(row...), int64(h))
+		c.Assert(err, IsNil)
+	}
+	for _, t := range []struct {
+		prefix   []types.Datum
+		expected []string
+	}{
+		{types.MakeDatums(5), []string{"5,NULL", "5,a", "5,y"}},
+		{types.MakeDatums(5, "y"), []string{"5,y"}},
+		{types.MakeDatums(6, nil), []string{"6,NULL"}},
+		{types.MakeDatums(7), nil},
+		{nil, []string{"4,z", "5,NULL", "5,a", "5,y", "6,NULL", "6,a"}},
+	} {
+		it, err := idx.SeekPrefix(s.sc, s.store, t.prefix)
+		c.Assert(err, IsNil)
+		var got []string
+		for {
+			vals, _, err := it.Next()
+			if terror.ErrorEqual(err, io.EOF) {
+				break
+			}
+			c.Assert(err, IsNil)
+			got = append(got, datumsString(c, vals))
+		}
+		it.Close()
+		c.Assert(got, DeepEquals, t.expected, Commentf("prefix %v", t.prefix))
+	}
+	_, err := idx.SeekPrefix(s.sc, s.store, types.MakeDatums(1, 2, 3))
+	c.Assert(err, NotNil)
+}
+
+// upperBoundStore is a store whose iterators ignore the upper bound, so only the iterator itself stops at it.
+type upperBoundStore struct {
+	*kv.BufferStore
+	uppers []kv.Key
+}
+
+func (s *upperBoundStore) Iter(k kv.Key, upperBound kv.Key) (kv.Iterator, error) {
+	s.uppers = append(s.uppers, upperBound)
+	return s.BufferStore.Iter(k, nil)
+}
+
+func (s *testIndexInternalSuite) TestSeekRange(c *C) {
+	for _, unique := range []bool{true, false} {
+		tblInfo := newTestTableInfo([]string{"a", "b"}, []int{0, 1}, unique)
+		idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+		store := &upperBoundStore{BufferStore: newTestStore()}
+		for i, vals := range [][]interface{}{{nil, "x"}, {5, "x"}, {10, "x"}, {10, "y"}, {15, "x"}, {20, "x"}, {25, "x"}} {
+			_, err := idx.Create(s.sctx, store.BufferStore, types.MakeDatums(vals...), int64(i))
+			c.Assert(err, IsNil)
+		}
+		collect := func(low, high []interface{}, highInclusive bool) []int64 {
+			it, err := idx.SeekRange(s.sc, store, types.MakeDatums(low...), types.MakeDatums(high...), highInclusive)
+			c.Assert(err, IsNil)
+			defer it.Close()
+			var handles []int64
+			for {
+				_, h, err := it.Next()
+				if terror.ErrorEqual(err, io.EOF) {
+					return handles
+				}
+				c.Assert(err, IsNil)
+				handles = append(handles, h)
+			}
+		}
+		c.Assert(collect([]interface{}{10}, []interface{}{20}, true), DeepEquals, []int64{2, 3, 4, 5})
+		c.Assert(collect([]interface{}{10}, []interface{}{20}, false), DeepEquals, []int64{2, 3, 4})
+		c.Assert(store.uppers[len(store.uppers)-1], NotNil)
+		c.Assert(collect([]interface{}{10, "y"}, []interface{}{15, "x"}, false), DeepEquals, []int64{3})
+		// Open bounds.
+		c.Assert(collect(nil, []interface{}{5}, true), DeepEquals, []int64{0, 1})
+		c.Assert(collect([]interface{}{20}, nil, false), DeepEquals, []int64{5, 6})
+		c.Assert(collect(nil, nil, false), HasLen, 7)
+		// Empty ranges.
+		c.Assert(collect([]interface{}{11}, []interface{}{14}, true), IsNil)
+		c.Assert(collect([]interface{}{10}, []interface{}{10}, false), IsNil)
+		c.Assert(collect([]interface{}{20}, []interface{}{10}, true), IsNil)
+		// NULL bounds, NULL sorts first.
+		c.Assert(collect([]interface{}{nil}, []interface{}{nil}, true), DeepEquals, []int64{0})
+		c.Assert(collect([]interface{}{nil}, []interface{}{5}, false), DeepEquals, []int64{0})
+	}
+
+	tblInfo := newTestTableInfo([]string{"a"}, []int{0}, false)
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+	_, err := idx.SeekRange(s.sc, newTestStore(), types.MakeDatums(1, 2), nil, false)
+	c.Assert(err, NotNil)
+}
+
+func (s *testIndexInternalSuite) TestKeyRange(c *C) {
+	for _, unique := range []bool{true, false} {
+		s.store = newTestStore()
+		idx := s.newIndex([]string{"a", "b"}, unique)
+		for i, vals := range [][]interface{}{{nil, "x"}, {5, "x"}, {10, "x"}, {10, "y"}, {15, "x"}, {20, "x"}, {25, "x"}} {
+			_, err := idx.Create(s.sctx, s.store, types.MakeDatums(vals...), int64(i))
+			c.Assert(err, IsNil)
+		}
+		kr, err := idx.KeyRange(s.sc, nil, nil, false)
+		c.Assert(err, IsNil)
+		c.Assert([]byte(kr.StartKey), BytesEquals, []byte(idx.prefix))
+		c.Assert([]byte(kr.EndKey), BytesEquals, []byte(idx.prefix.PrefixNext()))
+
+		// The range has the keys of the entries SeekRange returns.
+		for _, t := range []struct {
+			low, high     []interface{}
+			highInclusive bool
+			expected      int
+		}{
+			{[]interface{}{10}, []interface{}{20}, true, 4},
+			{[]interface{}{10}, []interface{}{20}, false, 3},
+			{[]interface{}{10, "y"}, []interface{}{20, "x"}, true, 3},
+			{[]interface{}{10, "y"}, []interface{}{20, "x"}, false, 2},
+			{nil, []interface{}{5}, true, 2},
+			{[]interface{}{20}, []interface{}{10}, true, 0},
+		} {
+			low, high := types.MakeDatums(t.low...), types.MakeDatums(t.high...)
+			kr, err := idx.KeyRange(s.sc, low, high, t.highInclusive)
+			c.Assert(err, IsNil)
+			var keys []string
+			for _, e := range dumpKVs(c, s.store, idx.prefix) {
+				if kv.Key(e[0]).Cmp(kr.StartKey) >= 0 && kv.Key(e[0]).Cmp(kr.EndKey) < 0 {
+					keys = append(keys, e[0])
+				}
+			}
+			c.Assert(keys, HasLen, t.expected, Commentf("%v", t))
+			it, err := idx.SeekRange(s.sc, s.store, low, high, t.highInclusive)
+			c.Assert(err, IsNil)
+			var seekKeys []string
+			for {
+				vals, h, err := it.Next()
+				if terror.ErrorEqual(err, io.EOF) {
+					break
+				}
+				c.Assert(err, IsNil)
+				key, _, err := idx.GenIndexKey(s.sc, vals, h, nil)
+				c.Assert(err, IsNil)
+				seekKeys = append(seekKeys, string(key))
+			}
+			it.Close()
+			c.Assert(seekKeys, DeepEquals, keys)
+		}
+	}
+	idx := s.newIndex([]string{"a"}, false)
+	_, err := idx.KeyRange(s.sc, types.MakeDatums(1, 2), nil, false)
+	c.Assert(err, NotNil)
+}
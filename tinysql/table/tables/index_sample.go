@@ -0,0 +1,108 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"math/rand"
+
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/types"
+)
+
+// StatsSampler is fed the entries returned by the iterator of SampleWhileScanning, e.g. to build the
+// histogram of the index from the samples.
+type StatsSampler interface {
+	// Sample is called with the decoded values and the handle of every entry the iterator returns,
+	// vals may be reused by the iterator after it returns.
+	Sample(vals []types.Datum, h int64)
+}
+
+// SampledEntry is an entry kept by a ReservoirSampler.
+type SampledEntry struct {
+	Values []types.Datum
+	Handle int64
+}
+
+// ReservoirSampler is a StatsSampler keeping a uniform random sample of a fixed size of the entries,
+// see Algorithm R. The sample is deterministic for a seed and the order of the entries.
+type ReservoirSampler struct {
+	size    int
+	seen    int64
+	rng     *rand.Rand
+	samples []SampledEntry
+}
+
+// NewReservoirSampler returns a ReservoirSampler keeping at most size entries, its random choices are
+// seeded by seed.
+func NewReservoirSampler(size int, seed int64) *ReservoirSampler {
+	return &ReservoirSampler{size: size, rng: rand.New(rand.NewSource(seed))}
+}
+
+// Sample implements the StatsSampler interface.
+func (s *ReservoirSampler) Sample(vals []types.Datum, h int64) {
+	s.seen++
+	i := len(s.samples)
+	if i >= s.size {
+		i = int(s.rng.Int63n(s.seen))
+		if i >= s.size {
+			return
+		}
+	}
+	entry := SampledEntry{Values: make([]types.Datum, len(vals)), Handle: h}
+	for j := range vals {
+		entry.Values[j] = types.CloneDatum(vals[j])
+	}
+	if i == len(s.samples) {
+		s.samples = append(s.samples, entry)
+	} else {
+		s.samples[i] = entry
+	}
+}
+
+// Samples returns the sampled entries, in no particular order.
+func (s *ReservoirSampler) Samples() []SampledEntry {
+	return s.samples
+}
+
+// Seen returns the number of the entries fed to the sampler.
+func (s *ReservoirSampler) Seen() int64 {
+	return s.seen
+}
+
+// samplingIter is an index iterator feeding the entries it returns to a StatsSampler.
+type samplingIter struct {
+	table.IndexIterator
+	sampler StatsSampler
+}
+
+// SampleWhileScanning is SeekFirst returning an iterator which also feeds every entry it returns to
+// sampler, so a query scan and the stats collection share one pass over the index. The normal scans
+// don't pay for the sampling.
+func (c *index) SampleWhileScanning(r kv.Retriever, sampler StatsSampler) (table.IndexIterator, error) {
+	it, err := c.SeekFirst(r)
+	if err != nil {
+		return nil, err
+	}
+	return &samplingIter{IndexIterator: it, sampler: sampler}, nil
+}
+
+// Next implements table.IndexIterator Next interface.
+func (c *samplingIter) Next() ([]types.Datum, int64, error) {
+	vals, h, err := c.IndexIterator.Next()
+	if err == nil {
+		c.sampler.Sample(vals, h)
+	}
+	return vals, h, err
+}
@@ -0,0 +1,82 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"io"
+	"math/rand"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/parser/terror"
+	"github.com/pingcap/tidb/types"
+)
+
+func (s *testIndexInternalSuite) TestSampleWhileScanning(c *C) {
+	const rows, size, seed = 100, 10, 42
+	idx := s.newIndex([]string{"a"}, false)
+	for i := 0; i < rows; i++ {
+		_, err := idx.Create(s.sctx, s.store, types.MakeDatums(i*2), int64(i))
+		c.Assert(err, IsNil)
+	}
+
+	sampler := NewReservoirSampler(size, seed)
+	it, err := idx.SampleWhileScanning(s.store, sampler)
+	c.Assert(err, IsNil)
+	defer it.Close()
+	var scanned []int64
+	for {
+		_, h, err := it.Next()
+		if terror.ErrorEqual(err, io.EOF) {
+			break
+		}
+		c.Assert(err, IsNil)
+		scanned = append(scanned, h)
+	}
+	// The caller still sees every entry.
+	c.Assert(scanned, HasLen, rows)
+	c.Assert(sampler.Seen(), Equals, int64(rows))
+
+	// Algorithm R over the handles in scan order with the same seed.
+	rng := rand.New(rand.NewSource(seed))
+	expected := make([]int64, 0, size)
+	for i, h := range scanned {
+		if i < size {
+			expected = append(expected, h)
+		} else if j := rng.Int63n(int64(i + 1)); j < size {
+			expected[j] = h
+		}
+	}
+	samples := sampler.Samples()
+	c.Assert(samples, HasLen, size)
+	for i, e := range samples {
+		c.Assert(e.Handle, Equals, expected[i])
+		c.Assert(e.Values, HasLen, 1)
+		c.Assert(e.Values[0].GetInt64(), Equals, e.Handle*2)
+	}
+	// The sample isn't just the first entries.
+	c.Assert(expected, Not(DeepEquals), scanned[:size])
+
+	// Fewer entries than the sample size are all kept.
+	small := NewReservoirSampler(rows*2, seed)
+	it2, err := idx.SampleWhileScanning(s.store, small)
+	c.Assert(err, IsNil)
+	defer it2.Close()
+	for {
+		if _, _, err := it2.Next(); err != nil {
+			c.Assert(terror.ErrorEqual(err, io.EOF), IsTrue)
+			break
+		}
+	}
+	c.Assert(small.Samples(), HasLen, rows)
+}
@@ -0,0 +1,88 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/sessionctx/stmtctx"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/codec"
+)
+
+// HandleIterator iterates the handles of the index entries in key order.
+type HandleIterator interface {
+	// Next returns the handle of the next entry, or io.EOF after the last one.
+	Next() (int64, error)
+	Close()
+}
+
+// handleIter is the HandleIterator of an indexIter, which decodes only the handles of the entries.
+type handleIter struct {
+	*indexIter
+}
+
+// SeekHandles is Seek returning an iterator over only the handles of the entries from low, e.g. for a DELETE
+// by index or the batch fetch of the rows. Its Next skips the index values in the key instead of decoding
+// them, and takes the handle from the value of a distinct entry or from the end of the key of any other.
+func (c *index) SeekHandles(sc *stmtctx.StatementContext, r kv.Retriever, low []types.Datum) (HandleIterator, error) {
+	if c.isCommonHandle() {
+		return nil, errors.Errorf("index %s of a table with common handles has no int handles", c.idxInfo.Name)
+	}
+	it, _, err := c.Seek(sc, r, low)
+	if err != nil {
+		return nil, err
+	}
+	return &handleIter{it.(*indexIter)}, nil
+}
+
+// Next implements HandleIterator Next interface.
+func (c *handleIter) Next() (int64, error) {
+	if err := c.checkNext(); err != nil {
+		return 0, err
+	}
+	c.count++
+	h, err := c.idx.decodeEntryHandleOnly(c.it.Key(), c.it.Value())
+	if err != nil {
+		return 0, err
+	}
+	c.handle = h
+	return h, c.it.Next()
+}
+
+// decodeEntryHandleOnly decodes the handle of the entry of key and value, the index values are only skipped.
+func (c *index) decodeEntryHandleOnly(key, value []byte) (int64, error) {
+	remain := key[len(c.prefix):]
+	for i := range c.idxInfo.Columns {
+		var err error
+		if remain, err = c.cutIndexValue(remain, i); err != nil {
+			return 0, err
+		}
+	}
+	if len(remain) == 0 {
+		return c.decodeHandleValue(value)
+	}
+	if c.compactHandles {
+		return DecodeHandleCompact(remain)
+	}
+	// The handle is the last datum, after the sequence of an index which keeps the insertion order.
+	if len(remain) < 9 {
+		return 0, errors.Errorf("index %s has an invalid handle suffix %x", c.idxInfo.Name, remain)
+	}
+	_, d, err := codec.DecodeOne(remain[len(remain)-9:])
+	if err != nil {
+		return 0, err
+	}
+	return d.GetInt64(), nil
+}
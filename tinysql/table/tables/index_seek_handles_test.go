@@ -0,0 +1,124 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"io"
+	"testing"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/parser/terror"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/mock"
+)
+
+func (s *testIndexInternalSuite) TestSeekHandles(c *C) {
+	for _, t := range []struct {
+		unique bool
+		opts   []IndexOption
+	}{
+		{unique: true},
+		{unique: false},
+		{unique: false, opts: []IndexOption{WithCompactHandles()}},
+		{unique: true, opts: []IndexOption{WithCompactHandles(), WithWriteTime(nil)}},
+	} {
+		s.store = newTestStore()
+		idx := s.newIndex([]string{"a", "b"}, t.unique, t.opts...)
+		rows := [][]interface{}{{1, "x"}, {2, nil}, {2, "y"}, {3, "z"}, {-1, "w"}}
+		for i, row := range rows {
+			_, err := idx.Create(s.sctx, s.store, types.MakeDatums(row...), []int64{5, 10, -20, 1 << 40, -7}[i])
+			c.Assert(err, IsNil)
+		}
+		it, _, err := idx.Seek(s.sc, s.store, types.MakeDatums(2, nil))
+		c.Assert(err, IsNil)
+		var expected []int64
+		for {
+			_, h, err := it.Next()
+			if terror.ErrorEqual(err, io.EOF) {
+				break
+			}
+			c.Assert(err, IsNil)
+			expected = append(expected, h)
+		}
+		it.Close()
+		c.Assert(expected, HasLen, 3)
+
+		hit, err := idx.SeekHandles(s.sc, s.store, types.MakeDatums(2, nil))
+		c.Assert(err, IsNil)
+		var handles []int64
+		for {
+			h, err := hit.Next()
+			if terror.ErrorEqual(err, io.EOF) {
+				break
+			}
+			c.Assert(err, IsNil)
+			handles = append(handles, h)
+		}
+		hit.Close()
+		c.Assert(handles, DeepEquals, expected, Commentf("unique %v", t.unique))
+	}
+}
+
+// benchScanIndex returns an index with n entries in its store.
+func benchScanIndex(b *testing.B, n int, unique bool) (*index, kv.Retriever) {
+	tblInfo := newTestTableInfo([]string{"a", "b", "c"}, []int{0, 1, 2}, unique)
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+	store := newTestStore()
+	sctx := mock.NewContext()
+	for i := 0; i < n; i++ {
+		if _, err := idx.Create(sctx, store, types.MakeDatums(i, "some string value", float64(i)/3), int64(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return idx, store
+}
+
+func benchmarkScan(b *testing.B, unique, handlesOnly bool) {
+	idx, store := benchScanIndex(b, 1000, unique)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var err error
+		if handlesOnly {
+			var it HandleIterator
+			if it, err = idx.SeekHandles(nil, store, nil); err != nil {
+				b.Fatal(err)
+			}
+			for err == nil {
+				_, err = it.Next()
+			}
+			it.Close()
+		} else {
+			var it table.IndexIterator
+			if it, _, err = idx.Seek(nil, store, nil); err != nil {
+				b.Fatal(err)
+			}
+			for err == nil {
+				_, _, err = it.Next()
+			}
+			it.Close()
+		}
+		if errors.Cause(err) != io.EOF {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkScanFullDecodeUnique(b *testing.B)    { benchmarkScan(b, true, false) }
+func BenchmarkScanHandlesUnique(b *testing.B)       { benchmarkScan(b, true, true) }
+func BenchmarkScanFullDecodeNonUnique(b *testing.B) { benchmarkScan(b, false, false) }
+func BenchmarkScanHandlesNonUnique(b *testing.B)    { benchmarkScan(b, false, true) }
@@ -0,0 +1,64 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/sessionctx/stmtctx"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/types"
+)
+
+// IndexSnapshot creates the iterators of several indices which all read from one snapshot, so they're
+// consistent with each other at one point in time, e.g. to verify two indices of a table agree, even if
+// the indices are written concurrently.
+type IndexSnapshot struct {
+	snap kv.Snapshot
+	ver  kv.Version
+}
+
+// NewIndexSnapshot returns an IndexSnapshot reading from snap, which is pinned at ver.
+func NewIndexSnapshot(snap kv.Snapshot, ver kv.Version) *IndexSnapshot {
+	return &IndexSnapshot{snap: snap, ver: ver}
+}
+
+// OpenIndexSnapshot returns an IndexSnapshot reading store at ver, or at the current version if ver is kv.MaxVersion.
+func OpenIndexSnapshot(store kv.Storage, ver kv.Version) (*IndexSnapshot, error) {
+	if ver.Cmp(kv.MaxVersion) == 0 {
+		var err error
+		if ver, err = store.CurrentVersion(); err != nil {
+			return nil, err
+		}
+	}
+	snap, err := store.GetSnapshot(ver)
+	if err != nil {
+		return nil, err
+	}
+	return NewIndexSnapshot(snap, ver), nil
+}
+
+// Version returns the version the snapshot is pinned at.
+func (s *IndexSnapshot) Version() kv.Version {
+	return s.ver
+}
+
+// SeekFirst returns an iterator of idx from its first entry in the snapshot.
+func (s *IndexSnapshot) SeekFirst(idx table.Index) (table.IndexIterator, error) {
+	return idx.SeekFirst(s.snap)
+}
+
+// Seek returns an iterator of idx from the first entry not less than indexedValues in the snapshot.
+func (s *IndexSnapshot) Seek(sc *stmtctx.StatementContext, idx table.Index, indexedValues []types.Datum) (table.IndexIterator, bool, error) {
+	return idx.Seek(sc, s.snap, indexedValues)
+}
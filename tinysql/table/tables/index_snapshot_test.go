@@ -0,0 +1,105 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tidb/parser/terror"
+	"github.com/pingcap/tidb/sessionctx/stmtctx"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/types"
+)
+
+// snapshotOf copies the KV pairs of buf into a read only retriever, which is a snapshot of buf.
+func snapshotOf(c *C, buf *kv.BufferStore) kv.Snapshot {
+	snap := kv.NewMemDbBuffer(4096)
+	it, err := buf.Iter(nil, nil)
+	c.Assert(err, IsNil)
+	defer it.Close()
+	for it.Valid() {
+		c.Assert(snap.Set(it.Key(), it.Value()), IsNil)
+		c.Assert(it.Next(), IsNil)
+	}
+	return snap
+}
+
+func (s *testIndexInternalSuite) TestIndexSnapshot(c *C) {
+	tblInfo := newTestTableInfo([]string{"a", "b"}, []int{0}, true)
+	tblInfo.Indices = append(tblInfo.Indices, &model.IndexInfo{
+		ID:      tblInfo.Indices[0].ID + 1,
+		Name:    model.NewCIStr("idx_b"),
+		Columns: []*model.IndexColumn{{Name: model.NewCIStr("b"), Offset: 1, Length: types.UnspecifiedLength}},
+		State:   model.StatePublic,
+	})
+	idxA := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0])
+	idxB := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[1])
+	writeRow := func(h int64) {
+		row := types.MakeDatums(h, fmt.Sprintf("b%d", h))
+		for _, idx := range []table.Index{idxA, idxB} {
+			vals, err := idx.FetchValues(row, nil)
+			c.Assert(err, IsNil)
+			_, err = idx.Create(s.sctx, s.store, vals, h)
+			c.Assert(err, IsNil)
+		}
+	}
+	for h := int64(0); h < 50; h++ {
+		writeRow(h)
+	}
+
+	snap := NewIndexSnapshot(snapshotOf(c, s.store), kv.NewVersion(1))
+	c.Assert(snap.Version(), Equals, kv.NewVersion(1))
+	// A writer goes on writing both indices while they're read from the snapshot.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for h := int64(50); h < 100; h++ {
+			writeRow(h)
+		}
+	}()
+	handles := func(idx table.Index) []int64 {
+		it, err := snap.SeekFirst(idx)
+		c.Assert(err, IsNil)
+		defer it.Close()
+		var hs []int64
+		for {
+			_, h, err := it.Next()
+			if terror.ErrorEqual(err, io.EOF) {
+				break
+			}
+			c.Assert(err, IsNil)
+			hs = append(hs, h)
+		}
+		sort.Slice(hs, func(i, j int) bool { return hs[i] < hs[j] })
+		return hs
+	}
+	handlesA, handlesB := handles(idxA), handles(idxB)
+	<-done
+	c.Assert(handlesA, HasLen, 50)
+	c.Assert(handlesB, DeepEquals, handlesA)
+	it, _, err := snap.Seek(&stmtctx.StatementContext{TimeZone: time.Local}, idxB, types.MakeDatums("b7"))
+	c.Assert(err, IsNil)
+	_, h, err := it.Next()
+	c.Assert(err, IsNil)
+	c.Assert(h, Equals, int64(7))
+	it.Close()
+	c.Assert(dumpKVs(c, s.store, tablecodec.EncodeTableIndexPrefix(tblInfo.ID, tblInfo.Indices[1].ID)), HasLen, 100)
+}
@@ -0,0 +1,66 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tidb/parser/mysql"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/types"
+)
+
+// WithStrictValues returns an IndexOption which makes FetchValues check the kind of every fetched value
+// fits the type of its table column, and return ErrIndexValueKindMismatch otherwise, so a row built wrong
+// by the caller fails early instead of writing a key which can't be decoded back. A NULL value always fits,
+// and so does any value of a type without a datum kind of its own, e.g. a DECIMAL.
+func WithStrictValues() IndexOption {
+	return func(c *index) {
+		c.strictValues = true
+	}
+}
+
+// checkValueKind checks the kind of v, fetched for the index column ic, fits the type of the table column.
+func (c *index) checkValueKind(ic *model.IndexColumn, v types.Datum) error {
+	ft := &c.tblInfo.Columns[ic.Offset].FieldType
+	var fits bool
+	switch k := v.Kind(); {
+	case k == types.KindNull:
+		fits = true
+	case k == types.KindMinNotNull || k == types.KindMaxValue:
+		// The bounds of a seek are never stored.
+		fits = false
+	default:
+		switch ft.Tp {
+		case mysql.TypeDate, mysql.TypeDatetime, mysql.TypeTimestamp:
+			fits = k == types.KindMysqlTime
+		case mysql.TypeJSON:
+			fits = k == types.KindMysqlJSON || k == types.KindString || k == types.KindBytes
+		case mysql.TypeNewDecimal, mysql.TypeDuration:
+			fits = true
+		default:
+			switch ft.EvalType() {
+			case types.ETInt:
+				fits = k == types.KindInt64 || k == types.KindUint64 || k == types.KindMysqlBit || k == types.KindBinaryLiteral
+			case types.ETReal:
+				fits = k == types.KindFloat32 || k == types.KindFloat64
+			default:
+				fits = k == types.KindString || k == types.KindBytes || k == types.KindBinaryLiteral || k == types.KindMysqlSet
+			}
+		}
+	}
+	if !fits {
+		return table.ErrIndexValueKindMismatch.GenWithStackByArgs(c.idxInfo.Name, ic.Name, ft.String(), types.KindStr(v.Kind()))
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/parser/mysql"
+	"github.com/pingcap/tidb/parser/terror"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/types"
+)
+
+func (s *testIndexInternalSuite) TestFetchValuesStrict(c *C) {
+	tblInfo := newTestTableInfo([]string{"a", "b", "c"}, []int{0, 1, 2}, false)
+	tblInfo.Columns[0].FieldType = *types.NewFieldType(mysql.TypeLonglong)
+	tblInfo.Columns[1].FieldType = *types.NewFieldType(mysql.TypeVarchar)
+	tblInfo.Columns[2].FieldType = *types.NewFieldType(mysql.TypeDouble)
+
+	// The default fast path doesn't check the kinds.
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0])
+	_, err := idx.FetchValues(types.MakeDatums("x", 1, 1.5), nil)
+	c.Assert(err, IsNil)
+
+	idx = NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], WithStrictValues())
+	vals, err := idx.FetchValues(types.MakeDatums(1, "x", 1.5), nil)
+	c.Assert(err, IsNil)
+	c.Assert(datumsString(c, vals), Equals, "1,x,1.5")
+	_, err = idx.FetchValues(types.MakeDatums(nil, nil, nil), nil)
+	c.Assert(err, IsNil)
+
+	_, err = idx.FetchValues(types.MakeDatums(1, 2, 1.5), nil)
+	c.Assert(terror.ErrorEqual(err, table.ErrIndexValueKindMismatch), IsTrue, Commentf("err %v", err))
+	c.Assert(err, ErrorMatches, ".*column b of type varchar.* gets a value of kind bigint")
+	_, err = idx.FetchValues(types.MakeDatums(1, "x", "1.5"), nil)
+	c.Assert(terror.ErrorEqual(err, table.ErrIndexValueKindMismatch), IsTrue, Commentf("err %v", err))
+}
@@ -0,0 +1,142 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/types"
+)
+
+// defaultTailInterval is the interval Tail polls the store at if none is given.
+const defaultTailInterval = time.Second
+
+// ChangeRecord is a change of an index entry streamed by Tail.
+type ChangeRecord struct {
+	// Version is the version the change is first seen at.
+	Version kv.Version
+	Values  []types.Datum
+	Handle  int64
+	// Deleted is set if the entry is deleted, Values and Handle are the ones of the deleted entry.
+	Deleted bool
+	// Err is set on the last record sent before the channel is closed if Tail fails.
+	Err error
+}
+
+// Tail streams the changes of the index committed after version from, until ctx is done and the channel is
+// closed. The store is polled every interval, and the index is diffed against the previous poll, so the
+// changes are sent in commit order across the polls, and in key order within a poll. The changes committed
+// between two polls are all reported at the later version, and an entry created and deleted between them
+// isn't reported. Every poll reads the whole index, so it suits the small indices, e.g. for a dashboard.
+func (c *index) Tail(ctx context.Context, store kv.Storage, from kv.Version, interval time.Duration) (<-chan ChangeRecord, error) {
+	if interval <= 0 {
+		interval = defaultTailInterval
+	}
+	snap, err := store.GetSnapshot(from)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := readPrefix(snap, c.scanPrefix)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan ChangeRecord)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		last := from
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			ver, next, records, err := c.pollChanges(store, last, entries)
+			if err != nil {
+				records = append(records, ChangeRecord{Version: ver, Err: err})
+			}
+			for _, record := range records {
+				select {
+				case ch <- record:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+			last, entries = ver, next
+		}
+	}()
+	return ch, nil
+}
+
+// pollChanges reads the entries of the index at the current version of store, if it's newer than last,
+// and returns the changes from the entries at last.
+func (c *index) pollChanges(store kv.Storage, last kv.Version, entries [][2][]byte) (kv.Version, [][2][]byte, []ChangeRecord, error) {
+	ver, err := store.CurrentVersion()
+	if err != nil || ver.Cmp(last) <= 0 {
+		return last, entries, nil, err
+	}
+	snap, err := store.GetSnapshot(ver)
+	if err != nil {
+		return ver, nil, nil, err
+	}
+	next, err := readPrefix(snap, c.scanPrefix)
+	if err != nil {
+		return ver, nil, nil, err
+	}
+	var records []ChangeRecord
+	record := func(entry [2][]byte, deleted bool) error {
+		vals, h, err := c.decodeEntry(entry[0], entry[1])
+		if err != nil {
+			return err
+		}
+		records = append(records, ChangeRecord{Version: ver, Values: vals, Handle: h, Deleted: deleted})
+		return nil
+	}
+	// Both are in key order, so they're merged like sorted lists.
+	i, j := 0, 0
+	for err == nil && (i < len(entries) || j < len(next)) {
+		cmp := 1
+		if i < len(entries) && j < len(next) {
+			cmp = bytes.Compare(entries[i][0], next[j][0])
+		} else if i < len(entries) {
+			cmp = -1
+		}
+		switch {
+		case cmp < 0:
+			err = record(entries[i], true)
+			i++
+		case cmp > 0:
+			err = record(next[j], false)
+			j++
+		default:
+			if !bytes.Equal(entries[i][1], next[j][1]) {
+				// A unique entry pointing to another handle is a new entry of the same values.
+				err = record(next[j], false)
+			}
+			i++
+			j++
+		}
+	}
+	if err != nil {
+		return ver, nil, nil, err
+	}
+	return ver, next, records, nil
+}
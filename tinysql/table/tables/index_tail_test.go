@@ -0,0 +1,90 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/types"
+)
+
+// versionedStore is a kv.Storage keeping a snapshot of every committed version.
+type versionedStore struct {
+	kv.Storage
+	mu    sync.Mutex
+	snaps []kv.Snapshot
+}
+
+// commit saves a snapshot of buf as the next version.
+func (s *versionedStore) commit(c *C, buf *kv.BufferStore) {
+	snap := snapshotOf(c, buf)
+	s.mu.Lock()
+	s.snaps = append(s.snaps, snap)
+	s.mu.Unlock()
+}
+
+func (s *versionedStore) CurrentVersion() (kv.Version, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return kv.NewVersion(uint64(len(s.snaps) - 1)), nil
+}
+
+func (s *versionedStore) GetSnapshot(ver kv.Version) (kv.Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snaps[ver.Ver], nil
+}
+
+func (s *testIndexInternalSuite) TestTail(c *C) {
+	idx := s.newIndex([]string{"a"}, true)
+	store := &versionedStore{}
+	_, err := idx.Create(s.sctx, s.store, types.MakeDatums(5), 5)
+	c.Assert(err, IsNil)
+	store.commit(c, s.store)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := idx.Tail(ctx, store, kv.NewVersion(0), time.Millisecond)
+	c.Assert(err, IsNil)
+	var got []string
+	receive := func(n int) {
+		for ; n > 0; n-- {
+			select {
+			case record := <-ch:
+				c.Assert(record.Err, IsNil)
+				got = append(got, fmt.Sprintf("%d:%s:%d:%v", record.Version.Ver, datumsString(c, record.Values), record.Handle, record.Deleted))
+			case <-time.After(5 * time.Second):
+				c.Fatalf("got %v", got)
+			}
+		}
+	}
+	// The entries are created after tailing starts.
+	_, err = idx.Create(s.sctx, s.store, types.MakeDatums(3), 3)
+	c.Assert(err, IsNil)
+	store.commit(c, s.store)
+	receive(1)
+	_, err = idx.Create(s.sctx, s.store, types.MakeDatums(1), 1)
+	c.Assert(err, IsNil)
+	c.Assert(idx.Delete(s.sc, s.store, types.MakeDatums(5), 5), IsNil)
+	store.commit(c, s.store)
+	receive(2)
+	c.Assert(got, DeepEquals, []string{"1:3:3:false", "2:1:1:false", "2:5:5:true"})
+	cancel()
+	for range ch {
+	}
+}
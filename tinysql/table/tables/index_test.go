@@ -18,7 +18,9 @@ import (
 	"io"
 	"time"
 
+	"github.com/pingcap-incubator/tinykv/proto/pkg/kvrpcpb"
 	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
 	"github.com/pingcap/tidb/domain"
 	"github.com/pingcap/tidb/kv"
 	"github.com/pingcap/tidb/parser/model"
@@ -26,6 +28,8 @@ import (
 	"github.com/pingcap/tidb/session"
 	"github.com/pingcap/tidb/sessionctx/stmtctx"
 	"github.com/pingcap/tidb/store/mockstore"
+	"github.com/pingcap/tidb/store/mockstore/mocktikv"
+	"github.com/pingcap/tidb/table"
 	"github.com/pingcap/tidb/table/tables"
 	"github.com/pingcap/tidb/types"
 	"github.com/pingcap/tidb/util/mock"
@@ -119,7 +123,7 @@ func (s *testIndexSuite) TestIndex(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(hit, IsTrue)
 
-	err = index.Drop(txn)
+	_, _, err = index.Drop(txn)
 	c.Assert(err, IsNil)
 
 	it, hit, err = index.Seek(sc, txn, values)
@@ -247,3 +251,67 @@ func (s *testIndexSuite) TestCombineIndexSeek(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(h, Equals, int64(1))
 }
+
+var _ = Suite(&testIndexTxnSuite{})
+
+// testIndexTxnSuite writes the indexes in the transactions of a mocked TiKV store, the data committed by the
+// other transactions is written to its MVCC store directly.
+type testIndexTxnSuite struct{}
+
+func (s *testIndexTxnSuite) TestCreateAssertion(c *C) {
+	mvccStore, err := mocktikv.NewMVCCLevelDB("")
+	c.Assert(err, IsNil)
+	store, err := mockstore.NewMockTikvStore(mockstore.WithMVCCStore(mvccStore))
+	c.Assert(err, IsNil)
+	defer store.Close()
+	tblInfo := &model.TableInfo{
+		ID: 1,
+		Indices: []*model.IndexInfo{
+			{
+				ID:      2,
+				Name:    model.NewCIStr("test"),
+				Unique:  true,
+				Columns: []*model.IndexColumn{{Offset: 0}},
+			},
+		},
+		Columns: []*model.ColumnInfo{{Offset: 0}},
+	}
+	index := tables.NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0])
+	mockCtx := mock.NewContext()
+
+	// Another transaction has committed an entry for 2.
+	buf := kv.NewMemDbBuffer(kv.DefaultTxnMembufCap)
+	_, err = index.Create(mockCtx, buf, types.MakeDatums(2), 20)
+	c.Assert(err, IsNil)
+	var mutations []*kvrpcpb.Mutation
+	var keys [][]byte
+	err = kv.WalkMemBuffer(buf, func(k kv.Key, v []byte) error {
+		mutations = append(mutations, &kvrpcpb.Mutation{Op: kvrpcpb.Op_Put, Key: k, Value: v})
+		keys = append(keys, k)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	for _, err := range mvccStore.Prewrite(&kvrpcpb.PrewriteRequest{Mutations: mutations, PrimaryLock: keys[0], StartVersion: 1}) {
+		c.Assert(err, IsNil)
+	}
+	c.Assert(mvccStore.Commit(keys, 1, 2), IsNil)
+
+	// The duplicate of 2 isn't read by the Create but fails the commit.
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	stats := &table.KVOpStats{}
+	for i := int64(1); i <= 2; i++ {
+		_, err = index.Create(mockCtx, txn, types.MakeDatums(i), i, table.WithAssertion(kv.NotExist), table.WithOpStats(stats))
+		c.Assert(err, IsNil)
+	}
+	c.Assert(stats.Gets, Equals, 0)
+	err = txn.Commit(context.Background())
+	c.Assert(kv.ErrKeyExists.Equal(errors.Cause(err)), IsTrue, Commentf("err %v", err))
+
+	// Without the assertion, it fails the Create.
+	txn, err = store.Begin()
+	c.Assert(err, IsNil)
+	_, err = index.Create(mockCtx, txn, types.MakeDatums(2), 2)
+	c.Assert(kv.ErrKeyExists.Equal(err), IsTrue, Commentf("err %v", err))
+	c.Assert(txn.Rollback(), IsNil)
+}
@@ -0,0 +1,139 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"context"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/sessionctx/stmtctx"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/types"
+)
+
+// WithTombstones returns an IndexOption which makes Delete keep a deleted entry as a tombstone instead of
+// removing its key: the value of the entry is kept in the versioned envelope with the delete time returned
+// by now, or time.Now if it's nil. The reads and the unique checks take a tombstone as a missing entry, and
+// the iterators skip them, except the ones returned by SeekWithTombstones, e.g. for a snapshot read which
+// needs the entries as they were before the deletes. PurgeTombstones removes the tombstones deleted before a safe point.
+// An index which keeps the insertion order or stores the original values in the values isn't supported.
+func WithTombstones(now func() time.Time) IndexOption {
+	return func(c *index) {
+		if now == nil {
+			now = time.Now
+		}
+		c.tombstoneNow = now
+	}
+}
+
+// isTombstone reports whether value is the value of a tombstoned entry.
+func (c *index) isTombstone(value []byte) bool {
+	return c.tombstoneNow != nil && isVersionedValue(value) && value[1]&valueTombstoneFlag != 0
+}
+
+// get is r.Get of the entry of key, a tombstone is taken as a missing entry.
+func (c *index) get(ctx context.Context, r kv.Retriever, key kv.Key) ([]byte, error) {
+	value, err := r.Get(ctx, key)
+	if err == nil && c.isTombstone(value) {
+		return nil, kv.ErrNotExist
+	}
+	return value, err
+}
+
+// dropTombstones removes the tombstones from the values returned by batchGet.
+func (c *index) dropTombstones(values map[string][]byte) map[string][]byte {
+	if c.tombstoneNow == nil {
+		return values
+	}
+	for k, v := range values {
+		if c.isTombstone(v) {
+			delete(values, k)
+		}
+	}
+	return values
+}
+
+// deleteKey removes the entry of key, or tombstones it with its live value, the payload of the envelope,
+// for an index with tombstones. The tombstone of an entry of a global index keeps the partition ID.
+func (c *index) deleteKey(m kv.Mutator, key kv.Key, value []byte) error {
+	if c.tombstoneNow == nil {
+		return m.Delete(key)
+	}
+	flags := valueTombstoneFlag
+	if c.global {
+		flags |= valuePartitionFlag
+	}
+	return m.Set(key, c.envelope(flags, value, "", c.tombstoneNow()))
+}
+
+// SeekWithTombstones is Seek returning an iterator which also returns the tombstoned entries,
+// whose EntryMeta returned by NextWithMeta tells they're deleted, see WithTombstones.
+func (c *index) SeekWithTombstones(sc *stmtctx.StatementContext, r kv.Retriever, indexedValues []types.Datum) (table.IndexIterator, error) {
+	if c.tombstoneNow == nil {
+		return nil, errors.Errorf("index %s doesn't keep tombstones", c.idxInfo.Name)
+	}
+	it, _, err := c.Seek(sc, r, indexedValues)
+	if err != nil {
+		return nil, err
+	}
+	it.(*indexIter).withTombstones = true
+	return it, nil
+}
+
+// PurgeTombstones removes the tombstones deleted before safePoint, after which no snapshot read needs them,
+// and returns the number of removed tombstones.
+func (c *index) PurgeTombstones(rm kv.RetrieverMutator, safePoint time.Time) (int, error) {
+	if c.tombstoneNow == nil {
+		return 0, nil
+	}
+	it, err := rm.Iter(c.scanPrefix, c.scanPrefix.PrefixNext())
+	if err != nil {
+		return 0, err
+	}
+	defer it.Close()
+	var keys []kv.Key
+	for it.Valid() && it.Key().HasPrefix(c.scanPrefix) {
+		var meta EntryMeta
+		if _, meta, err = c.splitValue(it.Value()); err != nil {
+			return 0, err
+		}
+		if meta.Deleted && meta.DeleteTime.Before(safePoint) {
+			keys = append(keys, append(kv.Key(nil), it.Key()...))
+		}
+		if err = it.Next(); err != nil {
+			return 0, err
+		}
+	}
+	for _, key := range keys {
+		if err = rm.Delete(key); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+// skipTombstones moves the iterator past the tombstones unless it returns them.
+func (c *indexIter) skipTombstones() error {
+	if c.withTombstones || c.it == nil {
+		return nil
+	}
+	for c.it.Valid() && c.idx.isTombstone(c.it.Value()) {
+		if err := c.it.Next(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"context"
+	"io"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/parser/terror"
+	"github.com/pingcap/tidb/types"
+)
+
+func (s *testIndexInternalSuite) TestTombstones(c *C) {
+	now := time.Unix(100, 0)
+	for _, unique := range []bool{false, true} {
+		s.store = newTestStore()
+		idx := s.newIndex([]string{"a"}, unique, WithTombstones(func() time.Time { return now }))
+		for h := int64(1); h <= 3; h++ {
+			_, err := idx.Create(s.sctx, s.store, types.MakeDatums(h*10), h)
+			c.Assert(err, IsNil)
+		}
+		c.Assert(idx.Delete(s.sc, s.store, types.MakeDatums(20), 2), IsNil)
+		c.Assert(dumpKVs(c, s.store, idx.prefix), HasLen, 3)
+
+		exist, _, err := idx.Exist(s.sc, s.store, types.MakeDatums(20), 2)
+		c.Assert(err, IsNil)
+		c.Assert(exist, IsFalse)
+
+		// The latest read skips the tombstone.
+		it, err := idx.SeekFirst(s.store)
+		c.Assert(err, IsNil)
+		var handles []int64
+		for {
+			_, h, err := it.Next()
+			if terror.ErrorEqual(err, io.EOF) {
+				break
+			}
+			c.Assert(err, IsNil)
+			handles = append(handles, h)
+		}
+		it.Close()
+		c.Assert(handles, DeepEquals, []int64{1, 3})
+
+		// The snapshot read still sees the deleted entry.
+		it, err = idx.SeekWithTombstones(s.sc, s.store, nil)
+		c.Assert(err, IsNil)
+		handles = handles[:0]
+		var deleted []int64
+		for {
+			_, h, meta, err := it.(*indexIter).NextWithMeta()
+			if terror.ErrorEqual(err, io.EOF) {
+				break
+			}
+			c.Assert(err, IsNil)
+			handles = append(handles, h)
+			if meta.Deleted {
+				c.Assert(meta.DeleteTime.Equal(now), IsTrue)
+				deleted = append(deleted, h)
+			}
+		}
+		it.Close()
+		c.Assert(handles, DeepEquals, []int64{1, 2, 3})
+		c.Assert(deleted, DeepEquals, []int64{2})
+
+		// A tombstone doesn't conflict, the entry is created again over it.
+		_, err = idx.Create(s.sctx, s.store, types.MakeDatums(20), 4)
+		c.Assert(err, IsNil)
+		exist, _, err = idx.Exist(s.sc, s.store, types.MakeDatums(20), 4)
+		c.Assert(err, IsNil)
+		c.Assert(exist, IsTrue)
+
+		c.Assert(idx.Delete(s.sc, s.store, types.MakeDatums(30), 3), IsNil)
+		n, err := idx.PurgeTombstones(s.store, now)
+		c.Assert(err, IsNil)
+		c.Assert(n, Equals, 0)
+		n, err = idx.PurgeTombstones(s.store, now.Add(time.Second))
+		c.Assert(err, IsNil)
+		if unique {
+			c.Assert(n, Equals, 1)
+		} else {
+			// The non-distinct tombstone of handle 2 is still kept under its own key.
+			c.Assert(n, Equals, 2)
+		}
+		c.Assert(dumpKVs(c, s.store, idx.prefix), HasLen, 2)
+
+		// An entry written before the index keeps tombstones, a '0' or a handle ending with 0x05, is live,
+		// and its tombstone keeps the value in the envelope.
+		key, distinct, err := idx.GenIndexKey(s.sc, types.MakeDatums(50), 5, nil)
+		c.Assert(err, IsNil)
+		value := []byte{'0'}
+		if distinct {
+			value = EncodeHandle(5)
+		}
+		c.Assert(s.store.Set(key, value), IsNil)
+		exist, h, err := idx.Exist(s.sc, s.store, types.MakeDatums(50), 5)
+		c.Assert(err, IsNil)
+		c.Assert(exist, IsTrue)
+		c.Assert(h, Equals, int64(5))
+		c.Assert(idx.Delete(s.sc, s.store, types.MakeDatums(50), 5), IsNil)
+		exist, _, err = idx.Exist(s.sc, s.store, types.MakeDatums(50), 5)
+		c.Assert(err, IsNil)
+		c.Assert(exist, IsFalse)
+		tombstone, err := s.store.Get(context.TODO(), key)
+		c.Assert(err, IsNil)
+		payload, meta, err := idx.splitValue(tombstone)
+		c.Assert(err, IsNil)
+		c.Assert(payload, BytesEquals, value)
+		c.Assert(meta.Deleted, IsTrue)
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/parser/charset"
+	"github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tidb/parser/mysql"
+	"github.com/pingcap/tidb/sessionctx/stmtctx"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/mock"
+)
+
+func (s *testIndexInternalSuite) TestSeekTruncatesValues(c *C) {
+	for _, unique := range []bool{true, false} {
+		tblInfo := newTestTableInfo([]string{"a"}, []int{0}, unique)
+		tblInfo.Columns[0].Charset = charset.CharsetUTF8MB4
+		tblInfo.Indices[0].Columns[0].Length = 3
+		idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0])
+		sctx := mock.NewContext()
+		sc := &stmtctx.StatementContext{TimeZone: time.Local}
+		buf := newTestStore()
+		for i, v := range []string{"abcdef", "abd", "xyz"} {
+			_, err := idx.Create(sctx, buf, types.MakeDatums(v), int64(i+1))
+			c.Assert(err, IsNil)
+		}
+
+		seek := func(v string) (string, int64, bool) {
+			it, hit, err := idx.Seek(sc, buf, types.MakeDatums(v))
+			c.Assert(err, IsNil)
+			defer it.Close()
+			vals, h, err := it.Next()
+			c.Assert(err, IsNil)
+			return vals[0].GetString(), h, hit
+		}
+		// The search value longer than the prefix length finds the truncated entry.
+		v, h, hit := seek("abcXYZ-longer-than-prefix")
+		c.Assert(v, Equals, "abc")
+		c.Assert(h, Equals, int64(1))
+		c.Assert(hit, Equals, unique)
+		v, h, _ = seek("abcdef")
+		c.Assert(v, Equals, "abc")
+		c.Assert(h, Equals, int64(1))
+		v, h, _ = seek("abca")
+		c.Assert(v, Equals, "abc")
+		c.Assert(h, Equals, int64(1))
+		v, h, _ = seek("abda")
+		c.Assert(v, Equals, "abd")
+		c.Assert(h, Equals, int64(2))
+	}
+}
+
+func (s *testIndexInternalSuite) TestTruncateUTF8MB4(c *C) {
+	tblInfo := newTestTableInfo([]string{"a"}, []int{0}, true)
+	tblInfo.Columns[0].Charset = charset.CharsetUTF8MB4
+	tblInfo.Indices[0].Columns[0].Length = 3
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0])
+	for _, t := range []struct {
+		value    string
+		expected string
+	}{
+		{"a\U0001F600b\U0001F600c", "a\U0001F600b"},
+		{"\U00020000\U00020001\U00020002\U00020003", "\U00020000\U00020001\U00020002"},
+		// An invalid byte counts as a rune and is kept as is.
+		{"a\xffb\U0001F600c", "a\xffb"},
+	} {
+		for _, d := range []types.Datum{types.NewStringDatum(t.value), types.NewBytesDatum([]byte(t.value))} {
+			vals := []types.Datum{d}
+			truncated := TruncateIndexValuesIfNeeded(tblInfo, tblInfo.Indices[0], vals)
+			c.Assert(truncated[0].GetBytes(), BytesEquals, []byte(t.expected))
+			c.Assert(truncated[0].Kind(), Equals, d.Kind())
+			key1, _, err := idx.GenIndexKey(s.sc, vals, 1, nil)
+			c.Assert(err, IsNil)
+			key2, _, err := idx.GenIndexKey(s.sc, vals, 1, nil)
+			c.Assert(err, IsNil)
+			c.Assert(key2, BytesEquals, key1)
+			key3, _, err := idx.GenIndexKey(s.sc, truncated, 1, nil)
+			c.Assert(err, IsNil)
+			c.Assert(key3, BytesEquals, key1)
+
+			// Exist and Delete find the entry written by Create.
+			buf := newTestStore()
+			_, err = idx.Create(s.sctx, buf, vals, 1)
+			c.Assert(err, IsNil)
+			exist, h, err := idx.Exist(s.sc, buf, vals, 1)
+			c.Assert(err, IsNil)
+			c.Assert(exist, IsTrue)
+			c.Assert(h, Equals, int64(1))
+			c.Assert(idx.Delete(s.sc, buf, vals, 1), IsNil)
+			c.Assert(dumpKVs(c, buf, tablecodec.EncodeTableIndexPrefix(tblInfo.ID, tblInfo.Indices[0].ID)), HasLen, 0)
+		}
+	}
+}
+
+// legacyTruncateIndexValues is TruncateIndexValuesIfNeeded before the datums were set once, which set
+// a truncated string datum through a string copy or a bytes round trip. It's the reference of the outputs.
+func legacyTruncateIndexValues(tblInfo *model.TableInfo, idxInfo *model.IndexInfo, indexedValues []types.Datum) []types.Datum {
+	indexedValues = append([]types.Datum(nil), indexedValues...)
+	for i := range indexedValues {
+		v := &indexedValues[i]
+		if v.Kind() != types.KindString && v.Kind() != types.KindBytes {
+			continue
+		}
+		ic := idxInfo.Columns[i]
+		col := tblInfo.Columns[ic.Offset]
+		colValue := v.GetBytes()
+		if col.Tp == mysql.TypeString && types.IsBinaryStr(&col.FieldType) && len(colValue) < col.Flen {
+			padded := make([]byte, col.Flen)
+			copy(padded, colValue)
+			colValue = padded
+			if v.Kind() == types.KindBytes {
+				v.SetBytes(colValue)
+			} else {
+				v.SetString(string(colValue))
+			}
+		}
+		origKind := v.Kind()
+		if col.Charset == charset.CharsetUTF8 || col.Charset == charset.CharsetUTF8MB4 {
+			if ic.Length != types.UnspecifiedLength && utf8.RuneCount(colValue) > ic.Length {
+				truncated := colValue[:runePrefixLen(colValue, ic.Length)]
+				if origKind == types.KindBytes {
+					v.SetBytes(truncated)
+				} else {
+					v.SetString(string(truncated))
+				}
+			}
+		} else if ic.Length != types.UnspecifiedLength && len(colValue) > ic.Length {
+			v.SetBytes(colValue[:ic.Length])
+			if origKind == types.KindString {
+				v.SetString(v.GetString())
+			}
+		}
+	}
+	return indexedValues
+}
+
+// newTruncateTableInfo returns a table with a utf8mb4 column, a latin1 column and a BINARY(4) column,
+// whose index stores 2 characters, 3 bytes and 3 bytes of them.
+func newTruncateTableInfo() *model.TableInfo {
+	tblInfo := newTestTableInfo([]string{"u", "l", "b"}, []int{0, 1, 2}, false)
+	tblInfo.Columns[0].Charset = charset.CharsetUTF8MB4
+	tblInfo.Columns[1].Charset = charset.CharsetLatin1
+	binTp := types.NewFieldType(mysql.TypeString)
+	binTp.Flen, binTp.Charset, binTp.Collate = 4, charset.CharsetBin, charset.CollationBin
+	tblInfo.Columns[2].FieldType = *binTp
+	tblInfo.Indices[0].Columns[0].Length = 2
+	tblInfo.Indices[0].Columns[1].Length = 3
+	tblInfo.Indices[0].Columns[2].Length = 3
+	return tblInfo
+}
+
+func (s *testIndexInternalSuite) TestTruncateSetOnce(c *C) {
+	tblInfo := newTruncateTableInfo()
+	idxInfo := tblInfo.Indices[0]
+	for _, vals := range [][]string{
+		{"你好世界", "abcdef", "ab"},
+		{"a\U0001F600b", "ab", "abcdef"},
+		{"ab", "abc", "a"},
+		{"a\xffb", "\xe4\xbd\xa0\xe5", ""},
+	} {
+		for _, bytesKind := range []bool{false, true} {
+			row := make([]types.Datum, len(vals))
+			for i, v := range vals {
+				if bytesKind {
+					row[i] = types.NewBytesDatum([]byte(v))
+				} else {
+					row[i] = types.NewStringDatum(v)
+				}
+			}
+			expected := legacyTruncateIndexValues(tblInfo, idxInfo, row)
+			truncated := TruncateIndexValuesIfNeeded(tblInfo, idxInfo, row)
+			c.Assert(truncated, HasLen, len(expected))
+			for i := range expected {
+				c.Assert(truncated[i].Kind(), Equals, expected[i].Kind())
+				c.Assert(truncated[i].GetBytes(), BytesEquals, expected[i].GetBytes())
+			}
+			// The row isn't truncated in place.
+			for i, v := range vals {
+				c.Assert(row[i].GetString(), Equals, v)
+			}
+		}
+	}
+}
+
+func benchmarkTruncate(b *testing.B, truncate func(*model.TableInfo, *model.IndexInfo, []types.Datum) []types.Datum) {
+	tblInfo := newTruncateTableInfo()
+	row := types.MakeDatums("你好世界", "abcdef", "abcdef")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		truncate(tblInfo, tblInfo.Indices[0], row)
+	}
+}
+
+func BenchmarkTruncateIndexValues(b *testing.B) {
+	benchmarkTruncate(b, TruncateIndexValuesIfNeeded)
+}
+
+func BenchmarkTruncateIndexValuesLegacy(b *testing.B) {
+	benchmarkTruncate(b, legacyTruncateIndexValues)
+}
+
+func (s *testIndexInternalSuite) TestTruncateIntoScratch(c *C) {
+	tblInfo := newTruncateTableInfo()
+	idxInfo := tblInfo.Indices[0]
+	idx := NewIndex(tblInfo.ID, tblInfo, idxInfo).(*index)
+	row := types.MakeDatums("你好世界", "abcdef", "ab")
+	orig := datumsString(c, row)
+	expected := TruncateIndexValuesIfNeeded(tblInfo, idxInfo, row)
+
+	// A short scratch is grown, and the grown one is reused.
+	truncated := TruncateIndexValuesInto(tblInfo, idxInfo, row, make([]types.Datum, 0, 1))
+	c.Assert(datumsString(c, truncated), Equals, datumsString(c, expected))
+	scratch := make([]types.Datum, 0, 3)
+	truncated = TruncateIndexValuesInto(tblInfo, idxInfo, row, scratch)
+	c.Assert(datumsString(c, truncated), Equals, datumsString(c, expected))
+	c.Assert(&truncated[0], Equals, &scratch[:1][0])
+	c.Assert(datumsString(c, row), Equals, orig)
+
+	var valsScratch []types.Datum
+	for h := int64(1); h <= 3; h++ {
+		key, distinct, newScratch, err := idx.GenIndexKeyWithScratch(s.sc, row, h, nil, valsScratch)
+		c.Assert(err, IsNil)
+		expectedKey, expectedDistinct, err := idx.GenIndexKey(s.sc, row, h, nil)
+		c.Assert(err, IsNil)
+		c.Assert(key, BytesEquals, expectedKey)
+		c.Assert(distinct, Equals, expectedDistinct)
+		c.Assert(datumsString(c, row), Equals, orig)
+		if valsScratch != nil {
+			c.Assert(&newScratch[0], Equals, &valsScratch[0])
+		}
+		valsScratch = newScratch
+	}
+	// Only the padding of the binary value is allocated with a scratch.
+	allocs := testing.AllocsPerRun(10, func() {
+		TruncateIndexValuesInto(tblInfo, idxInfo, row, valsScratch)
+	})
+	noScratchAllocs := testing.AllocsPerRun(10, func() {
+		TruncateIndexValuesIfNeeded(tblInfo, idxInfo, row)
+	})
+	c.Assert(allocs, Equals, noScratchAllocs-1)
+}
@@ -0,0 +1,106 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"container/list"
+	"encoding/binary"
+	"math"
+	"sync"
+
+	"github.com/pingcap/tidb/sessionctx/stmtctx"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/codec"
+)
+
+// valueCache is a bounded LRU cache of the encoded original values stored in the entry values,
+// keyed by the value tuple, so a load of repetitive values encodes every distinct tuple once.
+// It's safe for concurrent use.
+type valueCache struct {
+	mu       sync.Mutex
+	capacity int
+	lru      *list.List
+	items    map[string]*list.Element
+	// keyBuf is reused to build the cache keys under mu.
+	keyBuf []byte
+}
+
+type valueCacheItem struct {
+	key     string
+	encoded []byte
+}
+
+func newValueCache(capacity int) *valueCache {
+	return &valueCache{
+		capacity: capacity,
+		lru:      list.New(),
+		items:    make(map[string]*list.Element, capacity),
+	}
+}
+
+// encode returns codec.EncodeKey of vals from the cache, encoding and caching them if they're missed.
+// The returned slice is shared and must not be modified.
+func (vc *valueCache) encode(sc *stmtctx.StatementContext, vals []types.Datum) ([]byte, error) {
+	vc.mu.Lock()
+	vc.keyBuf = appendTupleKey(vc.keyBuf[:0], vals)
+	if elem, ok := vc.items[string(vc.keyBuf)]; ok {
+		vc.lru.MoveToFront(elem)
+		vc.mu.Unlock()
+		return elem.Value.(*valueCacheItem).encoded, nil
+	}
+	key := string(vc.keyBuf)
+	vc.mu.Unlock()
+
+	encoded, err := codec.EncodeKey(sc, nil, vals...)
+	if err != nil {
+		return nil, err
+	}
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	if elem, ok := vc.items[key]; ok {
+		// Another writer has cached the same tuple.
+		vc.lru.MoveToFront(elem)
+		return encoded, nil
+	}
+	vc.items[key] = vc.lru.PushFront(&valueCacheItem{key: key, encoded: encoded})
+	if vc.lru.Len() > vc.capacity {
+		oldest := vc.lru.Back()
+		vc.lru.Remove(oldest)
+		delete(vc.items, oldest.Value.(*valueCacheItem).key)
+	}
+	return encoded, nil
+}
+
+// appendTupleKey appends a cache key of vals to b, which is cheaper to build than the memcomparable encoding.
+// Every datum is its kind followed by its raw value, the bytes are prefixed by their length.
+func appendTupleKey(b []byte, vals []types.Datum) []byte {
+	var num [binary.MaxVarintLen64]byte
+	for i := range vals {
+		d := &vals[i]
+		b = append(b, d.Kind())
+		switch d.Kind() {
+		case types.KindInt64:
+			b = append(b, num[:binary.PutVarint(num[:], d.GetInt64())]...)
+		case types.KindUint64:
+			b = append(b, num[:binary.PutUvarint(num[:], d.GetUint64())]...)
+		case types.KindFloat32, types.KindFloat64:
+			b = append(b, num[:binary.PutUvarint(num[:], math.Float64bits(d.GetFloat64()))]...)
+		case types.KindString, types.KindBytes:
+			raw := d.GetBytes()
+			b = append(b, num[:binary.PutUvarint(num[:], uint64(len(raw)))]...)
+			b = append(b, raw...)
+		}
+	}
+	return b
+}
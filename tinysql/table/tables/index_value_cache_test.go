@@ -0,0 +1,100 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/codec"
+	"github.com/pingcap/tidb/util/mock"
+)
+
+func (s *testIndexInternalSuite) TestValueCache(c *C) {
+	tblInfo := newTestTableInfo([]string{"a", "b"}, []int{0, 1}, false)
+	hash := func(d types.Datum) uint64 { return uint64(len(d.GetString())) }
+	plain := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], WithHashedColumns(hash, 0)).(*index)
+	cached := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], WithHashedColumns(hash, 0), WithValueCache(4)).(*index)
+	plainBuf, cachedBuf := newTestStore(), newTestStore()
+	for i := 0; i < 100; i++ {
+		vals := types.MakeDatums(fmt.Sprintf("v%d", i%7), i%3)
+		_, err := plain.Create(s.sctx, plainBuf, vals, int64(i))
+		c.Assert(err, IsNil)
+		_, err = cached.Create(s.sctx, cachedBuf, vals, int64(i))
+		c.Assert(err, IsNil)
+	}
+	c.Assert(dumpKVs(c, cachedBuf, cached.prefix), DeepEquals, dumpKVs(c, plainBuf, plain.prefix))
+	c.Assert(cached.valueCache.lru.Len(), Equals, 4)
+	c.Assert(cached.valueCache.items, HasLen, 4)
+
+	// The tuples of different kinds don't share a cache key.
+	a, err := cached.valueCache.encode(s.sc, []types.Datum{types.NewIntDatum(1)})
+	c.Assert(err, IsNil)
+	b, err := cached.valueCache.encode(s.sc, []types.Datum{types.NewUintDatum(1)})
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Equal(a, b), IsFalse)
+
+	// The cache is safe for concurrent use.
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				vals := types.MakeDatums(fmt.Sprintf("v%d", (i+g)%9))
+				encoded, err := cached.valueCache.encode(s.sc, vals)
+				c.Assert(err, IsNil)
+				expected, err := codec.EncodeKey(s.sc, nil, vals...)
+				c.Assert(err, IsNil)
+				c.Assert(encoded, BytesEquals, expected)
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func benchmarkCoveringLoad(b *testing.B, opts ...IndexOption) {
+	tblInfo := newTestTableInfo([]string{"a", "b", "c"}, []int{0, 1, 2}, false)
+	hash := func(d types.Datum) uint64 { return uint64(len(d.GetString())) }
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0], append(opts, WithHashedColumns(hash, 0, 1, 2))...)
+	sctx := mock.NewContext()
+	rows := make([][]types.Datum, 16)
+	for i := range rows {
+		rows[i] = types.MakeDatums(fmt.Sprintf("region-%d", i%4), fmt.Sprintf("status-%d-%s", i%2, strings.Repeat("x", 64)), "payload")
+	}
+	buf := newTestStore()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%4096 == 0 {
+			buf = newTestStore()
+		}
+		if _, err := idx.Create(sctx, buf, rows[i%len(rows)], int64(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCoveringLoad(b *testing.B) {
+	benchmarkCoveringLoad(b)
+}
+
+func BenchmarkCoveringLoadValueCache(b *testing.B) {
+	benchmarkCoveringLoad(b, WithValueCache(64))
+}
@@ -0,0 +1,115 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/tablecodec"
+)
+
+// The index values are written in the versioned layout of tablecodec.EncodeVersionedIndexValue, the value of
+// the unversioned layout, e.g. '0' or the handle, led by kv.IndexValueVersion, a flags byte and the metadata.
+const (
+	// valuePartitionFlag is set for the partition ID of the row, see WithGlobal.
+	valuePartitionFlag = tablecodec.IndexValuePartitionFlag
+	// valueHintFlag is set for the placement hint, see WithPlacementHints.
+	valueHintFlag = tablecodec.IndexValueHintFlag
+	// valueWriteTimeFlag is set for the write time, see WithWriteTime.
+	valueWriteTimeFlag = tablecodec.IndexValueWriteTimeFlag
+	// valueTombstoneFlag is set for the delete time of a tombstone, see WithTombstones.
+	valueTombstoneFlag = tablecodec.IndexValueTombstoneFlag
+	// valueUntouchedFlag is set for an untouched value, which has no other field, see kv.IndexValueUntouchedFlag.
+	valueUntouchedFlag = kv.IndexValueUntouchedFlag
+)
+
+// WithLegacyValues returns an IndexOption which writes the values in the unversioned layout, without the
+// envelope, e.g. while the nodes of an older version which can't read the envelope are still running. The
+// values in the envelope are still read. The metadata is only stored in the envelope, so it can't be used
+// with WithGlobal, WithPlacementHints, WithWriteTime or WithTombstones.
+func WithLegacyValues() IndexOption {
+	return func(c *index) {
+		c.legacyValues = true
+	}
+}
+
+// isVersionedValue reports whether value is in the versioned layout.
+func isVersionedValue(value []byte) bool {
+	return tablecodec.IsVersionedIndexValue(value)
+}
+
+// stampValue returns payload in the versioned envelope with the metadata the index stores: the partition ID,
+// the placement hint and the write time.
+func (c *index) stampValue(payload []byte, hint string) []byte {
+	if c.legacyValues {
+		return payload
+	}
+	var flags byte
+	if c.global {
+		flags |= valuePartitionFlag
+	}
+	if c.placementHints {
+		flags |= valueHintFlag
+	}
+	if c.writeTimeNow != nil {
+		flags |= valueWriteTimeFlag
+	}
+	return c.envelope(flags, payload, hint, time.Time{})
+}
+
+// untouchedValue returns payload, an untouched value of the unversioned layout, in the versioned envelope.
+func (c *index) untouchedValue(payload []byte) []byte {
+	if c.legacyValues {
+		return payload
+	}
+	return c.envelope(valueUntouchedFlag, payload, "", time.Time{})
+}
+
+// envelope returns payload in the versioned layout with the fields of flags.
+func (c *index) envelope(flags byte, payload []byte, hint string, deleteTime time.Time) []byte {
+	fields := tablecodec.IndexValueFields{Flags: flags, PartitionID: c.partitionID, Hint: hint}
+	if flags&valueWriteTimeFlag != 0 {
+		fields.WriteTime = c.writeTimeNow().UnixNano()
+	}
+	if flags&valueTombstoneFlag != 0 {
+		fields.DeleteTime = deleteTime.UnixNano()
+	}
+	return tablecodec.EncodeVersionedIndexValue(fields, payload)
+}
+
+// splitValue splits the payload of value from the metadata of its envelope. An unversioned value is the
+// payload itself, with a zero write time, an empty hint and a zero partition ID.
+func (c *index) splitValue(value []byte) ([]byte, EntryMeta, error) {
+	var meta EntryMeta
+	payload, fields, err := tablecodec.DecodeVersionedIndexValue(value)
+	if err != nil {
+		return nil, meta, errors.Annotatef(err, "index %s", c.idxInfo.Name)
+	}
+	if fields.Flags&valuePartitionFlag != 0 {
+		meta.PartitionID = fields.PartitionID
+	}
+	if fields.Flags&valueHintFlag != 0 {
+		meta.PlacementHint = fields.Hint
+	}
+	if fields.Flags&valueWriteTimeFlag != 0 {
+		meta.WriteTime = time.Unix(0, fields.WriteTime)
+	}
+	if fields.Flags&valueTombstoneFlag != 0 {
+		meta.Deleted = true
+		meta.DeleteTime = time.Unix(0, fields.DeleteTime)
+	}
+	return payload, meta, nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/parser/terror"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/types"
+)
+
+func (s *testIndexInternalSuite) TestValueVersion(c *C) {
+	// The big-endian encoding of the handle starts with the version byte.
+	const versionLikeHandle = int64(-1) << 57
+	for _, unique := range []bool{true, false} {
+		s.store = newTestStore()
+		legacy := s.newIndex([]string{"a"}, unique, WithLegacyValues())
+		versioned := s.newIndex([]string{"a"}, unique)
+		// The legacy and the versioned entries are interleaved in the index.
+		for _, e := range []struct {
+			v   interface{}
+			h   int64
+			idx *index
+		}{
+			{1, versionLikeHandle, legacy},
+			{2, 2, versioned},
+			{nil, 3, legacy},
+			{3, 4, versioned},
+			{nil, 5, versioned},
+			{4, 6, legacy},
+		} {
+			_, err := e.idx.Create(s.sctx, s.store, types.MakeDatums(e.v), e.h)
+			c.Assert(err, IsNil)
+		}
+
+		var versionedValues []string
+		for _, pair := range dumpKVs(c, s.store, versioned.prefix) {
+			if isVersionedValue([]byte(pair[1])) {
+				versionedValues = append(versionedValues, fmt.Sprintf("%x", pair[1]))
+			}
+		}
+		if unique {
+			c.Assert(versionedValues, DeepEquals, []string{"fe0030", "fe000000000000000002", "fe000000000000000004"})
+		} else {
+			c.Assert(versionedValues, DeepEquals, []string{"fe0030", "fe0030", "fe0030"})
+		}
+
+		it, err := versioned.SeekFirst(s.store)
+		c.Assert(err, IsNil)
+		var got []string
+		for {
+			vals, h, err := it.Next()
+			if terror.ErrorEqual(err, io.EOF) {
+				break
+			}
+			c.Assert(err, IsNil)
+			got = append(got, fmt.Sprintf("%s:%d", datumsString(c, vals), h))
+		}
+		it.Close()
+		c.Assert(got, DeepEquals, []string{"NULL:3", "NULL:5", fmt.Sprintf("1:%d", versionLikeHandle), "2:2", "3:4", "4:6"})
+
+		for _, e := range []struct {
+			v interface{}
+			h int64
+		}{{1, versionLikeHandle}, {2, 2}, {nil, 5}, {4, 6}} {
+			for _, idx := range []*index{legacy, versioned} {
+				exist, h, err := idx.Exist(s.sc, s.store, types.MakeDatums(e.v), e.h)
+				c.Assert(err, IsNil)
+				c.Assert(exist, IsTrue)
+				c.Assert(h, Equals, e.h)
+			}
+		}
+		if unique {
+			h, err := versioned.Create(s.sctx, s.store, types.MakeDatums(1), 7)
+			c.Assert(kv.ErrKeyExists.Equal(err), IsTrue, Commentf("err %v", err))
+			c.Assert(h, Equals, versionLikeHandle)
+			h, err = legacy.Create(s.sctx, s.store, types.MakeDatums(3), 7)
+			c.Assert(kv.ErrKeyExists.Equal(err), IsTrue, Commentf("err %v", err))
+			c.Assert(h, Equals, int64(4))
+		}
+	}
+}
+
+func (s *testIndexInternalSuite) TestValueVersionPadding(c *C) {
+	// The compact handles take from 1 to 9 bytes, so some values would be 8 or 9 bytes long with the envelope.
+	idx := s.newIndex([]string{"a"}, true, WithCompactHandles())
+	handles := []int64{1, 1 << 20, 1 << 28, 1 << 36, 1 << 44, math.MaxInt64, -1, -1 << 40}
+	lens := make(map[int]bool)
+	for i, h := range handles {
+		_, err := idx.Create(s.sctx, s.store, types.MakeDatums(i), h)
+		c.Assert(err, IsNil)
+	}
+	for _, pair := range dumpKVs(c, s.store, idx.prefix) {
+		value := []byte(pair[1])
+		c.Assert(isVersionedValue(value), IsTrue, Commentf("value %x", value))
+		lens[len(value)] = true
+	}
+	c.Assert(lens[8] || lens[9], IsFalse)
+	c.Assert(lens[10] && lens[11], IsTrue, Commentf("lens %v", lens))
+	for i, h := range handles {
+		exist, got, err := idx.Exist(s.sc, s.store, types.MakeDatums(i), h)
+		c.Assert(err, IsNil)
+		c.Assert(exist, IsTrue)
+		c.Assert(got, Equals, h)
+	}
+
+	// A value whose flags aren't known, e.g. written by a newer version, isn't guessed at.
+	key, _, err := idx.GenIndexKey(s.sc, types.MakeDatums(0), 1, nil)
+	c.Assert(err, IsNil)
+	c.Assert(s.store.Set(key, []byte{kv.IndexValueVersion, 0x10, 0, 0x81, 0x03}), IsNil)
+	_, _, err = idx.Exist(s.sc, s.store, types.MakeDatums(0), 1)
+	c.Assert(err, ErrorMatches, ".*has unknown flags 0x10")
+	c.Assert(s.store.Set(key, []byte{kv.IndexValueVersion, valueHintFlag, 5, 'a', 0x81, 0x03}), IsNil)
+	_, _, err = idx.Exist(s.sc, s.store, types.MakeDatums(0), 1)
+	c.Assert(err, ErrorMatches, ".*is shorter than its fields")
+}
+
+func (s *testIndexInternalSuite) TestValueVersionUntouched(c *C) {
+	s.sctx.Store = &txnStore{txn: &memBufferTxn{mem: kv.NewMemDbBuffer(4096)}}
+	c.Assert(s.sctx.NewTxn(context.Background()), IsNil)
+	for _, legacy := range []bool{false, true} {
+		for _, unique := range []bool{true, false} {
+			s.store = newTestStore()
+			var opts []IndexOption
+			if legacy {
+				opts = append(opts, WithLegacyValues())
+			}
+			idx := s.newIndex([]string{"a"}, unique, opts...)
+			_, err := idx.Create(s.sctx, s.store, types.MakeDatums(1), 49, table.IndexIsUntouched)
+			c.Assert(err, IsNil)
+			_, err = idx.Create(s.sctx, s.store, types.MakeDatums(2), 49)
+			c.Assert(err, IsNil)
+			kvs := dumpKVs(c, s.store, idx.prefix)
+			c.Assert(kvs, HasLen, 2)
+			c.Assert(isVersionedValue([]byte(kvs[0][1])), Equals, !legacy)
+			c.Assert(tablecodec.IsUntouchedIndexKValue([]byte(kvs[0][0]), []byte(kvs[0][1])), IsTrue)
+			// The handle 49 ends with the untouched flag byte, its committed value isn't untouched.
+			c.Assert(tablecodec.IsUntouchedIndexKValue([]byte(kvs[1][0]), []byte(kvs[1][1])), IsFalse)
+			exist, h, err := idx.Exist(s.sc, s.store, types.MakeDatums(1), 49)
+			c.Assert(err, IsNil)
+			c.Assert(exist, IsTrue)
+			c.Assert(h, Equals, int64(49))
+		}
+	}
+}
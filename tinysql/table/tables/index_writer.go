@@ -0,0 +1,272 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"context"
+
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/sessionctx"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/types"
+)
+
+// BufferedIndexWriter buffers the entries written by Create and flushes them to the underlying
+// kv.RetrieverMutator in key order, so a backend sees ordered writes during a bulk load.
+// Reads done by Create, e.g. the unique checks, see the buffered entries as well.
+type BufferedIndexWriter struct {
+	idx       *index
+	rm        kv.RetrieverMutator
+	buf       *kv.BufferStore
+	batchSize int
+}
+
+// NewBufferedWriter returns a BufferedIndexWriter which writes to rm, it flushes automatically
+// when batchSize entries are buffered. The caller must call Flush after the last Create.
+func (c *index) NewBufferedWriter(rm kv.RetrieverMutator, batchSize int) *BufferedIndexWriter {
+	return &BufferedIndexWriter{
+		idx:       c,
+		rm:        rm,
+		buf:       kv.NewBufferStore(rm, kv.DefaultTxnMembufCap),
+		batchSize: batchSize,
+	}
+}
+
+// Create buffers a new entry like index.Create does.
+func (w *BufferedIndexWriter) Create(sctx sessionctx.Context, indexedValues []types.Datum, h int64, opts ...table.CreateIdxOptFunc) (int64, error) {
+	handle, err := w.idx.Create(sctx, w.buf, indexedValues, h, opts...)
+	if err != nil {
+		return handle, err
+	}
+	if w.batchSize > 0 && w.buf.Len() >= w.batchSize {
+		return 0, w.Flush()
+	}
+	return 0, nil
+}
+
+// Flush writes the buffered entries in key order and clears the buffer.
+func (w *BufferedIndexWriter) Flush() error {
+	if err := w.buf.SaveTo(w.rm); err != nil {
+		return err
+	}
+	w.buf.Reset()
+	return nil
+}
+
+// RowIndexWriter writes the entries of all the indices of a table for a row in one pass.
+// The keys of all the indices are generated into one shared buffer, the unique entries are checked
+// together before any entry is written, and then all the entries are set.
+type RowIndexWriter struct {
+	indices []table.Index
+	keyBuf  []byte
+	scratch []byte
+	valsBuf []types.Datum
+	entries []rowIndexEntry
+}
+
+// rowIndexEntry is an index entry of the row, its key is keyBuf[start:end].
+type rowIndexEntry struct {
+	idx        *index
+	start, end int
+	distinct   bool
+}
+
+// batchGetter is implemented by the kv.Retriever which can get several keys in one call.
+type batchGetter interface {
+	BatchGet(ctx context.Context, keys []kv.Key) (map[string][]byte, error)
+}
+
+// NewRowIndexWriter returns a RowIndexWriter for indices, which are usually all the writable indices of a table.
+func NewRowIndexWriter(indices []table.Index) *RowIndexWriter {
+	return &RowIndexWriter{indices: indices}
+}
+
+// WriteRow writes the index entries of row with handle h, which leave the same KV state as calling
+// Create of every index. Unlike the separate Creates, if a unique index already has an entry with
+// the same values, nothing is written and the existing entry's handle is returned with ErrKeyExists.
+// The indices which need their own write path, e.g. hashed indices, and the untouched entries are
+// written by Create, with the included values fetched from row for an index with included columns.
+func (w *RowIndexWriter) WriteRow(sctx sessionctx.Context, rm kv.RetrieverMutator, row []types.Datum, h int64, opts ...table.CreateIdxOptFunc) (int64, error) {
+	var opt table.CreateIdxOpt
+	for _, fn := range opts {
+		fn(&opt)
+	}
+	sc := sctx.GetSessionVars().StmtCtx
+	skipCheck := sc.BatchCheck
+	w.keyBuf = w.keyBuf[:0]
+	w.entries = w.entries[:0]
+	var others []table.Index
+	for _, idx := range w.indices {
+		c, ok := idx.(*index)
+		if !ok || opt.Untouched || c.storesOriginal() || c.seqGen != nil || (opt.PlacementHint != "" && !c.placementHints) || len(c.includeCols) > 0 || c.predicate != nil {
+			others = append(others, idx)
+			continue
+		}
+		vals, err := c.FetchValues(row, w.valsBuf)
+		if err != nil {
+			return 0, err
+		}
+		w.valsBuf = vals
+		key, distinct, err := c.GenIndexKey(sc, vals, h, w.scratch)
+		if err != nil {
+			return 0, err
+		}
+		w.scratch = key
+		start := len(w.keyBuf)
+		w.keyBuf = append(w.keyBuf, key...)
+		w.entries = append(w.entries, rowIndexEntry{idx: c, start: start, end: len(w.keyBuf), distinct: distinct})
+	}
+
+	if !skipCheck {
+		if handle, err := w.checkUnique(rm); err != nil {
+			return handle, err
+		}
+	}
+	for _, e := range w.entries {
+		// non-unique index doesn't need store value, write a '0' to reduce space
+		value := []byte{'0'}
+		if e.distinct {
+			value = e.idx.encodeHandleValue(h)
+		}
+		if err := rm.Set(w.keyBuf[e.start:e.end], e.idx.stampValue(value, opt.PlacementHint)); err != nil {
+			return 0, err
+		}
+	}
+	for _, idx := range others {
+		vals, err := idx.FetchValues(row, w.valsBuf)
+		if err != nil {
+			return 0, err
+		}
+		w.valsBuf = vals
+		idxOpts := opts
+		if c, ok := idx.(*index); ok && len(c.includeCols) > 0 && !opt.Untouched {
+			included, err := c.FetchIncludedValues(row)
+			if err != nil {
+				return 0, err
+			}
+			idxOpts = append(opts[:len(opts):len(opts)], table.WithIncludedValues(included))
+		}
+		if c, ok := idx.(*index); ok && c.predicate != nil {
+			idxOpts = append(idxOpts[:len(idxOpts):len(idxOpts)], table.WithRow(row))
+		}
+		if handle, err := idx.Create(sctx, rm, vals, h, idxOpts...); err != nil {
+			return handle, err
+		}
+	}
+	return 0, nil
+}
+
+// checkUnique checks the distinct entries of the row don't exist, in one BatchGet if rm supports it.
+// It returns the handle of the first existing entry with ErrKeyExists.
+func (w *RowIndexWriter) checkUnique(rm kv.RetrieverMutator) (int64, error) {
+	var keys []kv.Key
+	var entries []rowIndexEntry
+	for _, e := range w.entries {
+		if e.distinct {
+			keys = append(keys, w.keyBuf[e.start:e.end])
+			entries = append(entries, e)
+		}
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	values, err := batchGet(context.TODO(), rm, keys)
+	if err != nil {
+		return 0, err
+	}
+	for i, key := range keys {
+		if value, ok := values[string(key)]; ok && !entries[i].idx.isTombstone(value) {
+			return existingHandle(entries[i].idx, value)
+		}
+	}
+	return 0, nil
+}
+
+// batchGet gets the values of the existing keys, in one BatchGet if r supports it.
+func batchGet(ctx context.Context, r kv.Retriever, keys []kv.Key) (map[string][]byte, error) {
+	if bg, ok := r.(batchGetter); ok {
+		return bg.BatchGet(ctx, keys)
+	}
+	values := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		value, err := r.Get(ctx, key)
+		if kv.IsErrNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[string(key)] = value
+	}
+	return values, nil
+}
+
+// existingHandle returns the handle in the value of an existing unique entry of idx with ErrKeyExists.
+func existingHandle(idx *index, value []byte) (int64, error) {
+	handle, err := idx.decodeHandleValue(value)
+	if err != nil {
+		return 0, err
+	}
+	return handle, kv.ErrKeyExists
+}
+
+// opCountingMutator counts the writes to a kv.Mutator, see table.WithOpStats.
+type opCountingMutator struct {
+	kv.Mutator
+	stats *table.KVOpStats
+}
+
+// Set implements the kv.Mutator interface.
+func (m *opCountingMutator) Set(k kv.Key, v []byte) error {
+	m.stats.Sets++
+	return m.Mutator.Set(k, v)
+}
+
+// Delete implements the kv.Mutator interface.
+func (m *opCountingMutator) Delete(k kv.Key) error {
+	m.stats.Deletes++
+	return m.Mutator.Delete(k)
+}
+
+// opCountingRM counts the reads and the writes of a kv.RetrieverMutator.
+type opCountingRM struct {
+	opCountingMutator
+	r kv.Retriever
+}
+
+// Get implements the kv.Retriever interface.
+func (m *opCountingRM) Get(ctx context.Context, k kv.Key) ([]byte, error) {
+	m.stats.Gets++
+	return m.r.Get(ctx, k)
+}
+
+// Iter implements the kv.Retriever interface.
+func (m *opCountingRM) Iter(k kv.Key, upperBound kv.Key) (kv.Iterator, error) {
+	m.stats.Iters++
+	return m.r.Iter(k, upperBound)
+}
+
+// IterReverse implements the kv.Retriever interface.
+func (m *opCountingRM) IterReverse(k kv.Key) (kv.Iterator, error) {
+	m.stats.Iters++
+	return m.r.IterReverse(k)
+}
+
+// countOps returns m counting its operations in stats, which is still a kv.Retriever if m is.
+func countOps(m kv.Mutator, stats *table.KVOpStats) kv.Mutator {
+	if rm, ok := m.(kv.RetrieverMutator); ok {
+		return &opCountingRM{opCountingMutator{rm, stats}, rm}
+	}
+	return &opCountingMutator{m, stats}
+}
@@ -0,0 +1,170 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"context"
+	"sort"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tidb/parser/terror"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/types"
+)
+
+// recordMutator records the keys set through it.
+type recordMutator struct {
+	kv.RetrieverMutator
+	setKeys []kv.Key
+}
+
+func (m *recordMutator) Set(k kv.Key, v []byte) error {
+	m.setKeys = append(m.setKeys, append(kv.Key{}, k...))
+	return m.RetrieverMutator.Set(k, v)
+}
+
+func (s *testIndexInternalSuite) TestBufferedWriter(c *C) {
+	idx := s.newIndex([]string{"a"}, true)
+	values := []int64{7, 3, 9, 1, 5, 2, 8, 6, 4}
+
+	expected := newTestStore()
+	for i, v := range values {
+		_, err := idx.Create(s.sctx, expected, types.MakeDatums(v), int64(i))
+		c.Assert(err, IsNil)
+	}
+
+	store := &recordMutator{RetrieverMutator: newTestStore()}
+	w := idx.NewBufferedWriter(store, 4)
+	for i, v := range values {
+		_, err := w.Create(s.sctx, types.MakeDatums(v), int64(i))
+		c.Assert(err, IsNil)
+	}
+	// The unique check sees the buffered entries.
+	h, err := w.Create(s.sctx, types.MakeDatums(int64(4)), 100)
+	c.Assert(terror.ErrorEqual(err, kv.ErrKeyExists), IsTrue, Commentf("err %v", err))
+	c.Assert(h, Equals, int64(8))
+	c.Assert(w.Flush(), IsNil)
+
+	c.Assert(dumpKVs(c, store, idx.prefix), DeepEquals, dumpKVs(c, expected, idx.prefix))
+	// Every batch of 4 is written in key order.
+	c.Assert(store.setKeys, HasLen, len(values))
+	for i := 0; i < len(store.setKeys); i += 4 {
+		end := i + 4
+		if end > len(store.setKeys) {
+			end = len(store.setKeys)
+		}
+		batch := store.setKeys[i:end]
+		c.Assert(sort.SliceIsSorted(batch, func(i, j int) bool { return batch[i].Cmp(batch[j]) < 0 }), IsTrue)
+	}
+}
+
+func (s *testIndexInternalSuite) TestRowIndexWriter(c *C) {
+	tblInfo := newTestTableInfo([]string{"a", "b", "c"}, []int{0}, true)
+	bc := newTestTableInfo([]string{"a", "b", "c"}, []int{1, 2}, false).Indices[0]
+	bc.ID, bc.Name = 3, model.NewCIStr("bc")
+	cIdx := newTestTableInfo([]string{"a", "b", "c"}, []int{2}, true).Indices[0]
+	cIdx.ID, cIdx.Name = 4, model.NewCIStr("c")
+	tblInfo.Indices = append(tblInfo.Indices, bc, cIdx)
+	var indices []table.Index
+	for _, idxInfo := range tblInfo.Indices {
+		indices = append(indices, NewIndex(tblInfo.ID, tblInfo, idxInfo))
+	}
+	rows := [][]interface{}{{1, "x", 10}, {2, "x", nil}, {3, nil, nil}, {4, "y", 11}}
+
+	expected := newTestStore()
+	for i, row := range rows {
+		for _, idx := range indices {
+			vals, err := idx.FetchValues(types.MakeDatums(row...), nil)
+			c.Assert(err, IsNil)
+			_, err = idx.Create(s.sctx, expected, vals, int64(i))
+			c.Assert(err, IsNil)
+		}
+	}
+
+	store := newTestStore()
+	w := NewRowIndexWriter(indices)
+	for i, row := range rows {
+		_, err := w.WriteRow(s.sctx, store, types.MakeDatums(row...), int64(i))
+		c.Assert(err, IsNil)
+	}
+	prefix := tablecodec.EncodeTablePrefix(tblInfo.ID)
+	c.Assert(dumpKVs(c, store, prefix), DeepEquals, dumpKVs(c, expected, prefix))
+
+	// A duplicate on the last unique index writes none of the entries.
+	h, err := w.WriteRow(s.sctx, store, types.MakeDatums(5, "z", 11), 5)
+	c.Assert(terror.ErrorEqual(err, kv.ErrKeyExists), IsTrue, Commentf("err %v", err))
+	c.Assert(h, Equals, int64(3))
+	c.Assert(dumpKVs(c, store, prefix), DeepEquals, dumpKVs(c, expected, prefix))
+}
+
+// memBufferTxn is a transaction which only has a mem buffer, for the untouched entries.
+type memBufferTxn struct {
+	kv.Transaction
+	mem kv.MemBuffer
+}
+
+func (t *memBufferTxn) Valid() bool                { return true }
+func (t *memBufferTxn) GetMemBuffer() kv.MemBuffer { return t.mem }
+
+// txnStore is a kv.Storage beginning txn.
+type txnStore struct {
+	kv.Storage
+	txn kv.Transaction
+}
+
+func (s *txnStore) Begin() (kv.Transaction, error) { return s.txn, nil }
+
+func (s *testIndexInternalSuite) TestOpStats(c *C) {
+	s.sctx.Store = &txnStore{txn: &memBufferTxn{mem: kv.NewMemDbBuffer(4096)}}
+	c.Assert(s.sctx.NewTxn(context.Background()), IsNil)
+	sc := s.sctx.GetSessionVars().StmtCtx
+	for _, t := range []struct {
+		unique    bool
+		untouched bool
+		skipCheck bool
+		expected  table.KVOpStats
+	}{
+		{unique: false, expected: table.KVOpStats{Sets: 1}},
+		{unique: true, expected: table.KVOpStats{Gets: 1, Sets: 1}},
+		{unique: true, skipCheck: true, expected: table.KVOpStats{Sets: 1}},
+		{unique: true, untouched: true, expected: table.KVOpStats{Gets: 1, Sets: 1}},
+	} {
+		tblInfo := newTestTableInfo([]string{"a"}, []int{0}, t.unique)
+		idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+		buf := newTestStore()
+		stats := &table.KVOpStats{}
+		opts := []table.CreateIdxOptFunc{table.WithOpStats(stats)}
+		if t.untouched {
+			opts = append(opts, table.IndexIsUntouched)
+		}
+		sc.BatchCheck = t.skipCheck
+		_, err := idx.Create(s.sctx, buf, types.MakeDatums(1), 1, opts...)
+		c.Assert(err, IsNil)
+		c.Assert(*stats, Equals, t.expected, Commentf("%+v", t))
+		c.Assert(stats.Total(), Equals, t.expected.Gets+t.expected.Sets)
+
+		stats = &table.KVOpStats{}
+		c.Assert(idx.Delete(sc, buf, types.MakeDatums(1), 1, table.WithDeleteOpStats(stats)), IsNil)
+		c.Assert(*stats, Equals, table.KVOpStats{Deletes: 1})
+		if t.unique {
+			stats = &table.KVOpStats{}
+			c.Assert(idx.Delete(sc, buf, types.MakeDatums(1), 1, table.VerifyHandle, table.WithDeleteOpStats(stats)), IsNil)
+			c.Assert(*stats, Equals, table.KVOpStats{Gets: 1})
+		}
+	}
+	sc.BatchCheck = false
+}
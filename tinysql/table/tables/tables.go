@@ -21,6 +21,8 @@ import (
 	"context"
 	"encoding/binary"
 	"math"
+	"math/bits"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -129,7 +131,11 @@ func initTableIndices(t *TableCommon) error {
 		}
 
 		// Use partition ID for index, because TableCommon may be table or partition.
-		idx := NewIndex(t.physicalTableID, tblInfo, idxInfo)
+		// A stale column offset is rejected here instead of corrupting the entries written later.
+		idx, err := NewIndexWithCheck(t.physicalTableID, tblInfo, idxInfo)
+		if err != nil {
+			return err
+		}
 		t.indices = append(t.indices, idx)
 	}
 	return nil
@@ -220,13 +226,14 @@ func (t *TableCommon) IndexPrefix() kv.Key {
 }
 
 // RecordKey implements table.Table interface.
+// The handle is shuffled by ShardHandle if the table has HandleShards.
 func (t *TableCommon) RecordKey(h int64) kv.Key {
-	return tablecodec.EncodeRecordKey(t.recordPrefix, h)
+	return tablecodec.EncodeRecordKey(t.recordPrefix, ShardHandle(h, t.meta.HandleShards))
 }
 
 // FirstKey implements table.Table interface.
 func (t *TableCommon) FirstKey() kv.Key {
-	return t.RecordKey(math.MinInt64)
+	return tablecodec.EncodeRecordKey(t.recordPrefix, math.MinInt64)
 }
 
 // UpdateRecord implements table.Table UpdateRecord interface.
@@ -774,6 +781,7 @@ func (t *TableCommon) IterRecords(ctx sessionctx.Context, startKey kv.Key, cols
 		if err != nil {
 			return err
 		}
+		handle = UnshardHandle(handle, t.meta.HandleShards)
 		rowMap, err := tablecodec.DecodeRow(it.Value(), colMap, ctx.GetSessionVars().Location())
 		if err != nil {
 			return err
@@ -883,6 +891,118 @@ func (t *TableCommon) calcShard(startTS uint64) int64 {
 	return (hashVal & (1<<t.meta.ShardRowIDBits - 1)) << (64 - t.meta.ShardRowIDBits - 1)
 }
 
+// maxHandleShards is the maximum number of shards of ShardHandle.
+const maxHandleShards = 256
+
+// handleShardBits returns the number of the high bits ShardHandle puts the shard of shards in.
+func handleShardBits(shards int) uint {
+	if shards > maxHandleShards {
+		shards = maxHandleShards
+	}
+	return uint(bits.Len(uint(shards - 1)))
+}
+
+// handleShard returns the shard of handle h, which only depends on the bits ShardHandle keeps.
+func handleShard(h int64, shards int) int64 {
+	if shards > maxHandleShards {
+		shards = maxHandleShards
+	}
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(h)&(1<<(63-handleShardBits(shards))-1))
+	return int64(murmur3.Sum32(buf[:]) % uint32(shards))
+}
+
+// ShardHandle shuffles handle h into one of shards hash shards for its row key, like AUTO_RANDOM, so the rows
+// of monotonic handles are spread over shards ranges instead of written to the end of one. The shard is a hash
+// of the low bits of h, and it's XORed into the high bits below the sign bit, so the mapping is a bijection of
+// int64 which UnshardHandle inverts, for every handle. shards is at most maxHandleShards, and a shards below 2
+// leaves h unchanged. Only the row keys are sharded, the index entries store the real handles.
+func ShardHandle(h int64, shards int) int64 {
+	if shards < 2 {
+		return h
+	}
+	shift := 63 - handleShardBits(shards)
+	return h ^ handleShard(h, shards)<<shift
+}
+
+// UnshardHandle returns the real handle of the row key handle h sharded by ShardHandle.
+func UnshardHandle(h int64, shards int) int64 {
+	// The shard only depends on the low bits, which ShardHandle doesn't change, and XOR is its own inverse.
+	return ShardHandle(h, shards)
+}
+
+// ShardHandleRanges returns the ranges of the row key handles which the handles in ranges are sharded into by
+// ShardHandle, sorted and merged. The ranges are inclusive. A short range is sharded handle by handle, but the
+// handles of a long range are only known to be in the parts of the row key handles XORed with any shard, so
+// those ranges may also have the row keys of other handles, which must be filtered out by their handles.
+func ShardHandleRanges(ranges [][2]int64, shards int) [][2]int64 {
+	if shards < 2 {
+		return ranges
+	}
+	if shards > maxHandleShards {
+		shards = maxHandleShards
+	}
+	shift := 63 - handleShardBits(shards)
+	mask := int64(1)<<shift - 1
+	var sharded [][2]int64
+	// A part of the handles which only differ in their low bits is sharded into the parts XORed with the shards.
+	addPart := func(part, first, last int64) {
+		for s := int64(0); s < int64(shards); s++ {
+			base := (part ^ s) << shift
+			sharded = append(sharded, [2]int64{base | first, base | last})
+		}
+	}
+	for _, r := range ranges {
+		low, high := r[0], r[1]
+		if low > high {
+			continue
+		}
+		if uint64(high-low) < uint64(shards) {
+			for h := low; ; h++ {
+				sh := ShardHandle(h, shards)
+				sharded = append(sharded, [2]int64{sh, sh})
+				if h == high {
+					break
+				}
+			}
+			continue
+		}
+		lowPart, highPart := low>>shift, high>>shift
+		if lowPart == highPart {
+			addPart(lowPart, low&mask, high&mask)
+			continue
+		}
+		addPart(lowPart, low&mask, mask)
+		addPart(highPart, 0, high&mask)
+		// The whole parts between are sharded into whole parts, which are added once.
+		whole := make(map[int64]struct{})
+		for part := lowPart + 1; part < highPart; part++ {
+			for s := int64(0); s < int64(shards); s++ {
+				whole[part^s] = struct{}{}
+			}
+		}
+		for part := range whole {
+			sharded = append(sharded, [2]int64{part << shift, part<<shift | mask})
+		}
+	}
+	if len(sharded) == 0 {
+		return nil
+	}
+	sort.Slice(sharded, func(i, j int) bool { return sharded[i][0] < sharded[j][0] })
+	merged := sharded[:1]
+	for _, r := range sharded[1:] {
+		last := &merged[len(merged)-1]
+		if last[1] == math.MaxInt64 || r[0] <= last[1]+1 {
+			if r[1] > last[1] {
+				last[1] = r[1]
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
 // Allocator implements table.Table Allocator interface.
 func (t *TableCommon) Allocator(ctx sessionctx.Context) autoid.Allocator {
 	if ctx != nil {
@@ -900,6 +1020,8 @@ func (t *TableCommon) RebaseAutoID(ctx sessionctx.Context, newBase int64, isSetS
 }
 
 // Seek implements table.Table Seek interface.
+// The rows of a table with HandleShards are in the order of their row keys instead of their handles,
+// so h is the position of a row key, and the returned handle is the real handle of the row there.
 func (t *TableCommon) Seek(ctx sessionctx.Context, h int64) (int64, bool, error) {
 	txn, err := ctx.Txn(true)
 	if err != nil {
@@ -918,7 +1040,7 @@ func (t *TableCommon) Seek(ctx sessionctx.Context, h int64) (int64, bool, error)
 	if err != nil {
 		return 0, false, err
 	}
-	return handle, true, nil
+	return UnshardHandle(handle, t.meta.HandleShards), true, nil
 }
 
 // Type implements table.Table Type interface.
@@ -0,0 +1,106 @@
+// Copyright 2016 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tables
+
+import (
+	"math"
+	"testing"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tidb/parser/terror"
+	"github.com/pingcap/tidb/table"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/types"
+)
+
+func (s *testIndexInternalSuite) TestShardHandle(c *C) {
+	for _, shards := range []int{0, 1, 2, 3, 16, 256, 1000} {
+		for _, h := range []int64{math.MinInt64, -1 << 40, -1, 0, 1, 2, 1 << 40, math.MaxInt64} {
+			sharded := ShardHandle(h, shards)
+			c.Assert(UnshardHandle(sharded, shards), Equals, h, Commentf("shards %d handle %d", shards, h))
+			// The sign is kept.
+			c.Assert(sharded < 0, Equals, h < 0)
+			if shards < 2 {
+				c.Assert(sharded, Equals, h)
+			}
+		}
+	}
+	// Sequential handles are spread over the shards.
+	seen := make(map[int64]bool)
+	for h := int64(1); h <= 64; h++ {
+		seen[ShardHandle(h, 4)>>61] = true
+	}
+	c.Assert(seen, HasLen, 4)
+
+	// The index entries store the real handles, which still find the sharded rows.
+	tblInfo := newTestTableInfo([]string{"a"}, []int{0}, true)
+	tblInfo.HandleShards = 16
+	idx := NewIndex(tblInfo.ID, tblInfo, tblInfo.Indices[0]).(*index)
+	for h := int64(1); h <= 8; h++ {
+		_, err := idx.Create(s.sctx, s.store, types.MakeDatums(h), h)
+		c.Assert(err, IsNil)
+		c.Assert(s.store.Set(tablecodec.EncodeRowKeyWithHandle(tblInfo.ID, ShardHandle(h, 16)), []byte{byte(h)}), IsNil)
+	}
+	ok, h, err := idx.Exist(s.sc, s.store, types.MakeDatums(5), 5)
+	c.Assert(err, IsNil)
+	c.Assert(ok, IsTrue)
+	c.Assert(h, Equals, int64(5))
+	it, _, err := idx.SeekWithRowPrefetch(s.sc, s.store, types.MakeDatums(1), 0)
+	c.Assert(err, IsNil)
+	for h := int64(1); h <= 8; h++ {
+		row, err := it.Next()
+		c.Assert(err, IsNil)
+		c.Assert(row.Handle, Equals, h)
+		c.Assert(row.Row, BytesEquals, []byte{byte(h)})
+	}
+	it.Close()
+	c.Assert(s.sc.WarningCount(), Equals, uint16(0))
+}
+
+// BenchmarkShardHandle reports how evenly the sharded row keys of sequential handles are written to the
+// shards, as the ratio of the writes to the busiest shard to the writes to an even shard.
+func BenchmarkShardHandle(b *testing.B) {
+	const shards = 16
+	counts := make([]int, shards)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		counts[ShardHandle(int64(i), shards)>>(63-4)]++
+	}
+	busiest := 0
+	for _, n := range counts {
+		if n > busiest {
+			busiest = n
+		}
+	}
+	b.ReportMetric(float64(busiest)*shards/float64(b.N), "max/even")
+}
+
+func (s *testIndexInternalSuite) TestTableFromMetaChecksIndexColumns(c *C) {
+	tblInfo := newTestTableInfo([]string{"a", "b", "c"}, []int{1, 2}, false)
+	tblInfo.State = model.StatePublic
+	for _, col := range tblInfo.Columns {
+		col.State = model.StatePublic
+	}
+	tblInfo.Indices[0].State = model.StatePublic
+	tbl, err := TableFromMeta(nil, tblInfo)
+	c.Assert(err, IsNil)
+	c.Assert(tbl.Indices(), HasLen, 1)
+
+	// The offset of column "b" drifts to point at column "c".
+	tblInfo.Indices[0].Columns[0].Offset = 2
+	_, err = TableFromMeta(nil, tblInfo)
+	c.Assert(terror.ErrorEqual(err, table.ErrKeyColumnDoesNotExist), IsTrue, Commentf("err %v", err))
+	c.Assert(MockTableFromMeta(tblInfo), IsNil)
+}
@@ -488,12 +488,127 @@ func DecodeIndexHandle(key, value []byte, colsLen int, pkTp *types.FieldType) (i
 
 // DecodeIndexValueAsHandle uses to decode index value as handle id.
 func DecodeIndexValueAsHandle(data []byte) (int64, error) {
+	data, _, err := DecodeVersionedIndexValue(data)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
 	var h int64
 	buf := bytes.NewBuffer(data)
-	err := binary.Read(buf, binary.BigEndian, &h)
+	err = binary.Read(buf, binary.BigEndian, &h)
 	return h, errors.Trace(err)
 }
 
+// The flags of an index value in the versioned layout, see kv.IndexValueVersion. The fields of the set flags
+// follow the flags byte in the order of their bits, and then comes the value of the unversioned layout.
+const (
+	// IndexValuePartitionFlag is set for the 8-byte big-endian partition ID of the row.
+	IndexValuePartitionFlag byte = 0x01
+	// IndexValueHintFlag is set for the 1-byte length of the placement hint followed by the hint.
+	IndexValueHintFlag byte = 0x02
+	// IndexValueWriteTimeFlag is set for the 8-byte big-endian write time in Unix nanoseconds.
+	IndexValueWriteTimeFlag byte = 0x04
+	// IndexValueTombstoneFlag is set for the 8-byte big-endian delete time of a tombstone in Unix nanoseconds.
+	IndexValueTombstoneFlag byte = 0x08
+	// IndexValuePadFlag is set for the 2 zero bytes keeping the value from being 8 or 9 bytes long.
+	IndexValuePadFlag byte = 0x40
+
+	indexValueKnownFlags = IndexValuePartitionFlag | IndexValueHintFlag | IndexValueWriteTimeFlag |
+		IndexValueTombstoneFlag | IndexValuePadFlag | kv.IndexValueUntouchedFlag
+)
+
+// IndexValueFields is the fields of an index value in the versioned layout.
+type IndexValueFields struct {
+	Flags       byte
+	PartitionID int64
+	Hint        string
+	WriteTime   int64
+	DeleteTime  int64
+}
+
+// IsVersionedIndexValue reports whether value is in the versioned layout. An unversioned value starting with
+// kv.IndexValueVersion is a distinct value of 8 or 9 bytes, i.e. a handle, maybe followed by a format byte or
+// the untouched flag, so a versioned value is never 8 or 9 bytes long.
+func IsVersionedIndexValue(value []byte) bool {
+	return len(value) >= 2 && value[0] == kv.IndexValueVersion && len(value) != 8 && len(value) != 9
+}
+
+// EncodeVersionedIndexValue encodes payload, the value of the unversioned layout, in the versioned layout with
+// the fields of fields.Flags. IndexValuePadFlag is set if the value would be 8 or 9 bytes long.
+func EncodeVersionedIndexValue(fields IndexValueFields, payload []byte) []byte {
+	flags := fields.Flags &^ IndexValuePadFlag
+	value := make([]byte, 2, 2+27+len(fields.Hint)+len(payload))
+	value[0] = kv.IndexValueVersion
+	if flags&IndexValuePartitionFlag != 0 {
+		value = codec.EncodeUint(value, uint64(fields.PartitionID))
+	}
+	if flags&IndexValueHintFlag != 0 {
+		value = append(value, byte(len(fields.Hint)))
+		value = append(value, fields.Hint...)
+	}
+	if flags&IndexValueWriteTimeFlag != 0 {
+		value = codec.EncodeUint(value, uint64(fields.WriteTime))
+	}
+	if flags&IndexValueTombstoneFlag != 0 {
+		value = codec.EncodeUint(value, uint64(fields.DeleteTime))
+	}
+	if n := len(value) + len(payload); n == 8 || n == 9 {
+		flags |= IndexValuePadFlag
+		value = append(value, 0, 0)
+	}
+	value[1] = flags
+	return append(value, payload...)
+}
+
+// DecodeVersionedIndexValue splits the value of the unversioned layout from the fields of value. An
+// unversioned value is returned as is with no fields.
+func DecodeVersionedIndexValue(value []byte) ([]byte, IndexValueFields, error) {
+	var fields IndexValueFields
+	if !IsVersionedIndexValue(value) {
+		return value, fields, nil
+	}
+	fields.Flags = value[1]
+	if unknown := fields.Flags &^ indexValueKnownFlags; unknown != 0 {
+		return nil, fields, errors.Errorf("index value %x has unknown flags %#x", value, unknown)
+	}
+	rest := value[2:]
+	cut := func(n int) []byte {
+		if len(rest) < n {
+			rest = nil
+			return nil
+		}
+		field := rest[:n:n]
+		rest = rest[n:]
+		return field
+	}
+	if fields.Flags&IndexValuePartitionFlag != 0 {
+		if field := cut(8); field != nil {
+			fields.PartitionID = int64(binary.BigEndian.Uint64(field))
+		}
+	}
+	if fields.Flags&IndexValueHintFlag != 0 {
+		if field := cut(1); field != nil {
+			fields.Hint = string(cut(int(field[0])))
+		}
+	}
+	if fields.Flags&IndexValueWriteTimeFlag != 0 {
+		if field := cut(8); field != nil {
+			fields.WriteTime = int64(binary.BigEndian.Uint64(field))
+		}
+	}
+	if fields.Flags&IndexValueTombstoneFlag != 0 {
+		if field := cut(8); field != nil {
+			fields.DeleteTime = int64(binary.BigEndian.Uint64(field))
+		}
+	}
+	if fields.Flags&IndexValuePadFlag != 0 {
+		cut(2)
+	}
+	if rest == nil {
+		return nil, fields, errors.Errorf("index value %x is shorter than its fields", value)
+	}
+	return rest, fields, nil
+}
+
 // EncodeTableIndexPrefix encodes index prefix with tableID and idxID.
 func EncodeTableIndexPrefix(tableID, idxID int64) kv.Key {
 	key := make([]byte, 0, prefixLen)
@@ -543,7 +658,8 @@ func IsIndexKey(k []byte) bool {
 func IsUntouchedIndexKValue(k, v []byte) bool {
 	vLen := len(v)
 	return IsIndexKey(k) &&
-		((vLen == 1 || vLen == 9) && v[vLen-1] == kv.UnCommitIndexKVFlag)
+		(((vLen == 1 || vLen == 9) && v[vLen-1] == kv.UnCommitIndexKVFlag) ||
+			((vLen == 3 || vLen == 11) && v[0] == kv.IndexValueVersion && v[1] == kv.IndexValueUntouchedFlag && v[vLen-1] == kv.UnCommitIndexKVFlag))
 }
 
 // GenTablePrefix composes table record and index prefix: "t[tableID]".
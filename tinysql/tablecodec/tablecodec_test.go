@@ -108,6 +108,71 @@ func (s *testTableCodecSuite) TestIndexKey(c *C) {
 	c.Assert(isRecordKey, IsFalse)
 }
 
+func (s *testTableCodecSuite) TestIsUntouchedIndexKValue(c *C) {
+	key := EncodeIndexSeekKey(4, 5, []byte("a"))
+	handle := []byte{0, 0, 0, 0, 0, 0, 0, kv.UnCommitIndexKVFlag}
+	for _, t := range []struct {
+		value     []byte
+		untouched bool
+	}{
+		{[]byte{kv.UnCommitIndexKVFlag}, true},
+		{append(handle, kv.UnCommitIndexKVFlag), true},
+		{[]byte{kv.IndexValueVersion, kv.IndexValueUntouchedFlag, kv.UnCommitIndexKVFlag}, true},
+		{append([]byte{kv.IndexValueVersion, kv.IndexValueUntouchedFlag}, append(handle, kv.UnCommitIndexKVFlag)...), true},
+		{[]byte{'0'}, false},
+		// A handle ending with the flag byte isn't untouched, with or without the versioned layout.
+		{handle, false},
+		{append([]byte{kv.IndexValueVersion, 0}, handle...), false},
+		{append([]byte{kv.IndexValueVersion, 0x02, 0}, handle...), false},
+		{[]byte{kv.IndexValueVersion, 0, kv.UnCommitIndexKVFlag}, false},
+	} {
+		c.Assert(IsUntouchedIndexKValue(key, t.value), Equals, t.untouched, Commentf("value %x", t.value))
+	}
+	c.Assert(IsUntouchedIndexKValue(EncodeRowKeyWithHandle(4, 1), []byte{kv.UnCommitIndexKVFlag}), IsFalse)
+}
+
+func (s *testTableCodecSuite) TestVersionedIndexValue(c *C) {
+	handle := []byte{0, 0, 0, 0, 0, 0, 1, 0x2c}
+	for _, fields := range []IndexValueFields{
+		{},
+		{Flags: IndexValuePartitionFlag | IndexValueWriteTimeFlag, PartitionID: 7, WriteTime: 1e18},
+		{Flags: IndexValueHintFlag, Hint: "zone-a"},
+		{Flags: IndexValueHintFlag | IndexValueTombstoneFlag, DeleteTime: 42},
+	} {
+		value := EncodeVersionedIndexValue(fields, handle)
+		c.Assert(IsVersionedIndexValue(value), IsTrue)
+		payload, decoded, err := DecodeVersionedIndexValue(value)
+		c.Assert(err, IsNil)
+		c.Assert(payload, BytesEquals, handle)
+		c.Assert(decoded, DeepEquals, fields)
+		h, err := DecodeIndexValueAsHandle(value)
+		c.Assert(err, IsNil)
+		c.Assert(h, Equals, int64(300))
+	}
+
+	// A versioned value is padded to never be taken for an unversioned one of 8 or 9 bytes.
+	value := EncodeVersionedIndexValue(IndexValueFields{}, []byte("0123456"))
+	c.Assert(value, HasLen, 11)
+	c.Assert(value[1], Equals, IndexValuePadFlag)
+	payload, _, err := DecodeVersionedIndexValue(value)
+	c.Assert(err, IsNil)
+	c.Assert(string(payload), Equals, "0123456")
+
+	// The unversioned values are returned as is, even the handles led by kv.IndexValueVersion.
+	for _, value := range [][]byte{{'0'}, handle, {kv.IndexValueVersion, 0, 0, 0, 0, 0, 0, 1}, {kv.IndexValueVersion, 0, 0, 0, 0, 0, 0, 1, kv.UnCommitIndexKVFlag}} {
+		c.Assert(IsVersionedIndexValue(value), IsFalse)
+		payload, fields, err := DecodeVersionedIndexValue(value)
+		c.Assert(err, IsNil)
+		c.Assert(payload, BytesEquals, value)
+		c.Assert(fields, Equals, IndexValueFields{})
+	}
+
+	_, _, err = DecodeVersionedIndexValue([]byte{kv.IndexValueVersion, 0x10, '0'})
+	c.Assert(err, ErrorMatches, ".*has unknown flags 0x10")
+	_, _, err = DecodeVersionedIndexValue([]byte{kv.IndexValueVersion, IndexValueHintFlag, 5, 'a'})
+	c.Assert(err, ErrorMatches, ".*is shorter than its fields")
+}
+
 func (s *testTableCodecSuite) TestRecordKey(c *C) {
 	tableID := int64(55)
 	tableKey := EncodeRowKeyWithHandle(tableID, math.MaxUint32)
@@ -118,6 +118,22 @@ func EstimateValueSize(sc *stmtctx.StatementContext, val types.Datum) (int, erro
 	return l, nil
 }
 
+// EstimateKeySize returns the size of val encoded by EncodeKey, which is exact as the key encoding
+// of every supported kind has a size given by the value's kind and length.
+func EstimateKeySize(val types.Datum) (int, error) {
+	switch val.Kind() {
+	case types.KindInt64, types.KindUint64:
+		return sizeInt(true), nil
+	case types.KindString, types.KindBytes:
+		return sizeBytes(val.GetBytes(), true), nil
+	case types.KindFloat32, types.KindFloat64:
+		return 9, nil
+	case types.KindNull, types.KindMinNotNull, types.KindMaxValue:
+		return 1, nil
+	}
+	return 0, errors.Errorf("unsupported encode type %d", val.Kind())
+}
+
 func encodeBytes(b []byte, v []byte, comparable bool) []byte {
 	if comparable {
 		b = append(b, bytesFlag)
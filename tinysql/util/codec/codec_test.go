@@ -298,9 +298,10 @@ func (s *testCodecSuite) TestNumberCodec(c *C) {
 	b, u, err := DecodeComparableUvarint(b)
 	c.Assert(err, IsNil)
 	c.Assert(u, Equals, uint64(1))
-	_, i, err = DecodeComparableVarint(b)
+	b, i, err = DecodeComparableVarint(b)
 	c.Assert(err, IsNil)
 	c.Assert(i, Equals, int64(2))
+	c.Assert(b, HasLen, 0)
 }
 
 func (s *testCodecSuite) TestNumberOrder(c *C) {